@@ -0,0 +1,401 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// UserToken is a single-use token emailed to a user to verify their address
+// or reset their password. The store keeps its hash.
+type UserToken struct {
+	ID        int64
+	UserID    int64
+	Purpose   string
+	TokenHash string
+	// Email is the address the token was sent to
+	Email     string
+	ExpiresAt time.Time
+	CreatedAt time.Time
+}
+
+// Purposes of user tokens and how long they can be used
+const (
+	tokenVerifyEmail   = "verify_email"
+	tokenResetPassword = "reset_password"
+
+	emailVerificationTTL = 48 * time.Hour
+	passwordResetTTL     = time.Hour
+)
+
+// emailUserToken creates a token for purpose and emails its link to the
+// user's address. The link leads to path on the dashboard, which posts the
+// token back.
+func (s *Server) emailUserToken(ctx context.Context, user User, purpose string, ttl time.Duration, path, subject, text string) error {
+	now, err := s.monitor.Now(ctx)
+	if err != nil {
+		return err
+	}
+	token, err := newAccessToken()
+	if err != nil {
+		return err
+	}
+	err = s.users.CreateUserToken(ctx, UserToken{
+		UserID:    int64(user.ID),
+		Purpose:   purpose,
+		TokenHash: hashToken(token),
+		Email:     user.Email,
+		ExpiresAt: now.Add(ttl),
+	})
+	if err != nil {
+		return err
+	}
+
+	body := fmt.Sprintf("Hi %s,\n\n%s\n%s\n\nThe link can be used once within %s. "+
+		"If you did not expect this email, you can ignore it.\n",
+		user.Username, text, appURL(path+token), ttl)
+	return sendEmail(user.Email, "[ServerLord] "+subject, "text/plain", body)
+}
+
+// sendVerificationEmail emails the user a link to verify their address
+func (s *Server) sendVerificationEmail(ctx context.Context, user User) error {
+	return s.emailUserToken(ctx, user, tokenVerifyEmail, emailVerificationTTL, "/verify-email/",
+		"Verify your email address", "Confirm that this is your email address here:")
+}
+
+// How recent the login of a user without a password has to be for sensitive
+// changes without a second factor code
+const ssoReauthWindow = 10 * time.Minute
+
+// checkCurrentPassword requires the user's current password for sensitive
+// changes. Accounts created by single sign-on have none, so they need a
+// session from a login within ssoReauthWindow or a code from their
+// authenticator app instead. It writes the error response itself and returns
+// false on failure.
+func (s *Server) checkCurrentPassword(w http.ResponseWriter, r *http.Request, user User, password, code string) bool {
+	if user.Password == "" {
+		return s.checkRecentLogin(w, r, user, code)
+	}
+	if password == "" {
+		respondWithError(w, http.StatusBadRequest, "current_password is required")
+		return false
+	}
+	if bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(password)) != nil {
+		respondWithError(w, http.StatusForbidden, "Current password is incorrect")
+		return false
+	}
+	return true
+}
+
+// checkRecentLogin stands in for checkCurrentPassword for users without a
+// password
+func (s *Server) checkRecentLogin(w http.ResponseWriter, r *http.Request, user User, code string) bool {
+	now, err := s.monitor.Now(r.Context())
+	if err == nil {
+		var session Session
+		session, err = s.sessions.GetActiveSession(r.Context(), authSessionID(r))
+		if err == nil && session.UserID == int64(user.ID) && now.Sub(session.CreatedAt) < ssoReauthWindow {
+			return true
+		}
+	}
+	if err != nil && !errors.Is(err, ErrNotFound) {
+		log.Printf("Error retrieving session: %v", err)
+		respondWithError(w, http.StatusInternalServerError, "Error checking the login")
+		return false
+	}
+
+	if code == "" {
+		respondWithError(w, http.StatusForbidden, "Log in through single sign-on again or pass a code from your authenticator app")
+		return false
+	}
+	ok, err := s.verifyWithLockout(w, r, int64(user.ID), code, "")
+	if !ok {
+		return false
+	}
+	if errors.Is(err, errInvalidCode) {
+		respondWithError(w, http.StatusForbidden, "Invalid code")
+		return false
+	}
+	if err != nil {
+		log.Printf("Error verifying second factor: %v", err)
+		respondWithError(w, http.StatusInternalServerError, "Error checking the login")
+		return false
+	}
+	return true
+}
+
+// currentUser loads the authenticated user, with their password hash. It
+// writes the error response itself and returns ok=false on failure.
+func (s *Server) currentUser(w http.ResponseWriter, r *http.Request) (User, bool) {
+	user, err := s.users.GetUser(r.Context(), authUserID(r))
+	if err != nil {
+		if errors.Is(err, ErrNotFound) {
+			respondWithError(w, http.StatusNotFound, "User not found")
+		} else {
+			log.Printf("Error retrieving user: %v", err)
+			respondWithError(w, http.StatusInternalServerError, "Error retrieving user")
+		}
+		return User{}, false
+	}
+	return user, true
+}
+
+func (s *Server) getMe(w http.ResponseWriter, r *http.Request) {
+	user, ok := s.currentUser(w, r)
+	if !ok {
+		return
+	}
+	respondWithJSON(w, http.StatusOK, user)
+}
+
+// updateMe changes the username, email address or password of the
+// authenticated user. Changing the email address or password takes the
+// current password (see checkCurrentPassword for users without one). A new
+// address has to be verified again, and a new password ends the user's other
+// sessions.
+func (s *Server) updateMe(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Username        string `json:"username" validate:"trimmed,max=50"`
+		Email           string `json:"email" validate:"email,max=100"`
+		Password        string `json:"password" validate:"min=8,maxbytes=72"`
+		CurrentPassword string `json:"current_password"`
+		Code            string `json:"code"`
+	}
+	if !decodeJSON(w, r, &req) {
+		return
+	}
+
+	user, ok := s.currentUser(w, r)
+	if !ok {
+		return
+	}
+	if req.Username == user.Username {
+		req.Username = ""
+	}
+	if req.Email == user.Email {
+		req.Email = ""
+	}
+	if (req.Email != "" || req.Password != "") && !s.checkCurrentPassword(w, r, user, req.CurrentPassword, req.Code) {
+		return
+	}
+
+	update := UserUpdate{Username: req.Username, Email: req.Email}
+	if req.Password != "" {
+		hash, err := bcrypt.GenerateFromPassword([]byte(req.Password), bcrypt.DefaultCost)
+		if err != nil {
+			log.Printf("Error hashing password: %v", err)
+			respondWithError(w, http.StatusInternalServerError, "Error processing user data")
+			return
+		}
+		update.PasswordHash = string(hash)
+	}
+
+	updated, err := s.users.UpdateUser(r.Context(), int64(user.ID), update)
+	if err != nil {
+		if errors.Is(err, ErrConflict) {
+			respondWithError(w, http.StatusConflict, "Username or email is already taken")
+		} else {
+			log.Printf("Error updating user: %v", err)
+			respondWithError(w, http.StatusInternalServerError, "Error updating user")
+		}
+		return
+	}
+
+	if req.Email != "" {
+		if err := s.sendVerificationEmail(r.Context(), updated); err != nil {
+			log.Printf("Error emailing verification link to user ID %d: %v", updated.ID, err)
+		}
+	}
+	if req.Password != "" {
+		if err := s.endOtherSessions(r.Context(), int64(user.ID), authSessionID(r)); err != nil {
+			log.Printf("Error ending sessions of user ID %d: %v", user.ID, err)
+		}
+	}
+
+	log.Printf("User ID %d updated their account", updated.ID)
+	respondWithJSON(w, http.StatusOK, updated)
+}
+
+// endOtherSessions ends every session of the user but keepID
+func (s *Server) endOtherSessions(ctx context.Context, userID, keepID int64) error {
+	sessions, err := s.sessions.ListSessions(ctx, userID)
+	if err != nil {
+		return err
+	}
+	for _, session := range sessions {
+		if session.ID == keepID {
+			continue
+		}
+		if err := s.sessions.DeleteSession(ctx, userID, session.ID); err != nil && !errors.Is(err, ErrNotFound) {
+			return err
+		}
+	}
+	return nil
+}
+
+// deleteMe deletes the authenticated user's account with their personal
+// tasks and graph data. It takes the current password.
+func (s *Server) deleteMe(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Password string `json:"password"`
+		Code     string `json:"code"`
+	}
+	if r.ContentLength != 0 && !decodeJSON(w, r, &req) {
+		return
+	}
+
+	user, ok := s.currentUser(w, r)
+	if !ok {
+		return
+	}
+	if user.Password != "" && req.Password == "" {
+		respondWithError(w, http.StatusBadRequest, "password is required")
+		return
+	}
+	if !s.checkCurrentPassword(w, r, user, req.Password, req.Code) {
+		return
+	}
+
+	if err := s.users.DeleteUser(r.Context(), int64(user.ID)); err != nil {
+		switch {
+		case errors.Is(err, ErrConflict):
+			respondWithError(w, http.StatusConflict,
+				"You are the last owner of an organization; appoint another owner or delete it first")
+		case errors.Is(err, ErrNotFound):
+			respondWithError(w, http.StatusNotFound, "User not found")
+		default:
+			log.Printf("Error deleting user: %v", err)
+			respondWithError(w, http.StatusInternalServerError, "Error deleting user")
+		}
+		return
+	}
+
+	log.Printf("User ID %d deleted their account", user.ID)
+	respondWithJSON(w, http.StatusOK, map[string]string{"message": "Account deleted successfully"})
+}
+
+// resendVerification emails the authenticated user a new verification link
+func (s *Server) resendVerification(w http.ResponseWriter, r *http.Request) {
+	user, ok := s.currentUser(w, r)
+	if !ok {
+		return
+	}
+	if user.EmailVerified {
+		respondWithError(w, http.StatusConflict, "Email address is already verified")
+		return
+	}
+
+	if err := s.sendVerificationEmail(r.Context(), user); err != nil {
+		log.Printf("Error emailing verification link to user ID %d: %v", user.ID, err)
+		respondWithError(w, http.StatusInternalServerError, "Error sending verification email")
+		return
+	}
+	respondWithJSON(w, http.StatusOK, map[string]string{"message": "Verification email sent"})
+}
+
+// verifyEmail marks the address a verification link was sent to verified
+func (s *Server) verifyEmail(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Token string `json:"token" validate:"required"`
+	}
+	if !decodeJSON(w, r, &req) {
+		return
+	}
+
+	token, err := s.users.UseUserToken(r.Context(), tokenVerifyEmail, hashToken(req.Token))
+	if err == nil {
+		err = s.users.MarkEmailVerified(r.Context(), token.UserID, token.Email)
+	}
+	if err != nil {
+		if errors.Is(err, ErrNotFound) {
+			respondWithError(w, http.StatusBadRequest, "Verification link is invalid or expired")
+		} else {
+			log.Printf("Error verifying email: %v", err)
+			respondWithError(w, http.StatusInternalServerError, "Error verifying email")
+		}
+		return
+	}
+
+	log.Printf("User ID %d verified their email address", token.UserID)
+	respondWithJSON(w, http.StatusOK, map[string]string{"message": "Email address verified"})
+}
+
+// forgotPassword emails a password reset link. It answers the same whether
+// or not the address belongs to an account, and sends the email in the
+// background so that the response time does not tell either.
+func (s *Server) forgotPassword(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Email string `json:"email" validate:"required"`
+	}
+	if !decodeJSON(w, r, &req) {
+		return
+	}
+
+	go func(email string) {
+		ctx := context.Background()
+		user, err := s.users.GetUserByEmail(ctx, email)
+		if err != nil {
+			if !errors.Is(err, ErrNotFound) {
+				log.Printf("Error retrieving user: %v", err)
+			}
+			return
+		}
+		err = s.emailUserToken(ctx, user, tokenResetPassword, passwordResetTTL, "/reset-password/",
+			"Reset your password", "Choose a new password here:")
+		if err != nil {
+			log.Printf("Error emailing password reset link to user ID %d: %v", user.ID, err)
+		}
+	}(req.Email)
+
+	respondWithJSON(w, http.StatusOK, map[string]string{
+		"message": "If the address belongs to an account, a password reset link has been sent to it",
+	})
+}
+
+// resetPassword sets a new password with the token of a reset link and ends
+// every session of the user
+func (s *Server) resetPassword(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Token    string `json:"token" validate:"required"`
+		Password string `json:"password" validate:"required,min=8,maxbytes=72"`
+	}
+	if !decodeJSON(w, r, &req) {
+		return
+	}
+	hash, err := bcrypt.GenerateFromPassword([]byte(req.Password), bcrypt.DefaultCost)
+	if err != nil {
+		log.Printf("Error hashing password: %v", err)
+		respondWithError(w, http.StatusInternalServerError, "Error processing user data")
+		return
+	}
+
+	token, err := s.users.UseUserToken(r.Context(), tokenResetPassword, hashToken(req.Token))
+	if err == nil {
+		_, err = s.users.UpdateUser(r.Context(), token.UserID, UserUpdate{PasswordHash: string(hash)})
+	}
+	if err != nil {
+		if errors.Is(err, ErrNotFound) {
+			respondWithError(w, http.StatusBadRequest, "Reset link is invalid or expired")
+		} else {
+			log.Printf("Error resetting password: %v", err)
+			respondWithError(w, http.StatusInternalServerError, "Error resetting password")
+		}
+		return
+	}
+
+	if err := s.sessions.DeleteUserSessions(r.Context(), token.UserID); err != nil {
+		log.Printf("Error ending sessions of user ID %d: %v", token.UserID, err)
+	}
+	// Following the link proves the address, unless it changed since
+	if err := s.users.MarkEmailVerified(r.Context(), token.UserID, token.Email); err != nil && !errors.Is(err, ErrNotFound) {
+		log.Printf("Error marking email of user ID %d verified: %v", token.UserID, err)
+	}
+
+	log.Printf("User ID %d reset their password", token.UserID)
+	respondWithJSON(w, http.StatusOK, map[string]string{"message": "Password reset successfully"})
+}
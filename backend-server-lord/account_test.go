@@ -0,0 +1,196 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt"
+)
+
+func TestStoreUserTokens(t *testing.T) {
+	forEachStore(t, func(t *testing.T, store Store, clock *time.Time) {
+		ctx := context.Background()
+		user, err := store.CreateUser(ctx, "alice", "alice@example.com", "hash")
+		if err != nil {
+			t.Fatal(err)
+		}
+		userID := int64(user.ID)
+
+		create := func(purpose, hash string) {
+			t.Helper()
+			if err := store.CreateUserToken(ctx, UserToken{
+				UserID: userID, Purpose: purpose, TokenHash: hash, Email: "alice@example.com", ExpiresAt: clock.Add(time.Hour),
+			}); err != nil {
+				t.Fatal(err)
+			}
+		}
+		create(tokenVerifyEmail, "first")
+		create(tokenVerifyEmail, "second")
+		create(tokenResetPassword, "reset")
+
+		// A new token replaces the earlier one for the same purpose only
+		if _, err := store.UseUserToken(ctx, tokenVerifyEmail, "first"); !errors.Is(err, ErrNotFound) {
+			t.Errorf("UseUserToken of a replaced token: %v, want ErrNotFound", err)
+		}
+		if _, err := store.UseUserToken(ctx, tokenVerifyEmail, "reset"); !errors.Is(err, ErrNotFound) {
+			t.Errorf("UseUserToken for another purpose: %v, want ErrNotFound", err)
+		}
+		token, err := store.UseUserToken(ctx, tokenVerifyEmail, "second")
+		if err != nil || token.UserID != userID || token.Email != "alice@example.com" {
+			t.Fatalf("UseUserToken = %+v, %v", token, err)
+		}
+		if _, err := store.UseUserToken(ctx, tokenVerifyEmail, "second"); !errors.Is(err, ErrNotFound) {
+			t.Errorf("UseUserToken twice: %v, want ErrNotFound", err)
+		}
+		*clock = clock.Add(time.Hour)
+		if _, err := store.UseUserToken(ctx, tokenResetPassword, "reset"); !errors.Is(err, ErrNotFound) {
+			t.Errorf("UseUserToken of an expired token: %v, want ErrNotFound", err)
+		}
+
+		if err := store.MarkEmailVerified(ctx, userID, "alice@example.com"); err != nil {
+			t.Fatal(err)
+		}
+		if got, err := store.GetUser(ctx, userID); err != nil || !got.EmailVerified {
+			t.Errorf("GetUser after verifying = %+v, %v", got, err)
+		}
+		// A new address has to be verified again
+		updated, err := store.UpdateUser(ctx, userID, UserUpdate{Email: "alice@example.org"})
+		if err != nil || updated.Email != "alice@example.org" || updated.EmailVerified || updated.Username != "alice" {
+			t.Errorf("UpdateUser = %+v, %v", updated, err)
+		}
+		if err := store.MarkEmailVerified(ctx, userID, "alice@example.com"); !errors.Is(err, ErrNotFound) {
+			t.Errorf("MarkEmailVerified of the old address: %v, want ErrNotFound", err)
+		}
+	})
+}
+
+func TestStoreDeleteUser(t *testing.T) {
+	forEachStore(t, func(t *testing.T, store Store, clock *time.Time) {
+		ctx := context.Background()
+		task := createMonitorTestTask(t, store)
+		bob, err := store.CreateUser(ctx, "bob", "bob@example.com", "hash")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		org, err := store.CreateOrg(ctx, "Ops", task.UserID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := store.DeleteUser(ctx, task.UserID); !errors.Is(err, ErrConflict) {
+			t.Errorf("DeleteUser of the last owner: %v, want ErrConflict", err)
+		}
+		if err := store.AddOrgMember(ctx, org.ID, int64(bob.ID), roleOwner); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := store.DeleteUser(ctx, task.UserID); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := store.GetTask(ctx, task.ID); !errors.Is(err, ErrNotFound) {
+			t.Errorf("personal task after deleting the user: %v, want ErrNotFound", err)
+		}
+		if members, err := store.ListOrgMembers(ctx, org.ID); err != nil || len(members) != 1 || members[0].UserID != int64(bob.ID) {
+			t.Errorf("members after deleting the user %+v, %v; want bob", members, err)
+		}
+		if err := store.DeleteUser(ctx, task.UserID); !errors.Is(err, ErrNotFound) {
+			t.Errorf("DeleteUser twice: %v, want ErrNotFound", err)
+		}
+	})
+}
+
+func TestAccountAPI(t *testing.T) {
+	api := newTestAPI(t)
+	userID, token := api.signup("alice")
+	api.signup("bob")
+
+	expect(t, api.do("POST", "/api/users", "", map[string]string{
+		"username": "carol", "email": "carol@example.com", "password": "short",
+	}), http.StatusBadRequest, nil)
+
+	var me User
+	expect(t, api.do("GET", "/api/me", token, nil), http.StatusOK, &me)
+	if me.ID != userID || me.Email != "alice@example.com" || me.EmailVerified {
+		t.Fatalf("GET /api/me = %+v", me)
+	}
+
+	// A verification link proves the address
+	ctx := context.Background()
+	if err := api.store.CreateUserToken(ctx, UserToken{
+		UserID: int64(userID), Purpose: tokenVerifyEmail, TokenHash: hashToken("verify"), Email: me.Email, ExpiresAt: api.now.Add(time.Hour),
+	}); err != nil {
+		t.Fatal(err)
+	}
+	expect(t, api.do("POST", "/api/email/verify", "", map[string]string{"token": "verify"}), http.StatusOK, nil)
+	expect(t, api.do("POST", "/api/email/verify", "", map[string]string{"token": "verify"}), http.StatusBadRequest, nil)
+	expect(t, api.do("GET", "/api/me", token, nil), http.StatusOK, &me)
+	if !me.EmailVerified {
+		t.Errorf("email not verified: %+v", me)
+	}
+
+	expect(t, api.do("PUT", "/api/me", token, map[string]string{"username": "bob"}), http.StatusConflict, nil)
+	expect(t, api.do("PUT", "/api/me", token, map[string]string{"email": "alice@example.org"}), http.StatusBadRequest, nil)
+	expect(t, api.do("PUT", "/api/me", token, map[string]string{
+		"email": "alice@example.org", "current_password": "wrong password",
+	}), http.StatusForbidden, nil)
+	expect(t, api.do("PUT", "/api/me", token, map[string]string{
+		"email": "alice@example.org", "current_password": "correct horse",
+	}), http.StatusOK, &me)
+	if me.Email != "alice@example.org" || me.EmailVerified {
+		t.Errorf("after changing the email %+v, want it unverified", me)
+	}
+
+	// A password reset ends every session
+	if err := api.store.CreateUserToken(ctx, UserToken{
+		UserID: int64(userID), Purpose: tokenResetPassword, TokenHash: hashToken("reset"), Email: me.Email, ExpiresAt: api.now.Add(time.Hour),
+	}); err != nil {
+		t.Fatal(err)
+	}
+	expect(t, api.do("POST", "/api/password/reset", "", map[string]string{"token": "reset", "password": "short"}), http.StatusBadRequest, nil)
+	expect(t, api.do("POST", "/api/password/reset", "", map[string]string{"token": "reset", "password": "battery staple"}), http.StatusOK, nil)
+	expect(t, api.do("GET", "/api/me", token, nil), http.StatusUnauthorized, nil)
+
+	var login LoginResponse
+	expect(t, api.do("POST", "/api/login", "", map[string]string{
+		"email": "alice@example.org", "password": "battery staple",
+	}), http.StatusOK, &login)
+	expect(t, api.do("GET", "/api/me", login.Token, nil), http.StatusOK, &me)
+	if !me.EmailVerified {
+		t.Errorf("email not verified by the reset link: %+v", me)
+	}
+
+	expect(t, api.do("DELETE", "/api/me", login.Token, map[string]string{"password": "correct horse"}), http.StatusForbidden, nil)
+	expect(t, api.do("DELETE", "/api/me", login.Token, map[string]string{"password": "battery staple"}), http.StatusOK, nil)
+	expect(t, api.do("GET", fmt.Sprintf("/api/users/%d/tasks", userID), login.Token, nil), http.StatusUnauthorized, nil)
+}
+
+func TestAccountChangesWithoutPassword(t *testing.T) {
+	api, provider, _ := newOIDCTestAPI(t)
+	provider.setClaims(jwt.MapClaims{"email": "carol@example.com", "email_verified": true, "preferred_username": "carol"})
+	token := api.ssoLogin(provider, nil).Get("token")
+
+	// Right after the SSO login the address can change
+	expect(t, api.do("PUT", "/api/me", token, map[string]string{"email": "carol@example.org"}), http.StatusOK, nil)
+
+	// Later a stolen token alone is not enough
+	api.advance(ssoReauthWindow)
+	expect(t, api.do("PUT", "/api/me", token, map[string]string{"email": "mallory@example.com"}), http.StatusForbidden, nil)
+	expect(t, api.do("PUT", "/api/me", token, map[string]string{"password": "battery staple"}), http.StatusForbidden, nil)
+	expect(t, api.do("DELETE", "/api/me", token, nil), http.StatusForbidden, nil)
+
+	key, _ := api.enableTwoFactor(token)
+	expect(t, api.do("PUT", "/api/me", token, map[string]string{
+		"email": "mallory@example.com", "code": "000000",
+	}), http.StatusForbidden, nil)
+	var me User
+	expect(t, api.do("PUT", "/api/me", token, map[string]string{
+		"email": "carol@example.net", "code": totpCode(key, time.Now().Unix()/totpPeriod+1),
+	}), http.StatusOK, &me)
+	if me.Email != "carol@example.net" {
+		t.Errorf("email %q after the change with a code", me.Email)
+	}
+}
@@ -0,0 +1,208 @@
+package main
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// APIKey is a long-lived credential for scripts and CI, sent as a bearer
+// token like a JWT. The key itself is only returned when it is created; the
+// store keeps its hash.
+type APIKey struct {
+	ID     int64  `json:"id"`
+	UserID int64  `json:"user_id"`
+	Name   string `json:"name"`
+	// Prefix is the start of the key, to tell keys apart in listings
+	Prefix     string     `json:"prefix"`
+	Key        string     `json:"key,omitempty"`
+	KeyHash    string     `json:"-"`
+	Scopes     []string   `json:"scopes"`
+	ExpiresAt  *time.Time `json:"expires_at"` // nil never expires
+	LastUsedAt *time.Time `json:"last_used_at"`
+	CreatedAt  time.Time  `json:"created_at"`
+}
+
+// apiKeyPrefix starts every API key, which is how JWTMiddleware tells them
+// from JWTs
+const apiKeyPrefix = "sl_"
+
+// API key scopes. A key acts as its user, so it never gets more than the
+// user's role allows, and its scopes narrow that further: read makes GET
+// requests, tasks also creates, changes, moves and pings tasks, and ping
+// only pings tasks through /api/tasks/{id}/heartbeat. Keys cannot manage
+// API keys or sessions.
+const (
+	scopeRead  = "read"
+	scopeTasks = "tasks"
+	scopePing  = "ping"
+)
+
+var apiKeyScopes = map[string]bool{scopeRead: true, scopeTasks: true, scopePing: true}
+
+// apiKeyAllows reports whether a key with the given scopes may make the request
+func apiKeyAllows(scopes []string, r *http.Request) bool {
+	path := r.URL.Path
+	if route := mux.CurrentRoute(r); route != nil {
+		if template, err := route.GetPathTemplate(); err == nil {
+			path = template
+		}
+	}
+	for _, prefix := range []string{"/api/keys", "/api/sessions", "/api/logout"} {
+		if strings.HasPrefix(path, prefix) {
+			return false
+		}
+	}
+
+	ping := r.Method == http.MethodPost && path == "/api/tasks/{id}/heartbeat"
+	taskWrite := strings.HasPrefix(path, "/api/tasks") || strings.HasSuffix(path, "/tasks/bulk")
+	for _, scope := range scopes {
+		switch {
+		case scope == scopePing && ping,
+			scope == scopeTasks && (r.Method == http.MethodGet || taskWrite),
+			scope == scopeRead && r.Method == http.MethodGet:
+			return true
+		}
+	}
+	return false
+}
+
+// authenticateAPIKey resolves an API key sent as a bearer token to its user
+// and checks its scopes against the request. It writes the error response
+// itself and returns ok=false on failure.
+func (s *Server) authenticateAPIKey(w http.ResponseWriter, r *http.Request, secret string) (userID int64, ok bool) {
+	key, err := s.apiKeys.UseAPIKey(r.Context(), hashToken(secret))
+	if err != nil {
+		if errors.Is(err, ErrNotFound) {
+			respondWithError(w, http.StatusUnauthorized, "Invalid or expired API key")
+		} else {
+			log.Printf("Error checking API key: %v", err)
+			respondWithError(w, http.StatusInternalServerError, "Error checking API key")
+		}
+		return 0, false
+	}
+	if !apiKeyAllows(key.Scopes, r) {
+		respondWithError(w, http.StatusForbidden, "API key scopes do not allow this request")
+		return 0, false
+	}
+	return key.UserID, true
+}
+
+// getAPIKeys lists the API keys of the authenticated user
+func (s *Server) getAPIKeys(w http.ResponseWriter, r *http.Request) {
+	keys, err := s.apiKeys.ListAPIKeys(r.Context(), authUserID(r))
+	if err != nil {
+		log.Printf("Error retrieving API keys for user %d: %v", authUserID(r), err)
+		respondWithError(w, http.StatusInternalServerError, "Error retrieving API keys")
+		return
+	}
+	respondWithJSON(w, http.StatusOK, keys)
+}
+
+// createAPIKey issues an API key for the authenticated user. The response is
+// the only time the key is shown.
+func (s *Server) createAPIKey(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Name      string     `json:"name" validate:"required,max=255"`
+		Scopes    []string   `json:"scopes" validate:"required"`
+		ExpiresAt *time.Time `json:"expires_at"`
+	}
+	if !decodeJSON(w, r, &req) {
+		return
+	}
+
+	key := APIKey{UserID: authUserID(r), Name: strings.TrimSpace(req.Name), ExpiresAt: req.ExpiresAt}
+	seen := map[string]bool{}
+	for _, scope := range req.Scopes {
+		if !apiKeyScopes[scope] {
+			respondWithError(w, http.StatusBadRequest, "scopes must be read, tasks or ping")
+			return
+		}
+		if !seen[scope] {
+			seen[scope] = true
+			key.Scopes = append(key.Scopes, scope)
+		}
+	}
+	if len(key.Scopes) == 0 {
+		respondWithError(w, http.StatusBadRequest, "at least one scope is required")
+		return
+	}
+
+	now, err := s.monitor.Now(r.Context())
+	if err != nil {
+		log.Printf("Error reading store time: %v", err)
+		respondWithError(w, http.StatusInternalServerError, "Error creating API key")
+		return
+	}
+	if key.ExpiresAt != nil && !key.ExpiresAt.After(now) {
+		respondWithError(w, http.StatusBadRequest, "expires_at must be in the future")
+		return
+	}
+
+	secret, err := newAccessToken()
+	if err != nil {
+		log.Printf("Error generating API key: %v", err)
+		respondWithError(w, http.StatusInternalServerError, "Error creating API key")
+		return
+	}
+	key.Key = apiKeyPrefix + secret
+	key.Prefix = key.Key[:len(apiKeyPrefix)+8]
+	key.KeyHash = hashToken(key.Key)
+
+	created, err := s.apiKeys.CreateAPIKey(r.Context(), key)
+	if err != nil {
+		log.Printf("Error creating API key: %v", err)
+		respondWithError(w, http.StatusInternalServerError, "Error creating API key")
+		return
+	}
+	created.Key = key.Key
+
+	log.Printf("API key created successfully with ID: %d for user ID: %d", created.ID, created.UserID)
+	respondWithJSON(w, http.StatusCreated, created)
+}
+
+// deleteAPIKey revokes one of the authenticated user's API keys
+func (s *Server) deleteAPIKey(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	id, err := strconv.ParseInt(vars["id"], 10, 64)
+	if err != nil {
+		log.Printf("Invalid API key ID: %s", vars["id"])
+		respondWithError(w, http.StatusBadRequest, "Invalid API key ID")
+		return
+	}
+
+	if err := s.apiKeys.DeleteAPIKey(r.Context(), authUserID(r), id); err != nil {
+		if errors.Is(err, ErrNotFound) {
+			respondWithError(w, http.StatusNotFound, "API key not found")
+		} else {
+			log.Printf("Error deleting API key: %v", err)
+			respondWithError(w, http.StatusInternalServerError, "Error deleting API key")
+		}
+		return
+	}
+
+	log.Printf("API key deleted successfully: %d", id)
+	respondWithJSON(w, http.StatusOK, map[string]string{"message": "API key deleted successfully"})
+}
+
+// pingTask records a heartbeat for a task like /tasks/{task_number}/heartbeat,
+// for scripts that authenticate with a ping-only API key
+func (s *Server) pingTask(w http.ResponseWriter, r *http.Request) {
+	task, ok := s.taskFromRequest(w, r, roleEditor)
+	if !ok {
+		return
+	}
+
+	// By ID, as task numbers are not unique across users
+	if err := s.tasks.RecordTaskHeartbeat(r.Context(), task.ID); err != nil {
+		log.Printf("Error recording heartbeat for task %d: %v", task.ID, err)
+		respondWithError(w, http.StatusInternalServerError, "Error recording heartbeat")
+		return
+	}
+	respondWithJSON(w, http.StatusOK, map[string]string{"message": "Heartbeat received"})
+}
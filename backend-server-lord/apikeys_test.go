@@ -0,0 +1,112 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestStoreAPIKeys(t *testing.T) {
+	forEachStore(t, func(t *testing.T, store Store, clock *time.Time) {
+		ctx := context.Background()
+		task := createMonitorTestTask(t, store)
+
+		expires := clock.Add(time.Hour)
+		created, err := store.CreateAPIKey(ctx, APIKey{
+			UserID: task.UserID, Name: "ci", Prefix: "sl_abcdefgh", KeyHash: "hash", Scopes: []string{scopeRead, scopePing}, ExpiresAt: &expires,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if created.ID == 0 || created.LastUsedAt != nil {
+			t.Fatalf("CreateAPIKey = %+v", created)
+		}
+
+		*clock = clock.Add(time.Minute)
+		key, err := store.UseAPIKey(ctx, "hash")
+		if err != nil || key.ID != created.ID || len(key.Scopes) != 2 || key.LastUsedAt == nil || !key.LastUsedAt.Equal(*clock) {
+			t.Errorf("UseAPIKey = %+v, %v; want the key used now", key, err)
+		}
+		if keys, err := store.ListAPIKeys(ctx, task.UserID); err != nil || len(keys) != 1 || keys[0].LastUsedAt == nil {
+			t.Errorf("ListAPIKeys = %+v, %v", keys, err)
+		}
+
+		*clock = expires
+		if _, err := store.UseAPIKey(ctx, "hash"); !errors.Is(err, ErrNotFound) {
+			t.Errorf("UseAPIKey of an expired key: %v, want ErrNotFound", err)
+		}
+		if err := store.DeleteAPIKey(ctx, task.UserID+1, created.ID); !errors.Is(err, ErrNotFound) {
+			t.Errorf("DeleteAPIKey of another user: %v, want ErrNotFound", err)
+		}
+		if err := store.DeleteAPIKey(ctx, task.UserID, created.ID); err != nil {
+			t.Fatal(err)
+		}
+	})
+}
+
+func TestAPIKeyAPI(t *testing.T) {
+	api := newTestAPI(t)
+	aliceID, alice := api.signup("alice")
+	bobID, bob := api.signup("bob")
+	task := api.createTask(alice, aliceID, "backup", 7, 60)
+	// Task numbers are not unique across users
+	other := api.createTask(bob, bobID, "backup", 7, 60)
+
+	for _, body := range []map[string]interface{}{
+		{"name": "ci"},
+		{"name": "", "scopes": []string{scopeRead}},
+		{"name": "ci", "scopes": []string{"admin"}},
+		{"name": "ci", "scopes": []string{scopeRead}, "expires_at": api.now.Add(-time.Hour)},
+	} {
+		expect(t, api.do("POST", "/api/keys", alice, body), http.StatusBadRequest, nil)
+	}
+
+	newKey := func(scopes ...string) APIKey {
+		t.Helper()
+		var key APIKey
+		expect(t, api.do("POST", "/api/keys", alice, map[string]interface{}{"name": "ci", "scopes": scopes}), http.StatusCreated, &key)
+		if !strings.HasPrefix(key.Key, apiKeyPrefix) || !strings.HasPrefix(key.Key, key.Prefix) {
+			t.Fatalf("created key %+v", key)
+		}
+		return key
+	}
+	read, ping, tasks := newKey(scopeRead), newKey(scopePing), newKey(scopeTasks)
+
+	taskPath := fmt.Sprintf("/api/tasks/%d", task.ID)
+	expect(t, api.do("GET", taskPath, read.Key, nil), http.StatusOK, nil)
+	expect(t, api.do("GET", taskPath, ping.Key, nil), http.StatusForbidden, nil)
+	expect(t, api.do("POST", taskPath+"/heartbeat", read.Key, nil), http.StatusForbidden, nil)
+	expect(t, api.do("DELETE", taskPath, read.Key, nil), http.StatusForbidden, nil)
+
+	// Keys act as their user
+	expect(t, api.do("GET", fmt.Sprintf("/api/tasks/%d", other.ID), read.Key, nil), http.StatusForbidden, nil)
+	// and cannot manage keys
+	expect(t, api.do("GET", "/api/keys", tasks.Key, nil), http.StatusForbidden, nil)
+
+	api.advance(90 * time.Second)
+	monitorPass(t, api.store, nil)
+	expect(t, api.do("POST", taskPath+"/heartbeat", ping.Key, nil), http.StatusOK, nil)
+	if got, _ := api.store.GetTask(context.Background(), task.ID); got.Status != "alive" || !got.LastPing.Equal(api.now) {
+		t.Errorf("pinged task %+v, want alive", got)
+	}
+	if got, _ := api.store.GetTask(context.Background(), other.ID); got.Status != "dead" {
+		t.Errorf("task with the same number of another user is %s, want dead", got.Status)
+	}
+
+	expect(t, api.do("PUT", taskPath, tasks.Key, map[string]interface{}{
+		"name": "nightly backup", "interval": 120, "task_number": 7,
+	}), http.StatusOK, nil)
+
+	var keys []APIKey
+	expect(t, api.do("GET", "/api/keys", alice, nil), http.StatusOK, &keys)
+	if len(keys) != 3 || keys[0].Key != "" {
+		t.Errorf("listed keys %+v, want 3 without their secret", keys)
+	}
+	expect(t, api.do("DELETE", fmt.Sprintf("/api/keys/%d", read.ID), bob, nil), http.StatusNotFound, nil)
+	expect(t, api.do("DELETE", fmt.Sprintf("/api/keys/%d", read.ID), alice, nil), http.StatusOK, nil)
+	expect(t, api.do("GET", taskPath, read.Key, nil), http.StatusUnauthorized, nil)
+}
@@ -0,0 +1,198 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/gorilla/mux"
+)
+
+// contextKey types the values the middleware stores in request contexts
+type contextKey string
+
+// Context keys of the user authenticated by JWTMiddleware and of the session
+// their access token belongs to (none for API keys)
+const (
+	userIDContextKey    contextKey = "user_id"
+	sessionIDContextKey contextKey = "session_id"
+)
+
+// authUserID returns the ID of the user authenticated by JWTMiddleware, or 0
+func authUserID(r *http.Request) int64 {
+	id, _ := r.Context().Value(userIDContextKey).(int64)
+	return id
+}
+
+// withUserID returns a copy of ctx carrying the authenticated user's ID
+func withUserID(ctx context.Context, userID int64) context.Context {
+	return context.WithValue(ctx, userIDContextKey, userID)
+}
+
+// authSessionID returns the session of the request's access token, or 0
+func authSessionID(r *http.Request) int64 {
+	id, _ := r.Context().Value(sessionIDContextKey).(int64)
+	return id
+}
+
+// withSessionID returns a copy of ctx carrying the access token's session ID
+func withSessionID(ctx context.Context, sessionID int64) context.Context {
+	return context.WithValue(ctx, sessionIDContextKey, sessionID)
+}
+
+// hashToken returns the hex SHA-256 of a secret token, which is what the
+// stores keep instead of the token
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// Roles of organization members, each granting the rights of those below it:
+// viewers read everything of the organization, editors change its tasks,
+// status pages, badges, SLOs and notification routes, admins manage members
+// and invitations, and owners can also delete the organization and appoint
+// other owners.
+const (
+	roleViewer = "viewer"
+	roleEditor = "editor"
+	roleAdmin  = "admin"
+	roleOwner  = "owner"
+)
+
+var roleRanks = map[string]int{roleViewer: 1, roleEditor: 2, roleAdmin: 3, roleOwner: 4}
+
+// hasRole reports whether role grants at least the rights of required
+func hasRole(role, required string) bool {
+	return roleRanks[role] >= roleRanks[required] && roleRanks[role] > 0
+}
+
+// roleOn returns the role of userID on something owned by the organization
+// orgID, or personally by ownerID when orgID is 0. Users own their personal
+// things; anything else they cannot access gets "". Members of an
+// organization that requires two-factor authentication get
+// errTwoFactorRequired until they enable it.
+func (s *Server) roleOn(ctx context.Context, userID, ownerID, orgID int64) (string, error) {
+	if userID == 0 {
+		return "", nil
+	}
+	if orgID == 0 {
+		if userID == ownerID {
+			return roleOwner, nil
+		}
+		return "", nil
+	}
+	role, err := s.orgs.GetOrgRole(ctx, orgID, userID)
+	if errors.Is(err, ErrNotFound) {
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+	if err := s.checkOrgTwoFactor(ctx, orgID, userID); err != nil {
+		return "", err
+	}
+	return role, nil
+}
+
+// authorize checks that the authenticated user has at least the role
+// required on something owned by the organization orgID, or personally by
+// ownerID when orgID is 0. It writes the error response itself and returns
+// false when the check fails.
+func (s *Server) authorize(w http.ResponseWriter, r *http.Request, ownerID, orgID int64, required string) bool {
+	role, err := s.roleOn(r.Context(), authUserID(r), ownerID, orgID)
+	if errors.Is(err, errTwoFactorRequired) {
+		respondWithError(w, http.StatusForbidden, "This organization requires two-factor authentication; enable it on your account first")
+		return false
+	}
+	if err != nil {
+		log.Printf("Error checking permissions: %v", err)
+		respondWithError(w, http.StatusInternalServerError, "Error checking permissions")
+		return false
+	}
+	if !hasRole(role, required) {
+		respondWithError(w, http.StatusForbidden, "Insufficient permissions")
+		return false
+	}
+	return true
+}
+
+// taskFromRequest loads the task named by the {id} path variable and checks
+// that the authenticated user has at least the required role on it. It writes
+// the error response itself and returns ok=false on failure.
+func (s *Server) taskFromRequest(w http.ResponseWriter, r *http.Request, required string) (Task, bool) {
+	vars := mux.Vars(r)
+	id, err := strconv.ParseInt(vars["id"], 10, 64)
+	if err != nil {
+		log.Printf("Invalid task ID: %s", vars["id"])
+		respondWithError(w, http.StatusBadRequest, "Invalid task ID")
+		return Task{}, false
+	}
+
+	task, err := s.tasks.GetTask(r.Context(), id)
+	if err != nil {
+		if errors.Is(err, ErrNotFound) {
+			log.Printf("Task not found with ID: %d", id)
+			respondWithError(w, http.StatusNotFound, "Task not found")
+		} else {
+			log.Printf("Error retrieving task: %v", err)
+			respondWithError(w, http.StatusInternalServerError, "Error retrieving task")
+		}
+		return Task{}, false
+	}
+	if !s.authorize(w, r, task.UserID, task.OrgID, required) {
+		return Task{}, false
+	}
+	return task, true
+}
+
+// taskOwnerFromRequest reads whose tasks a request is about from the
+// {user_id} or {org_id} path variable and checks that the authenticated user
+// has at least the required role on them. It returns the user ID and
+// organization ID to list tasks with, and writes the error response itself
+// and returns ok=false on failure.
+func (s *Server) taskOwnerFromRequest(w http.ResponseWriter, r *http.Request, required string) (userID, orgID int64, ok bool) {
+	vars := mux.Vars(r)
+	if raw, isOrg := vars["org_id"]; isOrg {
+		orgID, err := strconv.ParseInt(raw, 10, 64)
+		if err != nil {
+			log.Printf("Invalid organization ID: %s", raw)
+			respondWithError(w, http.StatusBadRequest, "Invalid organization ID")
+			return 0, 0, false
+		}
+		return 0, orgID, s.authorize(w, r, 0, orgID, required)
+	}
+
+	userID, err := strconv.ParseInt(vars["user_id"], 10, 64)
+	if err != nil {
+		log.Printf("Invalid user ID: %s", vars["user_id"])
+		respondWithError(w, http.StatusBadRequest, "Invalid user ID")
+		return 0, 0, false
+	}
+	return userID, 0, s.authorize(w, r, userID, 0, required)
+}
+
+// ownerFromQuery reads the owner of a listing from ?org_id= or else ?user_id=
+// and checks that the authenticated user has at least the required role on
+// it. It writes the error response itself and returns ok=false on failure.
+func (s *Server) ownerFromQuery(w http.ResponseWriter, r *http.Request, required string) (userID, orgID int64, ok bool) {
+	query := r.URL.Query()
+	if raw := query.Get("org_id"); raw != "" {
+		orgID, err := strconv.ParseInt(raw, 10, 64)
+		if err != nil || orgID <= 0 {
+			respondWithError(w, http.StatusBadRequest, "Invalid org_id")
+			return 0, 0, false
+		}
+		return 0, orgID, s.authorize(w, r, 0, orgID, required)
+	}
+
+	userID, err := strconv.ParseInt(query.Get("user_id"), 10, 64)
+	if err != nil || userID <= 0 {
+		respondWithError(w, http.StatusBadRequest, "user_id or org_id is required")
+		return 0, 0, false
+	}
+	return userID, 0, s.authorize(w, r, userID, 0, required)
+}
@@ -0,0 +1,308 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"html"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// Badge is a read-only token that renders a task's status as an embeddable
+// badge at /badge/{token}.svg, or as a shields.io endpoint at /badge/{token}.json.
+// A badge covers a single task (TaskID), which stays visible as long as the
+// user who created the badge may view the task, or every personal task of the
+// user carrying Tag or belonging to Project.
+type Badge struct {
+	ID         int64     `json:"id"`
+	UserID     int64     `json:"user_id"`
+	TaskID     int64     `json:"task_id,omitempty"`
+	Tag        string    `json:"tag,omitempty"`
+	Project    string    `json:"project,omitempty"`
+	Label      string    `json:"label"` // defaults to the task name, tag or project
+	ShowUptime bool      `json:"show_uptime"`
+	Token      string    `json:"token"`
+	CreatedAt  time.Time `json:"created_at"`
+}
+
+// createBadgeRequest is the body of POST /api/badges
+type createBadgeRequest struct {
+	UserID     int64  `json:"user_id" validate:"min=1"`
+	TaskID     int64  `json:"task_id" validate:"min=1"`
+	Tag        string `json:"tag" validate:"max=64"`
+	Project    string `json:"project" validate:"max=255"`
+	Label      string `json:"label" validate:"max=100"`
+	ShowUptime bool   `json:"show_uptime"`
+}
+
+// badgeColors maps the shields.io color names used by the JSON endpoint to
+// the colors drawn in the SVG
+var badgeColors = map[string]string{
+	"brightgreen": "#4c1",
+	"red":         "#e05d44",
+	"lightgrey":   "#9f9f9f",
+}
+
+// badgeMessage describes the status of the tasks written by checkTaskStatus,
+// with the uptime from their counters when requested, and the shields.io
+// color for it. A group is down as soon as one of its tasks is.
+func badgeMessage(tasks []Task, showUptime bool) (string, string) {
+	if len(tasks) == 0 {
+		return "no tasks", "lightgrey"
+	}
+
+	var alive, dead int
+	var uptime, downtime float64
+	for _, task := range tasks {
+		switch task.Status {
+		case "alive":
+			alive++
+		case "dead":
+			dead++
+		}
+		uptime += task.UptimeSeconds
+		downtime += task.DowntimeSeconds
+	}
+
+	message, color := tasks[0].Status, "lightgrey"
+	switch {
+	case dead > 0 && len(tasks) == 1:
+		message, color = "down", "red"
+	case dead > 0:
+		message, color = fmt.Sprintf("%d/%d down", dead, len(tasks)), "red"
+	case alive > 0:
+		message, color = "up", "brightgreen"
+	case len(tasks) > 1:
+		message = "paused"
+	}
+
+	if tracked := uptime + downtime; showUptime && tracked > 0 {
+		message += fmt.Sprintf(" %.2f%%", uptime/tracked*100)
+	}
+	return message, color
+}
+
+// badgeTasks loads the tasks a badge covers and the label they go by
+func (s *Server) badgeTasks(ctx context.Context, badge Badge) ([]Task, string, error) {
+	if badge.TaskID == 0 {
+		label := badge.Tag
+		if label == "" {
+			label = badge.Project
+		}
+		tasks, err := s.tasks.ListUserTasks(ctx, badge.UserID, TaskFilter{Tag: badge.Tag, Project: badge.Project})
+		return tasks, label, err
+	}
+
+	task, err := s.tasks.GetTask(ctx, badge.TaskID)
+	if err != nil {
+		return nil, "", ErrNotFound
+	}
+	role, err := s.roleOn(ctx, badge.UserID, task.UserID, task.OrgID)
+	if errors.Is(err, errTwoFactorRequired) {
+		return nil, "", ErrNotFound
+	}
+	if err != nil {
+		return nil, "", err
+	}
+	if !hasRole(role, roleViewer) {
+		return nil, "", ErrNotFound
+	}
+	return []Task{task}, task.Name, nil
+}
+
+// badgeTextWidth approximates the width of text in 11px Verdana
+func badgeTextWidth(text string) int {
+	width := 0.0
+	for _, r := range text {
+		switch {
+		case r == ' ' || r == '.' || r == ',' || r == ':' || r == 'i' || r == 'l' || r == 'j' || r == '|':
+			width += 3.5
+		case r >= 'A' && r <= 'Z' || r == '%' || r == 'm' || r == 'w':
+			width += 9
+		default:
+			width += 7
+		}
+	}
+	return int(width + 0.5)
+}
+
+// renderBadgeSVG draws a flat two-part badge in the style of shields.io
+func renderBadgeSVG(label, message, color string) []byte {
+	labelWidth := badgeTextWidth(label) + 10
+	messageWidth := badgeTextWidth(message) + 10
+	width := labelWidth + messageWidth
+	label, message = html.EscapeString(label), html.EscapeString(message)
+
+	return []byte(fmt.Sprintf(`<svg xmlns="http://www.w3.org/2000/svg" width="%[1]d" height="20" role="img" aria-label="%[4]s: %[5]s">`+
+		`<title>%[4]s: %[5]s</title>`+
+		`<linearGradient id="s" x2="0" y2="100%%"><stop offset="0" stop-color="#bbb" stop-opacity=".1"/><stop offset="1" stop-opacity=".1"/></linearGradient>`+
+		`<clipPath id="r"><rect width="%[1]d" height="20" rx="3" fill="#fff"/></clipPath>`+
+		`<g clip-path="url(#r)"><rect width="%[2]d" height="20" fill="#555"/><rect x="%[2]d" width="%[3]d" height="20" fill="%[6]s"/><rect width="%[1]d" height="20" fill="url(#s)"/></g>`+
+		`<g fill="#fff" text-anchor="middle" font-family="Verdana,Geneva,DejaVu Sans,sans-serif" font-size="11">`+
+		`<text x="%[7]d" y="15" fill="#010101" fill-opacity=".3">%[4]s</text><text x="%[7]d" y="14">%[4]s</text>`+
+		`<text x="%[8]d" y="15" fill="#010101" fill-opacity=".3">%[5]s</text><text x="%[8]d" y="14">%[5]s</text>`+
+		`</g></svg>`,
+		width, labelWidth, messageWidth, label, message, badgeColors[color], labelWidth/2, labelWidth+messageWidth/2))
+}
+
+// serveBadge renders /badge/{token}.svg and /badge/{token}.json. Unknown
+// tokens still get a badge, so embeds show why instead of a broken image.
+func (s *Server) serveBadge(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	label, message, color := "status", "not found", "lightgrey"
+	code := http.StatusNotFound
+
+	badge, err := s.badges.GetBadgeByToken(r.Context(), vars["token"])
+	switch {
+	case err == nil:
+		tasks, name, err := s.badgeTasks(r.Context(), badge)
+		if err == nil {
+			label = name
+			message, color = badgeMessage(tasks, badge.ShowUptime)
+			code = http.StatusOK
+		} else if !errors.Is(err, ErrNotFound) {
+			log.Printf("Error retrieving tasks for badge %d: %v", badge.ID, err)
+			message, code = "error", http.StatusInternalServerError
+		}
+		if badge.Label != "" {
+			label = badge.Label
+		}
+	case !errors.Is(err, ErrNotFound):
+		log.Printf("Error retrieving badge: %v", err)
+		message, code = "error", http.StatusInternalServerError
+	}
+
+	// Badges are embedded through caching proxies, keep them current
+	w.Header().Set("Cache-Control", "no-cache, max-age=0")
+	if vars["ext"] == "json" {
+		respondWithJSON(w, code, map[string]interface{}{
+			"schemaVersion": 1,
+			"label":         label,
+			"message":       message,
+			"color":         color,
+		})
+		return
+	}
+
+	w.Header().Set("Content-Type", "image/svg+xml")
+	w.WriteHeader(code)
+	w.Write(renderBadgeSVG(label, message, color))
+}
+
+// getBadges lists the badges of a user (?user_id=)
+func (s *Server) getBadges(w http.ResponseWriter, r *http.Request) {
+	userID, err := strconv.ParseInt(r.URL.Query().Get("user_id"), 10, 64)
+	if err != nil || userID <= 0 {
+		respondWithError(w, http.StatusBadRequest, "user_id is required")
+		return
+	}
+	if !s.authorize(w, r, userID, 0, roleViewer) {
+		return
+	}
+
+	badges, err := s.badges.ListBadges(r.Context(), userID)
+	if err != nil {
+		log.Printf("Error retrieving badges for user %d: %v", userID, err)
+		respondWithError(w, http.StatusInternalServerError, "Error retrieving badges")
+		return
+	}
+	respondWithJSON(w, http.StatusOK, badges)
+}
+
+// createBadge issues a badge token for a task the authenticated user may edit
+// (task_id), or for their personal tasks with a tag or in a project
+func (s *Server) createBadge(w http.ResponseWriter, r *http.Request) {
+	var req createBadgeRequest
+	if !decodeJSON(w, r, &req) {
+		return
+	}
+	badge := Badge{
+		UserID:     req.UserID,
+		TaskID:     req.TaskID,
+		Tag:        strings.ToLower(strings.TrimSpace(req.Tag)),
+		Project:    strings.TrimSpace(req.Project),
+		Label:      req.Label,
+		ShowUptime: req.ShowUptime,
+	}
+	scopes := 0
+	for _, set := range []bool{badge.TaskID != 0, badge.Tag != "", badge.Project != ""} {
+		if set {
+			scopes++
+		}
+	}
+	if scopes != 1 {
+		respondWithError(w, http.StatusBadRequest, "exactly one of task_id, tag and project is required")
+		return
+	}
+
+	if badge.UserID == 0 {
+		badge.UserID = authUserID(r)
+	}
+	if badge.UserID != authUserID(r) {
+		respondWithError(w, http.StatusForbidden, "Insufficient permissions")
+		return
+	}
+
+	var err error
+	if badge.TaskID != 0 {
+		task, err := s.tasks.GetTask(r.Context(), badge.TaskID)
+		if err != nil {
+			if errors.Is(err, ErrNotFound) {
+				respondWithError(w, http.StatusBadRequest, "Task does not exist")
+			} else {
+				log.Printf("Error retrieving task: %v", err)
+				respondWithError(w, http.StatusInternalServerError, "Error creating badge")
+			}
+			return
+		}
+		if !s.authorize(w, r, task.UserID, task.OrgID, roleEditor) {
+			return
+		}
+	}
+
+	if badge.Token, err = newAccessToken(); err != nil {
+		log.Printf("Error generating badge token: %v", err)
+		respondWithError(w, http.StatusInternalServerError, "Error creating badge")
+		return
+	}
+
+	created, err := s.badges.CreateBadge(r.Context(), badge)
+	if err != nil {
+		log.Printf("Error creating badge: %v", err)
+		respondWithError(w, http.StatusInternalServerError, "Error creating badge")
+		return
+	}
+
+	log.Printf("Badge created successfully with ID: %d for user ID: %d", created.ID, created.UserID)
+	respondWithJSON(w, http.StatusCreated, created)
+}
+
+func (s *Server) deleteBadge(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	id, err := strconv.ParseInt(vars["id"], 10, 64)
+	if err != nil {
+		log.Printf("Invalid badge ID: %s", vars["id"])
+		respondWithError(w, http.StatusBadRequest, "Invalid badge ID")
+		return
+	}
+
+	// Users only see their own badges, so anyone else's are not found
+	if err := s.badges.DeleteBadge(r.Context(), authUserID(r), id); err != nil {
+		if errors.Is(err, ErrNotFound) {
+			respondWithError(w, http.StatusNotFound, "Badge not found")
+		} else {
+			log.Printf("Error deleting badge: %v", err)
+			respondWithError(w, http.StatusInternalServerError, "Error deleting badge")
+		}
+		return
+	}
+
+	log.Printf("Badge deleted successfully: %d", id)
+	respondWithJSON(w, http.StatusOK, map[string]string{"message": "Badge deleted successfully"})
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestBadgeMessage(t *testing.T) {
+	for _, tc := range []struct {
+		tasks          []Task
+		showUptime     bool
+		message, color string
+	}{
+		{[]Task{{Status: "alive", UptimeSeconds: 990, DowntimeSeconds: 10}}, false, "up", "brightgreen"},
+		{[]Task{{Status: "alive", UptimeSeconds: 990, DowntimeSeconds: 10}}, true, "up 99.00%", "brightgreen"},
+		{[]Task{{Status: "dead", UptimeSeconds: 1, DowntimeSeconds: 3}}, true, "down 25.00%", "red"},
+		// Nothing tracked yet: no uptime to show
+		{[]Task{{Status: "alive"}}, true, "up", "brightgreen"},
+		{[]Task{{Status: "paused"}}, false, "paused", "lightgrey"},
+		// Tag and project badges sum the time of every task
+		{[]Task{{Status: "alive", UptimeSeconds: 3}, {Status: "dead", UptimeSeconds: 2, DowntimeSeconds: 5}}, true, "1/2 down 50.00%", "red"},
+		{[]Task{{Status: "alive"}, {Status: "paused"}}, false, "up", "brightgreen"},
+		{[]Task{{Status: "paused"}, {Status: "paused"}}, false, "paused", "lightgrey"},
+		{nil, true, "no tasks", "lightgrey"},
+	} {
+		message, color := badgeMessage(tc.tasks, tc.showUptime)
+		if message != tc.message || color != tc.color {
+			t.Errorf("badgeMessage(%+v, %v) = %q, %q; want %q, %q", tc.tasks, tc.showUptime, message, color, tc.message, tc.color)
+		}
+	}
+}
+
+func TestStoreDeleteBadge(t *testing.T) {
+	forEachStore(t, func(t *testing.T, store Store, clock *time.Time) {
+		ctx := context.Background()
+		task := createMonitorTestTask(t, store)
+		created, err := store.CreateBadge(ctx, Badge{UserID: task.UserID, TaskID: task.ID, Token: "token"})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if err := store.DeleteBadge(ctx, task.UserID+1, created.ID); !errors.Is(err, ErrNotFound) {
+			t.Errorf("DeleteBadge of another user: %v, want ErrNotFound", err)
+		}
+		if err := store.DeleteBadge(ctx, task.UserID, created.ID); err != nil {
+			t.Fatal(err)
+		}
+		if err := store.DeleteBadge(ctx, task.UserID, created.ID); !errors.Is(err, ErrNotFound) {
+			t.Errorf("DeleteBadge of a deleted badge: %v, want ErrNotFound", err)
+		}
+	})
+}
+
+func TestBadgeAPI(t *testing.T) {
+	api := newTestAPI(t)
+	userID, token := api.signup("alice")
+	task := api.createTask(token, userID, "backup", 7, 60)
+
+	expect(t, api.do("POST", "/api/badges", token, map[string]interface{}{"task_id": task.ID + 1}), http.StatusBadRequest, nil)
+
+	var badge Badge
+	expect(t, api.do("POST", "/api/badges", token, map[string]interface{}{
+		"task_id": task.ID, "show_uptime": true,
+	}), http.StatusCreated, &badge)
+	if badge.Token == "" || badge.UserID != int64(userID) {
+		t.Fatalf("created badge %+v", badge)
+	}
+
+	api.advance(30 * time.Second)
+	monitorPass(t, api.store, nil)
+
+	// Badges are served without a login
+	rec := api.do("GET", "/badge/"+badge.Token+".svg", "", nil)
+	if rec.Code != http.StatusOK || rec.Header().Get("Content-Type") != "image/svg+xml" {
+		t.Fatalf("svg badge: %d %q", rec.Code, rec.Header().Get("Content-Type"))
+	}
+	if body := rec.Body.String(); !strings.Contains(body, "<title>backup: up 100.00%</title>") {
+		t.Errorf("svg badge %s", body)
+	}
+
+	var shield map[string]interface{}
+	expect(t, api.do("GET", "/badge/"+badge.Token+".json", "", nil), http.StatusOK, &shield)
+	if shield["label"] != "backup" || shield["message"] != "up 100.00%" || shield["color"] != "brightgreen" {
+		t.Errorf("shields.io badge %+v", shield)
+	}
+	expect(t, api.do("GET", "/badge/unknown.json", "", nil), http.StatusNotFound, &shield)
+	if shield["message"] != "not found" {
+		t.Errorf("badge of an unknown token %+v", shield)
+	}
+
+	var badges []Badge
+	expect(t, api.do("GET", fmt.Sprintf("/api/badges?user_id=%d", userID), token, nil), http.StatusOK, &badges)
+	if len(badges) != 1 || badges[0].ID != badge.ID {
+		t.Errorf("listed badges %+v", badges)
+	}
+	expect(t, api.do("DELETE", fmt.Sprintf("/api/badges/%d", badge.ID), token, nil), http.StatusOK, nil)
+	expect(t, api.do("GET", "/badge/"+badge.Token+".svg", "", nil), http.StatusNotFound, nil)
+}
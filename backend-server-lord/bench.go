@@ -0,0 +1,125 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v4"
+	"github.com/jackc/pgx/v4/pgxpool"
+)
+
+// runBenchmark measures monitor write throughput. It seeds the requested number
+// of tasks for a throwaway user, times full monitor passes over the tasks table
+// and removes the seeded rows afterwards. Point DATABASE_URL at a scratch
+// database: existing tasks are processed (and counted) too.
+//
+// Usage: task-tracker bench -tasks 10000,100000 -runs 3
+func runBenchmark(args []string) {
+	fs := flag.NewFlagSet("bench", flag.ExitOnError)
+	sizesFlag := fs.String("tasks", "10000,100000", "comma separated task counts to benchmark")
+	runs := fs.Int("runs", 3, "monitor passes per task count")
+	fs.Parse(args)
+
+	var sizes []int
+	for _, s := range strings.Split(*sizesFlag, ",") {
+		n, err := strconv.Atoi(strings.TrimSpace(s))
+		if err != nil || n <= 0 {
+			log.Fatalf("Invalid task count %q", s)
+		}
+		sizes = append(sizes, n)
+	}
+
+	ctx := context.Background()
+
+	store, err := openPostgresStore(ctx, databaseURL())
+	if err != nil {
+		log.Fatal("Error connecting to database: ", err)
+	}
+	defer store.Close()
+	db := store.pool
+
+	if err := initDB(store); err != nil {
+		log.Fatal("Error initializing the database: ", err)
+	}
+
+	printShardReports = false
+
+	var userID int64
+	benchName := fmt.Sprintf("bench_%d", time.Now().UnixNano())
+	err = db.QueryRow(ctx,
+		"INSERT INTO users(username, email, password) VALUES($1, $2, '') RETURNING id",
+		benchName, benchName+"@bench.local").Scan(&userID)
+	if err != nil {
+		log.Fatal("Error creating benchmark user: ", err)
+	}
+	defer func() {
+		if _, err := db.Exec(ctx, "DELETE FROM tasks WHERE user_id = $1", userID); err != nil {
+			log.Printf("Error removing benchmark tasks: %v", err)
+		}
+		if _, err := db.Exec(ctx, "DELETE FROM users WHERE id = $1", userID); err != nil {
+			log.Printf("Error removing benchmark user: %v", err)
+		}
+	}()
+
+	fmt.Printf("%-10s | %-5s | %-12s | %-12s | %-12s\n", "Tasks", "Run", "Processed", "Duration", "Tasks/sec")
+	fmt.Println(strings.Repeat("-", 62))
+
+	for _, size := range sizes {
+		if err := seedBenchmarkTasks(ctx, db, userID, size); err != nil {
+			log.Fatal("Error seeding benchmark tasks: ", err)
+		}
+
+		for run := 1; run <= *runs; run++ {
+			start := time.Now()
+			result, err := monitorShard(ctx, store, "tasks", nil)
+			if err != nil {
+				log.Fatal("Error running monitor pass: ", err)
+			}
+			elapsed := time.Since(start)
+
+			fmt.Printf("%-10d | %-5d | %-12d | %-12s | %-12.0f\n",
+				size, run, result.Updated, elapsed.Round(time.Millisecond),
+				float64(result.Updated)/elapsed.Seconds())
+		}
+
+		if _, err := db.Exec(ctx, "DELETE FROM tasks WHERE user_id = $1", userID); err != nil {
+			log.Fatal("Error removing benchmark tasks: ", err)
+		}
+	}
+}
+
+// seedBenchmarkTasks bulk loads n tasks for the benchmark user. Every other task
+// has an overdue last ping so that each pass exercises both alive and dead rows.
+func seedBenchmarkTasks(ctx context.Context, db *pgxpool.Pool, userID int64, n int) error {
+	now := time.Now().UTC()
+	rows := make([][]interface{}, n)
+	for i := 0; i < n; i++ {
+		lastPing := now
+		if i%2 == 1 {
+			lastPing = now.Add(-2 * time.Minute)
+		}
+		rows[i] = []interface{}{
+			fmt.Sprintf("bench-task-%d", i),
+			"",
+			userID,
+			lastPing,
+			60,
+			1_000_000_000 + i,
+			"alive",
+			now,
+		}
+	}
+
+	_, err := db.CopyFrom(
+		ctx,
+		pgx.Identifier{"tasks"},
+		[]string{"name", "ping_url", "user_id", "last_ping", "interval", "task_number", "status", "last_checked"},
+		pgx.CopyFromRows(rows),
+	)
+	return err
+}
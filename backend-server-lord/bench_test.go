@@ -0,0 +1,109 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"testing"
+	"time"
+)
+
+// benchmarkSizes are the task counts the monitor is benchmarked with, the
+// defaults of the bench command
+var benchmarkSizes = []int{10000, 100000}
+
+// benchmarkMonitor times full monitor passes over every partition of the
+// store and reports the tasks processed per second
+func benchmarkMonitor(b *testing.B, store MonitorStore) {
+	ctx := context.Background()
+	printShardReports = false
+	b.Cleanup(func() { printShardReports = true })
+
+	partitions, err := store.MonitorPartitions(ctx)
+	if err != nil {
+		b.Fatal(err)
+	}
+	processed := 0
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		for _, partition := range partitions {
+			result, err := monitorShard(ctx, store, partition, nil)
+			if err != nil {
+				b.Fatal(err)
+			}
+			processed += result.Updated
+		}
+	}
+	b.ReportMetric(float64(processed)/b.Elapsed().Seconds(), "tasks/s")
+}
+
+// BenchmarkMonitorMemory runs the monitor over the in-memory store, with
+// every other task overdue so that passes see both alive and dead tasks
+func BenchmarkMonitorMemory(b *testing.B) {
+	for _, size := range benchmarkSizes {
+		b.Run(fmt.Sprintf("tasks=%d", size), func(b *testing.B) {
+			ctx := context.Background()
+			store := NewMemoryStore()
+			user, err := store.CreateUser(ctx, "bench", "bench@bench.local", "")
+			if err != nil {
+				b.Fatal(err)
+			}
+			now := time.Now().UTC()
+			for i := 0; i < size; i++ {
+				created := now
+				if i%2 == 1 {
+					created = now.Add(-2 * time.Minute)
+				}
+				store.now = func() time.Time { return created }
+				task := Task{Name: fmt.Sprintf("bench-task-%d", i), UserID: int64(user.ID), Interval: 60, TaskNumber: 1_000_000_000 + i}
+				if _, err := store.CreateTask(ctx, task); err != nil {
+					b.Fatal(err)
+				}
+			}
+			store.now = func() time.Time { return time.Now().UTC() }
+
+			benchmarkMonitor(b, store)
+		})
+	}
+}
+
+// BenchmarkMonitorPostgres runs the monitor over the Postgres database in
+// BENCH_DATABASE_URL, and is skipped without one. Use a scratch database:
+// the tasks already in it are processed too.
+func BenchmarkMonitorPostgres(b *testing.B) {
+	dbURL := os.Getenv("BENCH_DATABASE_URL")
+	if dbURL == "" {
+		b.Skip("BENCH_DATABASE_URL is not set")
+	}
+	ctx := context.Background()
+	store, err := openPostgresStore(ctx, dbURL)
+	if err != nil {
+		b.Fatal(err)
+	}
+	b.Cleanup(store.Close)
+	if err := store.Migrate(ctx); err != nil {
+		b.Fatal(err)
+	}
+
+	for _, size := range benchmarkSizes {
+		b.Run(fmt.Sprintf("tasks=%d", size), func(b *testing.B) {
+			benchName := fmt.Sprintf("bench_%d", time.Now().UnixNano())
+			var userID int64
+			err := store.pool.QueryRow(ctx,
+				"INSERT INTO users(username, email, password) VALUES($1, $2, '') RETURNING id",
+				benchName, benchName+"@bench.local").Scan(&userID)
+			if err != nil {
+				b.Fatal(err)
+			}
+			b.Cleanup(func() {
+				store.pool.Exec(ctx, "DELETE FROM tasks WHERE user_id = $1", userID)
+				store.pool.Exec(ctx, "DELETE FROM users WHERE id = $1", userID)
+			})
+			if err := seedBenchmarkTasks(ctx, store.pool, userID, size); err != nil {
+				b.Fatal(err)
+			}
+
+			benchmarkMonitor(b, store)
+		})
+	}
+}
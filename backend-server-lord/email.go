@@ -0,0 +1,60 @@
+package main
+
+import (
+	"fmt"
+	"net/mail"
+	"net/smtp"
+	"os"
+	"strings"
+)
+
+// sendEmail sends a message through the SMTP server configured with SMTP_HOST,
+// SMTP_PORT (default 587), SMTP_USERNAME, SMTP_PASSWORD and SMTP_FROM.
+// contentType is the MIME type of body, e.g. "text/plain" or "text/html".
+func sendEmail(to, subject, contentType, body string) error {
+	host := os.Getenv("SMTP_HOST")
+	if host == "" {
+		return fmt.Errorf("SMTP_HOST is not set")
+	}
+	port := os.Getenv("SMTP_PORT")
+	if port == "" {
+		port = "587"
+	}
+	from := os.Getenv("SMTP_FROM")
+	if from == "" {
+		from = "serverlord@" + host
+	}
+
+	var auth smtp.Auth
+	if username := os.Getenv("SMTP_USERNAME"); username != "" {
+		auth = smtp.PlainAuth("", username, os.Getenv("SMTP_PASSWORD"), host)
+	}
+
+	// The subject carries user input, keep it on one header line
+	subject = strings.NewReplacer("\r", " ", "\n", " ").Replace(subject)
+
+	msg := "From: " + from + "\r\n" +
+		"To: " + to + "\r\n" +
+		"Subject: " + subject + "\r\n" +
+		"MIME-Version: 1.0\r\n" +
+		"Content-Type: " + contentType + "; charset=utf-8\r\n" +
+		"\r\n" + body + "\r\n"
+	return smtp.SendMail(host+":"+port, auth, from, []string{to}, []byte(msg))
+}
+
+// validEmail reports whether addr is a bare email address (no display name),
+// safe to use in a header
+func validEmail(addr string) bool {
+	parsed, err := mail.ParseAddress(addr)
+	return err == nil && parsed.Address == addr
+}
+
+// appURL returns the link to path on the dashboard for emails. APP_URL is its
+// public URL.
+func appURL(path string) string {
+	base := os.Getenv("APP_URL")
+	if base == "" {
+		base = "http://localhost:3000"
+	}
+	return strings.TrimRight(base, "/") + path
+}
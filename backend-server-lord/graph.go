@@ -0,0 +1,338 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+// graphSteps are the bucket sizes picked when a graph request has no step
+var graphSteps = []time.Duration{
+	5 * time.Second, 10 * time.Second, 15 * time.Second, 30 * time.Second,
+	time.Minute, 5 * time.Minute, 10 * time.Minute, 15 * time.Minute, 30 * time.Minute,
+	time.Hour, 3 * time.Hour, 6 * time.Hour, 12 * time.Hour,
+	24 * time.Hour, 7 * 24 * time.Hour,
+}
+
+// alignToStep returns the start of the step-sized bucket holding t. Buckets
+// are aligned to the Unix epoch, the same as in the stores' SQL.
+func alignToStep(t time.Time, step time.Duration) time.Time {
+	seconds := int64(step / time.Second)
+	unix := t.Unix()
+	start := unix / seconds * seconds
+	if unix < 0 && start != unix {
+		start -= seconds
+	}
+	return time.Unix(start, 0).UTC()
+}
+
+// parseGraphTime accepts RFC 3339 timestamps and Unix seconds
+func parseGraphTime(v string) (time.Time, error) {
+	if unix, err := strconv.ParseInt(v, 10, 64); err == nil {
+		return time.Unix(unix, 0).UTC(), nil
+	}
+	t, err := time.Parse(time.RFC3339, v)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return t.UTC(), nil
+}
+
+// graphWindow is the time range and bucketing of a graph request
+type graphWindow struct {
+	From      time.Time // aligned to Step
+	To        time.Time
+	Step      time.Duration
+	Tier      GraphTier
+	TimeRange string // the range parameter, when From was derived from it
+}
+
+// parseGraphWindow reads the from, to, range and step parameters of a graph
+// request. to defaults to now and from to range (default 30d) before to. The
+// tier is picked to fit the window; step defaults to the smallest of
+// graphSteps that yields at most maxGraphPoints buckets.
+func parseGraphWindow(query url.Values, now time.Time, tiers []GraphTier) (graphWindow, error) {
+	var w graphWindow
+	var err error
+
+	w.To = now
+	if v := query.Get("to"); v != "" {
+		if w.To, err = parseGraphTime(v); err != nil {
+			return w, fmt.Errorf("Invalid to")
+		}
+	}
+
+	if v := query.Get("from"); v != "" {
+		if w.From, err = parseGraphTime(v); err != nil {
+			return w, fmt.Errorf("Invalid from")
+		}
+	} else {
+		w.TimeRange = query.Get("range")
+		if w.TimeRange == "" {
+			w.TimeRange = "30d"
+		}
+		span, err := parseDays(w.TimeRange)
+		if err != nil || span <= 0 {
+			return w, fmt.Errorf("Invalid range")
+		}
+		w.From = w.To.Add(-span)
+	}
+	if !w.From.Before(w.To) {
+		return w, fmt.Errorf("from must be before to")
+	}
+
+	span := w.To.Sub(w.From)
+	w.Tier = graphTierFor(tiers, now.Sub(w.From), span)
+	spacing := w.Tier.Spacing()
+
+	if v := query.Get("step"); v != "" {
+		if seconds, err := strconv.Atoi(v); err == nil {
+			w.Step = time.Duration(seconds) * time.Second
+		} else if w.Step, err = parseDays(v); err != nil {
+			return w, fmt.Errorf("Invalid step")
+		}
+		if w.Step < time.Second || w.Step%time.Second != 0 {
+			return w, fmt.Errorf("step must be a whole number of seconds")
+		}
+		if w.Step < spacing || (!w.Tier.IsRaw() && w.Step%spacing != 0) {
+			return w, fmt.Errorf("step must be a multiple of %s, the stored resolution for this range", spacing)
+		}
+	} else {
+		w.Step = graphSteps[len(graphSteps)-1]
+		for _, step := range graphSteps {
+			if step >= spacing && step%spacing == 0 && span/step < maxGraphPoints {
+				w.Step = step
+				break
+			}
+		}
+	}
+
+	w.From = alignToStep(w.From, w.Step)
+	if buckets := (w.To.Sub(w.From) + w.Step - 1) / w.Step; buckets > maxGraphPoints {
+		return w, fmt.Errorf("range and step give %d points, at most %d are allowed", buckets, maxGraphPoints)
+	}
+	return w, nil
+}
+
+// GraphPoint is one bucket of a graph. Every bucket of the window is present;
+// buckets without samples have a null uptime_ratio.
+type GraphPoint struct {
+	Timestamp           string   `json:"timestamp"`
+	AliveCount          int      `json:"alive_count"`   // tasks with samples, never seen dead in the bucket
+	DeadCount           int      `json:"dead_count"`    // tasks seen dead in the bucket
+	NoDataCount         int      `json:"no_data_count"` // tasks without samples in the bucket
+	Samples             int64    `json:"samples"`
+	UptimeRatio         *float64 `json:"uptime_ratio"`
+	UptimeSeconds       float64  `json:"uptime_seconds"`   // accounted within the bucket
+	DowntimeSeconds     float64  `json:"downtime_seconds"` // accounted within the bucket
+	PingCount           int64    `json:"ping_count"`       // heartbeats within the bucket
+	AvgUptimePercentage float64  `json:"avg_uptime_percentage"`
+	// Cumulative counters at the end of the bucket, summed over the tasks
+	TotalUptimeSeconds   float64 `json:"total_uptime_seconds"`
+	TotalDowntimeSeconds float64 `json:"total_downtime_seconds"`
+	TotalTaskCount       int     `json:"total_task_count"`
+	HealthScore          float64 `json:"health_score"`
+}
+
+// bucketDeltas returns how much uptime, downtime and pings a task accrued in
+// bucket b, measured against prev (the task's previous bucket) when known and
+// within b otherwise. Counters reset by a task update clamp to zero.
+func bucketDeltas(prev, b *TaskGraphBucket) (float64, float64, int64) {
+	uptime, downtime, pings := b.MaxUptimeSeconds-b.MinUptimeSeconds,
+		b.MaxDowntimeSeconds-b.MinDowntimeSeconds, b.MaxPingCount-b.MinPingCount
+	if prev != nil {
+		uptime, downtime, pings = b.MaxUptimeSeconds-prev.MaxUptimeSeconds,
+			b.MaxDowntimeSeconds-prev.MaxDowntimeSeconds, b.MaxPingCount-prev.MaxPingCount
+	}
+	return max(uptime, 0), max(downtime, 0), max(pings, 0)
+}
+
+// buildGraphSeries turns per-task buckets into one point per bucket of the
+// window. Time and pings within a bucket are the growth of the cumulative
+// counters since the task's previous bucket (buckets before w.From only serve
+// as that baseline), or within the bucket for a task's first one.
+func buildGraphSeries(buckets []TaskGraphBucket, w graphWindow, taskCount int) []GraphPoint {
+	count := int((w.To.Sub(w.From) + w.Step - 1) / w.Step)
+	points := make([]GraphPoint, count)
+	percentages := make([]float64, count)
+	for i := range points {
+		points[i].Timestamp = w.From.Add(time.Duration(i) * w.Step).Format(time.RFC3339)
+	}
+
+	var prev *TaskGraphBucket
+	for i := range buckets {
+		b := &buckets[i]
+		if prev != nil && prev.TaskID != b.TaskID {
+			prev = nil
+		}
+
+		uptime, downtime, pings := bucketDeltas(prev, b)
+		prev = b
+
+		idx := int(b.BucketStart.Sub(w.From) / w.Step)
+		if b.BucketStart.Before(w.From) || idx >= count {
+			continue
+		}
+
+		p := &points[idx]
+		if b.DeadSamples > 0 {
+			p.DeadCount++
+		} else {
+			p.AliveCount++
+		}
+		p.Samples += b.Samples
+		p.UptimeSeconds += uptime
+		p.DowntimeSeconds += downtime
+		p.PingCount += pings
+		p.TotalUptimeSeconds += b.MaxUptimeSeconds
+		p.TotalDowntimeSeconds += b.MaxDowntimeSeconds
+		percentages[idx] += b.AvgUptimePercentage
+	}
+
+	for i := range points {
+		p := &points[i]
+		p.TotalTaskCount = p.AliveCount + p.DeadCount
+		p.NoDataCount = max(taskCount-p.TotalTaskCount, 0)
+		if p.TotalTaskCount == 0 {
+			continue
+		}
+		p.AvgUptimePercentage = percentages[i] / float64(p.TotalTaskCount)
+		p.HealthScore = float64(p.AliveCount) / float64(p.TotalTaskCount) * 100
+		if tracked := p.UptimeSeconds + p.DowntimeSeconds; tracked > 0 {
+			ratio := p.UptimeSeconds / tracked
+			p.UptimeRatio = &ratio
+		}
+	}
+	return points
+}
+
+// graphSeries parses the graph window of r and builds the series of q's tasks.
+// It writes the error response itself and returns ok=false on failure.
+func (s *Server) graphSeries(w http.ResponseWriter, r *http.Request, q GraphQuery, taskCount int) (graphWindow, []GraphPoint, bool) {
+	// Stored timestamps are in the store's clock
+	now, err := s.monitor.Now(r.Context())
+	if err != nil {
+		log.Printf("Error reading store time: %v", err)
+		respondWithError(w, http.StatusInternalServerError, "Error retrieving graph data")
+		return graphWindow{}, nil, false
+	}
+
+	window, err := parseGraphWindow(r.URL.Query(), now, s.graphTiers)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error())
+		return window, nil, false
+	}
+
+	points, err := s.querySeries(r.Context(), q, window, taskCount)
+	if err != nil {
+		log.Printf("Error querying graph data: %v", err)
+		respondWithError(w, http.StatusInternalServerError, "Error retrieving graph data")
+		return window, nil, false
+	}
+	return window, points, true
+}
+
+// querySeries builds the series of q's tasks over the window
+func (s *Server) querySeries(ctx context.Context, q GraphQuery, window graphWindow, taskCount int) ([]GraphPoint, error) {
+	// One extra bucket before the window gives every task a counter baseline
+	q.From = window.From.Add(-window.Step)
+	q.To = window.To
+	q.Step = window.Step
+	q.Tier = window.Tier
+
+	buckets, err := s.graphs.GraphBuckets(ctx, q)
+	if err != nil {
+		return nil, err
+	}
+	return buildGraphSeries(buckets, window, taskCount), nil
+}
+
+// getTaskGraph returns the graph of a single task
+func (s *Server) getTaskGraph(w http.ResponseWriter, r *http.Request) {
+	task, ok := s.taskFromRequest(w, r, roleViewer)
+	if !ok {
+		return
+	}
+	id := task.ID
+
+	window, points, ok := s.graphSeries(w, r, GraphQuery{TaskID: id}, 1)
+	if !ok {
+		return
+	}
+	log.Printf("Fetched graph data for task ID: %d (%d points, step %s, tier %s)", id, len(points), window.Step, window.Tier.Name)
+
+	respondWithJSON(w, http.StatusOK, struct {
+		TaskID     int64        `json:"task_id"`
+		From       time.Time    `json:"from"`
+		To         time.Time    `json:"to"`
+		Step       int64        `json:"step"`
+		Resolution string       `json:"resolution"`
+		Points     []GraphPoint `json:"points"`
+		Count      int          `json:"count"`
+	}{
+		TaskID:     id,
+		From:       window.From,
+		To:         window.To,
+		Step:       int64(window.Step / time.Second),
+		Resolution: window.Tier.Name,
+		Points:     points,
+		Count:      len(points),
+	})
+}
+
+// getUserGraph provides aggregated metrics for all personal tasks of a user,
+// or for all tasks of an organization under /api/orgs/{org_id}/graph
+func (s *Server) getUserGraph(w http.ResponseWriter, r *http.Request) {
+	userID, orgID, ok := s.taskOwnerFromRequest(w, r, roleViewer)
+	if !ok {
+		return
+	}
+
+	// ?tag= and ?project= narrow the graph to some of the tasks
+	filter := taskFilterFromRequest(r)
+	filter.OrgID = orgID
+	tasks, err := s.tasks.ListUserTasks(r.Context(), userID, filter)
+	if err != nil {
+		log.Printf("Error retrieving tasks for user %d, organization %d: %v", userID, orgID, err)
+		respondWithError(w, http.StatusInternalServerError, "Error retrieving graph data")
+		return
+	}
+
+	window, points, ok := s.graphSeries(w, r, GraphQuery{UserID: userID, Filter: filter}, len(tasks))
+	if !ok {
+		return
+	}
+	if len(tasks) == 0 {
+		log.Printf("No tasks found for user ID: %d, organization ID: %d", userID, orgID)
+		points = []GraphPoint{}
+	}
+	log.Printf("Fetched graph data for user ID: %d, organization ID: %d (%d points, step %s, tier %s)", userID, orgID, len(points), window.Step, window.Tier.Name)
+
+	respondWithJSON(w, http.StatusOK, struct {
+		Points     []GraphPoint `json:"points"`
+		UserID     int64        `json:"user_id,omitempty"`
+		OrgID      int64        `json:"org_id,omitempty"`
+		From       time.Time    `json:"from"`
+		To         time.Time    `json:"to"`
+		Step       int64        `json:"step"`
+		TimeRange  string       `json:"time_range,omitempty"`
+		Resolution string       `json:"resolution"`
+		Count      int          `json:"count"`
+		TaskCount  int          `json:"task_count"`
+	}{
+		Points:     points,
+		UserID:     userID,
+		OrgID:      orgID,
+		From:       window.From,
+		To:         window.To,
+		Step:       int64(window.Step / time.Second),
+		TimeRange:  window.TimeRange,
+		Resolution: window.Tier.Name,
+		Count:      len(points),
+		TaskCount:  len(tasks),
+	})
+}
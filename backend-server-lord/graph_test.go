@@ -0,0 +1,189 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"testing"
+	"time"
+)
+
+func TestGraphTierFor(t *testing.T) {
+	for _, tc := range []struct {
+		age, span time.Duration
+		want      string
+	}{
+		{time.Hour, time.Hour, "raw"},
+		{2 * time.Hour, 2 * time.Hour, "raw"},
+		{3 * time.Hour, 3 * time.Hour, "1m"},
+		{24 * time.Hour, 24 * time.Hour, "1m"},
+		// An hour two days ago is past the raw retention
+		{48 * time.Hour, time.Hour, "1m"},
+		{7 * 24 * time.Hour, 7 * 24 * time.Hour, "1h"},
+		{30 * 24 * time.Hour, 30 * 24 * time.Hour, "1h"},
+		{90 * 24 * time.Hour, 90 * 24 * time.Hour, "1d"},
+		{10 * 365 * 24 * time.Hour, 10 * 365 * 24 * time.Hour, "1d"},
+	} {
+		if got := graphTierFor(defaultGraphTiers, tc.age, tc.span); got.Name != tc.want {
+			t.Errorf("graphTierFor(%v, %v) = %s, want %s", tc.age, tc.span, got.Name, tc.want)
+		}
+	}
+}
+
+func TestAlignToStep(t *testing.T) {
+	at := time.Date(2026, 1, 3, 12, 34, 56, 0, time.UTC)
+	for _, tc := range []struct {
+		step time.Duration
+		want time.Time
+	}{
+		{5 * time.Second, time.Date(2026, 1, 3, 12, 34, 55, 0, time.UTC)},
+		{time.Minute, time.Date(2026, 1, 3, 12, 34, 0, 0, time.UTC)},
+		{15 * time.Minute, time.Date(2026, 1, 3, 12, 30, 0, 0, time.UTC)},
+		{time.Hour, time.Date(2026, 1, 3, 12, 0, 0, 0, time.UTC)},
+		{24 * time.Hour, time.Date(2026, 1, 3, 0, 0, 0, 0, time.UTC)},
+		// Weeks count from the epoch, a Thursday
+		{7 * 24 * time.Hour, time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)},
+	} {
+		if got := alignToStep(at, tc.step); !got.Equal(tc.want) {
+			t.Errorf("alignToStep(%v, %v) = %v, want %v", at, tc.step, got, tc.want)
+		}
+	}
+	before := time.Date(1969, 12, 31, 23, 59, 30, 0, time.UTC)
+	if got := alignToStep(before, time.Minute); !got.Equal(time.Date(1969, 12, 31, 23, 59, 0, 0, time.UTC)) {
+		t.Errorf("alignToStep(%v, 1m) = %v", before, got)
+	}
+}
+
+func TestParseGraphWindow(t *testing.T) {
+	now := time.Date(2026, 1, 1, 12, 34, 56, 0, time.UTC)
+	for _, tc := range []struct {
+		query string
+		from  time.Time
+		to    time.Time
+		step  time.Duration
+		tier  string
+		err   string
+	}{
+		{query: "range=1h", from: time.Date(2026, 1, 1, 11, 34, 55, 0, time.UTC), to: now, step: 5 * time.Second, tier: "raw"},
+		{query: "range=1d", from: time.Date(2025, 12, 31, 12, 34, 0, 0, time.UTC), to: now, step: time.Minute, tier: "1m"},
+		{query: "", from: time.Date(2025, 12, 2, 12, 0, 0, 0, time.UTC), to: now, step: time.Hour, tier: "1h"},
+		{query: "range=3650d", from: time.Date(2015, 12, 31, 0, 0, 0, 0, time.UTC), to: now, step: 7 * 24 * time.Hour, tier: "1d"},
+		{query: "from=2026-01-01T12:00:00Z&to=2026-01-01T12:30:00Z&step=5m",
+			from: time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC), to: time.Date(2026, 1, 1, 12, 30, 0, 0, time.UTC), step: 5 * time.Minute, tier: "raw"},
+		{query: fmt.Sprintf("from=%d&step=60", now.Add(-2*time.Hour-30*time.Second).Unix()),
+			from: time.Date(2026, 1, 1, 10, 34, 0, 0, time.UTC), to: now, step: time.Minute, tier: "raw"},
+		{query: "range=7d&step=1d", from: time.Date(2025, 12, 25, 0, 0, 0, 0, time.UTC), to: now, step: 24 * time.Hour, tier: "1h"},
+
+		{query: "range=1d&step=90", err: "step must be a multiple of 1m0s, the stored resolution for this range"},
+		{query: "range=1d&step=5", err: "step must be a multiple of 1m0s, the stored resolution for this range"},
+		{query: "range=3650d&step=1d", err: "range and step give 3651 points, at most 1500 are allowed"},
+		{query: "range=1h&step=1.5", err: "Invalid step"},
+		{query: "range=-1h", err: "Invalid range"},
+		{query: "from=yesterday", err: "Invalid from"},
+		{query: "from=2026-01-02T00:00:00Z", err: "from must be before to"},
+	} {
+		values, err := url.ParseQuery(tc.query)
+		if err != nil {
+			t.Fatal(err)
+		}
+		w, err := parseGraphWindow(values, now, defaultGraphTiers)
+		if tc.err != "" {
+			if err == nil || err.Error() != tc.err {
+				t.Errorf("%q: error %v, want %q", tc.query, err, tc.err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%q: %v", tc.query, err)
+			continue
+		}
+		if !w.From.Equal(tc.from) || !w.To.Equal(tc.to) || w.Step != tc.step || w.Tier.Name != tc.tier {
+			t.Errorf("%q: window %v..%v by %v from %s, want %v..%v by %v from %s",
+				tc.query, w.From, w.To, w.Step, w.Tier.Name, tc.from, tc.to, tc.step, tc.tier)
+		}
+	}
+}
+
+func TestBuildGraphSeries(t *testing.T) {
+	start := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+	w := graphWindow{From: start, To: start.Add(4*time.Minute + 30*time.Second), Step: time.Minute}
+	buckets := []TaskGraphBucket{
+		// Task 1: a baseline before the window, then alive at 12:00 and 12:02
+		{TaskID: 1, BucketStart: start.Add(-time.Minute), Samples: 12, MaxUptimeSeconds: 100, MaxPingCount: 4},
+		{TaskID: 1, BucketStart: start, Samples: 12, AvgUptimePercentage: 100,
+			MinUptimeSeconds: 105, MaxUptimeSeconds: 160, MinPingCount: 4, MaxPingCount: 5},
+		{TaskID: 1, BucketStart: start.Add(2 * time.Minute), Samples: 12, AvgUptimePercentage: 100,
+			MinUptimeSeconds: 225, MaxUptimeSeconds: 280, MinPingCount: 6, MaxPingCount: 7},
+		// Task 2: first seen at 12:02, dead for the second half of it
+		{TaskID: 2, BucketStart: start.Add(2 * time.Minute), Samples: 12, DeadSamples: 6, AvgUptimePercentage: 50,
+			MinUptimeSeconds: 0, MaxUptimeSeconds: 30, MinDowntimeSeconds: 0, MaxDowntimeSeconds: 30},
+	}
+	points := buildGraphSeries(buckets, w, 2)
+
+	// Every bucket of the window is present, the last one partial
+	if len(points) != 5 {
+		t.Fatalf("%d points, want 5", len(points))
+	}
+	for i, p := range points {
+		if want := start.Add(time.Duration(i) * time.Minute).Format(time.RFC3339); p.Timestamp != want {
+			t.Errorf("point %d at %s, want %s", i, p.Timestamp, want)
+		}
+	}
+
+	first := points[0]
+	if first.AliveCount != 1 || first.NoDataCount != 1 || first.UptimeSeconds != 60 || first.PingCount != 1 {
+		t.Errorf("12:00 = %+v, want task 1 alive with 60s of uptime and a ping since the baseline", first)
+	}
+	for _, i := range []int{1, 3, 4} {
+		if p := points[i]; p.UptimeRatio != nil || p.NoDataCount != 2 || p.TotalTaskCount != 0 || p.Samples != 0 {
+			t.Errorf("empty bucket %s = %+v, want no data", p.Timestamp, p)
+		}
+	}
+
+	third := points[2]
+	if third.AliveCount != 1 || third.DeadCount != 1 || third.NoDataCount != 0 || third.Samples != 24 {
+		t.Errorf("12:02 = %+v, want task 1 alive and task 2 dead", third)
+	}
+	// Task 1 grew 120s since its 12:00 bucket, task 2 within its first bucket
+	if third.UptimeSeconds != 150 || third.DowntimeSeconds != 30 || third.PingCount != 2 {
+		t.Errorf("12:02 uptime %v, downtime %v, pings %d; want 150, 30, 2", third.UptimeSeconds, third.DowntimeSeconds, third.PingCount)
+	}
+	if third.UptimeRatio == nil || *third.UptimeRatio != 150.0/180 || third.HealthScore != 50 || third.AvgUptimePercentage != 75 {
+		t.Errorf("12:02 ratio %v, health %v, average %v; want 5/6, 50, 75", third.UptimeRatio, third.HealthScore, third.AvgUptimePercentage)
+	}
+}
+
+func TestTaskGraphAPI(t *testing.T) {
+	api := newTestAPI(t)
+	userID, token := api.signup("alice")
+	task := api.createTask(token, userID, "backup", 7, 60)
+	start := api.now
+
+	for i := 1; i <= 6; i++ {
+		api.advance(20 * time.Second)
+		monitorPass(t, api.store, nil)
+	}
+
+	var graph struct {
+		From       time.Time    `json:"from"`
+		Step       int64        `json:"step"`
+		Resolution string       `json:"resolution"`
+		Points     []GraphPoint `json:"points"`
+	}
+	path := fmt.Sprintf("/api/tasks/%d/graph?from=%s&to=%s&step=1m", task.ID,
+		start.Add(-time.Minute).Format(time.RFC3339), start.Add(3*time.Minute).Format(time.RFC3339))
+	expect(t, api.do("GET", path, token, nil), http.StatusOK, &graph)
+	if graph.Step != 60 || graph.Resolution != "raw" || !graph.From.Equal(start.Add(-time.Minute)) || len(graph.Points) != 4 {
+		t.Fatalf("graph = %+v, want 4 raw minutes", graph)
+	}
+	// Created at 12:00 and sampled until 12:02, dead from the 12:01 deadline
+	for i, want := range []struct{ alive, dead, noData int }{{0, 0, 1}, {1, 0, 0}, {0, 1, 0}, {0, 1, 0}} {
+		p := graph.Points[i]
+		if p.AliveCount != want.alive || p.DeadCount != want.dead || p.NoDataCount != want.noData {
+			t.Errorf("point %s: %d alive, %d dead, %d without data; want %+v", p.Timestamp, p.AliveCount, p.DeadCount, p.NoDataCount, want)
+		}
+	}
+
+	expect(t, api.do("GET", fmt.Sprintf("/api/tasks/%d/graph?range=1h&step=3", task.ID), token, nil), http.StatusBadRequest, nil)
+	expect(t, api.do("GET", fmt.Sprintf("/api/tasks/%d/graph", task.ID+1), token, nil), http.StatusNotFound, nil)
+}
@@ -0,0 +1,195 @@
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// monitorStatus records the progress of the monitor loop for the health endpoints
+var monitorStatus struct {
+	sync.RWMutex
+	StartedAt    time.Time
+	LastTickAt   time.Time
+	LastDuration time.Duration
+	LastSummary  MonitoringSummary
+	LastError    string
+
+	// Last tick that completed without any error, used by the watchdog
+	LastHealthyTickAt time.Time
+	// Store time from which overdue tasks were given a grace period after a stall
+	GraceStart *time.Time
+}
+
+// markMonitorStarted records when the monitor loop began, so readiness has a
+// reference point before the first tick completes
+func markMonitorStarted() {
+	monitorStatus.Lock()
+	monitorStatus.StartedAt = time.Now()
+	monitorStatus.Unlock()
+}
+
+// recordMonitorTick stores the outcome of a completed checkTaskStatus run
+func recordMonitorTick(at time.Time, duration time.Duration, summary MonitoringSummary, err error) {
+	monitorStatus.Lock()
+	defer monitorStatus.Unlock()
+
+	monitorStatus.LastTickAt = at
+	monitorStatus.LastDuration = duration
+	monitorStatus.LastSummary = summary
+	monitorStatus.LastError = ""
+	if err != nil {
+		monitorStatus.LastError = err.Error()
+	} else if summary.FailedShards == 0 {
+		monitorStatus.LastHealthyTickAt = at
+	}
+}
+
+// lastMonitorProgress returns the time of the last completed tick, or the
+// monitor start time if no tick has completed yet
+func lastMonitorProgress() time.Time {
+	monitorStatus.RLock()
+	defer monitorStatus.RUnlock()
+
+	if monitorStatus.LastTickAt.IsZero() {
+		return monitorStatus.StartedAt
+	}
+	return monitorStatus.LastTickAt
+}
+
+// monitorMaxTickAge is how long the monitor may go without completing a tick
+// before the server reports itself as not ready. Set with MONITOR_MAX_TICK_AGE.
+func monitorMaxTickAge() time.Duration {
+	if v := os.Getenv("MONITOR_MAX_TICK_AGE"); v != "" {
+		if d, err := time.ParseDuration(v); err == nil && d > 0 {
+			return d
+		}
+		log.Printf("Invalid MONITOR_MAX_TICK_AGE %q, using default", v)
+	}
+	return 30 * time.Second
+}
+
+// healthzHandler reports that the process is up
+func healthzHandler(w http.ResponseWriter, r *http.Request) {
+	respondWithJSON(w, http.StatusOK, map[string]string{"status": "ok"})
+}
+
+// readyzHandler reports whether the server can do useful work: the database is
+// reachable, the schema is in place and the monitor loop is making progress
+func (s *Server) readyzHandler(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), 2*time.Second)
+	defer cancel()
+
+	checks := map[string]string{}
+	ready := true
+
+	if err := s.store.Ping(ctx); err != nil {
+		checks["database"] = err.Error()
+		ready = false
+	} else {
+		checks["database"] = "ok"
+
+		if err := s.store.CheckSchema(ctx); err != nil {
+			checks["schema"] = err.Error()
+			ready = false
+		} else {
+			checks["schema"] = "ok"
+		}
+	}
+
+	lastProgress := lastMonitorProgress()
+	maxAge := monitorMaxTickAge()
+	if lastProgress.IsZero() {
+		checks["monitor"] = "not started"
+		ready = false
+	} else if age := time.Since(lastProgress); age > maxAge {
+		checks["monitor"] = fmt.Sprintf("last tick %s ago (max %s)", age.Round(time.Second), maxAge)
+		ready = false
+	} else {
+		checks["monitor"] = "ok"
+	}
+
+	status := "ready"
+	code := http.StatusOK
+	if !ready {
+		status = "not ready"
+		code = http.StatusServiceUnavailable
+	}
+
+	respondWithJSON(w, code, map[string]interface{}{
+		"status": status,
+		"checks": checks,
+	})
+}
+
+// debugOnly guards a debug endpoint, which shows internal state, with the
+// DEBUG_TOKEN as a bearer token. Without a token the endpoint does not exist.
+func debugOnly(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		debugToken := os.Getenv("DEBUG_TOKEN")
+		if debugToken == "" {
+			respondWithError(w, http.StatusNotFound, "Not found")
+			return
+		}
+		token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if subtle.ConstantTimeCompare([]byte(token), []byte(debugToken)) != 1 {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			respondWithError(w, http.StatusUnauthorized, "Invalid debug token")
+			return
+		}
+		next(w, r)
+	}
+}
+
+// debugMonitorHandler exposes the internal state of the monitor loop
+func debugMonitorHandler(w http.ResponseWriter, r *http.Request) {
+	type shardStatus struct {
+		Name          string    `json:"name"`
+		LastMonitored time.Time `json:"last_monitored"`
+	}
+
+	shardMutex.RLock()
+	shards := make([]shardStatus, 0, len(shardMonitoringInfo))
+	for _, info := range shardMonitoringInfo {
+		shards = append(shards, shardStatus{Name: info.Name, LastMonitored: info.LastMonitored})
+	}
+	shardMutex.RUnlock()
+	sort.Slice(shards, func(i, j int) bool { return shards[i].Name < shards[j].Name })
+
+	monitorStatus.RLock()
+	response := struct {
+		StartedAt          time.Time         `json:"started_at"`
+		LastTickAt         *time.Time        `json:"last_tick_at"`
+		LastDurationMillis int64             `json:"last_duration_ms"`
+		LastSummary        MonitoringSummary `json:"last_summary"`
+		LastError          string            `json:"last_error,omitempty"`
+		LastHealthyTickAt  *time.Time        `json:"last_healthy_tick_at"`
+		GraceStart         *time.Time        `json:"grace_start"`
+		Shards             []shardStatus     `json:"shards"`
+	}{
+		StartedAt:          monitorStatus.StartedAt,
+		LastDurationMillis: monitorStatus.LastDuration.Milliseconds(),
+		LastSummary:        monitorStatus.LastSummary,
+		LastError:          monitorStatus.LastError,
+		GraceStart:         monitorStatus.GraceStart,
+		Shards:             shards,
+	}
+	if !monitorStatus.LastTickAt.IsZero() {
+		lastTick := monitorStatus.LastTickAt
+		response.LastTickAt = &lastTick
+	}
+	if !monitorStatus.LastHealthyTickAt.IsZero() {
+		lastHealthy := monitorStatus.LastHealthyTickAt
+		response.LastHealthyTickAt = &lastHealthy
+	}
+	monitorStatus.RUnlock()
+
+	respondWithJSON(w, http.StatusOK, response)
+}
@@ -0,0 +1,366 @@
+package main
+
+import (
+	"context"
+	"embed"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v4"
+	"github.com/jackc/pgx/v4/pgxpool"
+)
+
+// Schema migrations live in migrations/ as NNNN_name.up.sql / NNNN_name.down.sql
+// and are compiled into the binary. SQLite has its own set in migrations/sqlite,
+// using the same version number as the Postgres migration it corresponds to.
+//
+//go:embed migrations/*.sql migrations/sqlite/*.sql
+var migrationFiles embed.FS
+
+// Migration directories per backend
+const (
+	postgresMigrationsDir = "migrations"
+	sqliteMigrationsDir   = "migrations/sqlite"
+)
+
+// Name of the advisory lock that serialises migrations across replicas
+const migrationLockName = "task-tracker-schema-migrations"
+
+// querier is satisfied by both the pool and a single pooled connection
+type querier interface {
+	Query(ctx context.Context, sql string, args ...interface{}) (pgx.Rows, error)
+}
+
+// Migration is one versioned schema change
+type Migration struct {
+	Version int64
+	Name    string
+	Up      string
+	Down    string
+}
+
+// loadMigrations reads the embedded migration files in dir ordered by version
+func loadMigrations(dir string) ([]Migration, error) {
+	entries, err := migrationFiles.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	byVersion := map[int64]*Migration{}
+	for _, entry := range entries {
+		if entry.IsDir() {
+			continue
+		}
+		fileName := entry.Name()
+
+		var direction string
+		switch {
+		case strings.HasSuffix(fileName, ".up.sql"):
+			direction = "up"
+		case strings.HasSuffix(fileName, ".down.sql"):
+			direction = "down"
+		default:
+			return nil, fmt.Errorf("unexpected migration file %s", fileName)
+		}
+
+		base := strings.TrimSuffix(fileName, "."+direction+".sql")
+		parts := strings.SplitN(base, "_", 2)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("migration file %s is not named NNNN_name.%s.sql", fileName, direction)
+		}
+		version, err := strconv.ParseInt(parts[0], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("migration file %s has an invalid version: %v", fileName, err)
+		}
+
+		contents, err := migrationFiles.ReadFile(path.Join(dir, fileName))
+		if err != nil {
+			return nil, err
+		}
+
+		m, ok := byVersion[version]
+		if !ok {
+			m = &Migration{Version: version, Name: parts[1]}
+			byVersion[version] = m
+		} else if m.Name != parts[1] {
+			return nil, fmt.Errorf("migration version %d is used by both %s and %s", version, m.Name, parts[1])
+		}
+
+		if direction == "up" {
+			m.Up = string(contents)
+		} else {
+			m.Down = string(contents)
+		}
+	}
+
+	migrations := make([]Migration, 0, len(byVersion))
+	for _, m := range byVersion {
+		if m.Up == "" {
+			return nil, fmt.Errorf("migration %d_%s has no up file", m.Version, m.Name)
+		}
+		migrations = append(migrations, *m)
+	}
+	sort.Slice(migrations, func(i, j int) bool { return migrations[i].Version < migrations[j].Version })
+	return migrations, nil
+}
+
+// latestMigrationVersion is the version the embedded migrations in dir bring the schema to
+func latestMigrationVersion(dir string) (int64, error) {
+	migrations, err := loadMigrations(dir)
+	if err != nil {
+		return 0, err
+	}
+	if len(migrations) == 0 {
+		return 0, nil
+	}
+	return migrations[len(migrations)-1].Version, nil
+}
+
+// appliedMigrations returns the applied versions and when they were applied
+func appliedMigrations(ctx context.Context, q querier) (map[int64]time.Time, error) {
+	rows, err := q.Query(ctx, "SELECT version, applied_at FROM schema_migrations")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	applied := map[int64]time.Time{}
+	for rows.Next() {
+		var version int64
+		var appliedAt time.Time
+		if err := rows.Scan(&version, &appliedAt); err != nil {
+			return nil, err
+		}
+		applied[version] = appliedAt
+	}
+	return applied, rows.Err()
+}
+
+// migrateDB applies pending Postgres migrations ("up") or rolls back the most
+// recent steps ("down"). A session advisory lock is held for the whole run so
+// that replicas starting at the same time do not race; each migration runs in
+// its own transaction together with its schema_migrations bookkeeping.
+func migrateDB(ctx context.Context, pool *pgxpool.Pool, direction string, steps int) error {
+	migrations, err := loadMigrations(postgresMigrationsDir)
+	if err != nil {
+		return fmt.Errorf("error loading migrations: %v", err)
+	}
+
+	conn, err := pool.Acquire(ctx)
+	if err != nil {
+		return fmt.Errorf("error acquiring connection: %v", err)
+	}
+	defer conn.Release()
+
+	if _, err := conn.Exec(ctx, "SELECT pg_advisory_lock(hashtext($1))", migrationLockName); err != nil {
+		return fmt.Errorf("error acquiring migration lock: %v", err)
+	}
+	defer conn.Exec(context.Background(), "SELECT pg_advisory_unlock(hashtext($1))", migrationLockName)
+
+	_, err = conn.Exec(ctx, `CREATE TABLE IF NOT EXISTS schema_migrations (
+            version BIGINT PRIMARY KEY,
+            name VARCHAR(255) NOT NULL,
+            applied_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+        )`)
+	if err != nil {
+		return fmt.Errorf("error creating schema_migrations: %v", err)
+	}
+
+	applied, err := appliedMigrations(ctx, conn)
+	if err != nil {
+		return fmt.Errorf("error reading schema_migrations: %v", err)
+	}
+
+	return runMigrations(migrations, applied, direction, steps, func(m Migration, up bool) error {
+		tx, err := conn.Begin(ctx)
+		if err != nil {
+			return err
+		}
+		defer tx.Rollback(ctx)
+
+		script, bookkeeping, args := m.Down, "DELETE FROM schema_migrations WHERE version = $1", []interface{}{m.Version}
+		if up {
+			script, bookkeeping, args = m.Up, "INSERT INTO schema_migrations (version, name) VALUES ($1, $2)", []interface{}{m.Version, m.Name}
+		}
+		if _, err := tx.Exec(ctx, script); err != nil {
+			return err
+		}
+		if _, err := tx.Exec(ctx, bookkeeping, args...); err != nil {
+			return err
+		}
+		return tx.Commit(ctx)
+	})
+}
+
+// runMigrations picks the migrations to apply ("up": every pending one, or the
+// next steps) or to roll back ("down": the last steps, default 1) and runs
+// each through apply, which executes it and its bookkeeping atomically
+func runMigrations(migrations []Migration, applied map[int64]time.Time, direction string, steps int, apply func(m Migration, up bool) error) error {
+	switch direction {
+	case "up":
+		count := 0
+		for _, m := range migrations {
+			if _, ok := applied[m.Version]; ok {
+				continue
+			}
+			if steps > 0 && count == steps {
+				break
+			}
+
+			log.Printf("Applying migration %04d_%s", m.Version, m.Name)
+			if err := apply(m, true); err != nil {
+				return fmt.Errorf("error applying migration %04d_%s: %v", m.Version, m.Name, err)
+			}
+			count++
+		}
+		if count == 0 {
+			log.Println("Database schema is up to date")
+		} else {
+			log.Printf("Applied %d migration(s)", count)
+		}
+
+	case "down":
+		if steps <= 0 {
+			steps = 1
+		}
+		count := 0
+		for i := len(migrations) - 1; i >= 0 && count < steps; i-- {
+			m := migrations[i]
+			if _, ok := applied[m.Version]; !ok {
+				continue
+			}
+			if m.Down == "" {
+				return fmt.Errorf("migration %04d_%s cannot be rolled back: no down file", m.Version, m.Name)
+			}
+
+			log.Printf("Rolling back migration %04d_%s", m.Version, m.Name)
+			if err := apply(m, false); err != nil {
+				return fmt.Errorf("error rolling back migration %04d_%s: %v", m.Version, m.Name, err)
+			}
+			count++
+		}
+		log.Printf("Rolled back %d migration(s)", count)
+
+	default:
+		return fmt.Errorf("unknown migration direction %q", direction)
+	}
+
+	return nil
+}
+
+// postgresMigrationStatus returns the embedded Postgres migrations and the
+// applied versions
+func postgresMigrationStatus(ctx context.Context, pool *pgxpool.Pool) ([]Migration, map[int64]time.Time, error) {
+	migrations, err := loadMigrations(postgresMigrationsDir)
+	if err != nil {
+		return nil, nil, fmt.Errorf("error loading migrations: %v", err)
+	}
+
+	var exists bool
+	if err := pool.QueryRow(ctx, "SELECT to_regclass('public.schema_migrations') IS NOT NULL").Scan(&exists); err != nil {
+		return nil, nil, err
+	}
+
+	applied := map[int64]time.Time{}
+	if exists {
+		applied, err = appliedMigrations(ctx, pool)
+		if err != nil {
+			return nil, nil, fmt.Errorf("error reading schema_migrations: %v", err)
+		}
+	}
+	return migrations, applied, nil
+}
+
+// printMigrationStatus lists every migration and whether it is applied
+func printMigrationStatus(w io.Writer, migrations []Migration, applied map[int64]time.Time) {
+	fmt.Fprintf(w, "%-8s | %-30s | %-8s | %-25s\n", "Version", "Name", "Status", "Applied At")
+	fmt.Fprintln(w, strings.Repeat("-", 80))
+	for _, m := range migrations {
+		status := "pending"
+		appliedAt := ""
+		if at, ok := applied[m.Version]; ok {
+			status = "applied"
+			appliedAt = at.Format(time.RFC3339)
+		}
+		fmt.Fprintf(w, "%-8d | %-30s | %-8s | %-25s\n", m.Version, truncateString(m.Name, 30), status, appliedAt)
+	}
+}
+
+// checkSchemaVersion returns an error unless the current schema version
+// has every embedded migration in dir applied
+func checkSchemaVersion(dir string, current int64) error {
+	latest, err := latestMigrationVersion(dir)
+	if err != nil {
+		return err
+	}
+	if current < latest {
+		return fmt.Errorf("schema at version %d, expected %d", current, latest)
+	}
+	return nil
+}
+
+// schemaMigrator is implemented by the stores backed by a database schema
+type schemaMigrator interface {
+	// MigrateSchema applies ("up") or rolls back ("down") migrations
+	MigrateSchema(ctx context.Context, direction string, steps int) error
+	MigrationStatus(ctx context.Context) ([]Migration, map[int64]time.Time, error)
+}
+
+// runMigrateCommand implements the migrate subcommand:
+//
+//	task-tracker migrate up [n]     apply pending migrations (all, or the next n)
+//	task-tracker migrate down [n]   roll back the last n migrations (default 1)
+//	task-tracker migrate status     list migrations and whether they are applied
+func runMigrateCommand(args []string) {
+	if len(args) == 0 {
+		fmt.Fprintln(os.Stderr, "usage: task-tracker migrate up [n] | down [n] | status")
+		os.Exit(2)
+	}
+
+	steps := 0
+	if len(args) > 1 {
+		n, err := strconv.Atoi(args[1])
+		if err != nil || n <= 0 {
+			log.Fatalf("Invalid step count %q", args[1])
+		}
+		steps = n
+	}
+
+	ctx := context.Background()
+	store, err := openStore(ctx, databaseURL())
+	if err != nil {
+		log.Fatal("Error connecting to database: ", err)
+	}
+	defer store.Close()
+
+	migrator, ok := store.(schemaMigrator)
+	if !ok {
+		store.Close()
+		log.Fatal("This database does not use schema migrations")
+	}
+
+	switch args[0] {
+	case "up", "down":
+		err = migrator.MigrateSchema(ctx, args[0], steps)
+	case "status":
+		var migrations []Migration
+		var applied map[int64]time.Time
+		migrations, applied, err = migrator.MigrationStatus(ctx)
+		if err == nil {
+			printMigrationStatus(os.Stdout, migrations, applied)
+		}
+	default:
+		err = fmt.Errorf("unknown migrate command %q", args[0])
+	}
+	if err != nil {
+		store.Close()
+		log.Fatal(err)
+	}
+}
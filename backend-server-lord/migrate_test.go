@@ -0,0 +1,118 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestLoadMigrations(t *testing.T) {
+	migrations, err := loadMigrations(postgresMigrationsDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	names := map[int64]string{}
+	for i, m := range migrations {
+		if m.Version != int64(i+1) {
+			t.Errorf("migration %d_%s is number %d", m.Version, m.Name, i+1)
+		}
+		if m.Down == "" {
+			t.Errorf("migration %d_%s cannot be rolled back", m.Version, m.Name)
+		}
+		names[m.Version] = m.Name
+	}
+
+	// SQLite skips the Postgres-only migrations, but numbers the rest the same
+	sqliteMigrations, err := loadMigrations(sqliteMigrationsDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, m := range sqliteMigrations {
+		if names[m.Version] != m.Name {
+			t.Errorf("SQLite migration %d_%s has no Postgres counterpart", m.Version, m.Name)
+		}
+		if m.Down == "" {
+			t.Errorf("SQLite migration %d_%s cannot be rolled back", m.Version, m.Name)
+		}
+	}
+}
+
+// expectMigrationStatus checks that the status command reports the first
+// applied migrations as applied and the rest as pending
+func expectMigrationStatus(t *testing.T, migrator schemaMigrator, applied int) {
+	t.Helper()
+	migrations, appliedAt, err := migrator.MigrationStatus(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	var out bytes.Buffer
+	printMigrationStatus(&out, migrations, appliedAt)
+	status := out.String()
+
+	for i, m := range migrations {
+		want := "pending"
+		if i < applied {
+			want = "applied"
+		}
+		prefix := fmt.Sprintf("%-8d | %-30s | %-8s |", m.Version, truncateString(m.Name, 30), want)
+		if !strings.Contains(status, "\n"+prefix) {
+			t.Errorf("with %d applied, migration %d_%s is not reported %s:\n%s", applied, m.Version, m.Name, want, status)
+		}
+	}
+}
+
+// testMigrateUpDownStatus applies every migration, rolls them back one at a
+// time and applies them again, checking the status after each step
+func testMigrateUpDownStatus(t *testing.T, migrator schemaMigrator) {
+	ctx := context.Background()
+	migrations, _, err := migrator.MigrationStatus(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expectMigrationStatus(t, migrator, 0)
+	if err := migrator.MigrateSchema(ctx, "up", 0); err != nil {
+		t.Fatal(err)
+	}
+	expectMigrationStatus(t, migrator, len(migrations))
+
+	for applied := len(migrations) - 1; applied >= 0; applied-- {
+		if err := migrator.MigrateSchema(ctx, "down", 1); err != nil {
+			t.Fatal(err)
+		}
+		expectMigrationStatus(t, migrator, applied)
+	}
+	if err := migrator.MigrateSchema(ctx, "up", 0); err != nil {
+		t.Fatal(err)
+	}
+	expectMigrationStatus(t, migrator, len(migrations))
+}
+
+func TestMigrateUpDownStatusSQLite(t *testing.T) {
+	store, err := openSQLiteStore(context.Background(), "sqlite://"+filepath.Join(t.TempDir(), "tasks.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	testMigrateUpDownStatus(t, store)
+}
+
+// TestMigrateUpDownStatusPostgres runs the migrations against the Postgres
+// database in TEST_DATABASE_URL, and is skipped without one. Use an empty
+// database.
+func TestMigrateUpDownStatusPostgres(t *testing.T) {
+	dbURL := os.Getenv("TEST_DATABASE_URL")
+	if dbURL == "" {
+		t.Skip("TEST_DATABASE_URL is not set")
+	}
+	store, err := openPostgresStore(context.Background(), dbURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	testMigrateUpDownStatus(t, store)
+}
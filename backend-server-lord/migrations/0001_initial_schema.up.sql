@@ -0,0 +1,35 @@
+-- Base schema. IF NOT EXISTS lets databases created before migrations existed adopt it.
+CREATE TABLE IF NOT EXISTS users (
+    id SERIAL PRIMARY KEY,
+    username VARCHAR(50) UNIQUE NOT NULL,
+    email VARCHAR(100) UNIQUE NOT NULL,
+    password TEXT NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS tasks (
+    id SERIAL PRIMARY KEY,
+    name VARCHAR(255) NOT NULL,
+    ping_url VARCHAR(255),
+    user_id INTEGER REFERENCES users(id),
+    last_ping TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    interval INTEGER NOT NULL,
+    task_number INTEGER NOT NULL,
+    status VARCHAR(50) DEFAULT 'alive',
+    last_checked TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    previous_status VARCHAR(50) DEFAULT 'alive',
+    uptime_seconds FLOAT DEFAULT 0,
+    downtime_seconds FLOAT DEFAULT 0
+);
+
+CREATE TABLE IF NOT EXISTS task_graph_data (
+    id SERIAL PRIMARY KEY,
+    task_id INTEGER REFERENCES tasks(id) ON DELETE CASCADE,
+    timestamp TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    status VARCHAR(50) NOT NULL,
+    uptime_seconds FLOAT NOT NULL,
+    downtime_seconds FLOAT NOT NULL,
+    uptime_percentage FLOAT NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS idx_task_graph_data_task_id ON task_graph_data (task_id);
+CREATE INDEX IF NOT EXISTS idx_task_graph_data_timestamp ON task_graph_data (timestamp);
@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS task_transitions;
+ALTER TABLE tasks DROP COLUMN IF EXISTS status_changed_at;
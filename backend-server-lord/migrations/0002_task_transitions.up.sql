@@ -0,0 +1,19 @@
+ALTER TABLE tasks ADD COLUMN IF NOT EXISTS status_changed_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP;
+
+CREATE TABLE IF NOT EXISTS task_transitions (
+    id BIGSERIAL PRIMARY KEY,
+    task_id INTEGER REFERENCES tasks(id) ON DELETE CASCADE,
+    from_status VARCHAR(50),
+    to_status VARCHAR(50) NOT NULL,
+    transitioned_at TIMESTAMP NOT NULL,
+    uptime_seconds FLOAT NOT NULL DEFAULT 0,
+    downtime_seconds FLOAT NOT NULL DEFAULT 0
+);
+
+CREATE INDEX IF NOT EXISTS idx_task_transitions_task_id ON task_transitions (task_id, transitioned_at);
+
+-- Anchor the log for tasks created before transitions were recorded
+INSERT INTO task_transitions (task_id, from_status, to_status, transitioned_at, uptime_seconds, downtime_seconds)
+SELECT t.id, NULL, t.status, COALESCE(t.last_checked, CURRENT_TIMESTAMP), t.uptime_seconds, t.downtime_seconds
+FROM tasks t
+WHERE NOT EXISTS (SELECT 1 FROM task_transitions tt WHERE tt.task_id = t.id);
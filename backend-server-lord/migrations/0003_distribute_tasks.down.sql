@@ -0,0 +1,32 @@
+-- Turn the Citus tables back into local tables. No-op without Citus.
+DO $$
+BEGIN
+    IF NOT EXISTS (SELECT 1 FROM pg_extension WHERE extname = 'citus') THEN
+        RETURN;
+    END IF;
+
+    IF NOT EXISTS (SELECT 1 FROM pg_dist_partition WHERE logicalrelid = 'tasks'::regclass) THEN
+        RETURN;
+    END IF;
+
+    ALTER TABLE task_graph_data DROP CONSTRAINT IF EXISTS task_graph_data_task_id_fkey;
+    ALTER TABLE task_transitions DROP CONSTRAINT IF EXISTS task_transitions_task_id_fkey;
+    ALTER TABLE tasks DROP CONSTRAINT IF EXISTS tasks_user_id_fkey;
+
+    PERFORM undistribute_table('task_transitions');
+    PERFORM undistribute_table('task_graph_data');
+    PERFORM undistribute_table('tasks');
+    PERFORM undistribute_table('users');
+
+    ALTER TABLE task_graph_data DROP CONSTRAINT task_graph_data_pkey;
+    ALTER TABLE task_graph_data ADD PRIMARY KEY (id);
+    ALTER TABLE task_transitions DROP CONSTRAINT task_transitions_pkey;
+    ALTER TABLE task_transitions ADD PRIMARY KEY (id);
+
+    ALTER TABLE tasks ADD CONSTRAINT tasks_user_id_fkey FOREIGN KEY (user_id) REFERENCES users(id);
+    ALTER TABLE task_graph_data ADD CONSTRAINT task_graph_data_task_id_fkey
+        FOREIGN KEY (task_id) REFERENCES tasks(id) ON DELETE CASCADE;
+    ALTER TABLE task_transitions ADD CONSTRAINT task_transitions_task_id_fkey
+        FOREIGN KEY (task_id) REFERENCES tasks(id) ON DELETE CASCADE;
+END
+$$;
@@ -0,0 +1,48 @@
+-- Distribute tasks with Citus when the extension is installed. users becomes a
+-- reference table, tasks is hash distributed by id and the per-task tables are
+-- co-located with it so their ON DELETE CASCADE foreign keys keep working.
+-- Without Citus, or when tasks is already distributed, this is a no-op.
+--
+-- Later migrations place their tables by the same rules. A table keyed by
+-- task_id is distributed by it and co-located with tasks. Any other table is
+-- made a reference table, copied in full to every node: Citus only allows
+-- foreign keys from a reference table to other reference tables, and from a
+-- distributed table to reference tables or co-located rows. So such tables
+-- can reference users but not tasks, and keep task IDs without a foreign key.
+DO $$
+BEGIN
+    IF NOT EXISTS (SELECT 1 FROM pg_extension WHERE extname = 'citus') THEN
+        RAISE NOTICE 'citus is not installed, tasks stays a local table';
+        RETURN;
+    END IF;
+
+    IF EXISTS (SELECT 1 FROM pg_dist_partition WHERE logicalrelid = 'tasks'::regclass) THEN
+        RAISE NOTICE 'tasks is already distributed';
+        RETURN;
+    END IF;
+
+    -- Citus cannot distribute tables that local foreign keys point at, so the
+    -- foreign keys are dropped first and recreated once every table is distributed
+    ALTER TABLE task_graph_data DROP CONSTRAINT IF EXISTS task_graph_data_task_id_fkey;
+    ALTER TABLE task_transitions DROP CONSTRAINT IF EXISTS task_transitions_task_id_fkey;
+    ALTER TABLE tasks DROP CONSTRAINT IF EXISTS tasks_user_id_fkey;
+
+    PERFORM create_reference_table('users');
+    PERFORM create_distributed_table('tasks', 'id');
+
+    -- Primary keys of distributed tables must include the distribution column
+    ALTER TABLE task_graph_data DROP CONSTRAINT task_graph_data_pkey;
+    ALTER TABLE task_graph_data ADD PRIMARY KEY (task_id, id);
+    PERFORM create_distributed_table('task_graph_data', 'task_id', colocate_with => 'tasks');
+
+    ALTER TABLE task_transitions DROP CONSTRAINT task_transitions_pkey;
+    ALTER TABLE task_transitions ADD PRIMARY KEY (task_id, id);
+    PERFORM create_distributed_table('task_transitions', 'task_id', colocate_with => 'tasks');
+
+    ALTER TABLE tasks ADD CONSTRAINT tasks_user_id_fkey FOREIGN KEY (user_id) REFERENCES users(id);
+    ALTER TABLE task_graph_data ADD CONSTRAINT task_graph_data_task_id_fkey
+        FOREIGN KEY (task_id) REFERENCES tasks(id) ON DELETE CASCADE;
+    ALTER TABLE task_transitions ADD CONSTRAINT task_transitions_task_id_fkey
+        FOREIGN KEY (task_id) REFERENCES tasks(id) ON DELETE CASCADE;
+END
+$$;
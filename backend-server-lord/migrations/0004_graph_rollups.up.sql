@@ -0,0 +1,29 @@
+-- Rolled up graph data. Each resolution (60, 3600 and 86400 seconds) is a
+-- retention tier aggregated from the tier below it into aligned buckets.
+CREATE TABLE IF NOT EXISTS task_graph_rollups (
+    task_id INTEGER NOT NULL,
+    resolution_seconds INTEGER NOT NULL,
+    bucket_start TIMESTAMP NOT NULL,
+    samples INTEGER NOT NULL,
+    alive_samples INTEGER NOT NULL,
+    dead_samples INTEGER NOT NULL,
+    avg_uptime_percentage FLOAT NOT NULL,
+    uptime_seconds FLOAT NOT NULL,
+    downtime_seconds FLOAT NOT NULL,
+    PRIMARY KEY (task_id, resolution_seconds, bucket_start)
+);
+
+CREATE INDEX IF NOT EXISTS idx_task_graph_rollups_bucket ON task_graph_rollups (resolution_seconds, bucket_start);
+
+-- Co-locate with tasks when it is distributed, before the foreign key is added
+DO $$
+BEGIN
+    IF EXISTS (SELECT 1 FROM pg_extension WHERE extname = 'citus')
+        AND EXISTS (SELECT 1 FROM pg_dist_partition WHERE logicalrelid = 'tasks'::regclass) THEN
+        PERFORM create_distributed_table('task_graph_rollups', 'task_id', colocate_with => 'tasks');
+    END IF;
+END
+$$;
+
+ALTER TABLE task_graph_rollups ADD CONSTRAINT task_graph_rollups_task_id_fkey
+    FOREIGN KEY (task_id) REFERENCES tasks(id) ON DELETE CASCADE;
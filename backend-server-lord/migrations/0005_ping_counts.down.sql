@@ -0,0 +1,3 @@
+ALTER TABLE task_graph_rollups DROP COLUMN IF EXISTS ping_count;
+ALTER TABLE task_graph_data DROP COLUMN IF EXISTS ping_count;
+ALTER TABLE tasks DROP COLUMN IF EXISTS ping_count;
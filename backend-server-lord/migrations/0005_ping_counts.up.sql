@@ -0,0 +1,5 @@
+-- Cumulative heartbeat counters. Like the uptime and downtime counters they are
+-- sampled into the graph data, so the pings within any interval are a difference.
+ALTER TABLE tasks ADD COLUMN IF NOT EXISTS ping_count BIGINT NOT NULL DEFAULT 0;
+ALTER TABLE task_graph_data ADD COLUMN IF NOT EXISTS ping_count BIGINT NOT NULL DEFAULT 0;
+ALTER TABLE task_graph_rollups ADD COLUMN IF NOT EXISTS ping_count BIGINT NOT NULL DEFAULT 0;
@@ -0,0 +1,28 @@
+-- Service level objectives. Each SLO targets the share of time a task met its
+-- schedule over a rolling window of window_days.
+CREATE TABLE IF NOT EXISTS slos (
+    id BIGSERIAL NOT NULL,
+    task_id INTEGER NOT NULL,
+    name VARCHAR(255) NOT NULL,
+    target FLOAT NOT NULL,
+    window_days INTEGER NOT NULL,
+    webhook_url TEXT,
+    email VARCHAR(255),
+    created_at TIMESTAMP NOT NULL DEFAULT LOCALTIMESTAMP,
+    PRIMARY KEY (task_id, id)
+);
+
+CREATE INDEX IF NOT EXISTS idx_slos_id ON slos (id);
+
+-- Co-locate with tasks when it is distributed, before the foreign key is added
+DO $$
+BEGIN
+    IF EXISTS (SELECT 1 FROM pg_extension WHERE extname = 'citus')
+        AND EXISTS (SELECT 1 FROM pg_dist_partition WHERE logicalrelid = 'tasks'::regclass) THEN
+        PERFORM create_distributed_table('slos', 'task_id', colocate_with => 'tasks');
+    END IF;
+END
+$$;
+
+ALTER TABLE slos ADD CONSTRAINT slos_task_id_fkey
+    FOREIGN KEY (task_id) REFERENCES tasks(id) ON DELETE CASCADE;
@@ -0,0 +1,26 @@
+-- Scheduled availability reports, emailed after every completed period.
+-- last_period_end is the end of the last period that was sent.
+CREATE TABLE IF NOT EXISTS report_schedules (
+    id BIGSERIAL PRIMARY KEY,
+    user_id INTEGER NOT NULL,
+    period VARCHAR(16) NOT NULL,
+    format VARCHAR(16) NOT NULL,
+    email VARCHAR(255) NOT NULL,
+    last_period_end TIMESTAMP,
+    created_at TIMESTAMP NOT NULL DEFAULT LOCALTIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_report_schedules_user_id ON report_schedules (user_id);
+
+-- users is a reference table under Citus, so this one is replicated alongside it
+DO $$
+BEGIN
+    IF EXISTS (SELECT 1 FROM pg_extension WHERE extname = 'citus')
+        AND EXISTS (SELECT 1 FROM pg_dist_partition WHERE logicalrelid = 'users'::regclass) THEN
+        PERFORM create_reference_table('report_schedules');
+    END IF;
+END
+$$;
+
+ALTER TABLE report_schedules ADD CONSTRAINT report_schedules_user_id_fkey
+    FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE;
@@ -0,0 +1,30 @@
+-- Status pages share the health of selected tasks under a public slug.
+-- task_ids is an array rather than a join table: tasks are distributed under
+-- Citus and a reference table cannot point at them, so deleted tasks are
+-- skipped when the page is rendered.
+CREATE TABLE IF NOT EXISTS status_pages (
+    id BIGSERIAL PRIMARY KEY,
+    user_id INTEGER NOT NULL,
+    slug VARCHAR(64) NOT NULL UNIQUE,
+    title VARCHAR(255) NOT NULL,
+    task_ids BIGINT[] NOT NULL DEFAULT '{}',
+    protection VARCHAR(16) NOT NULL DEFAULT 'public',
+    password_hash VARCHAR(255),
+    access_token VARCHAR(64),
+    created_at TIMESTAMP NOT NULL DEFAULT LOCALTIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_status_pages_user_id ON status_pages (user_id);
+
+-- users is a reference table under Citus, so this one is replicated alongside it
+DO $$
+BEGIN
+    IF EXISTS (SELECT 1 FROM pg_extension WHERE extname = 'citus')
+        AND EXISTS (SELECT 1 FROM pg_dist_partition WHERE logicalrelid = 'users'::regclass) THEN
+        PERFORM create_reference_table('status_pages');
+    END IF;
+END
+$$;
+
+ALTER TABLE status_pages ADD CONSTRAINT status_pages_user_id_fkey
+    FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE;
@@ -0,0 +1,27 @@
+-- Read-only badge tokens for embedding a task's status in other pages. Like
+-- status_pages, badges hang off users and only keep the task ID, since a
+-- reference table cannot point at the distributed tasks table.
+CREATE TABLE IF NOT EXISTS badges (
+    id BIGSERIAL PRIMARY KEY,
+    user_id INTEGER NOT NULL,
+    task_id BIGINT NOT NULL,
+    label VARCHAR(255) NOT NULL DEFAULT '',
+    show_uptime BOOLEAN NOT NULL DEFAULT FALSE,
+    token VARCHAR(64) NOT NULL UNIQUE,
+    created_at TIMESTAMP NOT NULL DEFAULT LOCALTIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_badges_user_id ON badges (user_id);
+
+-- users is a reference table under Citus, so this one is replicated alongside it
+DO $$
+BEGIN
+    IF EXISTS (SELECT 1 FROM pg_extension WHERE extname = 'citus')
+        AND EXISTS (SELECT 1 FROM pg_dist_partition WHERE logicalrelid = 'users'::regclass) THEN
+        PERFORM create_reference_table('badges');
+    END IF;
+END
+$$;
+
+ALTER TABLE badges ADD CONSTRAINT badges_user_id_fkey
+    FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE;
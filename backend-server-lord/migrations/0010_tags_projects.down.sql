@@ -0,0 +1,13 @@
+DROP TABLE IF EXISTS notification_routes;
+
+DELETE FROM badges WHERE task_id = 0;
+ALTER TABLE badges DROP COLUMN IF EXISTS project;
+ALTER TABLE badges DROP COLUMN IF EXISTS tag;
+
+-- Paused tasks had no status before
+UPDATE tasks SET status = 'alive' WHERE status = 'paused';
+
+DROP INDEX IF EXISTS idx_tasks_tags;
+DROP INDEX IF EXISTS idx_tasks_user_id_project;
+ALTER TABLE tasks DROP COLUMN IF EXISTS tags;
+ALTER TABLE tasks DROP COLUMN IF EXISTS project;
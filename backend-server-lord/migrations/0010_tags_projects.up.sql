@@ -0,0 +1,38 @@
+-- Tasks belong to a project and carry free-form tags, which lists, graphs,
+-- badges, bulk operations and notification routes filter on.
+ALTER TABLE tasks ADD COLUMN IF NOT EXISTS project VARCHAR(255) NOT NULL DEFAULT '';
+ALTER TABLE tasks ADD COLUMN IF NOT EXISTS tags TEXT[] NOT NULL DEFAULT '{}';
+
+CREATE INDEX IF NOT EXISTS idx_tasks_user_id_project ON tasks (user_id, project);
+CREATE INDEX IF NOT EXISTS idx_tasks_tags ON tasks USING GIN (tags);
+
+-- Badges cover a single task (task_id) or all tasks with a tag or in a project
+-- (task_id 0)
+ALTER TABLE badges ADD COLUMN IF NOT EXISTS tag VARCHAR(64) NOT NULL DEFAULT '';
+ALTER TABLE badges ADD COLUMN IF NOT EXISTS project VARCHAR(255) NOT NULL DEFAULT '';
+
+-- Extra alert destinations for the tasks with a tag and/or in a project
+CREATE TABLE IF NOT EXISTS notification_routes (
+    id BIGSERIAL PRIMARY KEY,
+    user_id INTEGER NOT NULL,
+    tag VARCHAR(64) NOT NULL DEFAULT '',
+    project VARCHAR(255) NOT NULL DEFAULT '',
+    webhook_url TEXT NOT NULL DEFAULT '',
+    email VARCHAR(255) NOT NULL DEFAULT '',
+    created_at TIMESTAMP NOT NULL DEFAULT LOCALTIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_notification_routes_user_id ON notification_routes (user_id);
+
+-- users is a reference table under Citus, so this one is replicated alongside it
+DO $$
+BEGIN
+    IF EXISTS (SELECT 1 FROM pg_extension WHERE extname = 'citus')
+        AND EXISTS (SELECT 1 FROM pg_dist_partition WHERE logicalrelid = 'users'::regclass) THEN
+        PERFORM create_reference_table('notification_routes');
+    END IF;
+END
+$$;
+
+ALTER TABLE notification_routes ADD CONSTRAINT notification_routes_user_id_fkey
+    FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE;
@@ -0,0 +1,11 @@
+-- Everything shared with an organization goes back to the user who created it
+DROP INDEX IF EXISTS idx_notification_routes_org_id;
+DROP INDEX IF EXISTS idx_status_pages_org_id;
+DROP INDEX IF EXISTS idx_tasks_org_id;
+ALTER TABLE notification_routes DROP COLUMN IF EXISTS org_id;
+ALTER TABLE status_pages DROP COLUMN IF EXISTS org_id;
+ALTER TABLE tasks DROP COLUMN IF EXISTS org_id;
+
+DROP TABLE IF EXISTS org_invitations;
+DROP TABLE IF EXISTS org_members;
+DROP TABLE IF EXISTS organizations;
@@ -0,0 +1,64 @@
+-- Organizations let a team share tasks, status pages and notification routes.
+-- Every member has one role: owner, admin, editor or viewer.
+CREATE TABLE IF NOT EXISTS organizations (
+    id BIGSERIAL PRIMARY KEY,
+    name VARCHAR(255) NOT NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT LOCALTIMESTAMP
+);
+
+CREATE TABLE IF NOT EXISTS org_members (
+    org_id BIGINT NOT NULL,
+    user_id INTEGER NOT NULL,
+    role VARCHAR(16) NOT NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT LOCALTIMESTAMP,
+    PRIMARY KEY (org_id, user_id)
+);
+
+CREATE INDEX IF NOT EXISTS idx_org_members_user_id ON org_members (user_id);
+
+-- Pending invitations by email. Only the SHA-256 of the emailed token is kept.
+CREATE TABLE IF NOT EXISTS org_invitations (
+    id BIGSERIAL PRIMARY KEY,
+    org_id BIGINT NOT NULL,
+    email VARCHAR(255) NOT NULL,
+    role VARCHAR(16) NOT NULL,
+    token_hash VARCHAR(64) NOT NULL UNIQUE,
+    invited_by INTEGER NOT NULL,
+    expires_at TIMESTAMP NOT NULL,
+    accepted_at TIMESTAMP,
+    created_at TIMESTAMP NOT NULL DEFAULT LOCALTIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_org_invitations_org_id ON org_invitations (org_id);
+
+-- users is a reference table under Citus, so these are replicated alongside it
+DO $$
+BEGIN
+    IF EXISTS (SELECT 1 FROM pg_extension WHERE extname = 'citus')
+        AND EXISTS (SELECT 1 FROM pg_dist_partition WHERE logicalrelid = 'users'::regclass) THEN
+        PERFORM create_reference_table('organizations');
+        PERFORM create_reference_table('org_members');
+        PERFORM create_reference_table('org_invitations');
+    END IF;
+END
+$$;
+
+ALTER TABLE org_members ADD CONSTRAINT org_members_org_id_fkey
+    FOREIGN KEY (org_id) REFERENCES organizations(id) ON DELETE CASCADE;
+ALTER TABLE org_members ADD CONSTRAINT org_members_user_id_fkey
+    FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE;
+ALTER TABLE org_invitations ADD CONSTRAINT org_invitations_org_id_fkey
+    FOREIGN KEY (org_id) REFERENCES organizations(id) ON DELETE CASCADE;
+
+-- Tasks, status pages and notification routes belong to an organization, or
+-- to their user alone when org_id is NULL. tasks.org_id has no foreign key:
+-- an organization is only deleted once it has no tasks left.
+ALTER TABLE tasks ADD COLUMN IF NOT EXISTS org_id BIGINT;
+ALTER TABLE status_pages ADD COLUMN IF NOT EXISTS org_id BIGINT
+    REFERENCES organizations(id) ON DELETE CASCADE;
+ALTER TABLE notification_routes ADD COLUMN IF NOT EXISTS org_id BIGINT
+    REFERENCES organizations(id) ON DELETE CASCADE;
+
+CREATE INDEX IF NOT EXISTS idx_tasks_org_id ON tasks (org_id);
+CREATE INDEX IF NOT EXISTS idx_status_pages_org_id ON status_pages (org_id);
+CREATE INDEX IF NOT EXISTS idx_notification_routes_org_id ON notification_routes (org_id);
@@ -0,0 +1,30 @@
+-- Login sessions. Each holds the SHA-256 of its current refresh token and of
+-- the one it replaced, so a replayed (stolen) refresh token is detected.
+-- Access tokens carry the session ID and stop working once it is deleted.
+CREATE TABLE IF NOT EXISTS sessions (
+    id BIGSERIAL PRIMARY KEY,
+    user_id INTEGER NOT NULL,
+    refresh_hash VARCHAR(64) NOT NULL UNIQUE,
+    previous_refresh_hash VARCHAR(64) NOT NULL DEFAULT '',
+    user_agent TEXT NOT NULL DEFAULT '',
+    ip VARCHAR(64) NOT NULL DEFAULT '',
+    created_at TIMESTAMP NOT NULL DEFAULT LOCALTIMESTAMP,
+    last_used_at TIMESTAMP NOT NULL DEFAULT LOCALTIMESTAMP,
+    expires_at TIMESTAMP NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS idx_sessions_user_id ON sessions (user_id);
+CREATE INDEX IF NOT EXISTS idx_sessions_previous_refresh_hash ON sessions (previous_refresh_hash);
+
+-- users is a reference table under Citus, so this one is replicated alongside it
+DO $$
+BEGIN
+    IF EXISTS (SELECT 1 FROM pg_extension WHERE extname = 'citus')
+        AND EXISTS (SELECT 1 FROM pg_dist_partition WHERE logicalrelid = 'users'::regclass) THEN
+        PERFORM create_reference_table('sessions');
+    END IF;
+END
+$$;
+
+ALTER TABLE sessions ADD CONSTRAINT sessions_user_id_fkey
+    FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE;
@@ -0,0 +1,30 @@
+-- Users confirm their email address by following an emailed link
+ALTER TABLE users ADD COLUMN IF NOT EXISTS email_verified_at TIMESTAMP;
+
+-- Single-use tokens emailed to verify an address or reset a password. Only
+-- the SHA-256 of the token is kept. email is the address the token was sent
+-- to, so a verification link stops working once the address changes.
+CREATE TABLE IF NOT EXISTS user_tokens (
+    id BIGSERIAL PRIMARY KEY,
+    user_id INTEGER NOT NULL,
+    purpose VARCHAR(32) NOT NULL,
+    token_hash VARCHAR(64) NOT NULL UNIQUE,
+    email VARCHAR(100) NOT NULL,
+    expires_at TIMESTAMP NOT NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT LOCALTIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_user_tokens_user_id ON user_tokens (user_id);
+
+-- users is a reference table under Citus, so this one is replicated alongside it
+DO $$
+BEGIN
+    IF EXISTS (SELECT 1 FROM pg_extension WHERE extname = 'citus')
+        AND EXISTS (SELECT 1 FROM pg_dist_partition WHERE logicalrelid = 'users'::regclass) THEN
+        PERFORM create_reference_table('user_tokens');
+    END IF;
+END
+$$;
+
+ALTER TABLE user_tokens ADD CONSTRAINT user_tokens_user_id_fkey
+    FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE;
@@ -0,0 +1,30 @@
+-- TOTP two-factor authentication. totp_secret is set when enrollment starts
+-- and totp_enabled_at once a code confirmed it. totp_last_step is the time
+-- step of the last accepted code, which cannot be used again.
+ALTER TABLE users ADD COLUMN IF NOT EXISTS totp_secret VARCHAR(64);
+ALTER TABLE users ADD COLUMN IF NOT EXISTS totp_enabled_at TIMESTAMP;
+ALTER TABLE users ADD COLUMN IF NOT EXISTS totp_last_step BIGINT NOT NULL DEFAULT 0;
+
+-- Single-use recovery codes for a lost authenticator. Only their SHA-256 is kept.
+CREATE TABLE IF NOT EXISTS recovery_codes (
+    user_id INTEGER NOT NULL,
+    code_hash VARCHAR(64) NOT NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT LOCALTIMESTAMP,
+    PRIMARY KEY (user_id, code_hash)
+);
+
+-- users is a reference table under Citus, so this one is replicated alongside it
+DO $$
+BEGIN
+    IF EXISTS (SELECT 1 FROM pg_extension WHERE extname = 'citus')
+        AND EXISTS (SELECT 1 FROM pg_dist_partition WHERE logicalrelid = 'users'::regclass) THEN
+        PERFORM create_reference_table('recovery_codes');
+    END IF;
+END
+$$;
+
+ALTER TABLE recovery_codes ADD CONSTRAINT recovery_codes_user_id_fkey
+    FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE;
+
+-- Organizations can deny members without two-factor authentication access
+ALTER TABLE organizations ADD COLUMN IF NOT EXISTS require_2fa BOOLEAN NOT NULL DEFAULT FALSE;
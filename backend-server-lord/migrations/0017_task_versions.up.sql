@@ -0,0 +1,4 @@
+-- Counts the edits of a task through the API. It is the ETag of the task, so
+-- an edit can require that nobody changed the task since it was read. The
+-- monitor and heartbeats do not change it.
+ALTER TABLE tasks ADD COLUMN IF NOT EXISTS version BIGINT NOT NULL DEFAULT 1;
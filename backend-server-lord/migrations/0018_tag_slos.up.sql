@@ -0,0 +1,29 @@
+-- SLOs on a tag instead of a task cover every personal task of user_id
+-- carrying the tag. They draw their IDs from the sequence of the task SLOs,
+-- so an ID names one SLO across both tables.
+CREATE TABLE IF NOT EXISTS tag_slos (
+    id BIGINT PRIMARY KEY DEFAULT nextval('slos_id_seq'),
+    user_id INTEGER NOT NULL,
+    tag VARCHAR(64) NOT NULL,
+    name VARCHAR(255) NOT NULL,
+    target FLOAT NOT NULL,
+    window_days INTEGER NOT NULL,
+    webhook_url TEXT,
+    email VARCHAR(255),
+    created_at TIMESTAMP NOT NULL DEFAULT LOCALTIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_tag_slos_user_id ON tag_slos (user_id);
+
+-- users is a reference table under Citus, so this one is replicated alongside it
+DO $$
+BEGIN
+    IF EXISTS (SELECT 1 FROM pg_extension WHERE extname = 'citus')
+        AND EXISTS (SELECT 1 FROM pg_dist_partition WHERE logicalrelid = 'users'::regclass) THEN
+        PERFORM create_reference_table('tag_slos');
+    END IF;
+END
+$$;
+
+ALTER TABLE tag_slos ADD CONSTRAINT tag_slos_user_id_fkey
+    FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE;
@@ -0,0 +1,2 @@
+ALTER TABLE tag_slos DROP COLUMN IF EXISTS kind;
+ALTER TABLE slos DROP COLUMN IF EXISTS kind;
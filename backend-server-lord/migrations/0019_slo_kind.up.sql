@@ -0,0 +1,4 @@
+-- An SLO measures the share of time its tasks are alive (time) or the share
+-- of their runs that succeed (runs)
+ALTER TABLE slos ADD COLUMN IF NOT EXISTS kind VARCHAR(8) NOT NULL DEFAULT 'time';
+ALTER TABLE tag_slos ADD COLUMN IF NOT EXISTS kind VARCHAR(8) NOT NULL DEFAULT 'time';
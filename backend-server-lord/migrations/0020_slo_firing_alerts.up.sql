@@ -0,0 +1,4 @@
+-- The burn-rate alerts last notified as firing, comma-separated, so that
+-- restarts and other servers do not notify again
+ALTER TABLE slos ADD COLUMN IF NOT EXISTS firing_alerts VARCHAR(64) NOT NULL DEFAULT '';
+ALTER TABLE tag_slos ADD COLUMN IF NOT EXISTS firing_alerts VARCHAR(64) NOT NULL DEFAULT '';
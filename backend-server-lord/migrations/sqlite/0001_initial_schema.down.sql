@@ -0,0 +1,3 @@
+DROP TABLE IF EXISTS task_graph_data;
+DROP TABLE IF EXISTS tasks;
+DROP TABLE IF EXISTS users;
@@ -0,0 +1,38 @@
+-- Base schema, matching migrations/0001_initial_schema.up.sql
+CREATE TABLE IF NOT EXISTS users (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    username VARCHAR(50) UNIQUE NOT NULL,
+    email VARCHAR(100) UNIQUE NOT NULL,
+    password TEXT NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS tasks (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    name VARCHAR(255) NOT NULL,
+    ping_url VARCHAR(255),
+    user_id INTEGER REFERENCES users(id),
+    last_ping TIMESTAMP,
+    interval INTEGER NOT NULL,
+    task_number INTEGER NOT NULL,
+    status VARCHAR(50) DEFAULT 'alive',
+    last_checked TIMESTAMP,
+    previous_status VARCHAR(50) DEFAULT 'alive',
+    uptime_seconds REAL DEFAULT 0,
+    downtime_seconds REAL DEFAULT 0
+);
+
+CREATE INDEX IF NOT EXISTS idx_tasks_user_id ON tasks (user_id);
+CREATE INDEX IF NOT EXISTS idx_tasks_task_number ON tasks (task_number);
+
+CREATE TABLE IF NOT EXISTS task_graph_data (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    task_id INTEGER REFERENCES tasks(id) ON DELETE CASCADE,
+    timestamp TIMESTAMP NOT NULL,
+    status VARCHAR(50) NOT NULL,
+    uptime_seconds REAL NOT NULL,
+    downtime_seconds REAL NOT NULL,
+    uptime_percentage REAL NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS idx_task_graph_data_task_id ON task_graph_data (task_id);
+CREATE INDEX IF NOT EXISTS idx_task_graph_data_timestamp ON task_graph_data (timestamp);
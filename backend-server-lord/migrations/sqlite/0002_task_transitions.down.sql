@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS task_transitions;
+ALTER TABLE tasks DROP COLUMN status_changed_at;
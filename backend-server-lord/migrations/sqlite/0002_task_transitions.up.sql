@@ -0,0 +1,14 @@
+ALTER TABLE tasks ADD COLUMN status_changed_at TIMESTAMP;
+
+CREATE TABLE IF NOT EXISTS task_transitions (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    task_id INTEGER REFERENCES tasks(id) ON DELETE CASCADE,
+    from_status VARCHAR(50),
+    to_status VARCHAR(50) NOT NULL,
+    transitioned_at TIMESTAMP NOT NULL,
+    uptime_seconds REAL NOT NULL DEFAULT 0,
+    downtime_seconds REAL NOT NULL DEFAULT 0
+);
+
+CREATE INDEX IF NOT EXISTS idx_task_transitions_task_id ON task_transitions (task_id, transitioned_at);
+
@@ -0,0 +1,14 @@
+CREATE TABLE IF NOT EXISTS task_graph_rollups (
+    task_id INTEGER NOT NULL REFERENCES tasks(id) ON DELETE CASCADE,
+    resolution_seconds INTEGER NOT NULL,
+    bucket_start TIMESTAMP NOT NULL,
+    samples INTEGER NOT NULL,
+    alive_samples INTEGER NOT NULL,
+    dead_samples INTEGER NOT NULL,
+    avg_uptime_percentage REAL NOT NULL,
+    uptime_seconds REAL NOT NULL,
+    downtime_seconds REAL NOT NULL,
+    PRIMARY KEY (task_id, resolution_seconds, bucket_start)
+);
+
+CREATE INDEX IF NOT EXISTS idx_task_graph_rollups_bucket ON task_graph_rollups (resolution_seconds, bucket_start);
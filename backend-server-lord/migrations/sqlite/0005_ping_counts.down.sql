@@ -0,0 +1,3 @@
+ALTER TABLE task_graph_rollups DROP COLUMN ping_count;
+ALTER TABLE task_graph_data DROP COLUMN ping_count;
+ALTER TABLE tasks DROP COLUMN ping_count;
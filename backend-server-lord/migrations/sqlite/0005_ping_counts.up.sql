@@ -0,0 +1,3 @@
+ALTER TABLE tasks ADD COLUMN ping_count INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE task_graph_data ADD COLUMN ping_count INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE task_graph_rollups ADD COLUMN ping_count INTEGER NOT NULL DEFAULT 0;
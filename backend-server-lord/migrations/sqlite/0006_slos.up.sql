@@ -0,0 +1,12 @@
+CREATE TABLE IF NOT EXISTS slos (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    task_id INTEGER NOT NULL REFERENCES tasks(id) ON DELETE CASCADE,
+    name VARCHAR(255) NOT NULL,
+    target REAL NOT NULL,
+    window_days INTEGER NOT NULL,
+    webhook_url TEXT,
+    email VARCHAR(255),
+    created_at TIMESTAMP NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS idx_slos_task_id ON slos (task_id);
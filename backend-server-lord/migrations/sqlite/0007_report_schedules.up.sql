@@ -0,0 +1,11 @@
+CREATE TABLE IF NOT EXISTS report_schedules (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    period VARCHAR(16) NOT NULL,
+    format VARCHAR(16) NOT NULL,
+    email VARCHAR(255) NOT NULL,
+    last_period_end TIMESTAMP,
+    created_at TIMESTAMP NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS idx_report_schedules_user_id ON report_schedules (user_id);
@@ -0,0 +1,14 @@
+-- task_ids is a JSON array of task IDs
+CREATE TABLE IF NOT EXISTS status_pages (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    slug VARCHAR(64) NOT NULL UNIQUE,
+    title VARCHAR(255) NOT NULL,
+    task_ids TEXT NOT NULL DEFAULT '[]',
+    protection VARCHAR(16) NOT NULL DEFAULT 'public',
+    password_hash VARCHAR(255),
+    access_token VARCHAR(64),
+    created_at TIMESTAMP NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS idx_status_pages_user_id ON status_pages (user_id);
@@ -0,0 +1,11 @@
+CREATE TABLE IF NOT EXISTS badges (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    task_id INTEGER NOT NULL,
+    label VARCHAR(255) NOT NULL DEFAULT '',
+    show_uptime BOOLEAN NOT NULL DEFAULT FALSE,
+    token VARCHAR(64) NOT NULL UNIQUE,
+    created_at TIMESTAMP NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS idx_badges_user_id ON badges (user_id);
@@ -0,0 +1,11 @@
+DROP TABLE IF EXISTS notification_routes;
+
+DELETE FROM badges WHERE task_id = 0;
+ALTER TABLE badges DROP COLUMN project;
+ALTER TABLE badges DROP COLUMN tag;
+
+UPDATE tasks SET status = 'alive' WHERE status = 'paused';
+
+DROP INDEX IF EXISTS idx_tasks_user_id_project;
+ALTER TABLE tasks DROP COLUMN tags;
+ALTER TABLE tasks DROP COLUMN project;
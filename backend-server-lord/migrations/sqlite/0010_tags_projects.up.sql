@@ -0,0 +1,20 @@
+-- tags is a JSON array of strings
+ALTER TABLE tasks ADD COLUMN project VARCHAR(255) NOT NULL DEFAULT '';
+ALTER TABLE tasks ADD COLUMN tags TEXT NOT NULL DEFAULT '[]';
+
+CREATE INDEX IF NOT EXISTS idx_tasks_user_id_project ON tasks (user_id, project);
+
+ALTER TABLE badges ADD COLUMN tag VARCHAR(64) NOT NULL DEFAULT '';
+ALTER TABLE badges ADD COLUMN project VARCHAR(255) NOT NULL DEFAULT '';
+
+CREATE TABLE IF NOT EXISTS notification_routes (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    tag VARCHAR(64) NOT NULL DEFAULT '',
+    project VARCHAR(255) NOT NULL DEFAULT '',
+    webhook_url TEXT NOT NULL DEFAULT '',
+    email VARCHAR(255) NOT NULL DEFAULT '',
+    created_at TIMESTAMP NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS idx_notification_routes_user_id ON notification_routes (user_id);
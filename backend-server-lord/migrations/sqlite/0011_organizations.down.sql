@@ -0,0 +1,10 @@
+DROP INDEX IF EXISTS idx_notification_routes_org_id;
+DROP INDEX IF EXISTS idx_status_pages_org_id;
+DROP INDEX IF EXISTS idx_tasks_org_id;
+ALTER TABLE notification_routes DROP COLUMN org_id;
+ALTER TABLE status_pages DROP COLUMN org_id;
+ALTER TABLE tasks DROP COLUMN org_id;
+
+DROP TABLE IF EXISTS org_invitations;
+DROP TABLE IF EXISTS org_members;
+DROP TABLE IF EXISTS organizations;
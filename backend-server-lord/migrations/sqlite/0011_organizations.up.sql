@@ -0,0 +1,39 @@
+CREATE TABLE IF NOT EXISTS organizations (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    name VARCHAR(255) NOT NULL,
+    created_at TIMESTAMP NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS org_members (
+    org_id INTEGER NOT NULL REFERENCES organizations(id) ON DELETE CASCADE,
+    user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    role VARCHAR(16) NOT NULL,
+    created_at TIMESTAMP NOT NULL,
+    PRIMARY KEY (org_id, user_id)
+);
+
+CREATE INDEX IF NOT EXISTS idx_org_members_user_id ON org_members (user_id);
+
+CREATE TABLE IF NOT EXISTS org_invitations (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    org_id INTEGER NOT NULL REFERENCES organizations(id) ON DELETE CASCADE,
+    email VARCHAR(255) NOT NULL,
+    role VARCHAR(16) NOT NULL,
+    token_hash VARCHAR(64) NOT NULL UNIQUE,
+    invited_by INTEGER NOT NULL,
+    expires_at TIMESTAMP NOT NULL,
+    accepted_at TIMESTAMP,
+    created_at TIMESTAMP NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS idx_org_invitations_org_id ON org_invitations (org_id);
+
+-- No foreign keys on the org_id columns, SQLite cannot drop such columns
+-- again; DeleteOrg removes the status pages and routes itself
+ALTER TABLE tasks ADD COLUMN org_id INTEGER;
+ALTER TABLE status_pages ADD COLUMN org_id INTEGER;
+ALTER TABLE notification_routes ADD COLUMN org_id INTEGER;
+
+CREATE INDEX IF NOT EXISTS idx_tasks_org_id ON tasks (org_id);
+CREATE INDEX IF NOT EXISTS idx_status_pages_org_id ON status_pages (org_id);
+CREATE INDEX IF NOT EXISTS idx_notification_routes_org_id ON notification_routes (org_id);
@@ -0,0 +1,18 @@
+CREATE TABLE slos_old (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    task_id INTEGER NOT NULL REFERENCES tasks(id) ON DELETE CASCADE,
+    name VARCHAR(255) NOT NULL,
+    target REAL NOT NULL,
+    window_days INTEGER NOT NULL,
+    webhook_url TEXT,
+    email VARCHAR(255),
+    created_at TIMESTAMP NOT NULL
+);
+
+INSERT INTO slos_old (id, task_id, name, target, window_days, webhook_url, email, created_at)
+    SELECT id, task_id, name, target, window_days, webhook_url, email, created_at FROM slos
+    WHERE task_id IS NOT NULL;
+DROP TABLE slos;
+ALTER TABLE slos_old RENAME TO slos;
+
+CREATE INDEX IF NOT EXISTS idx_slos_task_id ON slos (task_id);
@@ -0,0 +1,24 @@
+-- SLOs on a tag instead of a task cover every personal task of user_id
+-- carrying the tag. SQLite cannot relax NOT NULL in place, so the table is
+-- rebuilt.
+CREATE TABLE slos_new (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    task_id INTEGER REFERENCES tasks(id) ON DELETE CASCADE,
+    user_id INTEGER REFERENCES users(id) ON DELETE CASCADE,
+    tag VARCHAR(64),
+    name VARCHAR(255) NOT NULL,
+    target REAL NOT NULL,
+    window_days INTEGER NOT NULL,
+    webhook_url TEXT,
+    email VARCHAR(255),
+    created_at TIMESTAMP NOT NULL,
+    CHECK ((task_id IS NULL) = (tag IS NOT NULL))
+);
+
+INSERT INTO slos_new (id, task_id, name, target, window_days, webhook_url, email, created_at)
+    SELECT id, task_id, name, target, window_days, webhook_url, email, created_at FROM slos;
+DROP TABLE slos;
+ALTER TABLE slos_new RENAME TO slos;
+
+CREATE INDEX IF NOT EXISTS idx_slos_task_id ON slos (task_id);
+CREATE INDEX IF NOT EXISTS idx_slos_user_id ON slos (user_id);
@@ -0,0 +1,3 @@
+-- An SLO measures the share of time its tasks are alive (time) or the share
+-- of their runs that succeed (runs)
+ALTER TABLE slos ADD COLUMN kind VARCHAR(8) NOT NULL DEFAULT 'time';
@@ -0,0 +1 @@
+ALTER TABLE slos DROP COLUMN firing_alerts;
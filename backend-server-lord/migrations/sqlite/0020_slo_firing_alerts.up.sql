@@ -0,0 +1,3 @@
+-- The burn-rate alerts last notified as firing, comma-separated, so that
+-- restarts do not notify again
+ALTER TABLE slos ADD COLUMN firing_alerts VARCHAR(64) NOT NULL DEFAULT '';
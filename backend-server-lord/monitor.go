@@ -0,0 +1,297 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"go.opentelemetry.io/otel"
+)
+
+// taskmonitoring function
+// ShardInfo tracks monitoring information about shards
+type ShardInfo struct {
+	Name          string
+	LastMonitored time.Time
+}
+
+// Global map to track when each shard was last monitored
+var shardMonitoringInfo = make(map[string]*ShardInfo)
+var shardMutex sync.RWMutex
+
+// How often the monitor checks every task, which is also the spacing of the
+// raw graph samples
+const monitorTickInterval = 5 * time.Second
+
+// printShardReports controls the per-task status table printed for every shard.
+// The benchmark turns it off so that terminal output does not dominate the timings.
+var printShardReports = true
+
+// MonitoringSummary holds the task counts of one checkTaskStatus run
+type MonitoringSummary struct {
+	TotalTasks   int `json:"total_tasks"`
+	AliveTasks   int `json:"alive_tasks"`
+	DeadTasks    int `json:"dead_tasks"`
+	PausedTasks  int `json:"paused_tasks"`
+	UpdatedTasks int `json:"updated_tasks"`
+	WarningTasks int `json:"warning_tasks"` // Tasks approaching their timeout
+	FailedShards int `json:"failed_shards"`
+}
+
+// shardResult summarises a single monitor pass over one shard
+type shardResult struct {
+	Updated  int
+	Alive    int
+	Dead     int
+	Paused   int
+	NewDead  int
+	Warnings int
+}
+
+// checkTaskStatus runs one monitor pass over every shard. A pass that takes
+// longer than monitorMaxTickAge is cancelled, so a hung shard cannot hold up
+// the next ticks or the shutdown.
+func (s *Server) checkTaskStatus(ctx context.Context) {
+	ctx, cancel := context.WithTimeout(ctx, monitorMaxTickAge())
+	defer cancel()
+
+	// Start an OpenTelemetry span
+	ctx, span := otel.Tracer("task-tracker").Start(ctx, "checkTaskStatus")
+	startTime := time.Now()
+
+	// Track monitoring stats
+	var monitoringSummary struct {
+		sync.Mutex
+		MonitoringSummary
+	}
+	var tickErr error
+
+	defer func() {
+		endTime := time.Now()
+		duration := endTime.Sub(startTime)
+		span.End()
+
+		recordMonitorTick(endTime, duration, monitoringSummary.MonitoringSummary, tickErr)
+
+		// Print timing information
+		output := fmt.Sprintf("Route: checkTaskStatus | Start: %s | End: %s | Duration: %dms\n",
+			startTime.Format(time.RFC3339Nano), endTime.Format(time.RFC3339Nano), duration.Milliseconds(),
+		)
+		os.Stdout.WriteString(output)
+	}()
+
+	// After a stall (or on the first tick after startup) heartbeats may have been
+	// lost, so overdue tasks get a grace period instead of being marked dead en masse
+	graceStart, err := monitorGraceStart(ctx, s.monitor)
+	if err != nil {
+		log.Printf("Error determining monitor grace period: %v", err)
+		tickErr = err
+		return
+	}
+
+	log.Println("Fetching shards for task monitoring...")
+
+	shards, err := s.monitor.MonitorPartitions(ctx)
+	if err != nil {
+		log.Printf("Error fetching shard names: %v", err)
+		tickErr = err
+		return
+	}
+
+	log.Printf("Found %d shards: %v", len(shards), shards)
+
+	// Create a semaphore with fixed capacity
+	maxConcurrentShards := 3
+	sem := make(chan struct{}, maxConcurrentShards)
+
+	// Create a wait group to wait for all goroutines to finish
+	var wg sync.WaitGroup
+
+	// Process each shard
+	for _, shard := range shards {
+		// Check if this shard needs monitoring
+		shardMutex.RLock()
+		info, exists := shardMonitoringInfo[shard]
+		needsMonitoring := !exists || time.Since(info.LastMonitored) > 15*time.Second
+		shardMutex.RUnlock()
+
+		if !needsMonitoring {
+			log.Printf("Skipping shard %s - recently monitored at %v", shard, info.LastMonitored)
+			continue
+		}
+
+		// Increment wait group counter
+		wg.Add(1)
+
+		// Acquire semaphore slot (this will block if all slots are in use)
+		sem <- struct{}{}
+
+		// Process shard in a separate goroutine
+		go func(shardName string) {
+			defer wg.Done()
+			defer func() { <-sem }() // Release semaphore when done
+
+			result, err := monitorShard(ctx, s.monitor, shardName, graceStart)
+			if err != nil {
+				log.Printf("Error monitoring shard %s: %v", shardName, err)
+				monitoringSummary.Lock()
+				monitoringSummary.FailedShards++
+				monitoringSummary.Unlock()
+				return
+			}
+
+			// Update global counters
+			monitoringSummary.Lock()
+			monitoringSummary.TotalTasks += (result.Alive + result.Dead + result.Paused)
+			monitoringSummary.AliveTasks += result.Alive
+			monitoringSummary.DeadTasks += result.Dead
+			monitoringSummary.PausedTasks += result.Paused
+			monitoringSummary.UpdatedTasks += result.NewDead
+			monitoringSummary.WarningTasks += result.Warnings
+			monitoringSummary.Unlock()
+
+			// Update last monitored timestamp
+			shardMutex.Lock()
+			shardMonitoringInfo[shardName] = &ShardInfo{
+				Name:          shardName,
+				LastMonitored: time.Now(),
+			}
+			shardMutex.Unlock()
+		}(shard)
+	}
+
+	// Wait for all goroutines to complete
+	wg.Wait()
+
+	// Print overall summary
+	fmt.Printf("\n===== MONITORING SUMMARY =====\n")
+	fmt.Printf("Total Tasks: %d\n", monitoringSummary.TotalTasks)
+	fmt.Printf("Alive Tasks: %d\n", monitoringSummary.AliveTasks)
+	fmt.Printf("Dead Tasks: %d\n", monitoringSummary.DeadTasks)
+	fmt.Printf("Paused Tasks: %d\n", monitoringSummary.PausedTasks)
+	fmt.Printf("Tasks Updated to Dead: %d\n", monitoringSummary.UpdatedTasks)
+	fmt.Printf("Warning Tasks (approaching timeout): %d\n", monitoringSummary.WarningTasks)
+	fmt.Println(strings.Repeat("=", 30))
+
+	log.Println("Completed task status check for all shards")
+}
+
+// monitorShard runs a monitor pass over one shard and prints its status report.
+// The store marks overdue tasks dead, accounts the time since their last check to
+// uptime or downtime and stores a graph sample for every task, all atomically.
+// When graceStart is set, a task's deadline is counted from no earlier than graceStart.
+func monitorShard(ctx context.Context, store MonitorStore, shardName string, graceStart *time.Time) (shardResult, error) {
+	var result shardResult
+
+	shardCtx, shardSpan := otel.Tracer("task-tracker").Start(ctx, fmt.Sprintf("process-shard-%s", shardName))
+	defer shardSpan.End()
+
+	log.Printf("Processing shard: %s", shardName)
+
+	newDead, tasks, err := store.MonitorPartition(shardCtx, shardName, graceStart)
+	if err != nil {
+		shardSpan.RecordError(err)
+		return result, err
+	}
+	result.NewDead = newDead
+	result.Updated = len(tasks)
+
+	// Log output for this shard
+	if printShardReports {
+		fmt.Printf("\n===== SHARD %s STATUS REPORT =====\n", shardName)
+		fmt.Printf("%-5s | %-20s | %-10s | %-8s | %-15s | %-10s | %-15s | %-15s\n",
+			"ID", "Name", "Task#", "Status", "Last Ping", "Interval", "Uptime (sec)", "Downtime (sec)")
+		fmt.Println(strings.Repeat("-", 110))
+	}
+
+	for _, task := range tasks {
+		// Update counters based on new status
+		switch task.Status {
+		case "alive":
+			result.Alive++
+		case "paused":
+			result.Paused++
+		default:
+			result.Dead++
+		}
+
+		// Check if task is approaching timeout (> 80% of interval passed)
+		isWarning := task.Status == "alive" && task.SinceLastPing > float64(task.Interval)*0.8
+		if isWarning {
+			result.Warnings++
+		}
+
+		// Format the output
+		if printShardReports {
+			fmt.Printf("%-5d | %-20s | %-10d | %-8s | %-15s | %-10d | %-15.1f | %-15.1f\n",
+				task.ID,
+				truncateString(task.Name, 20),
+				task.TaskNumber,
+				task.Status,
+				task.LastPing.Format("15:04:05"),
+				task.Interval,
+				task.UptimeSeconds,
+				task.DowntimeSeconds,
+			)
+		}
+	}
+
+	if printShardReports {
+		fmt.Printf("\nSHARD SUMMARY: %d total tasks (%d alive, %d dead, %d paused, %d warnings)\n",
+			result.Alive+result.Dead+result.Paused, result.Alive, result.Dead, result.Paused, result.Warnings)
+		fmt.Println(strings.Repeat("=", 50))
+	}
+
+	log.Printf("Finished processing shard %s: Updated %d tasks, marked %d as dead",
+		shardName, result.Updated, result.NewDead)
+	return result, nil
+}
+
+// uptimePercentage is the share of the tracked time a task was alive
+func uptimePercentage(uptimeSeconds, downtimeSeconds float64) float64 {
+	if uptimeSeconds+downtimeSeconds <= 0 {
+		return 0
+	}
+	return (uptimeSeconds / (uptimeSeconds + downtimeSeconds)) * 100
+}
+
+// Helper functions for formatting output
+func truncateString(s string, maxLen int) string {
+	if len(s) <= maxLen {
+		return s
+	}
+	return s[:maxLen-3] + "..."
+}
+
+func warningNote(isWarning bool, interval int, timeDiff float64) string {
+	if !isWarning {
+		return ""
+	}
+
+	percentRemaining := 100 - (timeDiff * 100 / float64(interval))
+	return fmt.Sprintf(" WARNING: %.1f%% time remaining", percentRemaining)
+}
+
+// startTaskMonitor runs checkTaskStatus on every tick until ctx is cancelled.
+// A tick that is already running is not interrupted: it completes with its own
+// context so no shard transaction is abandoned halfway.
+func (s *Server) startTaskMonitor(ctx context.Context) {
+	log.Println("Starting task status monitor...")
+	markMonitorStarted()
+	ticker := time.NewTicker(monitorTickInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			log.Println("Task status monitor stopped")
+			return
+		case <-ticker.C:
+			s.checkTaskStatus(ctx)
+		}
+	}
+}
@@ -0,0 +1,281 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// createMonitorTestTask creates a user and a task of a 60 second interval
+// at the store clock's current time
+func createMonitorTestTask(t *testing.T, store Store) Task {
+	t.Helper()
+	ctx := context.Background()
+	user, err := store.CreateUser(ctx, "alice", "alice@example.com", "hash")
+	if err != nil {
+		t.Fatal(err)
+	}
+	task, err := store.CreateTask(ctx, Task{Name: "backup", UserID: int64(user.ID), Interval: 60, TaskNumber: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return task
+}
+
+// monitorPass runs the monitor over every partition of the store
+func monitorPass(t *testing.T, store MonitorStore, graceStart *time.Time) int {
+	t.Helper()
+	printShardReports = false
+	t.Cleanup(func() { printShardReports = true })
+
+	partitions, err := store.MonitorPartitions(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	newDead := 0
+	for _, partition := range partitions {
+		result, err := monitorShard(context.Background(), store, partition, graceStart)
+		if err != nil {
+			t.Fatal(err)
+		}
+		newDead += result.NewDead
+	}
+	return newDead
+}
+
+func getTask(t *testing.T, store TaskStore, id int64) Task {
+	t.Helper()
+	task, err := store.GetTask(context.Background(), id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return task
+}
+
+func TestMonitorMarksOverdueTaskDead(t *testing.T) {
+	forEachStore(t, func(t *testing.T, store Store, clock *time.Time) {
+		task := createMonitorTestTask(t, store)
+		created := *clock
+
+		*clock = created.Add(45 * time.Second)
+		if n := monitorPass(t, store, nil); n != 0 {
+			t.Fatalf("%d tasks marked dead within their interval", n)
+		}
+		if got := getTask(t, store, task.ID); got.Status != "alive" || got.UptimeSeconds != 45 {
+			t.Fatalf("after 45s: status %s, uptime %v; want alive, 45", got.Status, got.UptimeSeconds)
+		}
+
+		*clock = created.Add(90 * time.Second)
+		if n := monitorPass(t, store, nil); n != 1 {
+			t.Fatalf("%d tasks marked dead after the deadline, want 1", n)
+		}
+		got := getTask(t, store, task.ID)
+		if got.Status != "dead" || got.PreviousStatus != "alive" {
+			t.Fatalf("after 90s: status %s (previous %s), want dead", got.Status, got.PreviousStatus)
+		}
+		// Alive up to the deadline, dead since
+		if got.UptimeSeconds != 60 || got.DowntimeSeconds != 30 {
+			t.Errorf("uptime %v, downtime %v; want 60, 30", got.UptimeSeconds, got.DowntimeSeconds)
+		}
+
+		transitions, err := store.ListTransitions(context.Background(), task.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		last := transitions[len(transitions)-1]
+		if len(transitions) != 2 || last.ToStatus != "dead" || !last.TransitionedAt.Equal(created.Add(60*time.Second)) {
+			t.Errorf("transitions %+v, want the creation and a death at the deadline", transitions)
+		}
+
+		// A later pass leaves the dead task alone
+		*clock = created.Add(120 * time.Second)
+		if n := monitorPass(t, store, nil); n != 0 {
+			t.Errorf("dead task marked dead again")
+		}
+	})
+}
+
+func TestMonitorHeartbeatRevivesTask(t *testing.T) {
+	forEachStore(t, func(t *testing.T, store Store, clock *time.Time) {
+		task := createMonitorTestTask(t, store)
+		created := *clock
+
+		*clock = created.Add(2 * time.Minute)
+		monitorPass(t, store, nil)
+
+		*clock = created.Add(3 * time.Minute)
+		ids, err := store.RecordHeartbeat(context.Background(), task.TaskNumber)
+		if err != nil || len(ids) != 1 {
+			t.Fatalf("RecordHeartbeat = %v, %v", ids, err)
+		}
+		got := getTask(t, store, task.ID)
+		if got.Status != "alive" || got.PingCount != 1 || got.DowntimeSeconds != 120 {
+			t.Errorf("after the ping: status %s, pings %d, downtime %v; want alive, 1, 120",
+				got.Status, got.PingCount, got.DowntimeSeconds)
+		}
+
+		// The deadline now counts from the ping
+		*clock = created.Add(3*time.Minute + 50*time.Second)
+		if n := monitorPass(t, store, nil); n != 0 {
+			t.Errorf("task marked dead %d times within the interval after its ping", n)
+		}
+	})
+}
+
+func TestMonitorGraceStart(t *testing.T) {
+	forEachStore(t, func(t *testing.T, store Store, clock *time.Time) {
+		task := createMonitorTestTask(t, store)
+		created := *clock
+
+		// The monitor was down: the task is overdue, but gets an interval from
+		// the recovery before it is marked dead
+		graceStart := created.Add(10 * time.Minute)
+		*clock = graceStart.Add(30 * time.Second)
+		if n := monitorPass(t, store, &graceStart); n != 0 {
+			t.Fatalf("overdue task marked dead within the grace period")
+		}
+
+		*clock = graceStart.Add(61 * time.Second)
+		if n := monitorPass(t, store, &graceStart); n != 1 {
+			t.Fatalf("task not marked dead after the grace period")
+		}
+		if got := getTask(t, store, task.ID); got.StatusChangedAt == nil || !got.StatusChangedAt.Equal(graceStart.Add(time.Minute)) {
+			t.Errorf("died at %v, want the end of the grace period", got.StatusChangedAt)
+		}
+	})
+}
+
+// clearLastPing makes the task look like it never pinged
+func clearLastPing(t *testing.T, store Store, id int64) {
+	t.Helper()
+	var err error
+	switch s := store.(type) {
+	case *MemoryStore:
+		s.mu.Lock()
+		s.tasks[id].LastPing = nil
+		s.mu.Unlock()
+	case *SQLiteStore:
+		_, err = s.db.Exec(`UPDATE tasks SET last_ping = NULL WHERE id = ?`, id)
+	case *PostgresStore:
+		_, err = s.pool.Exec(context.Background(), `UPDATE tasks SET last_ping = NULL WHERE id = $1`, id)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+// TestMonitorSkipsNeverPingedTask runs against the Postgres database in
+// TEST_DATABASE_URL too, which has to be empty
+func TestMonitorSkipsNeverPingedTask(t *testing.T) {
+	test := func(t *testing.T, store Store, now time.Time) {
+		task := createMonitorTestTask(t, store)
+		clearLastPing(t, store, task.ID)
+
+		// The grace period ended long ago, but without a ping there is no deadline
+		graceStart := now.Add(-time.Hour)
+		if n := monitorPass(t, store, &graceStart); n != 0 {
+			t.Errorf("task that never pinged marked dead %d times", n)
+		}
+		if got := getTask(t, store, task.ID); got.Status != "alive" {
+			t.Errorf("status %s, want alive", got.Status)
+		}
+	}
+
+	forEachStore(t, func(t *testing.T, store Store, clock *time.Time) {
+		test(t, store, *clock)
+	})
+	t.Run("postgres", func(t *testing.T) {
+		dbURL := os.Getenv("TEST_DATABASE_URL")
+		if dbURL == "" {
+			t.Skip("TEST_DATABASE_URL is not set")
+		}
+		store, err := openPostgresStore(context.Background(), dbURL)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer store.Close()
+		if err := store.Migrate(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+		test(t, store, time.Now())
+	})
+}
+
+func TestCheckTaskStatus(t *testing.T) {
+	forEachStore(t, func(t *testing.T, store Store, clock *time.Time) {
+		task := createMonitorTestTask(t, store)
+		server := NewServer(store)
+
+		// Start from a fresh monitor, as after startup
+		printShardReports = false
+		shardMonitoringInfo = make(map[string]*ShardInfo)
+		monitorStatus.Lock()
+		monitorStatus.LastHealthyTickAt = time.Time{}
+		monitorStatus.GraceStart = nil
+		monitorStatus.Unlock()
+		t.Cleanup(func() {
+			printShardReports = true
+			shardMonitoringInfo = make(map[string]*ShardInfo)
+		})
+
+		// The first tick gives the overdue task one interval of grace
+		*clock = clock.Add(5 * time.Minute)
+		server.checkTaskStatus(context.Background())
+		if got := getTask(t, store, task.ID); got.Status != "alive" {
+			t.Fatalf("first tick marked an overdue task %s", got.Status)
+		}
+
+		*clock = clock.Add(2 * time.Minute)
+		shardMonitoringInfo = make(map[string]*ShardInfo)
+		server.checkTaskStatus(context.Background())
+		if got := getTask(t, store, task.ID); got.Status != "dead" {
+			t.Fatalf("task %s an interval after the first tick, want dead", got.Status)
+		}
+
+		monitorStatus.RLock()
+		summary, healthy := monitorStatus.LastSummary, monitorStatus.LastHealthyTickAt
+		monitorStatus.RUnlock()
+		if healthy.IsZero() || summary.TotalTasks != 1 || summary.DeadTasks != 1 || summary.UpdatedTasks != 1 {
+			t.Errorf("summary %+v (healthy at %v), want the one task marked dead", summary, healthy)
+		}
+	})
+}
+
+func TestCheckTaskStatusStopsWithContext(t *testing.T) {
+	clock := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+	store, err := openSQLiteStore(context.Background(), "sqlite://"+filepath.Join(t.TempDir(), "tasks.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(store.Close)
+	if err := store.Migrate(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	store.now = func() time.Time { return clock }
+	task := createMonitorTestTask(t, store)
+	server := NewServer(store)
+
+	printShardReports = false
+	shardMonitoringInfo = make(map[string]*ShardInfo)
+	t.Cleanup(func() {
+		printShardReports = true
+		shardMonitoringInfo = make(map[string]*ShardInfo)
+	})
+
+	// A pass whose context is done touches no task
+	clock = clock.Add(time.Hour)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	server.checkTaskStatus(ctx)
+	if got := getTask(t, store, task.ID); got.Status != "alive" || !got.LastChecked.Equal(*task.LastChecked) {
+		t.Errorf("task %s checked at %v after a cancelled pass, want it untouched", got.Status, got.LastChecked)
+	}
+	monitorStatus.RLock()
+	lastError, failed := monitorStatus.LastError, monitorStatus.LastSummary.FailedShards
+	monitorStatus.RUnlock()
+	if lastError == "" && failed == 0 {
+		t.Error("cancelled pass recorded as healthy")
+	}
+}
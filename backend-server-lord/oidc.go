@@ -0,0 +1,696 @@
+package main
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math/big"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt"
+)
+
+// oidcConfig configures single sign-on through an OpenID Connect provider,
+// read from the OIDC_* environment variables
+type oidcConfig struct {
+	Issuer       string
+	ClientID     string
+	ClientSecret string // empty for public clients, which rely on PKCE alone
+	RedirectURL  string
+	FrontendURL  string // dashboard page the callback sends the browser back to
+	Scopes       []string
+	GroupsClaim  string
+	// GroupRoles maps a group listed in GroupsClaim to the organization roles
+	// its members get
+	GroupRoles map[string][]oidcGrant
+	// LinkByEmail links provider accounts seen for the first time to the
+	// existing user with their email address if the provider verified it.
+	// Otherwise such logins are refused, as the provider vouches for the
+	// address but not for whoever registered the user with it.
+	LinkByEmail bool
+}
+
+// oidcGrant is a role in an organization granted through a group
+type oidcGrant struct {
+	OrgID int64
+	Role  string
+}
+
+// oidcConfigFromEnv reads the SSO configuration, or returns nil when
+// OIDC_ISSUER is not set. Malformed OIDC_GROUP_ROLES entries are skipped.
+func oidcConfigFromEnv() *oidcConfig {
+	issuer := strings.TrimSuffix(os.Getenv("OIDC_ISSUER"), "/")
+	if issuer == "" {
+		return nil
+	}
+
+	config := &oidcConfig{
+		Issuer:       issuer,
+		ClientID:     os.Getenv("OIDC_CLIENT_ID"),
+		ClientSecret: os.Getenv("OIDC_CLIENT_SECRET"),
+		RedirectURL:  os.Getenv("OIDC_REDIRECT_URL"),
+		FrontendURL:  os.Getenv("OIDC_FRONTEND_URL"),
+		Scopes:       strings.Fields(os.Getenv("OIDC_SCOPES")),
+		GroupsClaim:  os.Getenv("OIDC_GROUPS_CLAIM"),
+		GroupRoles:   map[string][]oidcGrant{},
+		LinkByEmail:  os.Getenv("OIDC_LINK_BY_EMAIL") == "true",
+	}
+	if config.RedirectURL == "" {
+		port := os.Getenv("PORT")
+		if port == "" {
+			port = "3000"
+		}
+		config.RedirectURL = "http://localhost:" + port + "/api/oidc/callback"
+	}
+	if config.FrontendURL == "" {
+		config.FrontendURL = appURL("/login/sso")
+	}
+	if len(config.Scopes) == 0 {
+		config.Scopes = []string{"openid", "email", "profile"}
+	}
+	if config.GroupsClaim == "" {
+		config.GroupsClaim = "groups"
+	}
+
+	// group=org_id:role, comma separated
+	for _, entry := range strings.Split(os.Getenv("OIDC_GROUP_ROLES"), ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		group, grant, _ := strings.Cut(entry, "=")
+		org, role, _ := strings.Cut(grant, ":")
+		orgID, err := strconv.ParseInt(org, 10, 64)
+		if group == "" || err != nil || orgID <= 0 || roleRanks[role] == 0 {
+			log.Printf("Invalid OIDC_GROUP_ROLES entry %q, skipping", entry)
+			continue
+		}
+		config.GroupRoles[group] = append(config.GroupRoles[group], oidcGrant{OrgID: orgID, Role: role})
+	}
+	return config
+}
+
+// oidcMetadata is the part of the provider's discovery document the login uses
+type oidcMetadata struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JWKSURI               string `json:"jwks_uri"`
+}
+
+// oidcProvider talks to the configured OpenID Connect provider. The discovery
+// document is fetched on first use and the signing keys whenever an ID token
+// names a key that is not known yet.
+type oidcProvider struct {
+	config oidcConfig
+	client *http.Client
+
+	mu          sync.Mutex
+	metadata    *oidcMetadata
+	keys        map[string]crypto.PublicKey
+	keysFetched time.Time
+}
+
+// How often the signing keys may be refetched for unknown key IDs
+const oidcKeyRefreshInterval = time.Minute
+
+// newOIDCProvider returns nil when SSO is not configured
+func newOIDCProvider(config *oidcConfig) *oidcProvider {
+	if config == nil {
+		return nil
+	}
+	return &oidcProvider{config: *config, client: &http.Client{Timeout: 10 * time.Second}}
+}
+
+// getJSON fetches a JSON document from the provider
+func (p *oidcProvider) getJSON(ctx context.Context, url string, v interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("GET %s: %s", url, resp.Status)
+	}
+	return json.NewDecoder(resp.Body).Decode(v)
+}
+
+// discover returns the provider's discovery document
+func (p *oidcProvider) discover(ctx context.Context) (oidcMetadata, error) {
+	p.mu.Lock()
+	cached := p.metadata
+	p.mu.Unlock()
+	if cached != nil {
+		return *cached, nil
+	}
+
+	// Fetch without holding the lock, so a slow provider does not hold up
+	// every other login; concurrent first logins may each fetch it
+	var metadata oidcMetadata
+	if err := p.getJSON(ctx, p.config.Issuer+"/.well-known/openid-configuration", &metadata); err != nil {
+		return oidcMetadata{}, err
+	}
+	if strings.TrimSuffix(metadata.Issuer, "/") != p.config.Issuer {
+		return oidcMetadata{}, fmt.Errorf("discovery document is for issuer %q", metadata.Issuer)
+	}
+	if metadata.AuthorizationEndpoint == "" || metadata.TokenEndpoint == "" || metadata.JWKSURI == "" {
+		return oidcMetadata{}, errors.New("discovery document lacks an endpoint")
+	}
+	p.mu.Lock()
+	p.metadata = &metadata
+	p.mu.Unlock()
+	return metadata, nil
+}
+
+// jsonWebKey is an RSA or EC public key of a JWKS
+type jsonWebKey struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+// publicKey decodes the key, or returns nil for key types logins don't use
+func (k jsonWebKey) publicKey() (crypto.PublicKey, error) {
+	decode := func(v string) (*big.Int, error) {
+		b, err := base64.RawURLEncoding.DecodeString(v)
+		return new(big.Int).SetBytes(b), err
+	}
+
+	switch k.Kty {
+	case "RSA":
+		n, err := decode(k.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := decode(k.E)
+		if err != nil {
+			return nil, err
+		}
+		return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+	case "EC":
+		curves := map[string]elliptic.Curve{"P-256": elliptic.P256(), "P-384": elliptic.P384(), "P-521": elliptic.P521()}
+		curve, ok := curves[k.Crv]
+		if !ok {
+			return nil, nil
+		}
+		x, err := decode(k.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := decode(k.Y)
+		if err != nil {
+			return nil, err
+		}
+		return &ecdsa.PublicKey{Curve: curve, X: x, Y: y}, nil
+	}
+	return nil, nil
+}
+
+// signingKey returns the provider's key with the given ID, refetching the
+// key set when the ID is unknown. An empty ID matches a lone key.
+func (p *oidcProvider) signingKey(ctx context.Context, jwksURI, kid string) (crypto.PublicKey, error) {
+	p.mu.Lock()
+	key := p.cachedKey(kid)
+	lastFetched := p.keysFetched
+	refetch := key == nil && time.Since(lastFetched) >= oidcKeyRefreshInterval
+	if refetch {
+		// Claim the refetch, so concurrent logins don't all go to the
+		// provider while it runs without the lock
+		p.keysFetched = time.Now()
+	}
+	p.mu.Unlock()
+	if key != nil {
+		return key, nil
+	}
+	if !refetch {
+		return nil, fmt.Errorf("unknown signing key %q", kid)
+	}
+
+	keys, err := p.fetchKeys(ctx, jwksURI)
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if err != nil {
+		// Let the next login try again
+		p.keysFetched = lastFetched
+		return nil, err
+	}
+	p.keys = keys
+	if key := p.cachedKey(kid); key != nil {
+		return key, nil
+	}
+	return nil, fmt.Errorf("unknown signing key %q", kid)
+}
+
+// cachedKey looks up a key of the last fetched key set. The caller holds p.mu.
+func (p *oidcProvider) cachedKey(kid string) crypto.PublicKey {
+	if key, ok := p.keys[kid]; ok {
+		return key
+	}
+	if kid == "" && len(p.keys) == 1 {
+		for _, key := range p.keys {
+			return key
+		}
+	}
+	return nil
+}
+
+// fetchKeys fetches the provider's signing keys
+func (p *oidcProvider) fetchKeys(ctx context.Context, jwksURI string) (map[string]crypto.PublicKey, error) {
+	var set struct {
+		Keys []jsonWebKey `json:"keys"`
+	}
+	if err := p.getJSON(ctx, jwksURI, &set); err != nil {
+		return nil, err
+	}
+	keys := map[string]crypto.PublicKey{}
+	for _, k := range set.Keys {
+		if k.Use != "" && k.Use != "sig" {
+			continue
+		}
+		key, err := k.publicKey()
+		if err != nil {
+			log.Printf("Skipping malformed signing key %q of the identity provider: %v", k.Kid, err)
+			continue
+		}
+		if key != nil {
+			keys[k.Kid] = key
+		}
+	}
+	return keys, nil
+}
+
+// authURL returns where to send the browser to log in
+func (p *oidcProvider) authURL(metadata oidcMetadata, state, nonce, verifier string) string {
+	challenge := sha256.Sum256([]byte(verifier))
+	params := url.Values{
+		"response_type":         {"code"},
+		"client_id":             {p.config.ClientID},
+		"redirect_uri":          {p.config.RedirectURL},
+		"scope":                 {strings.Join(p.config.Scopes, " ")},
+		"state":                 {state},
+		"nonce":                 {nonce},
+		"code_challenge":        {base64.RawURLEncoding.EncodeToString(challenge[:])},
+		"code_challenge_method": {"S256"},
+	}
+	separator := "?"
+	if strings.Contains(metadata.AuthorizationEndpoint, "?") {
+		separator = "&"
+	}
+	return metadata.AuthorizationEndpoint + separator + params.Encode()
+}
+
+// exchange trades an authorization code for the ID token
+func (p *oidcProvider) exchange(ctx context.Context, metadata oidcMetadata, code, verifier string) (string, error) {
+	form := url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"redirect_uri":  {p.config.RedirectURL},
+		"client_id":     {p.config.ClientID},
+		"code_verifier": {verifier},
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, metadata.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	if p.config.ClientSecret != "" {
+		req.SetBasicAuth(url.QueryEscape(p.config.ClientID), url.QueryEscape(p.config.ClientSecret))
+	}
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	var body struct {
+		IDToken          string `json:"id_token"`
+		Error            string `json:"error"`
+		ErrorDescription string `json:"error_description"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return "", fmt.Errorf("token endpoint: %s: %v", resp.Status, err)
+	}
+	if resp.StatusCode != http.StatusOK || body.Error != "" {
+		return "", fmt.Errorf("token endpoint: %s: %s %s", resp.Status, body.Error, body.ErrorDescription)
+	}
+	if body.IDToken == "" {
+		return "", errors.New("token endpoint returned no id_token")
+	}
+	return body.IDToken, nil
+}
+
+// verifyIDToken checks the ID token's signature, issuer, audience, expiry
+// and nonce, and returns its claims
+func (p *oidcProvider) verifyIDToken(ctx context.Context, metadata oidcMetadata, raw, nonce string) (jwt.MapClaims, error) {
+	token, err := jwt.Parse(raw, func(token *jwt.Token) (interface{}, error) {
+		switch token.Method.(type) {
+		case *jwt.SigningMethodRSA, *jwt.SigningMethodECDSA:
+		default:
+			return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
+		}
+		kid, _ := token.Header["kid"].(string)
+		return p.signingKey(ctx, metadata.JWKSURI, kid)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	claims, _ := token.Claims.(jwt.MapClaims)
+	if iss, _ := claims["iss"].(string); strings.TrimSuffix(iss, "/") != p.config.Issuer {
+		return nil, fmt.Errorf("issued by %q", iss)
+	}
+	if !claims.VerifyAudience(p.config.ClientID, true) {
+		return nil, errors.New("issued for another client")
+	}
+	if azp, ok := claims["azp"].(string); ok && azp != p.config.ClientID {
+		return nil, errors.New("issued for another client")
+	}
+	if got, _ := claims["nonce"].(string); subtle.ConstantTimeCompare([]byte(got), []byte(nonce)) != 1 {
+		return nil, errors.New("nonce mismatch")
+	}
+	if sub, _ := claims["sub"].(string); sub == "" {
+		return nil, errors.New("no subject")
+	}
+	return claims, nil
+}
+
+// oidcStateCookie carries the state, nonce and PKCE verifier of a login from
+// the redirect to the callback, signed so it cannot be forged
+const oidcStateCookie = "serverlord_oidc"
+
+// How long a login at the provider may take
+const oidcLoginTimeout = 10 * time.Minute
+
+// Errors of logins whose account cannot be matched to a user
+var (
+	errOIDCNoEmail    = errors.New("the identity provider did not share an email address")
+	errOIDCEmailTaken = errors.New("an account with this email address already exists; log in with its password")
+)
+
+// oidcLogin redirects the browser to the provider's login page
+func (s *Server) oidcLogin(w http.ResponseWriter, r *http.Request) {
+	if s.oidc == nil {
+		respondWithError(w, http.StatusNotFound, "SSO is not configured")
+		return
+	}
+
+	metadata, err := s.oidc.discover(r.Context())
+	if err != nil {
+		log.Printf("Error discovering the identity provider: %v", err)
+		respondWithError(w, http.StatusBadGateway, "Identity provider is unavailable")
+		return
+	}
+
+	var secrets [3]string
+	for i := range secrets {
+		if secrets[i], err = newAccessToken(); err != nil {
+			log.Printf("Error generating login state: %v", err)
+			respondWithError(w, http.StatusInternalServerError, "Error starting login")
+			return
+		}
+	}
+	state, nonce, verifier := secrets[0], secrets[1], secrets[2]
+
+	cookie, err := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
+		"state":    state,
+		"nonce":    nonce,
+		"verifier": verifier,
+		"exp":      time.Now().Add(oidcLoginTimeout).Unix(),
+	}).SignedString(jwtSecret)
+	if err != nil {
+		log.Printf("Error signing login state: %v", err)
+		respondWithError(w, http.StatusInternalServerError, "Error starting login")
+		return
+	}
+	http.SetCookie(w, &http.Cookie{
+		Name:     oidcStateCookie,
+		Value:    cookie,
+		Path:     "/api/oidc",
+		MaxAge:   int(oidcLoginTimeout.Seconds()),
+		HttpOnly: true,
+		Secure:   strings.HasPrefix(s.oidc.config.RedirectURL, "https://"),
+		SameSite: http.SameSiteLaxMode,
+	})
+	http.Redirect(w, r, s.oidc.authURL(metadata, state, nonce, verifier), http.StatusFound)
+}
+
+// oidcCallback completes a login at the provider: it checks the state,
+// exchanges the code, verifies the ID token, finds or provisions the user and
+// opens a session like /api/login. The browser is sent back to the dashboard
+// with the outcome.
+func (s *Server) oidcCallback(w http.ResponseWriter, r *http.Request) {
+	if s.oidc == nil {
+		respondWithError(w, http.StatusNotFound, "SSO is not configured")
+		return
+	}
+
+	query := r.URL.Query()
+	if reason := query.Get("error"); reason != "" {
+		log.Printf("Identity provider refused the login: %s %s", reason, query.Get("error_description"))
+		s.oidcRedirect(w, r, url.Values{"error": {"Login was refused by the identity provider"}})
+		return
+	}
+
+	cookie, err := r.Cookie(oidcStateCookie)
+	var login jwt.MapClaims
+	if err == nil {
+		var token *jwt.Token
+		token, err = jwt.Parse(cookie.Value, func(token *jwt.Token) (interface{}, error) {
+			if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
+				return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
+			}
+			return jwtSecret, nil
+		})
+		if err == nil {
+			login, _ = token.Claims.(jwt.MapClaims)
+		}
+	}
+	if err != nil {
+		s.oidcRedirect(w, r, url.Values{"error": {"Login expired, please start again"}})
+		return
+	}
+	http.SetCookie(w, &http.Cookie{Name: oidcStateCookie, Path: "/api/oidc", MaxAge: -1})
+
+	state, _ := login["state"].(string)
+	nonce, _ := login["nonce"].(string)
+	verifier, _ := login["verifier"].(string)
+	if state == "" || subtle.ConstantTimeCompare([]byte(query.Get("state")), []byte(state)) != 1 {
+		s.oidcRedirect(w, r, url.Values{"error": {"Invalid login state"}})
+		return
+	}
+	if query.Get("code") == "" {
+		s.oidcRedirect(w, r, url.Values{"error": {"code is required"}})
+		return
+	}
+
+	metadata, err := s.oidc.discover(r.Context())
+	var idToken string
+	if err == nil {
+		idToken, err = s.oidc.exchange(r.Context(), metadata, query.Get("code"), verifier)
+	}
+	if err != nil {
+		log.Printf("Error exchanging the authorization code: %v", err)
+		s.oidcRedirect(w, r, url.Values{"error": {"Error completing login with the identity provider"}})
+		return
+	}
+
+	claims, err := s.oidc.verifyIDToken(r.Context(), metadata, idToken, nonce)
+	if err != nil {
+		log.Printf("Rejected ID token: %v", err)
+		s.oidcRedirect(w, r, url.Values{"error": {"Invalid ID token"}})
+		return
+	}
+
+	user, err := s.oidcUser(r.Context(), claims)
+	if err != nil {
+		message := err.Error()
+		if !errors.Is(err, errOIDCNoEmail) && !errors.Is(err, errOIDCEmailTaken) {
+			log.Printf("Error provisioning SSO user: %v", err)
+			message = "Error logging in"
+		}
+		s.oidcRedirect(w, r, url.Values{"error": {message}})
+		return
+	}
+	s.syncOIDCGroups(r.Context(), int64(user.ID), claims)
+
+	response, mfaToken, err := s.loginOutcome(r, user)
+	switch {
+	case err != nil:
+		log.Printf("Error logging in user ID %d: %v", user.ID, err)
+		s.oidcRedirect(w, r, url.Values{"error": {"Error logging in"}})
+	case mfaToken != "":
+		s.oidcRedirect(w, r, url.Values{
+			"mfa_token":  {mfaToken},
+			"expires_in": {strconv.Itoa(int(mfaTokenTTL.Seconds()))},
+		})
+	default:
+		log.Printf("User %s logged in through SSO", user.Username)
+		s.oidcRedirect(w, r, url.Values{
+			"token":         {response.Token},
+			"refresh_token": {response.RefreshToken},
+			"expires_in":    {strconv.Itoa(response.ExpiresIn)},
+		})
+	}
+}
+
+// oidcRedirect sends the browser back to the dashboard's SSO page with the
+// outcome of the login. It goes in the URL fragment, which the browser
+// neither sends to servers nor passes on in the Referer.
+func (s *Server) oidcRedirect(w http.ResponseWriter, r *http.Request, outcome url.Values) {
+	w.Header().Set("Cache-Control", "no-store")
+	http.Redirect(w, r, s.oidc.config.FrontendURL+"#"+outcome.Encode(), http.StatusFound)
+}
+
+// oidcUser returns the user a provider account logs in as. Accounts seen for
+// the first time get a new user without a password, or with LinkByEmail are
+// linked to the user with their email address if the provider verified it.
+func (s *Server) oidcUser(ctx context.Context, claims jwt.MapClaims) (User, error) {
+	issuer := s.oidc.config.Issuer
+	subject, _ := claims["sub"].(string)
+
+	user, err := s.users.GetUserByIdentity(ctx, issuer, subject)
+	if !errors.Is(err, ErrNotFound) {
+		return user, err
+	}
+
+	email, _ := claims["email"].(string)
+	verified, _ := claims["email_verified"].(bool)
+	if email == "" {
+		return User{}, errOIDCNoEmail
+	}
+	user, err = s.users.GetUserByEmail(ctx, email)
+	switch {
+	case err == nil && !(verified && s.oidc.config.LinkByEmail):
+		return User{}, errOIDCEmailTaken
+	case err == nil:
+		user.Password = ""
+	case errors.Is(err, ErrNotFound):
+		if user, err = s.provisionOIDCUser(ctx, claims, email); err != nil {
+			return User{}, err
+		}
+	default:
+		return User{}, err
+	}
+
+	if err := s.users.LinkIdentity(ctx, int64(user.ID), issuer, subject); err != nil {
+		return User{}, err
+	}
+	if verified && !user.EmailVerified {
+		if err := s.users.MarkEmailVerified(ctx, int64(user.ID), email); err != nil {
+			log.Printf("Error marking email of user ID %d verified: %v", user.ID, err)
+		} else {
+			user.EmailVerified = true
+		}
+	}
+	log.Printf("Linked SSO account %s to user ID: %d", subject, user.ID)
+	return user, nil
+}
+
+// provisionOIDCUser creates the user of a provider account, named after its
+// preferred_username or email address, with a numbered suffix when the name
+// is taken. The empty password hash never matches, so the user logs in
+// through SSO until they set a password with a reset link.
+func (s *Server) provisionOIDCUser(ctx context.Context, claims jwt.MapClaims, email string) (User, error) {
+	name, _ := claims["preferred_username"].(string)
+	if name == "" {
+		name, _, _ = strings.Cut(email, "@")
+	}
+	name = strings.Map(func(r rune) rune {
+		if r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '.' || r == '_' || r == '-' {
+			return r
+		}
+		return -1
+	}, name)
+	if len(name) > 40 {
+		name = name[:40]
+	}
+	if name == "" {
+		name = "user"
+	}
+
+	username := name
+	for attempt := 0; ; attempt++ {
+		user, err := s.users.CreateUser(ctx, username, email, "")
+		if !errors.Is(err, ErrConflict) || attempt == 4 {
+			return user, err
+		}
+		suffix := make([]byte, 3)
+		if _, err := rand.Read(suffix); err != nil {
+			return User{}, err
+		}
+		username = name + "-" + hex.EncodeToString(suffix)
+	}
+}
+
+// syncOIDCGroups gives the user the organization roles OIDC_GROUP_ROLES maps
+// their groups to, the highest one per organization. Memberships are only
+// added or raised to the mapped role, never lowered or removed, so roles
+// granted by hand above it are kept.
+func (s *Server) syncOIDCGroups(ctx context.Context, userID int64, claims jwt.MapClaims) {
+	if len(s.oidc.config.GroupRoles) == 0 {
+		return
+	}
+
+	var groups []string
+	switch v := claims[s.oidc.config.GroupsClaim].(type) {
+	case string:
+		groups = []string{v}
+	case []interface{}:
+		for _, group := range v {
+			if name, ok := group.(string); ok {
+				groups = append(groups, name)
+			}
+		}
+	}
+
+	roles := map[int64]string{}
+	for _, group := range groups {
+		for _, grant := range s.oidc.config.GroupRoles[group] {
+			if roleRanks[grant.Role] > roleRanks[roles[grant.OrgID]] {
+				roles[grant.OrgID] = grant.Role
+			}
+		}
+	}
+
+	for orgID, role := range roles {
+		current, err := s.orgs.GetOrgRole(ctx, orgID, userID)
+		switch {
+		case errors.Is(err, ErrNotFound):
+			err = s.orgs.AddOrgMember(ctx, orgID, userID, role)
+		case err == nil && roleRanks[role] > roleRanks[current]:
+			err = s.orgs.SetOrgRole(ctx, orgID, userID, role)
+		}
+		if err != nil {
+			log.Printf("Error granting user %d the %s role in organization %d: %v", userID, role, orgID, err)
+		}
+	}
+}
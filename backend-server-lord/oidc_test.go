@@ -0,0 +1,439 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt"
+)
+
+const (
+	testOIDCClientID    = "server-lord"
+	testOIDCRedirectURL = "http://localhost:3000/api/oidc/callback"
+	testOIDCFrontendURL = "http://localhost:3001/login/sso"
+)
+
+// mockOIDCCode is an authorization code the mock provider issued
+type mockOIDCCode struct {
+	Challenge string
+	Nonce     string
+}
+
+// mockOIDCProvider is an OpenID Connect provider that logs every browser in
+// as the account described by Claims
+type mockOIDCProvider struct {
+	t      *testing.T
+	server *httptest.Server
+	key    *rsa.PrivateKey
+
+	mu sync.Mutex
+	// Claims are added to the ID tokens, overriding the standard ones
+	Claims jwt.MapClaims
+	// SigningKey signs the ID tokens under the published key's ID
+	SigningKey *rsa.PrivateKey
+	// DiscoveryIssuer is the issuer the discovery document names
+	DiscoveryIssuer string
+	Codes           map[string]mockOIDCCode
+	Requests        map[string]int
+}
+
+func newMockOIDCProvider(t *testing.T) *mockOIDCProvider {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := &mockOIDCProvider{t: t, key: key, SigningKey: key, Codes: map[string]mockOIDCCode{}, Requests: map[string]int{}}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", p.discovery)
+	mux.HandleFunc("/authorize", p.authorize)
+	mux.HandleFunc("/token", p.token)
+	mux.HandleFunc("/jwks", p.jwks)
+	p.server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		p.mu.Lock()
+		p.Requests[r.URL.Path]++
+		p.mu.Unlock()
+		mux.ServeHTTP(w, r)
+	}))
+	t.Cleanup(p.server.Close)
+	p.DiscoveryIssuer = p.server.URL
+	return p
+}
+
+func (p *mockOIDCProvider) discovery(w http.ResponseWriter, r *http.Request) {
+	p.mu.Lock()
+	issuer := p.DiscoveryIssuer
+	p.mu.Unlock()
+	json.NewEncoder(w).Encode(map[string]string{
+		"issuer":                 issuer,
+		"authorization_endpoint": p.server.URL + "/authorize",
+		"token_endpoint":         p.server.URL + "/token",
+		"jwks_uri":               p.server.URL + "/jwks",
+	})
+}
+
+// authorize logs the browser in right away and sends it back with a code
+func (p *mockOIDCProvider) authorize(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	if query.Get("response_type") != "code" || query.Get("client_id") != testOIDCClientID ||
+		query.Get("redirect_uri") != testOIDCRedirectURL || query.Get("code_challenge_method") != "S256" ||
+		query.Get("code_challenge") == "" || query.Get("nonce") == "" {
+		p.t.Errorf("unexpected authorization request %s", r.URL.RawQuery)
+		http.Error(w, "invalid_request", http.StatusBadRequest)
+		return
+	}
+	code, _ := newAccessToken()
+	p.mu.Lock()
+	p.Codes[code] = mockOIDCCode{Challenge: query.Get("code_challenge"), Nonce: query.Get("nonce")}
+	p.mu.Unlock()
+	http.Redirect(w, r, testOIDCRedirectURL+"?"+url.Values{"code": {code}, "state": {query.Get("state")}}.Encode(), http.StatusFound)
+}
+
+// token trades a code for an ID token once the verifier matches its challenge
+func (p *mockOIDCProvider) token(w http.ResponseWriter, r *http.Request) {
+	fail := func(reason string) {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{"error": "invalid_grant", "error_description": reason})
+	}
+	if err := r.ParseForm(); err != nil || r.PostForm.Get("grant_type") != "authorization_code" ||
+		r.PostForm.Get("client_id") != testOIDCClientID || r.PostForm.Get("redirect_uri") != testOIDCRedirectURL {
+		fail("malformed token request")
+		return
+	}
+
+	p.mu.Lock()
+	code, ok := p.Codes[r.PostForm.Get("code")]
+	delete(p.Codes, r.PostForm.Get("code"))
+	claims := jwt.MapClaims{
+		"iss":   p.server.URL,
+		"aud":   testOIDCClientID,
+		"sub":   "user-1",
+		"nonce": code.Nonce,
+		"iat":   time.Now().Unix(),
+		"exp":   time.Now().Add(5 * time.Minute).Unix(),
+	}
+	for name, value := range p.Claims {
+		claims[name] = value
+	}
+	signingKey := p.SigningKey
+	p.mu.Unlock()
+
+	if !ok {
+		fail("unknown code")
+		return
+	}
+	verifier := sha256.Sum256([]byte(r.PostForm.Get("code_verifier")))
+	if base64.RawURLEncoding.EncodeToString(verifier[:]) != code.Challenge {
+		fail("code_verifier does not match the code_challenge")
+		return
+	}
+
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+	token.Header["kid"] = "key-1"
+	idToken, err := token.SignedString(signingKey)
+	if err != nil {
+		p.t.Fatal(err)
+	}
+	json.NewEncoder(w).Encode(map[string]string{"access_token": "unused", "token_type": "Bearer", "id_token": idToken})
+}
+
+func (p *mockOIDCProvider) jwks(w http.ResponseWriter, r *http.Request) {
+	json.NewEncoder(w).Encode(map[string]interface{}{"keys": []map[string]string{{
+		"kty": "RSA",
+		"kid": "key-1",
+		"use": "sig",
+		"n":   base64.RawURLEncoding.EncodeToString(p.key.N.Bytes()),
+		"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(p.key.E)).Bytes()),
+	}}})
+}
+
+// setClaims replaces the claims added to the next ID tokens
+func (p *mockOIDCProvider) setClaims(claims jwt.MapClaims) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.Claims = claims
+}
+
+// newOIDCTestAPI serves the API with SSO through a mock provider, whose
+// "platform" group maps to admins and "oncall" group to viewers of org
+func newOIDCTestAPI(t *testing.T) (*testAPI, *mockOIDCProvider, Organization) {
+	t.Helper()
+	api := newTestAPI(t)
+	provider := newMockOIDCProvider(t)
+
+	ownerID, _ := api.signup("owner")
+	org, err := api.store.CreateOrg(context.Background(), "Platform", int64(ownerID))
+	if err != nil {
+		t.Fatal(err)
+	}
+	api.server.oidc = newOIDCProvider(&oidcConfig{
+		Issuer:      provider.server.URL,
+		ClientID:    testOIDCClientID,
+		RedirectURL: testOIDCRedirectURL,
+		FrontendURL: testOIDCFrontendURL,
+		Scopes:      []string{"openid", "email", "profile"},
+		GroupsClaim: "groups",
+		GroupRoles: map[string][]oidcGrant{
+			"platform": {{OrgID: org.ID, Role: roleAdmin}},
+			"oncall":   {{OrgID: org.ID, Role: roleViewer}},
+		},
+	})
+	return api, provider, org
+}
+
+// ssoLogin walks a browser through the login at the mock provider, calling
+// tamper (when not nil) with the code before it comes back to the callback.
+// It returns the outcome the callback sends to the dashboard.
+func (api *testAPI) ssoLogin(provider *mockOIDCProvider, tamper func(code string)) url.Values {
+	api.t.Helper()
+	rec := httptest.NewRecorder()
+	api.handler.ServeHTTP(rec, httptest.NewRequest("GET", "/api/oidc/login", nil))
+	if rec.Code != http.StatusFound {
+		api.t.Fatalf("GET /api/oidc/login: status %d: %s", rec.Code, rec.Body.String())
+	}
+	cookies := rec.Result().Cookies()
+
+	client := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse }}
+	resp, err := client.Get(rec.Header().Get("Location"))
+	if err != nil {
+		api.t.Fatal(err)
+	}
+	resp.Body.Close()
+	callback, err := url.Parse(resp.Header.Get("Location"))
+	if err != nil || resp.StatusCode != http.StatusFound {
+		api.t.Fatalf("provider answered %s, redirecting to %q", resp.Status, resp.Header.Get("Location"))
+	}
+	if tamper != nil {
+		tamper(callback.Query().Get("code"))
+	}
+
+	req := httptest.NewRequest("GET", "/api/oidc/callback?"+callback.RawQuery, nil)
+	for _, cookie := range cookies {
+		req.AddCookie(cookie)
+	}
+	rec = httptest.NewRecorder()
+	api.handler.ServeHTTP(rec, req)
+	location := rec.Header().Get("Location")
+	if rec.Code != http.StatusFound || !strings.HasPrefix(location, testOIDCFrontendURL+"#") {
+		api.t.Fatalf("callback: status %d, location %q: %s", rec.Code, location, rec.Body.String())
+	}
+	outcome, err := url.ParseQuery(strings.TrimPrefix(location, testOIDCFrontendURL+"#"))
+	if err != nil {
+		api.t.Fatal(err)
+	}
+	return outcome
+}
+
+func TestOIDCLoginProvisionsUser(t *testing.T) {
+	api, provider, org := newOIDCTestAPI(t)
+	provider.setClaims(jwt.MapClaims{
+		"email": "carol@example.com", "email_verified": true,
+		"preferred_username": "carol", "groups": []string{"oncall"},
+	})
+
+	outcome := api.ssoLogin(provider, nil)
+	if outcome.Get("error") != "" || outcome.Get("token") == "" || outcome.Get("refresh_token") == "" {
+		t.Fatalf("login outcome %v, want tokens", outcome)
+	}
+	var me User
+	expect(t, api.do("GET", "/api/me", outcome.Get("token"), nil), http.StatusOK, &me)
+	if me.Username != "carol" || me.Email != "carol@example.com" || !me.EmailVerified {
+		t.Errorf("provisioned user %+v", me)
+	}
+	if role, err := api.store.GetOrgRole(context.Background(), org.ID, int64(me.ID)); err != nil || role != roleViewer {
+		t.Errorf("role in the organization %q (%v), want %s", role, err, roleViewer)
+	}
+
+	// The second login finds the linked account, even under another email,
+	// and raises the role for the new group
+	provider.setClaims(jwt.MapClaims{
+		"email": "carol@corp.example.com", "email_verified": true,
+		"preferred_username": "carol", "groups": []string{"oncall", "platform"},
+	})
+	outcome = api.ssoLogin(provider, nil)
+	var again User
+	expect(t, api.do("GET", "/api/me", outcome.Get("token"), nil), http.StatusOK, &again)
+	if again.ID != me.ID {
+		t.Errorf("second login as user %d, want %d", again.ID, me.ID)
+	}
+	if role, _ := api.store.GetOrgRole(context.Background(), org.ID, int64(me.ID)); role != roleAdmin {
+		t.Errorf("role in the organization %q, want %s", role, roleAdmin)
+	}
+
+	// A role above the mapped one, like one granted by hand, is not lowered
+	provider.setClaims(jwt.MapClaims{
+		"email": "carol@corp.example.com", "email_verified": true,
+		"preferred_username": "carol", "groups": []string{"oncall"},
+	})
+	api.ssoLogin(provider, nil)
+	if role, _ := api.store.GetOrgRole(context.Background(), org.ID, int64(me.ID)); role != roleAdmin {
+		t.Errorf("role in the organization %q after a login with a lower group, want %s", role, roleAdmin)
+	}
+
+	// Discovery and the keys were fetched once for both logins
+	provider.mu.Lock()
+	defer provider.mu.Unlock()
+	if n := provider.Requests["/.well-known/openid-configuration"]; n != 1 {
+		t.Errorf("discovery document fetched %d times", n)
+	}
+	if n := provider.Requests["/jwks"]; n != 1 {
+		t.Errorf("signing keys fetched %d times", n)
+	}
+}
+
+func TestOIDCLoginLinksVerifiedEmail(t *testing.T) {
+	api, provider, _ := newOIDCTestAPI(t)
+	aliceID, _ := api.signup("alice")
+
+	// Without OIDC_LINK_BY_EMAIL not even a verified email links
+	provider.setClaims(jwt.MapClaims{"email": "alice@example.com", "email_verified": true})
+	if outcome := api.ssoLogin(provider, nil); outcome.Get("error") != errOIDCEmailTaken.Error() {
+		t.Errorf("linking disabled: outcome %v, want %q", outcome, errOIDCEmailTaken)
+	}
+
+	api.server.oidc.config.LinkByEmail = true
+	provider.setClaims(jwt.MapClaims{"email": "alice@example.com", "email_verified": false})
+	if outcome := api.ssoLogin(provider, nil); outcome.Get("error") != errOIDCEmailTaken.Error() {
+		t.Errorf("unverified email: outcome %v, want %q", outcome, errOIDCEmailTaken)
+	}
+
+	provider.setClaims(jwt.MapClaims{"email": "alice@example.com", "email_verified": true})
+	outcome := api.ssoLogin(provider, nil)
+	var me User
+	expect(t, api.do("GET", "/api/me", outcome.Get("token"), nil), http.StatusOK, &me)
+	if me.ID != aliceID {
+		t.Errorf("logged in as user %d, want alice (%d)", me.ID, aliceID)
+	}
+}
+
+func TestOIDCLoginWithTwoFactor(t *testing.T) {
+	api, provider, _ := newOIDCTestAPI(t)
+	aliceID, _ := api.signup("alice")
+	ctx := context.Background()
+	if err := api.store.SetTOTPSecret(ctx, int64(aliceID), "JBSWY3DPEHPK3PXP"); err != nil {
+		t.Fatal(err)
+	}
+	if err := api.store.EnableTwoFactor(ctx, int64(aliceID), 0, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	api.server.oidc.config.LinkByEmail = true
+	provider.setClaims(jwt.MapClaims{"email": "alice@example.com", "email_verified": true})
+	outcome := api.ssoLogin(provider, nil)
+	if outcome.Get("mfa_token") == "" || outcome.Get("token") != "" {
+		t.Errorf("outcome %v, want an MFA token and no session", outcome)
+	}
+}
+
+func TestOIDCCallbackRejectsInvalidLogins(t *testing.T) {
+	otherKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		setup   func(provider *mockOIDCProvider)
+		tamper  func(provider *mockOIDCProvider, code string)
+		message string
+	}{
+		{
+			name: "wrong code verifier",
+			tamper: func(provider *mockOIDCProvider, code string) {
+				provider.mu.Lock()
+				defer provider.mu.Unlock()
+				issued := provider.Codes[code]
+				issued.Challenge = base64.RawURLEncoding.EncodeToString(make([]byte, 32))
+				provider.Codes[code] = issued
+			},
+			message: "Error completing login with the identity provider",
+		},
+		{
+			name:    "bad signature",
+			setup:   func(provider *mockOIDCProvider) { provider.SigningKey = otherKey },
+			message: "Invalid ID token",
+		},
+		{
+			name:    "wrong issuer",
+			setup:   func(provider *mockOIDCProvider) { provider.Claims["iss"] = "https://evil.example.com" },
+			message: "Invalid ID token",
+		},
+		{
+			name:    "wrong audience",
+			setup:   func(provider *mockOIDCProvider) { provider.Claims["aud"] = "another-client" },
+			message: "Invalid ID token",
+		},
+		{
+			name:    "wrong nonce",
+			setup:   func(provider *mockOIDCProvider) { provider.Claims["nonce"] = "replayed" },
+			message: "Invalid ID token",
+		},
+		{
+			name:    "expired",
+			setup:   func(provider *mockOIDCProvider) { provider.Claims["exp"] = time.Now().Add(-time.Minute).Unix() },
+			message: "Invalid ID token",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			api, provider, _ := newOIDCTestAPI(t)
+			provider.setClaims(jwt.MapClaims{"email": "mallory@example.com", "email_verified": true})
+			if tt.setup != nil {
+				provider.mu.Lock()
+				tt.setup(provider)
+				provider.mu.Unlock()
+			}
+			var tamper func(string)
+			if tt.tamper != nil {
+				tamper = func(code string) { tt.tamper(provider, code) }
+			}
+
+			outcome := api.ssoLogin(provider, tamper)
+			if outcome.Get("error") != tt.message || outcome.Get("token") != "" {
+				t.Errorf("outcome %v, want error %q", outcome, tt.message)
+			}
+			if _, err := api.store.GetUserByEmail(context.Background(), "mallory@example.com"); err != ErrNotFound {
+				t.Errorf("user provisioned despite the failed login (%v)", err)
+			}
+		})
+	}
+}
+
+func TestOIDCCallbackChecksState(t *testing.T) {
+	api, provider, _ := newOIDCTestAPI(t)
+	provider.setClaims(jwt.MapClaims{"email": "carol@example.com", "email_verified": true})
+
+	// A callback without the cookie of the login that started it
+	rec := httptest.NewRecorder()
+	api.handler.ServeHTTP(rec, httptest.NewRequest("GET", "/api/oidc/callback?code=abc&state=xyz", nil))
+	want := testOIDCFrontendURL + "#" + url.Values{"error": {"Login expired, please start again"}}.Encode()
+	if rec.Code != http.StatusFound || rec.Header().Get("Location") != want {
+		t.Errorf("callback without login: status %d, location %q", rec.Code, rec.Header().Get("Location"))
+	}
+}
+
+func TestOIDCDiscoveryChecksIssuer(t *testing.T) {
+	api, provider, _ := newOIDCTestAPI(t)
+	provider.mu.Lock()
+	provider.DiscoveryIssuer = "https://evil.example.com"
+	provider.mu.Unlock()
+
+	rec := httptest.NewRecorder()
+	api.handler.ServeHTTP(rec, httptest.NewRequest("GET", "/api/oidc/login", nil))
+	if rec.Code != http.StatusBadGateway {
+		t.Errorf("login with a discovery document of another issuer: status %d, want %d", rec.Code, http.StatusBadGateway)
+	}
+}
@@ -0,0 +1,520 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// Organization lets a team share tasks, status pages and notification routes
+// instead of one login. See the roles in auth.go.
+type Organization struct {
+	ID   int64  `json:"id"`
+	Name string `json:"name"`
+	// Role is the requesting user's role in the organization
+	Role string `json:"role,omitempty"`
+	// Require2FA denies members without two-factor authentication access
+	Require2FA bool      `json:"require_2fa"`
+	CreatedAt  time.Time `json:"created_at"`
+}
+
+// OrgMember is the membership of a user in an organization
+type OrgMember struct {
+	OrgID     int64     `json:"org_id"`
+	UserID    int64     `json:"user_id"`
+	Username  string    `json:"username"`
+	Email     string    `json:"email"`
+	Role      string    `json:"role"`
+	TwoFactor bool      `json:"two_factor"` // whether the user enabled 2FA
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// OrgInvitation invites an email address into an organization. The token is
+// emailed and only returned when the invitation is created; the store keeps
+// its hash.
+type OrgInvitation struct {
+	ID         int64      `json:"id"`
+	OrgID      int64      `json:"org_id"`
+	Email      string     `json:"email"`
+	Role       string     `json:"role"`
+	Token      string     `json:"token,omitempty"`
+	TokenHash  string     `json:"-"`
+	InvitedBy  int64      `json:"invited_by"`
+	ExpiresAt  time.Time  `json:"expires_at"`
+	AcceptedAt *time.Time `json:"accepted_at,omitempty"`
+	CreatedAt  time.Time  `json:"created_at"`
+}
+
+// How long an invitation can be accepted
+const invitationTTL = 7 * 24 * time.Hour
+
+// orgFromRequest loads the organization named by the {org_id} path variable
+// and checks that the authenticated user has at least the required role in
+// it. Non-members get a 404. It writes the error response itself and returns
+// ok=false on failure.
+func (s *Server) orgFromRequest(w http.ResponseWriter, r *http.Request, required string) (Organization, bool) {
+	vars := mux.Vars(r)
+	id, err := strconv.ParseInt(vars["org_id"], 10, 64)
+	if err != nil {
+		log.Printf("Invalid organization ID: %s", vars["org_id"])
+		respondWithError(w, http.StatusBadRequest, "Invalid organization ID")
+		return Organization{}, false
+	}
+
+	role, err := s.roleOn(r.Context(), authUserID(r), 0, id)
+	if err == nil && role == "" {
+		err = ErrNotFound
+	}
+	var org Organization
+	if err == nil {
+		org, err = s.orgs.GetOrg(r.Context(), id)
+	}
+	if err != nil {
+		if errors.Is(err, ErrNotFound) {
+			respondWithError(w, http.StatusNotFound, "Organization not found")
+		} else if errors.Is(err, errTwoFactorRequired) {
+			respondWithError(w, http.StatusForbidden, "This organization requires two-factor authentication; enable it on your account first")
+		} else {
+			log.Printf("Error retrieving organization: %v", err)
+			respondWithError(w, http.StatusInternalServerError, "Error retrieving organization")
+		}
+		return Organization{}, false
+	}
+	if !hasRole(role, required) {
+		respondWithError(w, http.StatusForbidden, "Insufficient permissions")
+		return Organization{}, false
+	}
+	org.Role = role
+	return org, true
+}
+
+// getOrgs lists the organizations of the authenticated user
+func (s *Server) getOrgs(w http.ResponseWriter, r *http.Request) {
+	orgs, err := s.orgs.ListUserOrgs(r.Context(), authUserID(r))
+	if err != nil {
+		log.Printf("Error retrieving organizations for user %d: %v", authUserID(r), err)
+		respondWithError(w, http.StatusInternalServerError, "Error retrieving organizations")
+		return
+	}
+	respondWithJSON(w, http.StatusOK, orgs)
+}
+
+// createOrg creates an organization owned by the authenticated user
+func (s *Server) createOrg(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Name string `json:"name" validate:"required,max=255"`
+	}
+	if !decodeJSON(w, r, &req) {
+		return
+	}
+	req.Name = strings.TrimSpace(req.Name)
+
+	org, err := s.orgs.CreateOrg(r.Context(), req.Name, authUserID(r))
+	if err != nil {
+		log.Printf("Error creating organization: %v", err)
+		respondWithError(w, http.StatusInternalServerError, "Error creating organization")
+		return
+	}
+
+	log.Printf("Organization created successfully with ID: %d by user ID: %d", org.ID, authUserID(r))
+	respondWithJSON(w, http.StatusCreated, org)
+}
+
+func (s *Server) getOrg(w http.ResponseWriter, r *http.Request) {
+	org, ok := s.orgFromRequest(w, r, roleViewer)
+	if !ok {
+		return
+	}
+	respondWithJSON(w, http.StatusOK, org)
+}
+
+// updateOrg renames an organization or changes whether its members need
+// two-factor authentication. Admins can only require it once they have it
+// themselves, so they do not lock themselves out.
+func (s *Server) updateOrg(w http.ResponseWriter, r *http.Request) {
+	org, ok := s.orgFromRequest(w, r, roleAdmin)
+	if !ok {
+		return
+	}
+
+	var req struct {
+		Name       *string `json:"name" validate:"notblank,max=255"`
+		Require2FA *bool   `json:"require_2fa"`
+	}
+	if !decodeJSON(w, r, &req) {
+		return
+	}
+
+	if req.Name != nil {
+		org.Name = strings.TrimSpace(*req.Name)
+	}
+	if req.Require2FA != nil {
+		if *req.Require2FA && !org.Require2FA {
+			tf, err := s.twoFactor.GetTwoFactor(r.Context(), authUserID(r))
+			if err != nil {
+				log.Printf("Error retrieving two-factor state: %v", err)
+				respondWithError(w, http.StatusInternalServerError, "Error updating organization")
+				return
+			}
+			if !tf.Enabled {
+				respondWithError(w, http.StatusConflict, "Enable two-factor authentication on your account before requiring it")
+				return
+			}
+		}
+		org.Require2FA = *req.Require2FA
+	}
+
+	role := org.Role
+	org, err := s.orgs.UpdateOrg(r.Context(), org)
+	if err != nil {
+		if errors.Is(err, ErrNotFound) {
+			respondWithError(w, http.StatusNotFound, "Organization not found")
+		} else {
+			log.Printf("Error updating organization: %v", err)
+			respondWithError(w, http.StatusInternalServerError, "Error updating organization")
+		}
+		return
+	}
+	org.Role = role
+
+	log.Printf("Organization %d updated by user ID: %d", org.ID, authUserID(r))
+	respondWithJSON(w, http.StatusOK, org)
+}
+
+// deleteOrg deletes an organization with its status pages, notification
+// routes, members and invitations. Its tasks have to be moved or deleted
+// first, so monitoring is never dropped by accident.
+func (s *Server) deleteOrg(w http.ResponseWriter, r *http.Request) {
+	org, ok := s.orgFromRequest(w, r, roleOwner)
+	if !ok {
+		return
+	}
+
+	tasks, err := s.tasks.ListUserTasks(r.Context(), 0, TaskFilter{OrgID: org.ID})
+	if err != nil {
+		log.Printf("Error querying tasks: %v", err)
+		respondWithError(w, http.StatusInternalServerError, "Error deleting organization")
+		return
+	}
+	if len(tasks) > 0 {
+		respondWithError(w, http.StatusConflict, fmt.Sprintf("The organization still has %d tasks, move or delete them first", len(tasks)))
+		return
+	}
+
+	if err := s.orgs.DeleteOrg(r.Context(), org.ID); err != nil && !errors.Is(err, ErrNotFound) {
+		log.Printf("Error deleting organization: %v", err)
+		respondWithError(w, http.StatusInternalServerError, "Error deleting organization")
+		return
+	}
+
+	log.Printf("Organization deleted successfully: %d", org.ID)
+	respondWithJSON(w, http.StatusOK, map[string]string{"message": "Organization deleted successfully"})
+}
+
+func (s *Server) getOrgMembers(w http.ResponseWriter, r *http.Request) {
+	org, ok := s.orgFromRequest(w, r, roleViewer)
+	if !ok {
+		return
+	}
+
+	members, err := s.orgs.ListOrgMembers(r.Context(), org.ID)
+	if err != nil {
+		log.Printf("Error retrieving members of organization %d: %v", org.ID, err)
+		respondWithError(w, http.StatusInternalServerError, "Error retrieving members")
+		return
+	}
+	respondWithJSON(w, http.StatusOK, members)
+}
+
+// memberFromRequest loads the organization and the member named by the
+// {org_id} and {user_id} path variables. Changing a member takes an admin,
+// and changing an owner (or making one) takes an owner. self allows members
+// to change themselves regardless of their role. It writes the error
+// response itself and returns ok=false on failure.
+func (s *Server) memberFromRequest(w http.ResponseWriter, r *http.Request, self bool) (Organization, OrgMember, []OrgMember, bool) {
+	vars := mux.Vars(r)
+	userID, err := strconv.ParseInt(vars["user_id"], 10, 64)
+	if err != nil {
+		log.Printf("Invalid user ID: %s", vars["user_id"])
+		respondWithError(w, http.StatusBadRequest, "Invalid user ID")
+		return Organization{}, OrgMember{}, nil, false
+	}
+
+	required := roleAdmin
+	if self && userID == authUserID(r) {
+		required = roleViewer
+	}
+	org, ok := s.orgFromRequest(w, r, required)
+	if !ok {
+		return org, OrgMember{}, nil, false
+	}
+
+	members, err := s.orgs.ListOrgMembers(r.Context(), org.ID)
+	if err != nil {
+		log.Printf("Error retrieving members of organization %d: %v", org.ID, err)
+		respondWithError(w, http.StatusInternalServerError, "Error retrieving members")
+		return org, OrgMember{}, nil, false
+	}
+	for _, member := range members {
+		if member.UserID != userID {
+			continue
+		}
+		if member.Role == roleOwner && !hasRole(org.Role, roleOwner) {
+			respondWithError(w, http.StatusForbidden, "Only owners can change owners")
+			return org, member, nil, false
+		}
+		return org, member, members, true
+	}
+	respondWithError(w, http.StatusNotFound, "Member not found")
+	return org, OrgMember{}, nil, false
+}
+
+// lastOwner reports whether member is the only owner among members
+func lastOwner(member OrgMember, members []OrgMember) bool {
+	if member.Role != roleOwner {
+		return false
+	}
+	owners := 0
+	for _, m := range members {
+		if m.Role == roleOwner {
+			owners++
+		}
+	}
+	return owners == 1
+}
+
+// updateOrgMember changes the role of a member
+func (s *Server) updateOrgMember(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Role string `json:"role" validate:"required,oneof=owner admin editor viewer"`
+	}
+	if !decodeJSON(w, r, &req) {
+		return
+	}
+
+	org, member, members, ok := s.memberFromRequest(w, r, false)
+	if !ok {
+		return
+	}
+	if req.Role == roleOwner && !hasRole(org.Role, roleOwner) {
+		respondWithError(w, http.StatusForbidden, "Only owners can change owners")
+		return
+	}
+	if req.Role != roleOwner && lastOwner(member, members) {
+		respondWithError(w, http.StatusConflict, "An organization needs at least one owner")
+		return
+	}
+
+	if err := s.orgs.SetOrgRole(r.Context(), org.ID, member.UserID, req.Role); err != nil {
+		log.Printf("Error updating member %d of organization %d: %v", member.UserID, org.ID, err)
+		respondWithError(w, http.StatusInternalServerError, "Error updating member")
+		return
+	}
+
+	log.Printf("User ID %d is now %s of organization ID: %d", member.UserID, req.Role, org.ID)
+	member.Role = req.Role
+	respondWithJSON(w, http.StatusOK, member)
+}
+
+// removeOrgMember removes a member; members can also leave on their own
+func (s *Server) removeOrgMember(w http.ResponseWriter, r *http.Request) {
+	org, member, members, ok := s.memberFromRequest(w, r, true)
+	if !ok {
+		return
+	}
+	if lastOwner(member, members) {
+		respondWithError(w, http.StatusConflict, "An organization needs at least one owner")
+		return
+	}
+
+	if err := s.orgs.RemoveOrgMember(r.Context(), org.ID, member.UserID); err != nil && !errors.Is(err, ErrNotFound) {
+		log.Printf("Error removing member %d of organization %d: %v", member.UserID, org.ID, err)
+		respondWithError(w, http.StatusInternalServerError, "Error removing member")
+		return
+	}
+
+	log.Printf("User ID %d removed from organization ID: %d", member.UserID, org.ID)
+	respondWithJSON(w, http.StatusOK, map[string]string{"message": "Member removed successfully"})
+}
+
+func (s *Server) getInvitations(w http.ResponseWriter, r *http.Request) {
+	org, ok := s.orgFromRequest(w, r, roleAdmin)
+	if !ok {
+		return
+	}
+
+	invitations, err := s.orgs.ListInvitations(r.Context(), org.ID)
+	if err != nil {
+		log.Printf("Error retrieving invitations of organization %d: %v", org.ID, err)
+		respondWithError(w, http.StatusInternalServerError, "Error retrieving invitations")
+		return
+	}
+	respondWithJSON(w, http.StatusOK, invitations)
+}
+
+// invitationURL is the link in invitation emails. The dashboard accepts the
+// invitation for the signed in user.
+func invitationURL(token string) string {
+	return appURL("/invitations/" + token)
+}
+
+// createInvitation invites an email address into the organization and emails
+// it the token to accept with. The response carries the token too, so that
+// it can be handed over some other way when email is not set up.
+func (s *Server) createInvitation(w http.ResponseWriter, r *http.Request) {
+	org, ok := s.orgFromRequest(w, r, roleAdmin)
+	if !ok {
+		return
+	}
+
+	var req struct {
+		Email string `json:"email" validate:"required,email"`
+		Role  string `json:"role" validate:"oneof=owner admin editor viewer"`
+	}
+	if !decodeJSON(w, r, &req) {
+		return
+	}
+
+	invitation := OrgInvitation{Email: req.Email, Role: req.Role}
+	if invitation.Role == "" {
+		invitation.Role = roleViewer
+	}
+	if invitation.Role == roleOwner && !hasRole(org.Role, roleOwner) {
+		respondWithError(w, http.StatusForbidden, "Only owners can invite owners")
+		return
+	}
+
+	now, err := s.monitor.Now(r.Context())
+	if err == nil {
+		invitation.Token, err = newAccessToken()
+	}
+	if err != nil {
+		log.Printf("Error generating invitation token: %v", err)
+		respondWithError(w, http.StatusInternalServerError, "Error creating invitation")
+		return
+	}
+	invitation.OrgID = org.ID
+	invitation.TokenHash = hashToken(invitation.Token)
+	invitation.InvitedBy = authUserID(r)
+	invitation.ExpiresAt = now.Add(invitationTTL)
+
+	created, err := s.orgs.CreateInvitation(r.Context(), invitation)
+	if err != nil {
+		log.Printf("Error creating invitation: %v", err)
+		respondWithError(w, http.StatusInternalServerError, "Error creating invitation")
+		return
+	}
+	created.Token = invitation.Token
+
+	body := fmt.Sprintf("You have been invited to join %s on ServerLord as %s.\n\n"+
+		"Accept the invitation here within %d days:\n%s\n",
+		org.Name, created.Role, int(invitationTTL.Hours()/24), invitationURL(created.Token))
+	if err := sendEmail(created.Email, "[ServerLord] Invitation to join "+org.Name, "text/plain", body); err != nil {
+		log.Printf("Error emailing invitation %d: %v", created.ID, err)
+	}
+
+	log.Printf("Invitation created successfully with ID: %d for organization ID: %d", created.ID, org.ID)
+	respondWithJSON(w, http.StatusCreated, created)
+}
+
+func (s *Server) deleteInvitation(w http.ResponseWriter, r *http.Request) {
+	org, ok := s.orgFromRequest(w, r, roleAdmin)
+	if !ok {
+		return
+	}
+	vars := mux.Vars(r)
+	id, err := strconv.ParseInt(vars["id"], 10, 64)
+	if err != nil {
+		log.Printf("Invalid invitation ID: %s", vars["id"])
+		respondWithError(w, http.StatusBadRequest, "Invalid invitation ID")
+		return
+	}
+
+	if err := s.orgs.DeleteInvitation(r.Context(), org.ID, id); err != nil {
+		if errors.Is(err, ErrNotFound) {
+			respondWithError(w, http.StatusNotFound, "Invitation not found")
+		} else {
+			log.Printf("Error deleting invitation: %v", err)
+			respondWithError(w, http.StatusInternalServerError, "Error deleting invitation")
+		}
+		return
+	}
+
+	log.Printf("Invitation deleted successfully: %d", id)
+	respondWithJSON(w, http.StatusOK, map[string]string{"message": "Invitation deleted successfully"})
+}
+
+// acceptInvitation adds the authenticated user to the organization of the
+// invitation with the emailed token
+func (s *Server) acceptInvitation(w http.ResponseWriter, r *http.Request) {
+	token := mux.Vars(r)["token"]
+
+	// Invitations go to an email address, so only its verified owner joins
+	user, err := s.users.GetUser(r.Context(), authUserID(r))
+	if err != nil {
+		log.Printf("Error retrieving user: %v", err)
+		respondWithError(w, http.StatusInternalServerError, "Error accepting invitation")
+		return
+	}
+	if !user.EmailVerified {
+		respondWithError(w, http.StatusForbidden, "Verify your email address before accepting an invitation")
+		return
+	}
+
+	member, err := s.orgs.AcceptInvitation(r.Context(), hashToken(token), authUserID(r))
+	if err != nil {
+		switch {
+		case errors.Is(err, ErrNotFound):
+			respondWithError(w, http.StatusNotFound, "Invitation not found, expired or sent to another email address")
+		case errors.Is(err, ErrConflict):
+			respondWithError(w, http.StatusConflict, "Already a member of the organization")
+		default:
+			log.Printf("Error accepting invitation: %v", err)
+			respondWithError(w, http.StatusInternalServerError, "Error accepting invitation")
+		}
+		return
+	}
+
+	log.Printf("User ID %d joined organization ID: %d as %s", member.UserID, member.OrgID, member.Role)
+	respondWithJSON(w, http.StatusOK, member)
+}
+
+// moveTask moves a task into an organization ({"org_id": N}), or back to its
+// user ({"org_id": 0}). It takes editing rights on both sides.
+func (s *Server) moveTask(w http.ResponseWriter, r *http.Request) {
+	task, ok := s.taskFromRequest(w, r, roleEditor)
+	if !ok {
+		return
+	}
+
+	var req struct {
+		OrgID int64 `json:"org_id"`
+	}
+	if !decodeJSON(w, r, &req) {
+		return
+	}
+
+	if !s.authorize(w, r, task.UserID, req.OrgID, roleEditor) {
+		return
+	}
+
+	if err := s.tasks.SetTaskOrg(r.Context(), task.ID, req.OrgID); err != nil {
+		if errors.Is(err, ErrNotFound) {
+			respondWithError(w, http.StatusNotFound, "Task not found")
+		} else {
+			log.Printf("Error moving task: %v", err)
+			respondWithError(w, http.StatusInternalServerError, "Error moving task")
+		}
+		return
+	}
+	task.OrgID = req.OrgID
+
+	log.Printf("Task ID %d moved to organization ID: %d", task.ID, req.OrgID)
+	respondWithJSON(w, http.StatusOK, task)
+}
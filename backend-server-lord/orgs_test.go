@@ -0,0 +1,197 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestHasRole(t *testing.T) {
+	for _, tc := range []struct {
+		role, required string
+		want           bool
+	}{
+		{roleOwner, roleAdmin, true},
+		{roleEditor, roleEditor, true},
+		{roleEditor, roleAdmin, false},
+		{roleViewer, roleViewer, true},
+		{"", roleViewer, false},
+		{"superuser", roleViewer, false},
+	} {
+		if got := hasRole(tc.role, tc.required); got != tc.want {
+			t.Errorf("hasRole(%q, %q) = %v, want %v", tc.role, tc.required, got, tc.want)
+		}
+	}
+}
+
+func TestStoreOrgs(t *testing.T) {
+	forEachStore(t, func(t *testing.T, store Store, clock *time.Time) {
+		ctx := context.Background()
+		alice, err := store.CreateUser(ctx, "alice", "alice@example.com", "hash")
+		if err != nil {
+			t.Fatal(err)
+		}
+		bob, err := store.CreateUser(ctx, "bob", "bob@example.com", "hash")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		org, err := store.CreateOrg(ctx, "Ops", int64(alice.ID))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if role, err := store.GetOrgRole(ctx, org.ID, int64(alice.ID)); err != nil || role != roleOwner {
+			t.Errorf("role of the creator %q, %v; want owner", role, err)
+		}
+		if _, err := store.GetOrgRole(ctx, org.ID, int64(bob.ID)); !errors.Is(err, ErrNotFound) {
+			t.Errorf("role of a non-member: %v, want ErrNotFound", err)
+		}
+
+		invite := func(tokenHash string, expiresIn time.Duration) {
+			t.Helper()
+			if _, err := store.CreateInvitation(ctx, OrgInvitation{
+				OrgID: org.ID, Email: "Bob@Example.com", Role: roleEditor, TokenHash: tokenHash,
+				InvitedBy: int64(alice.ID), ExpiresAt: clock.Add(expiresIn),
+			}); err != nil {
+				t.Fatal(err)
+			}
+		}
+		invite("expired", time.Minute)
+		invite("valid", invitationTTL)
+		*clock = clock.Add(time.Hour)
+
+		if _, err := store.AcceptInvitation(ctx, "expired", int64(bob.ID)); !errors.Is(err, ErrNotFound) {
+			t.Errorf("accepting an expired invitation: %v, want ErrNotFound", err)
+		}
+
+		// Only the verified owner of the invited address, in any case, joins
+		if _, err := store.AcceptInvitation(ctx, "valid", int64(bob.ID)); !errors.Is(err, ErrNotFound) {
+			t.Errorf("accepting with an unverified address: %v, want ErrNotFound", err)
+		}
+		if err := store.MarkEmailVerified(ctx, int64(bob.ID), "bob@example.com"); err != nil {
+			t.Fatal(err)
+		}
+		if err := store.MarkEmailVerified(ctx, int64(alice.ID), "alice@example.com"); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := store.AcceptInvitation(ctx, "valid", int64(alice.ID)); !errors.Is(err, ErrNotFound) {
+			t.Errorf("accepting an invitation sent to another address: %v, want ErrNotFound", err)
+		}
+		member, err := store.AcceptInvitation(ctx, "valid", int64(bob.ID))
+		if err != nil || member.Role != roleEditor || member.UserID != int64(bob.ID) {
+			t.Fatalf("AcceptInvitation = %+v, %v", member, err)
+		}
+		if _, err := store.AcceptInvitation(ctx, "valid", int64(bob.ID)); !errors.Is(err, ErrNotFound) {
+			t.Errorf("accepting an invitation twice: %v, want ErrNotFound", err)
+		}
+		invite("again", invitationTTL)
+		if _, err := store.AcceptInvitation(ctx, "again", int64(bob.ID)); !errors.Is(err, ErrConflict) {
+			t.Errorf("accepting as a member: %v, want ErrConflict", err)
+		}
+
+		if members, err := store.ListOrgMembers(ctx, org.ID); err != nil || len(members) != 2 {
+			t.Errorf("ListOrgMembers = %+v, %v; want alice and bob", members, err)
+		}
+		if orgs, err := store.ListUserOrgs(ctx, int64(bob.ID)); err != nil || len(orgs) != 1 || orgs[0].Role != roleEditor {
+			t.Errorf("ListUserOrgs = %+v, %v; want Ops as editor", orgs, err)
+		}
+
+		// Organization tasks leave the personal listing
+		task := createTestTaskFor(t, store, int64(alice.ID))
+		if err := store.SetTaskOrg(ctx, task.ID, org.ID); err != nil {
+			t.Fatal(err)
+		}
+		if tasks, err := store.ListUserTasks(ctx, int64(alice.ID), TaskFilter{}); err != nil || len(tasks) != 0 {
+			t.Errorf("personal tasks %+v, %v; want none", tasks, err)
+		}
+		if tasks, err := store.ListUserTasks(ctx, int64(bob.ID), TaskFilter{OrgID: org.ID}); err != nil || len(tasks) != 1 {
+			t.Errorf("organization tasks %+v, %v; want the task", tasks, err)
+		}
+
+		if err := store.RemoveOrgMember(ctx, org.ID, int64(bob.ID)); err != nil {
+			t.Fatal(err)
+		}
+		if err := store.DeleteOrg(ctx, org.ID); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := store.GetOrg(ctx, org.ID); !errors.Is(err, ErrNotFound) {
+			t.Errorf("GetOrg after deleting it: %v, want ErrNotFound", err)
+		}
+	})
+}
+
+// createTestTaskFor creates a task of an existing user
+func createTestTaskFor(t *testing.T, store Store, userID int64) Task {
+	t.Helper()
+	task, err := store.CreateTask(context.Background(), Task{Name: "backup", UserID: userID, Interval: 60, TaskNumber: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return task
+}
+
+func TestOrgAPI(t *testing.T) {
+	api := newTestAPI(t)
+	aliceID, alice := api.signup("alice")
+	bobID, bob := api.signup("bob")
+	carolID, carol := api.signup("carol")
+
+	var org Organization
+	expect(t, api.do("POST", "/api/orgs", alice, map[string]string{"name": " Ops "}), http.StatusCreated, &org)
+	if org.Name != "Ops" {
+		t.Fatalf("created organization %+v", org)
+	}
+	orgPath := fmt.Sprintf("/api/orgs/%d", org.ID)
+
+	task := api.createTask(alice, aliceID, "backup", 7, 60)
+	taskPath := fmt.Sprintf("/api/tasks/%d", task.ID)
+	expect(t, api.do("GET", taskPath, bob, nil), http.StatusForbidden, nil)
+	expect(t, api.do("PUT", taskPath+"/org", alice, map[string]int64{"org_id": org.ID}), http.StatusOK, nil)
+
+	// Only members see the organization
+	expect(t, api.do("GET", orgPath, carol, nil), http.StatusNotFound, nil)
+	expect(t, api.do("GET", orgPath+"/tasks", carol, nil), http.StatusForbidden, nil)
+
+	var invitation OrgInvitation
+	expect(t, api.do("POST", orgPath+"/invitations", alice, map[string]string{"email": "bob@example.com"}), http.StatusCreated, &invitation)
+	if invitation.Token == "" || invitation.Role != roleViewer {
+		t.Fatalf("created invitation %+v", invitation)
+	}
+	acceptPath := "/api/invitations/" + invitation.Token + "/accept"
+	expect(t, api.do("POST", acceptPath, bob, nil), http.StatusForbidden, nil)
+	for _, user := range []struct {
+		id    int
+		email string
+	}{{bobID, "bob@example.com"}, {carolID, "carol@example.com"}} {
+		if err := api.store.MarkEmailVerified(context.Background(), int64(user.id), user.email); err != nil {
+			t.Fatal(err)
+		}
+	}
+	expect(t, api.do("POST", acceptPath, carol, nil), http.StatusNotFound, nil)
+	expect(t, api.do("POST", acceptPath, bob, nil), http.StatusOK, nil)
+
+	// Viewers read the organization's tasks but do not change them
+	var listed taskListing
+	expect(t, api.do("GET", orgPath+"/tasks", bob, nil), http.StatusOK, &listed)
+	if len(listed.Tasks) != 1 || listed.Tasks[0].Task.ID != task.ID {
+		t.Errorf("organization tasks %+v, want the moved task", listed)
+	}
+	expect(t, api.do("GET", taskPath, bob, nil), http.StatusOK, nil)
+	expect(t, api.do("DELETE", taskPath, bob, nil), http.StatusForbidden, nil)
+	expect(t, api.do("POST", orgPath+"/invitations", bob, map[string]string{"email": "carol@example.com"}), http.StatusForbidden, nil)
+
+	memberPath := fmt.Sprintf("%s/members/%d", orgPath, bobID)
+	expect(t, api.do("PUT", memberPath, bob, map[string]string{"role": roleEditor}), http.StatusForbidden, nil)
+	expect(t, api.do("PUT", memberPath, alice, map[string]string{"role": roleEditor}), http.StatusOK, nil)
+	expect(t, api.do("PUT", taskPath, bob, map[string]interface{}{
+		"name": "nightly backup", "interval": 120, "task_number": 7,
+	}), http.StatusOK, nil)
+
+	// The last owner can neither be demoted nor leave
+	expect(t, api.do("PUT", fmt.Sprintf("%s/members/%d", orgPath, aliceID), alice, map[string]string{"role": roleAdmin}), http.StatusConflict, nil)
+	expect(t, api.do("DELETE", memberPath, bob, nil), http.StatusOK, nil)
+	expect(t, api.do("GET", taskPath, bob, nil), http.StatusForbidden, nil)
+}
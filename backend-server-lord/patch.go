@@ -0,0 +1,192 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// taskReadOnlyFields are the fields of a task that only the server writes.
+// A merge patch naming one of them is rejected rather than silently ignored.
+var taskReadOnlyFields = map[string]bool{
+	"id":                true,
+	"user_id":           true,
+	"org_id":            true,
+	"last_ping":         true,
+	"last_checked":      true,
+	"previous_status":   true,
+	"status_changed_at": true,
+	"uptime_seconds":    true,
+	"downtime_seconds":  true,
+	"ping_count":        true,
+	"version":           true,
+}
+
+// taskETag is the entity tag of a task, its quoted version. The version only
+// counts edits, so the tag stays the same while the monitor and heartbeats
+// update the status and counters.
+func taskETag(task Task) string {
+	return strconv.Quote(strconv.FormatInt(task.Version, 10))
+}
+
+// ifMatchVersion checks the If-Match header of a request to change a task.
+// It returns the version the change must apply to, or 0 when the request has
+// no If-Match or sends "*". It responds with 412 and returns ok=false when
+// the header names another version than the current one.
+func ifMatchVersion(w http.ResponseWriter, r *http.Request, task Task) (int64, bool) {
+	values := r.Header.Values("If-Match")
+	if len(values) == 0 {
+		return 0, true
+	}
+
+	etag := taskETag(task)
+	for _, tag := range strings.Split(strings.Join(values, ","), ",") {
+		switch strings.TrimSpace(tag) {
+		case "*":
+			return 0, true
+		case etag:
+			return task.Version, true
+		}
+	}
+
+	log.Printf("If-Match %q does not match task %d at %s", strings.Join(values, ","), task.ID, etag)
+	w.Header().Set("ETag", etag)
+	respondWithError(w, http.StatusPreconditionFailed, "The task was changed since it was read; fetch it again and retry")
+	return 0, false
+}
+
+// decodeMergePatch reads a JSON merge patch (RFC 7396) request body, which
+// must be an object. It writes the error response itself and returns
+// ok=false on failure.
+func decodeMergePatch(w http.ResponseWriter, r *http.Request) (map[string]interface{}, bool) {
+	defer r.Body.Close()
+
+	if contentType := r.Header.Get("Content-Type"); contentType != "" {
+		mediaType, _, err := mime.ParseMediaType(contentType)
+		if err != nil || (mediaType != "application/merge-patch+json" && mediaType != "application/json") {
+			respondWithError(w, http.StatusUnsupportedMediaType, "Content-Type must be application/merge-patch+json")
+			return nil, false
+		}
+	}
+
+	// Numbers stay json.Numbers, so large integers survive the round trip
+	decoder := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxRequestBodyBytes))
+	decoder.UseNumber()
+	var patch interface{}
+	err := decoder.Decode(&patch)
+	if err == nil && decoder.Decode(&struct{}{}) != io.EOF {
+		err = errors.New("trailing data after the JSON body")
+	}
+	if err != nil {
+		respondWithDecodeError(w, err)
+		return nil, false
+	}
+
+	object, ok := patch.(map[string]interface{})
+	if !ok {
+		respondWithError(w, http.StatusBadRequest, "A merge patch must be a JSON object")
+		return nil, false
+	}
+	return object, true
+}
+
+// mergePatch applies an RFC 7396 merge patch to target: members of the patch
+// replace those of the target, null members remove them and objects merge
+// recursively. Anything but an object replaces the target as a whole.
+func mergePatch(target, patch interface{}) interface{} {
+	patchObject, ok := patch.(map[string]interface{})
+	if !ok {
+		return patch
+	}
+	targetObject, ok := target.(map[string]interface{})
+	if !ok {
+		targetObject = map[string]interface{}{}
+	}
+	for name, value := range patchObject {
+		if value == nil {
+			delete(targetObject, name)
+		} else {
+			targetObject[name] = mergePatch(targetObject[name], value)
+		}
+	}
+	return targetObject
+}
+
+// patchTask changes the fields of a task named by a JSON merge patch, leaving
+// the others alone. The patch applies to the task as it was read, so a
+// concurrent edit makes it fail with 412 rather than be overwritten.
+func (s *Server) patchTask(w http.ResponseWriter, r *http.Request) {
+	existing, ok := s.taskFromRequest(w, r, roleEditor)
+	if !ok {
+		return
+	}
+
+	log.Printf("Patching task with ID: %d", existing.ID)
+
+	version, ok := ifMatchVersion(w, r, existing)
+	if !ok {
+		return
+	}
+	if version == 0 {
+		version = existing.Version
+	}
+
+	patch, ok := decodeMergePatch(w, r)
+	if !ok {
+		return
+	}
+	var readOnly []FieldError
+	for name := range patch {
+		if taskReadOnlyFields[name] {
+			readOnly = append(readOnly, FieldError{Field: name, Message: "is read-only"})
+		}
+	}
+	if len(readOnly) > 0 {
+		sort.Slice(readOnly, func(i, j int) bool { return readOnly[i].Field < readOnly[j].Field })
+		respondWithFieldErrors(w, readOnly)
+		return
+	}
+
+	// Merge the patch into the editable fields of the task and check the
+	// result like the body of a PUT
+	current, err := json.Marshal(updateTaskRequest{
+		Name:       existing.Name,
+		PingURL:    existing.PingURL,
+		Interval:   existing.Interval,
+		TaskNumber: existing.TaskNumber,
+		Project:    existing.Project,
+		Tags:       existing.Tags,
+	})
+	if err != nil {
+		log.Printf("Error encoding task %d: %v", existing.ID, err)
+		respondWithError(w, http.StatusInternalServerError, "Error updating task")
+		return
+	}
+	var document interface{}
+	decoder := json.NewDecoder(bytes.NewReader(current))
+	decoder.UseNumber()
+	if err := decoder.Decode(&document); err != nil {
+		log.Printf("Error decoding task %d: %v", existing.ID, err)
+		respondWithError(w, http.StatusInternalServerError, "Error updating task")
+		return
+	}
+	merged, err := json.Marshal(mergePatch(document, patch))
+	if err != nil {
+		log.Printf("Error encoding patched task %d: %v", existing.ID, err)
+		respondWithError(w, http.StatusInternalServerError, "Error updating task")
+		return
+	}
+
+	var req updateTaskRequest
+	if !decodeValid(w, bytes.NewReader(merged), &req) {
+		return
+	}
+	s.saveTask(w, r, existing, req, version)
+}
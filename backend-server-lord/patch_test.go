@@ -0,0 +1,112 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+func TestMergePatch(t *testing.T) {
+	// Examples from RFC 7396, appendix A
+	tests := []struct {
+		target, patch, want string
+	}{
+		{`{"a":"b"}`, `{"a":"c"}`, `{"a":"c"}`},
+		{`{"a":"b"}`, `{"b":"c"}`, `{"a":"b","b":"c"}`},
+		{`{"a":"b"}`, `{"a":null}`, `{}`},
+		{`{"a":"b","b":"c"}`, `{"a":null}`, `{"b":"c"}`},
+		{`{"a":["b"]}`, `{"a":"c"}`, `{"a":"c"}`},
+		{`{"a":"c"}`, `{"a":["b"]}`, `{"a":["b"]}`},
+		{`{"a":{"b":"c"}}`, `{"a":{"b":"d","c":null}}`, `{"a":{"b":"d"}}`},
+		{`{"a":[{"b":"c"}]}`, `{"a":[1]}`, `{"a":[1]}`},
+		{`["a","b"]`, `["c","d"]`, `["c","d"]`},
+		{`{"a":"b"}`, `["c"]`, `["c"]`},
+		{`{"e":null}`, `{"a":1}`, `{"a":1,"e":null}`},
+		{`[1,2]`, `{"a":"b","c":null}`, `{"a":"b"}`},
+		{`{}`, `{"a":{"bb":{"ccc":null}}}`, `{"a":{"bb":{}}}`},
+	}
+	for _, tt := range tests {
+		var target, patch, want interface{}
+		for i, s := range []string{tt.target, tt.patch, tt.want} {
+			if err := json.Unmarshal([]byte(s), []*interface{}{&target, &patch, &want}[i]); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if got := mergePatch(target, patch); !reflect.DeepEqual(got, want) {
+			t.Errorf("mergePatch(%s, %s) = %v, want %s", tt.target, tt.patch, got, tt.want)
+		}
+	}
+}
+
+// doIfMatch sends a request like do, with an If-Match header when ifMatch
+// is not empty
+func (api *testAPI) doIfMatch(method, path, token, ifMatch, contentType, body string) *httptest.ResponseRecorder {
+	api.t.Helper()
+	req := httptest.NewRequest(method, path, bytes.NewBufferString(body))
+	req.Header.Set("Content-Type", contentType)
+	req.Header.Set("Authorization", "Bearer "+token)
+	if ifMatch != "" {
+		req.Header.Set("If-Match", ifMatch)
+	}
+	rec := httptest.NewRecorder()
+	api.handler.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestPatchTask(t *testing.T) {
+	api := newTestAPI(t)
+	userID, token := api.signup("alice")
+	task := api.createTask(token, userID, "backup", 1, 60)
+	path := fmt.Sprintf("/api/tasks/%d", task.ID)
+	const mergePatchJSON = "application/merge-patch+json"
+
+	rec := api.do("GET", path, token, nil)
+	expect(t, rec, http.StatusOK, nil)
+	etag := rec.Header().Get("ETag")
+	if etag == "" {
+		t.Fatal("GET returned no ETag")
+	}
+
+	// Only the named fields change, and null clears the project
+	var patched Task
+	rec = api.doIfMatch("PATCH", path, token, etag, mergePatchJSON, `{"name": "nightly backup", "project": null}`)
+	expect(t, rec, http.StatusOK, &patched)
+	if patched.Name != "nightly backup" || patched.Interval != 60 || patched.TaskNumber != 1 {
+		t.Errorf("patched task = %+v", patched)
+	}
+	if rec.Header().Get("ETag") == etag {
+		t.Error("ETag did not change with the edit")
+	}
+
+	// The old ETag is stale now, for PATCH and PUT alike
+	rec = api.doIfMatch("PATCH", path, token, etag, mergePatchJSON, `{"interval": 120}`)
+	expect(t, rec, http.StatusPreconditionFailed, nil)
+	if rec.Header().Get("ETag") == etag {
+		t.Error("412 response did not carry the current ETag")
+	}
+	body := `{"name": "backup", "interval": 120}`
+	expect(t, api.doIfMatch("PUT", path, token, etag, "application/json", body), http.StatusPreconditionFailed, nil)
+	expect(t, api.doIfMatch("PUT", path, token, "*", "application/json", body), http.StatusOK, nil)
+
+	var problem Problem
+	expect(t, api.doIfMatch("PATCH", path, token, "", mergePatchJSON, `{"ping_count": 5, "id": 9}`), http.StatusBadRequest, &problem)
+	want := []FieldError{{"id", "is read-only"}, {"ping_count", "is read-only"}}
+	if !reflect.DeepEqual(problem.Errors, want) {
+		t.Errorf("errors = %+v, want %+v", problem.Errors, want)
+	}
+	expect(t, api.doIfMatch("PATCH", path, token, "", mergePatchJSON, `{"interval": 0}`), http.StatusBadRequest, nil)
+	expect(t, api.doIfMatch("PATCH", path, token, "", mergePatchJSON, `["name"]`), http.StatusBadRequest, nil)
+	expect(t, api.doIfMatch("PATCH", path, token, "", "text/plain", `{"name": "x"}`), http.StatusUnsupportedMediaType, nil)
+
+	var got Task
+	expect(t, api.do("GET", path, token, nil), http.StatusOK, &struct {
+		Task *Task `json:"task"`
+	}{&got})
+	if got.Name != "backup" || got.Interval != 120 {
+		t.Errorf("task after rejected patches = %+v", got)
+	}
+}
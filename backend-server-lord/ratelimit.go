@@ -0,0 +1,361 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// What a rate limit counts requests by
+const (
+	rateKeyIP   = "ip"   // the client IP, see clientIP
+	rateKeyUser = "user" // the authenticated user
+	rateKeyPing = "ping" // the task number or ID of a heartbeat, apart per route
+)
+
+// RateLimit is a token bucket applied to a group of routes. Every key gets a
+// bucket of Requests tokens that refills evenly over Per; each request takes
+// a token and is refused with 429 while the bucket is empty.
+type RateLimit struct {
+	Name     string
+	Key      string
+	Requests int // 0 disables the limit
+	Per      time.Duration
+	EnvVar   string
+}
+
+// defaultRateLimits lists the limits with their defaults
+var defaultRateLimits = []RateLimit{
+	{Name: "auth", Key: rateKeyIP, Requests: 20, Per: time.Minute, EnvVar: "RATE_LIMIT_AUTH"},
+	{Name: "signup", Key: rateKeyIP, Requests: 10, Per: time.Hour, EnvVar: "RATE_LIMIT_SIGNUP"},
+	{Name: "heartbeat", Key: rateKeyPing, Requests: 30, Per: time.Minute, EnvVar: "RATE_LIMIT_HEARTBEAT"},
+	{Name: "heartbeat_ip", Key: rateKeyIP, Requests: 1200, Per: time.Minute, EnvVar: "RATE_LIMIT_HEARTBEAT_IP"},
+	{Name: "api", Key: rateKeyUser, Requests: 600, Per: time.Minute, EnvVar: "RATE_LIMIT_API"},
+	{Name: "status_page", Key: rateKeyIP, Requests: 120, Per: time.Minute, EnvVar: "RATE_LIMIT_STATUS_PAGE"},
+}
+
+// rateLimitPolicy returns the limits as configured through their environment
+// variables, given as requests per duration (e.g. RATE_LIMIT_AUTH=5/1m,
+// RATE_LIMIT_SIGNUP=100/24h) or "off"
+func rateLimitPolicy() map[string]RateLimit {
+	limits := map[string]RateLimit{}
+	for _, limit := range defaultRateLimits {
+		if v := os.Getenv(limit.EnvVar); v != "" {
+			requests, per, err := parseRate(v)
+			if err != nil {
+				log.Printf("Invalid %s %q, using default", limit.EnvVar, v)
+			} else {
+				limit.Requests, limit.Per = requests, per
+			}
+		}
+		limits[limit.Name] = limit
+	}
+	return limits
+}
+
+// parseRate parses "10/1m" into 10 requests per minute; "off" and "0" are no limit
+func parseRate(v string) (int, time.Duration, error) {
+	if v == "off" || v == "0" {
+		return 0, 0, nil
+	}
+	count, period, ok := strings.Cut(v, "/")
+	requests, err := strconv.Atoi(count)
+	if !ok || err != nil || requests < 0 {
+		return 0, 0, fmt.Errorf("invalid rate %q", v)
+	}
+	per, err := parseDays(period)
+	if err != nil || per <= 0 {
+		return 0, 0, fmt.Errorf("invalid rate %q", v)
+	}
+	return requests, per, nil
+}
+
+// How often idle buckets are dropped
+const rateLimitSweepInterval = time.Minute
+
+// rateLimiter keeps the buckets of every limit and counts refused requests
+type rateLimiter struct {
+	limits map[string]RateLimit
+
+	mu        sync.Mutex
+	buckets   map[string]*tokenBucket // by limit name and key
+	lastSweep time.Time
+	throttled map[string]int64 // refused requests by limit or lockout
+}
+
+type tokenBucket struct {
+	limit   RateLimit
+	tokens  float64
+	updated time.Time
+}
+
+func newRateLimiter(limits map[string]RateLimit) *rateLimiter {
+	return &rateLimiter{
+		limits:    limits,
+		buckets:   map[string]*tokenBucket{},
+		lastSweep: time.Now(),
+		throttled: map[string]int64{},
+	}
+}
+
+// refill adds the tokens earned since the last update
+func (b *tokenBucket) refill(now time.Time) {
+	rate := float64(b.limit.Requests) / b.limit.Per.Seconds()
+	b.tokens = math.Min(float64(b.limit.Requests), b.tokens+now.Sub(b.updated).Seconds()*rate)
+	b.updated = now
+}
+
+// take takes a token from the bucket of key under the named limit. When the
+// bucket is empty it returns false and how long until the next token.
+func (l *rateLimiter) take(name, key string, now time.Time) (time.Duration, bool) {
+	limit := l.limits[name]
+	if limit.Requests <= 0 {
+		return 0, true
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if now.Sub(l.lastSweep) > rateLimitSweepInterval {
+		l.sweep(now)
+	}
+	id := name + ":" + key
+	b, ok := l.buckets[id]
+	if !ok {
+		b = &tokenBucket{limit: limit, tokens: float64(limit.Requests), updated: now}
+		l.buckets[id] = b
+	}
+	b.refill(now)
+	if b.tokens >= 1 {
+		b.tokens--
+		return 0, true
+	}
+
+	l.throttled[name]++
+	wait := time.Duration((1 - b.tokens) / float64(limit.Requests) * float64(limit.Per))
+	return wait, false
+}
+
+// sweep drops the buckets that have refilled completely, as they are the same
+// as no bucket. The caller holds mu.
+func (l *rateLimiter) sweep(now time.Time) {
+	for id, b := range l.buckets {
+		b.refill(now)
+		if b.tokens >= float64(b.limit.Requests) {
+			delete(l.buckets, id)
+		}
+	}
+	l.lastSweep = now
+}
+
+// count records a request refused for another reason, like a lockout
+func (l *rateLimiter) count(name string) {
+	l.mu.Lock()
+	l.throttled[name]++
+	l.mu.Unlock()
+}
+
+// rateLimited wraps a handler with the named limit
+func (s *Server) rateLimited(name string, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if s.allowRequest(w, r, name) {
+			next(w, r)
+		}
+	}
+}
+
+// allowRequest takes a token from the bucket of r under the named limit. It
+// writes the 429 response itself and returns false when the bucket is empty.
+func (s *Server) allowRequest(w http.ResponseWriter, r *http.Request, name string) bool {
+	var key string
+	switch s.limiter.limits[name].Key {
+	case rateKeyIP:
+		key = clientIP(r)
+	case rateKeyUser:
+		key = strconv.FormatInt(authUserID(r), 10)
+	case rateKeyPing:
+		// Task numbers and task IDs overlap, so each gets its own buckets
+		vars := mux.Vars(r)
+		if vars["taskId"] != "" {
+			key = "number:" + vars["taskId"]
+		} else {
+			key = "id:" + vars["id"]
+		}
+	}
+
+	wait, ok := s.limiter.take(name, key, time.Now())
+	if !ok {
+		log.Printf("Rate limit %s exceeded by %s %s", name, s.limiter.limits[name].Key, key)
+		tooManyRequests(w, wait, "Too many requests, try again later")
+	}
+	return ok
+}
+
+// tooManyRequests responds with 429 and a Retry-After header of at least a second
+func tooManyRequests(w http.ResponseWriter, wait time.Duration, message string) {
+	seconds := int(math.Ceil(wait.Seconds()))
+	if seconds < 1 {
+		seconds = 1
+	}
+	w.Header().Set("Retry-After", strconv.Itoa(seconds))
+	respondWithError(w, http.StatusTooManyRequests, message)
+}
+
+// Failed logins and second factors lock the account out progressively:
+// after lockoutFreeFailures failures every further failure locks it for
+// twice as long as the one before, starting at lockoutBase and up to
+// lockoutMax. Failures are forgotten after lockoutForget without one.
+// Logins from every client IP together get accountLockoutFreeFailures.
+const (
+	lockoutFreeFailures        = 5
+	accountLockoutFreeFailures = 20
+	lockoutBase                = time.Minute
+	lockoutMax                 = 15 * time.Minute
+	lockoutForget              = time.Hour
+)
+
+// lockout tracks failed attempts per key, like the email address and client
+// IP of a login. Every attempt is counted as a failure up front, so parallel
+// guesses cannot all get in before the first one failed; a success resets it.
+type lockout struct {
+	name         string // counted under this name when it refuses a request
+	freeFailures int    // failures before the first lockout
+	limiter      *rateLimiter
+
+	mu        sync.Mutex
+	entries   map[string]*lockoutEntry
+	lastSweep time.Time
+}
+
+type lockoutEntry struct {
+	failures    int
+	lastFailure time.Time
+	lockedUntil time.Time
+}
+
+func newLockout(name string, freeFailures int, limiter *rateLimiter) *lockout {
+	return &lockout{name: name, freeFailures: freeFailures, limiter: limiter, entries: map[string]*lockoutEntry{}, lastSweep: time.Now()}
+}
+
+// attempt returns how long key is still locked out, counting the refused
+// attempt. Otherwise it records the attempt as a failure, which a later
+// reset or release takes back.
+func (l *lockout) attempt(key string, now time.Time) time.Duration {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	// Drop forgotten entries now and then, so the map does not grow forever
+	if now.Sub(l.lastSweep) > rateLimitSweepInterval {
+		for k, old := range l.entries {
+			if now.Sub(old.lastFailure) > lockoutForget {
+				delete(l.entries, k)
+			}
+		}
+		l.lastSweep = now
+	}
+
+	e, ok := l.entries[key]
+	if !ok || now.Sub(e.lastFailure) > lockoutForget {
+		e = &lockoutEntry{}
+		l.entries[key] = e
+	}
+	if wait := e.lockedUntil.Sub(now); wait > 0 {
+		l.limiter.count(l.name + "_lockout")
+		return wait
+	}
+
+	e.failures++
+	e.lastFailure = now
+	e.lockedUntil = l.lockedUntil(e)
+	if e.lockedUntil.After(now) {
+		log.Printf("Locked out %s %s for %s after %d failures", l.name, key, e.lockedUntil.Sub(now), e.failures)
+	}
+	return 0
+}
+
+// lockedUntil returns until when the failures of e lock its key out
+func (l *lockout) lockedUntil(e *lockoutEntry) time.Time {
+	over := e.failures - l.freeFailures
+	if over <= 0 {
+		return time.Time{}
+	}
+	wait := lockoutMax
+	if over <= 10 {
+		wait = min(lockoutBase<<(over-1), lockoutMax)
+	}
+	return e.lastFailure.Add(wait)
+}
+
+// release takes back an attempt of key that was not a wrong guess, like one
+// that failed on the database or was refused by another lockout
+func (l *lockout) release(key string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	e, ok := l.entries[key]
+	if !ok {
+		return
+	}
+	if e.failures--; e.failures <= 0 {
+		delete(l.entries, key)
+		return
+	}
+	e.lockedUntil = l.lockedUntil(e)
+}
+
+// reset forgets the failures of key after a successful attempt
+func (l *lockout) reset(key string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	delete(l.entries, key)
+}
+
+// debugRateLimitHandler exposes the configured limits and how many requests
+// each limit and lockout refused since the server started
+func (s *Server) debugRateLimitHandler(w http.ResponseWriter, r *http.Request) {
+	type limitStatus struct {
+		Name      string `json:"name"`
+		Key       string `json:"key"`
+		Requests  int    `json:"requests"`
+		Per       string `json:"per"`
+		Throttled int64  `json:"throttled"`
+	}
+
+	s.limiter.mu.Lock()
+	limits := make([]limitStatus, 0, len(s.limiter.limits))
+	for _, limit := range s.limiter.limits {
+		limits = append(limits, limitStatus{
+			Name:      limit.Name,
+			Key:       limit.Key,
+			Requests:  limit.Requests,
+			Per:       limit.Per.String(),
+			Throttled: s.limiter.throttled[limit.Name],
+		})
+	}
+	response := struct {
+		Limits              []limitStatus `json:"limits"`
+		LoginLockout        int64         `json:"login_lockout_throttled"`
+		LoginAccountLockout int64         `json:"login_account_lockout_throttled"`
+		MFALockout          int64         `json:"mfa_lockout_throttled"`
+		TrackedBuckets      int           `json:"tracked_buckets"`
+	}{
+		Limits:              limits,
+		LoginLockout:        s.limiter.throttled["login_lockout"],
+		LoginAccountLockout: s.limiter.throttled["login_account_lockout"],
+		MFALockout:          s.limiter.throttled["mfa_lockout"],
+		TrackedBuckets:      len(s.limiter.buckets),
+	}
+	s.limiter.mu.Unlock()
+	sort.Slice(response.Limits, func(i, j int) bool { return response.Limits[i].Name < response.Limits[j].Name })
+
+	respondWithJSON(w, http.StatusOK, response)
+}
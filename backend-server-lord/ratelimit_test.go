@@ -0,0 +1,170 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+// login tries to log in from the client IP
+func (api *testAPI) login(ip, email, password string) *httptest.ResponseRecorder {
+	api.t.Helper()
+	body, _ := json.Marshal(map[string]string{"email": email, "password": password})
+	req := httptest.NewRequest("POST", "/api/login", bytes.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	req.RemoteAddr = ip + ":40000"
+	rec := httptest.NewRecorder()
+	api.handler.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestLoginLockoutIsPerClientIP(t *testing.T) {
+	api := newTestAPI(t)
+	api.signup("alice")
+
+	for i := 0; i < lockoutFreeFailures+1; i++ {
+		expect(t, api.login("198.51.100.7", "alice@example.com", "wrong password"), http.StatusUnauthorized, nil)
+	}
+	rec := api.login("198.51.100.7", "alice@example.com", "correct horse")
+	expect(t, rec, http.StatusTooManyRequests, nil)
+	if rec.Header().Get("Retry-After") == "" {
+		t.Error("lockout without Retry-After")
+	}
+
+	// The guesses from one address do not lock alice out elsewhere
+	expect(t, api.login("203.0.113.9", "alice@example.com", "correct horse"), http.StatusOK, nil)
+}
+
+func TestLoginLockoutPerAccount(t *testing.T) {
+	api := newTestAPI(t)
+	api.signup("alice")
+
+	// One guess per address stays under the per-IP lockout, but not under
+	// the one of the account
+	for i := 0; i < accountLockoutFreeFailures+1; i++ {
+		expect(t, api.login(fmt.Sprintf("203.0.113.%d", i), " Alice@Example.com", "wrong password"), http.StatusUnauthorized, nil)
+	}
+	expect(t, api.login("198.51.100.7", "alice@example.com", "correct horse"), http.StatusTooManyRequests, nil)
+}
+
+func TestLoginLockoutHoldsUnderParallelGuesses(t *testing.T) {
+	api := newTestAPI(t)
+	api.signup("alice")
+
+	// Every guess is counted before the password is compared, so a burst
+	// gets no more guesses through than one after the other
+	const guesses = 30
+	codes := make(chan int, guesses)
+	var wg sync.WaitGroup
+	for i := 0; i < guesses; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			codes <- api.login("198.51.100.7", "alice@example.com", "wrong password").Code
+		}()
+	}
+	wg.Wait()
+	close(codes)
+
+	unauthorized := 0
+	for code := range codes {
+		if code == http.StatusUnauthorized {
+			unauthorized++
+		}
+	}
+	if unauthorized != lockoutFreeFailures+1 {
+		t.Errorf("%d guesses checked, want %d", unauthorized, lockoutFreeFailures+1)
+	}
+}
+
+func TestLockoutDropsForgottenEntries(t *testing.T) {
+	l := newLockout("login", lockoutFreeFailures, newRateLimiter(rateLimitPolicy()))
+	start := time.Now()
+	l.attempt("alice@example.com from 198.51.100.7", start)
+	l.attempt("bob@example.com from 198.51.100.7", start.Add(lockoutForget))
+
+	l.attempt("carol@example.com from 198.51.100.7", start.Add(lockoutForget+rateLimitSweepInterval+time.Second))
+	if _, ok := l.entries["alice@example.com from 198.51.100.7"]; ok || len(l.entries) != 2 {
+		t.Errorf("entries %v after the sweep, want bob and carol", l.entries)
+	}
+}
+
+func TestDebugRateLimitCountsAccountLockout(t *testing.T) {
+	t.Setenv("DEBUG_TOKEN", "debug-secret")
+	api := newTestAPI(t)
+	api.signup("alice")
+
+	for i := 0; i < accountLockoutFreeFailures+1; i++ {
+		expect(t, api.login(fmt.Sprintf("203.0.113.%d", i), "alice@example.com", "wrong password"), http.StatusUnauthorized, nil)
+	}
+	for i := 0; i < 2; i++ {
+		expect(t, api.login(fmt.Sprintf("198.51.100.%d", i), "alice@example.com", "correct horse"), http.StatusTooManyRequests, nil)
+	}
+
+	var status struct {
+		LoginLockout        int64 `json:"login_lockout_throttled"`
+		LoginAccountLockout int64 `json:"login_account_lockout_throttled"`
+	}
+	expect(t, api.do("GET", "/debug/ratelimit", "debug-secret", nil), http.StatusOK, &status)
+	if status.LoginAccountLockout != 2 || status.LoginLockout != 0 {
+		t.Errorf("account lockout refused %d and login lockout %d, want 2 and 0", status.LoginAccountLockout, status.LoginLockout)
+	}
+}
+
+func TestLoginLockoutIgnoresSpoofedForwardedFor(t *testing.T) {
+	t.Setenv("TRUST_PROXY", "true")
+	api := newTestAPI(t)
+	api.signup("alice")
+
+	// The proxy appends the real address to whatever the client sent
+	login := func(spoofed, password string) *httptest.ResponseRecorder {
+		body, _ := json.Marshal(map[string]string{"email": "alice@example.com", "password": password})
+		req := httptest.NewRequest("POST", "/api/login", bytes.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("X-Forwarded-For", spoofed+", 198.51.100.7")
+		req.RemoteAddr = "10.0.0.2:40000"
+		rec := httptest.NewRecorder()
+		api.handler.ServeHTTP(rec, req)
+		return rec
+	}
+	for i := 0; i < lockoutFreeFailures+1; i++ {
+		expect(t, login(fmt.Sprintf("203.0.113.%d", i), "wrong password"), http.StatusUnauthorized, nil)
+	}
+	expect(t, login("203.0.113.99", "correct horse"), http.StatusTooManyRequests, nil)
+}
+
+func TestHeartbeatLimitKeysPerRoute(t *testing.T) {
+	limits := rateLimitPolicy()
+	limits["heartbeat"] = RateLimit{Name: "heartbeat", Key: rateKeyPing, Requests: 1, Per: time.Hour}
+	api := newTestAPI(t)
+	api.server.limiter = newRateLimiter(limits)
+
+	userID, token := api.signup("alice")
+	task := api.createTask(token, userID, "backup", 1, 60)
+	if task.ID != 1 {
+		t.Fatalf("task ID %d, want one that equals its task number", task.ID)
+	}
+
+	// Task number 1 and task ID 1 have their own buckets
+	expect(t, api.do("POST", "/tasks/1/heartbeat", "", nil), http.StatusOK, nil)
+	expect(t, api.do("POST", "/api/tasks/1/heartbeat", token, nil), http.StatusOK, nil)
+	expect(t, api.do("POST", "/tasks/1/heartbeat", "", nil), http.StatusTooManyRequests, nil)
+	expect(t, api.do("POST", "/api/tasks/1/heartbeat", token, nil), http.StatusTooManyRequests, nil)
+}
+
+func TestStatusPageIsRateLimited(t *testing.T) {
+	limits := rateLimitPolicy()
+	limits["status_page"] = RateLimit{Name: "status_page", Key: rateKeyIP, Requests: 2, Per: time.Hour}
+	api := newTestAPI(t)
+	api.server.limiter = newRateLimiter(limits)
+
+	for i := 0; i < 2; i++ {
+		expect(t, api.do("GET", "/status/missing", "", nil), http.StatusNotFound, nil)
+	}
+	expect(t, api.do("GET", "/status/missing", "", nil), http.StatusTooManyRequests, nil)
+}
@@ -0,0 +1,698 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	htmltemplate "html/template"
+	"log"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	texttemplate "text/template"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// ReportSchedule emails a user's report in Format after every completed Period
+type ReportSchedule struct {
+	ID            int64      `json:"id"`
+	UserID        int64      `json:"user_id"`
+	Period        string     `json:"period"`
+	Format        string     `json:"format"`
+	Email         string     `json:"email"`
+	LastPeriodEnd *time.Time `json:"last_period_end"`
+	CreatedAt     time.Time  `json:"created_at"`
+}
+
+// createReportScheduleRequest is the body of POST /api/reports/schedules
+type createReportScheduleRequest struct {
+	UserID int64  `json:"user_id" validate:"min=1"`
+	Period string `json:"period" validate:"required,oneof=daily weekly monthly"`
+	Format string `json:"format" validate:"oneof=html markdown"` // defaults to html
+	Email  string `json:"email" validate:"required,email"`
+}
+
+// How often the report schedules are checked for a completed period
+const reportScheduleInterval = 5 * time.Minute
+
+// Number of tasks listed in the report's rankings
+const reportTopN = 5
+
+// reportPeriod returns the period of the given kind that contains at: a UTC
+// day, a week starting on Monday or a calendar month
+func reportPeriod(period string, at time.Time) (time.Time, time.Time, error) {
+	at = at.UTC()
+	day := time.Date(at.Year(), at.Month(), at.Day(), 0, 0, 0, 0, time.UTC)
+
+	switch period {
+	case "daily":
+		return day, day.AddDate(0, 0, 1), nil
+	case "weekly":
+		from := day.AddDate(0, 0, -((int(day.Weekday()) + 6) % 7))
+		return from, from.AddDate(0, 0, 7), nil
+	case "monthly":
+		from := time.Date(at.Year(), at.Month(), 1, 0, 0, 0, 0, time.UTC)
+		return from, from.AddDate(0, 1, 0), nil
+	default:
+		return time.Time{}, time.Time{}, fmt.Errorf("period must be daily, weekly or monthly")
+	}
+}
+
+// lastCompletedPeriod returns the latest period of the given kind that ended by now
+func lastCompletedPeriod(period string, now time.Time) (time.Time, time.Time, error) {
+	current, _, err := reportPeriod(period, now)
+	if err != nil {
+		return current, current, err
+	}
+	return reportPeriod(period, current.Add(-time.Nanosecond))
+}
+
+// ReportTask is the availability of one task over the report period
+type ReportTask struct {
+	ID              int64    `json:"id"`
+	Name            string   `json:"name"`
+	Status          string   `json:"status"` // current status
+	Interval        int      `json:"interval"`
+	Uptime          *float64 `json:"uptime"` // percent of the tracked time, null if none was tracked
+	UptimeSeconds   float64  `json:"uptime_seconds"`
+	DowntimeSeconds float64  `json:"downtime_seconds"`
+	Incidents       int      `json:"incidents"`
+	// Longest time the task took to come back within the period
+	LongestIncidentSeconds float64 `json:"longest_incident_seconds"`
+	Runs                   int64   `json:"runs"`        // heartbeats received
+	MissedRuns             int64   `json:"missed_runs"` // scheduled runs that fell in an incident
+}
+
+// ReportIncident is a period during which a task was dead
+type ReportIncident struct {
+	TaskID          int64      `json:"task_id"`
+	TaskName        string     `json:"task_name"`
+	Start           time.Time  `json:"start"`
+	End             *time.Time `json:"end"` // null while ongoing
+	DurationSeconds float64    `json:"duration_seconds"`
+}
+
+// Report summarises the availability of a user's tasks over a period
+type Report struct {
+	UserID      int64     `json:"user_id"`
+	Period      string    `json:"period"`
+	From        time.Time `json:"from"`
+	To          time.Time `json:"to"`
+	GeneratedAt time.Time `json:"generated_at"`
+
+	TaskCount       int      `json:"task_count"`
+	Uptime          *float64 `json:"uptime"`
+	UptimeSeconds   float64  `json:"uptime_seconds"`
+	DowntimeSeconds float64  `json:"downtime_seconds"`
+	Runs            int64    `json:"runs"`
+	MissedRuns      int64    `json:"missed_runs"`
+
+	Tasks            []ReportTask     `json:"tasks"` // lowest uptime first
+	Incidents        []ReportIncident `json:"incidents"`
+	SlowestToRecover []ReportTask     `json:"slowest_to_recover"`
+	MostMissedRuns   []ReportTask     `json:"most_missed_runs"`
+}
+
+// buildReport gathers the report of the user's tasks over [from, to) from the
+// transition log (uptime, incidents, missed runs) and the graph data (runs)
+func (s *Server) buildReport(ctx context.Context, userID int64, period string, from, to time.Time) (Report, error) {
+	now, err := s.monitor.Now(ctx)
+	if err != nil {
+		return Report{}, err
+	}
+	end := to
+	if now.Before(end) {
+		end = now
+	}
+
+	report := Report{
+		UserID:           userID,
+		Period:           period,
+		From:             from,
+		To:               to,
+		GeneratedAt:      now,
+		Tasks:            []ReportTask{},
+		Incidents:        []ReportIncident{},
+		SlowestToRecover: []ReportTask{},
+		MostMissedRuns:   []ReportTask{},
+	}
+
+	tasks, err := s.tasks.ListUserTasks(ctx, userID, TaskFilter{})
+	if err != nil {
+		return report, err
+	}
+	report.TaskCount = len(tasks)
+	if len(tasks) == 0 {
+		return report, nil
+	}
+
+	runs, err := s.reportRuns(ctx, userID, from, to, now)
+	if err != nil {
+		return report, err
+	}
+
+	for _, task := range tasks {
+		transitions, err := s.transitions.ListTransitions(ctx, task.ID)
+		if err != nil {
+			return report, err
+		}
+
+		rt := ReportTask{
+			ID:       task.ID,
+			Name:     task.Name,
+			Status:   task.Status,
+			Interval: task.Interval,
+			Runs:     runs[task.ID],
+		}
+		rt.UptimeSeconds, rt.DowntimeSeconds = timeInStates(transitions, from, end)
+		if tracked := rt.UptimeSeconds + rt.DowntimeSeconds; tracked > 0 {
+			uptime := rt.UptimeSeconds / tracked * 100
+			rt.Uptime = &uptime
+		}
+
+		for i, t := range transitions {
+			if t.ToStatus != "dead" || !t.TransitionedAt.Before(end) {
+				continue
+			}
+			incident := ReportIncident{TaskID: task.ID, TaskName: task.Name, Start: t.TransitionedAt}
+			incidentEnd := end
+			if i+1 < len(transitions) {
+				incident.End = &transitions[i+1].TransitionedAt
+				incidentEnd = transitions[i+1].TransitionedAt
+			}
+			if !incidentEnd.After(from) {
+				continue
+			}
+			incident.DurationSeconds = incidentEnd.Sub(incident.Start).Seconds()
+			report.Incidents = append(report.Incidents, incident)
+
+			rt.Incidents++
+			rt.LongestIncidentSeconds = math.Max(rt.LongestIncidentSeconds, incident.DurationSeconds)
+
+			// Every interval that started while the task was dead is a missed run
+			inPeriod := incidentEnd.Sub(maxTime(incident.Start, from)).Seconds()
+			if task.Interval > 0 && inPeriod > 0 {
+				rt.MissedRuns += int64(math.Ceil(inPeriod / float64(task.Interval)))
+			}
+		}
+
+		report.UptimeSeconds += rt.UptimeSeconds
+		report.DowntimeSeconds += rt.DowntimeSeconds
+		report.Runs += rt.Runs
+		report.MissedRuns += rt.MissedRuns
+		report.Tasks = append(report.Tasks, rt)
+	}
+
+	if tracked := report.UptimeSeconds + report.DowntimeSeconds; tracked > 0 {
+		uptime := report.UptimeSeconds / tracked * 100
+		report.Uptime = &uptime
+	}
+
+	sort.SliceStable(report.Tasks, func(i, j int) bool {
+		return uptimeOrFull(report.Tasks[i].Uptime) < uptimeOrFull(report.Tasks[j].Uptime)
+	})
+	sort.SliceStable(report.Incidents, func(i, j int) bool {
+		return report.Incidents[i].Start.Before(report.Incidents[j].Start)
+	})
+	report.SlowestToRecover = topReportTasks(report.Tasks, func(t ReportTask) float64 { return t.LongestIncidentSeconds })
+	report.MostMissedRuns = topReportTasks(report.Tasks, func(t ReportTask) float64 { return float64(t.MissedRuns) })
+	return report, nil
+}
+
+// reportRuns counts the heartbeats of every task of the user in [from, to)
+// from the graph data, in daily buckets
+func (s *Server) reportRuns(ctx context.Context, userID int64, from, to, now time.Time) (map[int64]int64, error) {
+	step := 24 * time.Hour
+	buckets, err := s.graphs.GraphBuckets(ctx, GraphQuery{
+		UserID: userID,
+		From:   from.Add(-step), // the baseline for the first day
+		To:     to,
+		Step:   step,
+		Tier:   graphTierFor(s.graphTiers, now.Sub(from), to.Sub(from)),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	runs := map[int64]int64{}
+	var prev *TaskGraphBucket
+	for i := range buckets {
+		b := &buckets[i]
+		if prev != nil && prev.TaskID != b.TaskID {
+			prev = nil
+		}
+		_, _, pings := bucketDeltas(prev, b)
+		prev = b
+		if !b.BucketStart.Before(from) {
+			runs[b.TaskID] += pings
+		}
+	}
+	return runs, nil
+}
+
+func maxTime(a, b time.Time) time.Time {
+	if a.After(b) {
+		return a
+	}
+	return b
+}
+
+// uptimeOrFull sorts tasks without tracked time after every other task
+func uptimeOrFull(uptime *float64) float64 {
+	if uptime == nil {
+		return math.Inf(1)
+	}
+	return *uptime
+}
+
+// topReportTasks returns up to reportTopN tasks with the highest non-zero key
+func topReportTasks(tasks []ReportTask, key func(ReportTask) float64) []ReportTask {
+	top := []ReportTask{}
+	for _, t := range tasks {
+		if key(t) > 0 {
+			top = append(top, t)
+		}
+	}
+	sort.SliceStable(top, func(i, j int) bool { return key(top[i]) > key(top[j]) })
+	if len(top) > reportTopN {
+		top = top[:reportTopN]
+	}
+	return top
+}
+
+// humanDuration formats seconds as e.g. "2h 5m" or "42s"
+func humanDuration(seconds float64) string {
+	d := time.Duration(seconds * float64(time.Second)).Round(time.Second)
+	switch {
+	case d >= 24*time.Hour:
+		return fmt.Sprintf("%dd %dh", d/(24*time.Hour), (d%(24*time.Hour))/time.Hour)
+	case d >= time.Hour:
+		return fmt.Sprintf("%dh %dm", d/time.Hour, (d%time.Hour)/time.Minute)
+	case d >= time.Minute:
+		return fmt.Sprintf("%dm %ds", d/time.Minute, (d%time.Minute)/time.Second)
+	default:
+		return fmt.Sprintf("%ds", d/time.Second)
+	}
+}
+
+// formatUptime formats an uptime percentage, or "n/a" when nothing was tracked
+func formatUptime(uptime *float64) string {
+	if uptime == nil {
+		return "n/a"
+	}
+	return fmt.Sprintf("%.3f%%", *uptime)
+}
+
+var reportFuncs = map[string]interface{}{
+	"duration": humanDuration,
+	"uptime":   formatUptime,
+	"date":     func(t time.Time) string { return t.Format("2006-01-02") },
+	"datetime": func(t time.Time) string { return t.Format("2006-01-02 15:04 MST") },
+}
+
+var reportHTMLTemplate = htmltemplate.Must(htmltemplate.New("report").Funcs(reportFuncs).Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>Availability report {{date .From}} – {{date .To}}</title>
+<style>
+body { font-family: -apple-system, Helvetica, Arial, sans-serif; color: #222; max-width: 860px; margin: 24px auto; }
+table { border-collapse: collapse; width: 100%; margin-bottom: 24px; }
+th, td { border-bottom: 1px solid #ddd; padding: 6px 8px; text-align: left; }
+th { background: #f5f5f5; }
+.dead { color: #c0392b; font-weight: bold; }
+.alive { color: #27ae60; }
+</style>
+</head>
+<body>
+<h1>Availability report</h1>
+<p>{{.Period}} report for {{date .From}} to {{date .To}} (exclusive), generated {{datetime .GeneratedAt}}.</p>
+
+<h2>Summary</h2>
+<table>
+<tr><th>Tasks</th><td>{{.TaskCount}}</td></tr>
+<tr><th>Uptime</th><td>{{uptime .Uptime}}</td></tr>
+<tr><th>Downtime</th><td>{{duration .DowntimeSeconds}}</td></tr>
+<tr><th>Incidents</th><td>{{len .Incidents}}</td></tr>
+<tr><th>Runs received</th><td>{{.Runs}}</td></tr>
+<tr><th>Missed runs</th><td>{{.MissedRuns}}</td></tr>
+</table>
+
+<h2>Tasks</h2>
+{{if .Tasks}}<table>
+<tr><th>Task</th><th>Status</th><th>Uptime</th><th>Downtime</th><th>Incidents</th><th>Runs</th><th>Missed runs</th></tr>
+{{range .Tasks}}<tr><td>{{.Name}}</td><td class="{{.Status}}">{{.Status}}</td><td>{{uptime .Uptime}}</td><td>{{duration .DowntimeSeconds}}</td><td>{{.Incidents}}</td><td>{{.Runs}}</td><td>{{.MissedRuns}}</td></tr>
+{{end}}</table>{{else}}<p>No tasks.</p>{{end}}
+
+<h2>Incidents</h2>
+{{if .Incidents}}<table>
+<tr><th>Task</th><th>Started</th><th>Recovered</th><th>Duration</th></tr>
+{{range .Incidents}}<tr><td>{{.TaskName}}</td><td>{{datetime .Start}}</td><td>{{if .End}}{{datetime .End}}{{else}}<span class="dead">ongoing</span>{{end}}</td><td>{{duration .DurationSeconds}}</td></tr>
+{{end}}</table>{{else}}<p>No incidents.</p>{{end}}
+
+<h2>Slowest to recover</h2>
+{{if .SlowestToRecover}}<table>
+<tr><th>Task</th><th>Longest incident</th><th>Incidents</th></tr>
+{{range .SlowestToRecover}}<tr><td>{{.Name}}</td><td>{{duration .LongestIncidentSeconds}}</td><td>{{.Incidents}}</td></tr>
+{{end}}</table>{{else}}<p>Every task stayed up.</p>{{end}}
+
+<h2>Most missed runs</h2>
+{{if .MostMissedRuns}}<table>
+<tr><th>Task</th><th>Missed runs</th><th>Interval</th></tr>
+{{range .MostMissedRuns}}<tr><td>{{.Name}}</td><td>{{.MissedRuns}}</td><td>{{.Interval}}s</td></tr>
+{{end}}</table>{{else}}<p>No missed runs.</p>{{end}}
+</body>
+</html>
+`))
+
+var reportMarkdownTemplate = texttemplate.Must(texttemplate.New("report").Funcs(reportFuncs).Parse(`# Availability report
+
+{{.Period}} report for {{date .From}} to {{date .To}} (exclusive), generated {{datetime .GeneratedAt}}.
+
+## Summary
+
+| | |
+|---|---|
+| Tasks | {{.TaskCount}} |
+| Uptime | {{uptime .Uptime}} |
+| Downtime | {{duration .DowntimeSeconds}} |
+| Incidents | {{len .Incidents}} |
+| Runs received | {{.Runs}} |
+| Missed runs | {{.MissedRuns}} |
+
+## Tasks
+{{if .Tasks}}
+| Task | Status | Uptime | Downtime | Incidents | Runs | Missed runs |
+|---|---|---|---|---|---|---|
+{{range .Tasks}}| {{.Name}} | {{.Status}} | {{uptime .Uptime}} | {{duration .DowntimeSeconds}} | {{.Incidents}} | {{.Runs}} | {{.MissedRuns}} |
+{{end}}{{else}}
+No tasks.
+{{end}}
+## Incidents
+{{if .Incidents}}
+| Task | Started | Recovered | Duration |
+|---|---|---|---|
+{{range .Incidents}}| {{.TaskName}} | {{datetime .Start}} | {{if .End}}{{datetime .End}}{{else}}ongoing{{end}} | {{duration .DurationSeconds}} |
+{{end}}{{else}}
+No incidents.
+{{end}}
+## Slowest to recover
+{{if .SlowestToRecover}}
+| Task | Longest incident | Incidents |
+|---|---|---|
+{{range .SlowestToRecover}}| {{.Name}} | {{duration .LongestIncidentSeconds}} | {{.Incidents}} |
+{{end}}{{else}}
+Every task stayed up.
+{{end}}
+## Most missed runs
+{{if .MostMissedRuns}}
+| Task | Missed runs | Interval |
+|---|---|---|
+{{range .MostMissedRuns}}| {{.Name}} | {{.MissedRuns}} | {{.Interval}}s |
+{{end}}{{else}}
+No missed runs.
+{{end}}`))
+
+// renderReport renders the report as "html" or "markdown" and returns the
+// content type with the document
+func renderReport(report Report, format string) (string, []byte, error) {
+	var buf bytes.Buffer
+	switch format {
+	case "html":
+		err := reportHTMLTemplate.Execute(&buf, report)
+		return "text/html", buf.Bytes(), err
+	case "markdown":
+		err := reportMarkdownTemplate.Execute(&buf, report)
+		return "text/markdown", buf.Bytes(), err
+	default:
+		return "", nil, fmt.Errorf("unknown report format %q", format)
+	}
+}
+
+// getReport returns the report on the personal tasks of a user (?user_id=)
+// for a period (?period=, default weekly) as JSON, HTML or Markdown
+// (?format=). The period is the one containing ?date= (YYYY-MM-DD), or else
+// the last completed one.
+func (s *Server) getReport(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	userID, err := strconv.ParseInt(query.Get("user_id"), 10, 64)
+	if err != nil || userID <= 0 {
+		respondWithError(w, http.StatusBadRequest, "user_id is required")
+		return
+	}
+	if !s.authorize(w, r, userID, 0, roleViewer) {
+		return
+	}
+
+	period := query.Get("period")
+	if period == "" {
+		period = "weekly"
+	}
+	format := query.Get("format")
+	if format == "" {
+		format = "json"
+	}
+	if format != "json" && format != "html" && format != "markdown" {
+		respondWithError(w, http.StatusBadRequest, "format must be json, html or markdown")
+		return
+	}
+
+	var from, to time.Time
+	if date := query.Get("date"); date != "" {
+		at, err := time.Parse("2006-01-02", date)
+		if err != nil {
+			respondWithError(w, http.StatusBadRequest, "Invalid date")
+			return
+		}
+		from, to, err = reportPeriod(period, at)
+	} else {
+		var now time.Time
+		if now, err = s.monitor.Now(r.Context()); err != nil {
+			log.Printf("Error reading store time: %v", err)
+			respondWithError(w, http.StatusInternalServerError, "Error generating report")
+			return
+		}
+		from, to, err = lastCompletedPeriod(period, now)
+	}
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	report, err := s.buildReport(r.Context(), userID, period, from, to)
+	if err != nil {
+		log.Printf("Error generating report for user %d: %v", userID, err)
+		respondWithError(w, http.StatusInternalServerError, "Error generating report")
+		return
+	}
+
+	log.Printf("Generated %s report for user ID: %d (%s to %s)", period, userID, from.Format("2006-01-02"), to.Format("2006-01-02"))
+	if format == "json" {
+		respondWithJSON(w, http.StatusOK, report)
+		return
+	}
+
+	contentType, body, err := renderReport(report, format)
+	if err != nil {
+		log.Printf("Error rendering report: %v", err)
+		respondWithError(w, http.StatusInternalServerError, "Error generating report")
+		return
+	}
+	w.Header().Set("Content-Type", contentType+"; charset=utf-8")
+	w.WriteHeader(http.StatusOK)
+	w.Write(body)
+}
+
+// getReportSchedules lists the report schedules of a user (?user_id=)
+func (s *Server) getReportSchedules(w http.ResponseWriter, r *http.Request) {
+	userID, err := strconv.ParseInt(r.URL.Query().Get("user_id"), 10, 64)
+	if err != nil || userID <= 0 {
+		respondWithError(w, http.StatusBadRequest, "user_id is required")
+		return
+	}
+	if !s.authorize(w, r, userID, 0, roleViewer) {
+		return
+	}
+
+	schedules, err := s.reports.ListReportSchedules(r.Context(), userID)
+	if err != nil {
+		log.Printf("Error retrieving report schedules for user %d: %v", userID, err)
+		respondWithError(w, http.StatusInternalServerError, "Error retrieving report schedules")
+		return
+	}
+	respondWithJSON(w, http.StatusOK, schedules)
+}
+
+// createReportSchedule subscribes an email address to the authenticated
+// user's reports. The first report is sent when the current period completes.
+func (s *Server) createReportSchedule(w http.ResponseWriter, r *http.Request) {
+	var req createReportScheduleRequest
+	if !decodeJSON(w, r, &req) {
+		return
+	}
+	schedule := ReportSchedule{
+		UserID: req.UserID,
+		Period: req.Period,
+		Format: req.Format,
+		Email:  req.Email,
+	}
+
+	if schedule.Format == "" {
+		schedule.Format = "html"
+	}
+
+	if schedule.UserID == 0 {
+		schedule.UserID = authUserID(r)
+	}
+	if schedule.UserID != authUserID(r) {
+		respondWithError(w, http.StatusForbidden, "Insufficient permissions")
+		return
+	}
+
+	now, err := s.monitor.Now(r.Context())
+	if err != nil {
+		log.Printf("Error reading store time: %v", err)
+		respondWithError(w, http.StatusInternalServerError, "Error creating report schedule")
+		return
+	}
+	_, lastEnd, err := lastCompletedPeriod(schedule.Period, now)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	schedule.LastPeriodEnd = &lastEnd
+
+	created, err := s.reports.CreateReportSchedule(r.Context(), schedule)
+	if err != nil {
+		log.Printf("Error creating report schedule: %v", err)
+		respondWithError(w, http.StatusInternalServerError, "Error creating report schedule")
+		return
+	}
+
+	log.Printf("Report schedule created successfully with ID: %d", created.ID)
+	respondWithJSON(w, http.StatusCreated, created)
+}
+
+func (s *Server) deleteReportSchedule(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	id, err := strconv.ParseInt(vars["id"], 10, 64)
+	if err != nil {
+		log.Printf("Invalid report schedule ID: %s", vars["id"])
+		respondWithError(w, http.StatusBadRequest, "Invalid report schedule ID")
+		return
+	}
+
+	// Users only see their own schedules, so anyone else's are not found
+	schedules, err := s.reports.ListReportSchedules(r.Context(), authUserID(r))
+	if err != nil {
+		log.Printf("Error retrieving report schedules: %v", err)
+		respondWithError(w, http.StatusInternalServerError, "Error deleting report schedule")
+		return
+	}
+	owned := false
+	for _, schedule := range schedules {
+		owned = owned || schedule.ID == id
+	}
+	if !owned {
+		respondWithError(w, http.StatusNotFound, "Report schedule not found")
+		return
+	}
+
+	if err := s.reports.DeleteReportSchedule(r.Context(), id); err != nil {
+		if errors.Is(err, ErrNotFound) {
+			respondWithError(w, http.StatusNotFound, "Report schedule not found")
+		} else {
+			log.Printf("Error deleting report schedule: %v", err)
+			respondWithError(w, http.StatusInternalServerError, "Error deleting report schedule")
+		}
+		return
+	}
+
+	log.Printf("Report schedule deleted successfully: %d", id)
+	respondWithJSON(w, http.StatusOK, map[string]string{"message": "Report schedule deleted successfully"})
+}
+
+// startReportSchedules emails the report of every schedule whose period has
+// completed since its last delivery, checking on each tick until ctx is
+// cancelled. A failed delivery is retried on the next tick.
+func (s *Server) startReportSchedules(ctx context.Context) {
+	log.Println("Starting scheduled reports...")
+	ticker := time.NewTicker(reportScheduleInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			log.Println("Scheduled reports stopped")
+			return
+		case <-ticker.C:
+		}
+
+		if err := s.sendDueReports(ctx); err != nil {
+			log.Printf("Error sending scheduled reports: %v", err)
+		}
+	}
+}
+
+// sendDueReports sends the reports of the schedules with a newly completed period
+func (s *Server) sendDueReports(ctx context.Context) error {
+	now, err := s.monitor.Now(ctx)
+	if err != nil {
+		return err
+	}
+	schedules, err := s.reports.ListReportSchedules(ctx, 0)
+	if err != nil {
+		return err
+	}
+
+	for _, schedule := range schedules {
+		from, to, err := lastCompletedPeriod(schedule.Period, now)
+		if err != nil {
+			log.Printf("Skipping report schedule %d: %v", schedule.ID, err)
+			continue
+		}
+		if schedule.LastPeriodEnd != nil && !schedule.LastPeriodEnd.Before(to) {
+			continue
+		}
+
+		// Claim the period before sending, so that it is sent by only one
+		// server, and give it back if sending fails
+		claimed, err := s.reports.ClaimReportPeriod(ctx, schedule.ID, to)
+		if err != nil {
+			log.Printf("Error claiming report for schedule %d: %v", schedule.ID, err)
+			continue
+		}
+		if !claimed {
+			continue
+		}
+		if err := s.sendScheduledReport(ctx, schedule, from, to); err != nil {
+			log.Printf("Error sending report for schedule %d: %v", schedule.ID, err)
+			if err := s.reports.ReleaseReportPeriod(ctx, schedule.ID, to, schedule.LastPeriodEnd); err != nil {
+				log.Printf("Error releasing report for schedule %d: %v", schedule.ID, err)
+			}
+			continue
+		}
+		log.Printf("Sent %s report for user %d to %s", schedule.Period, schedule.UserID, schedule.Email)
+	}
+	return nil
+}
+
+// sendScheduledReport emails the report of a schedule for the period [from, to)
+func (s *Server) sendScheduledReport(ctx context.Context, schedule ReportSchedule, from, to time.Time) error {
+	report, err := s.buildReport(ctx, schedule.UserID, schedule.Period, from, to)
+	if err != nil {
+		return fmt.Errorf("generating report: %w", err)
+	}
+	contentType, body, err := renderReport(report, schedule.Format)
+	if err != nil {
+		return fmt.Errorf("rendering report: %w", err)
+	}
+
+	subject := fmt.Sprintf("[ServerLord] %s availability report %s: %s uptime, %d incidents",
+		schedule.Period, from.Format("2006-01-02"), formatUptime(report.Uptime), len(report.Incidents))
+	return sendEmail(schedule.Email, subject, contentType, string(body))
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestReportPeriod(t *testing.T) {
+	// A Wednesday afternoon
+	at := time.Date(2026, 1, 14, 15, 30, 0, 0, time.UTC)
+	for _, tc := range []struct {
+		period   string
+		from, to string
+	}{
+		{"daily", "2026-01-14", "2026-01-15"},
+		{"weekly", "2026-01-12", "2026-01-19"},
+		{"monthly", "2026-01-01", "2026-02-01"},
+	} {
+		from, to, err := reportPeriod(tc.period, at)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if from.Format("2006-01-02") != tc.from || to.Format("2006-01-02") != tc.to {
+			t.Errorf("%s period %v to %v, want %s to %s", tc.period, from, to, tc.from, tc.to)
+		}
+	}
+	if _, _, err := reportPeriod("yearly", at); err == nil {
+		t.Error("yearly period accepted")
+	}
+
+	// On a Monday the last completed week is the previous one
+	from, to, err := lastCompletedPeriod("weekly", time.Date(2026, 1, 12, 0, 0, 1, 0, time.UTC))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if from.Format("2006-01-02") != "2026-01-05" || to.Format("2006-01-02") != "2026-01-12" {
+		t.Errorf("last completed week %v to %v", from, to)
+	}
+}
+
+func TestRenderReport(t *testing.T) {
+	uptime := 99.5
+	report := Report{
+		Period: "daily",
+		From:   time.Date(2026, 1, 14, 0, 0, 0, 0, time.UTC),
+		To:     time.Date(2026, 1, 15, 0, 0, 0, 0, time.UTC),
+		Uptime: &uptime,
+		Tasks:  []ReportTask{{ID: 1, Name: "<backup>", Status: "alive", Uptime: &uptime, Incidents: 1, LongestIncidentSeconds: 432}},
+	}
+	report.SlowestToRecover = report.Tasks
+
+	contentType, body, err := renderReport(report, "html")
+	if err != nil || contentType != "text/html" {
+		t.Fatalf("html report: %q, %v", contentType, err)
+	}
+	if !strings.Contains(string(body), "&lt;backup&gt;") || strings.Contains(string(body), "<backup>") {
+		t.Errorf("task name not escaped in the html report:\n%s", body)
+	}
+
+	contentType, body, err = renderReport(report, "markdown")
+	if err != nil || contentType != "text/markdown" {
+		t.Fatalf("markdown report: %q, %v", contentType, err)
+	}
+	if !strings.Contains(string(body), "| <backup> | 7m 12s | 1 |") {
+		t.Errorf("markdown report misses the slowest task:\n%s", body)
+	}
+
+	if _, _, err := renderReport(report, "pdf"); err == nil {
+		t.Error("pdf format accepted")
+	}
+}
+
+func TestStoreReportPeriodClaims(t *testing.T) {
+	forEachStore(t, func(t *testing.T, store Store, clock *time.Time) {
+		ctx := context.Background()
+		task := createMonitorTestTask(t, store)
+		schedule, err := store.CreateReportSchedule(ctx, ReportSchedule{UserID: task.UserID, Period: "daily", Format: "html", Email: "ops@example.com"})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// Of two servers only one claims the period, and a failed delivery
+		// gives it back
+		end := time.Date(2026, 1, 2, 0, 0, 0, 0, time.UTC)
+		for i, want := range []bool{true, false} {
+			if claimed, err := store.ClaimReportPeriod(ctx, schedule.ID, end); err != nil || claimed != want {
+				t.Errorf("ClaimReportPeriod #%d = %v, %v; want %v", i+1, claimed, err, want)
+			}
+		}
+		if err := store.ReleaseReportPeriod(ctx, schedule.ID, end, nil); err != nil {
+			t.Fatal(err)
+		}
+		if claimed, err := store.ClaimReportPeriod(ctx, schedule.ID, end); err != nil || !claimed {
+			t.Errorf("ClaimReportPeriod after the release = %v, %v", claimed, err)
+		}
+		if claimed, err := store.ClaimReportPeriod(ctx, schedule.ID, end.AddDate(0, 0, -1)); err != nil || claimed {
+			t.Errorf("ClaimReportPeriod of an earlier period = %v, %v", claimed, err)
+		}
+
+		schedules, err := store.ListReportSchedules(ctx, task.UserID)
+		if err != nil || len(schedules) != 1 || schedules[0].LastPeriodEnd == nil || !schedules[0].LastPeriodEnd.Equal(end) {
+			t.Errorf("ListReportSchedules = %+v, %v", schedules, err)
+		}
+	})
+}
+
+func TestSendDueReportsReleasesFailedPeriod(t *testing.T) {
+	t.Setenv("SMTP_HOST", "")
+	store := NewMemoryStore()
+	server := NewServer(store)
+	ctx := context.Background()
+	task := createMonitorTestTask(t, store)
+	schedule, err := store.CreateReportSchedule(ctx, ReportSchedule{UserID: task.UserID, Period: "daily", Format: "html", Email: "ops@example.com"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := server.sendDueReports(ctx); err != nil {
+		t.Fatal(err)
+	}
+	schedules, err := store.ListReportSchedules(ctx, task.UserID)
+	if err != nil || len(schedules) != 1 || schedules[0].ID != schedule.ID || schedules[0].LastPeriodEnd != nil {
+		t.Errorf("schedules after a failed delivery = %+v, %v; want the period unclaimed", schedules, err)
+	}
+}
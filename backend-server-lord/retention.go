@@ -0,0 +1,127 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// How often the retention job compacts the graph data
+const graphRetentionInterval = time.Minute
+
+// Most points per task a graph query should return; the graph API picks the
+// finest tier that stays within it
+const maxGraphPoints = 1500
+
+// GraphTier is one level of graph data. The raw tier holds the monitor's
+// samples; every rollup tier aggregates the tier below it into aligned buckets
+// of Resolution.
+type GraphTier struct {
+	Name       string
+	Resolution time.Duration // 0 for the raw samples
+	Retention  time.Duration // 0 keeps the data forever
+	EnvVar     string
+}
+
+// IsRaw reports whether the tier holds the raw monitor samples
+func (t GraphTier) IsRaw() bool {
+	return t.Resolution == 0
+}
+
+// Spacing is the time between two points of a task in the tier
+func (t GraphTier) Spacing() time.Duration {
+	if t.IsRaw() {
+		return monitorTickInterval
+	}
+	return t.Resolution
+}
+
+// defaultGraphTiers lists the tiers from finest to coarsest with their default retention
+var defaultGraphTiers = []GraphTier{
+	{Name: "raw", Resolution: 0, Retention: 24 * time.Hour, EnvVar: "GRAPH_RETENTION_RAW"},
+	{Name: "1m", Resolution: time.Minute, Retention: 7 * 24 * time.Hour, EnvVar: "GRAPH_RETENTION_1M"},
+	{Name: "1h", Resolution: time.Hour, Retention: 90 * 24 * time.Hour, EnvVar: "GRAPH_RETENTION_1H"},
+	{Name: "1d", Resolution: 24 * time.Hour, Retention: 2 * 365 * 24 * time.Hour, EnvVar: "GRAPH_RETENTION_1D"},
+}
+
+// graphRetentionPolicy returns the graph tiers with the retention configured
+// through their environment variables (e.g. GRAPH_RETENTION_RAW=48h,
+// GRAPH_RETENTION_1D=0 to keep daily rollups forever)
+func graphRetentionPolicy() []GraphTier {
+	tiers := make([]GraphTier, len(defaultGraphTiers))
+	copy(tiers, defaultGraphTiers)
+
+	for i, tier := range tiers {
+		v := os.Getenv(tier.EnvVar)
+		if v == "" {
+			continue
+		}
+		d, err := parseDays(v)
+		if err != nil || d < 0 {
+			log.Printf("Invalid %s %q, using default", tier.EnvVar, v)
+			continue
+		}
+		tiers[i].Retention = d
+	}
+	return tiers
+}
+
+// parseDays parses a duration that may also be given in whole days ("30d")
+func parseDays(v string) (time.Duration, error) {
+	if strings.HasSuffix(v, "d") {
+		days, err := strconv.Atoi(strings.TrimSuffix(v, "d"))
+		if err != nil {
+			return 0, fmt.Errorf("invalid number of days %q", v)
+		}
+		return time.Duration(days) * 24 * time.Hour, nil
+	}
+	return time.ParseDuration(v)
+}
+
+// graphTierFor picks the tier to serve a graph covering span and reaching age
+// into the past. That is the finest tier that still holds data that old and
+// returns at most maxGraphPoints points per task over the span, falling back
+// to the coarsest tier.
+func graphTierFor(tiers []GraphTier, age, span time.Duration) GraphTier {
+	for _, tier := range tiers {
+		if tier.Retention > 0 && tier.Retention < age {
+			continue
+		}
+		if span/tier.Spacing() <= maxGraphPoints {
+			return tier
+		}
+	}
+	return tiers[len(tiers)-1]
+}
+
+// startGraphRetention compacts graph data on every tick until ctx is cancelled:
+// completed buckets are rolled up into every rollup tier and data older than
+// its tier's retention is deleted
+func (s *Server) startGraphRetention(ctx context.Context) {
+	log.Println("Starting graph data retention job...")
+	ticker := time.NewTicker(graphRetentionInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			log.Println("Graph data retention job stopped")
+			return
+		case <-ticker.C:
+		}
+
+		result, err := s.graphs.CompactGraphData(context.Background(), s.graphTiers)
+		if err != nil {
+			log.Printf("Error compacting graph data: %v", err)
+			continue
+		}
+		if result.RolledUp > 0 || result.Pruned > 0 {
+			log.Printf("Compacted graph data: %d rollup buckets written, %d expired rows pruned",
+				result.RolledUp, result.Pruned)
+		}
+	}
+}
@@ -0,0 +1,170 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// NotificationRoute sends the alerts of a user's personal tasks, or of an
+// organization's tasks (OrgID), that carry Tag and/or belong to Project to an
+// extra webhook and/or email address, e.g. everything tagged db-maintenance
+// to the database team. A route with neither matches every task.
+type NotificationRoute struct {
+	ID         int64     `json:"id"`
+	UserID     int64     `json:"user_id"`
+	OrgID      int64     `json:"org_id,omitempty"`
+	Tag        string    `json:"tag"`
+	Project    string    `json:"project"`
+	WebhookURL string    `json:"webhook_url,omitempty"`
+	Email      string    `json:"email,omitempty"`
+	CreatedAt  time.Time `json:"created_at"`
+}
+
+// createNotificationRouteRequest is the body of POST /api/notification-routes
+type createNotificationRouteRequest struct {
+	UserID     int64  `json:"user_id" validate:"min=1"`
+	OrgID      int64  `json:"org_id" validate:"min=1"`
+	Tag        string `json:"tag"`
+	Project    string `json:"project" validate:"max=255"`
+	WebhookURL string `json:"webhook_url" validate:"url"`
+	Email      string `json:"email" validate:"email"`
+}
+
+// routeDestinations returns the webhooks and email addresses of the routes
+// matching the task
+func (s *Server) routeDestinations(ctx context.Context, task Task) ([]string, []string, error) {
+	routes, err := s.routes.ListNotificationRoutes(ctx, task.UserID, task.OrgID)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var webhookURLs, emails []string
+	for _, route := range routes {
+		if !(TaskFilter{Tag: route.Tag, Project: route.Project}).Matches(task) {
+			continue
+		}
+		webhookURLs = append(webhookURLs, route.WebhookURL)
+		emails = append(emails, route.Email)
+	}
+	return webhookURLs, emails, nil
+}
+
+// uniqueDestinations drops empty and repeated destinations, falling back to
+// fallback when none is left
+func uniqueDestinations(destinations []string, fallback string) []string {
+	seen := map[string]bool{}
+	unique := []string{}
+	for _, d := range destinations {
+		if d != "" && !seen[d] {
+			seen[d] = true
+			unique = append(unique, d)
+		}
+	}
+	if len(unique) == 0 && fallback != "" {
+		unique = append(unique, fallback)
+	}
+	return unique
+}
+
+// getNotificationRoutes lists the notification routes of a user (?user_id=)
+// or organization (?org_id=)
+func (s *Server) getNotificationRoutes(w http.ResponseWriter, r *http.Request) {
+	userID, orgID, ok := s.ownerFromQuery(w, r, roleViewer)
+	if !ok {
+		return
+	}
+
+	routes, err := s.routes.ListNotificationRoutes(r.Context(), userID, orgID)
+	if err != nil {
+		log.Printf("Error retrieving notification routes for user %d, organization %d: %v", userID, orgID, err)
+		respondWithError(w, http.StatusInternalServerError, "Error retrieving notification routes")
+		return
+	}
+	respondWithJSON(w, http.StatusOK, routes)
+}
+
+func (s *Server) createNotificationRoute(w http.ResponseWriter, r *http.Request) {
+	var req createNotificationRouteRequest
+	if !decodeJSON(w, r, &req) {
+		return
+	}
+	route := NotificationRoute{
+		UserID:     req.UserID,
+		OrgID:      req.OrgID,
+		Tag:        strings.ToLower(strings.TrimSpace(req.Tag)),
+		Project:    strings.TrimSpace(req.Project),
+		WebhookURL: req.WebhookURL,
+		Email:      req.Email,
+	}
+	switch {
+	case route.Tag != "" && !tagPattern.MatchString(route.Tag):
+		respondWithError(w, http.StatusBadRequest, "tag is invalid")
+		return
+	case route.WebhookURL == "" && route.Email == "":
+		respondWithError(w, http.StatusBadRequest, "webhook_url or email is required")
+		return
+	}
+
+	// Routes are created by the caller, for themselves or for an
+	// organization they can edit
+	if route.UserID == 0 {
+		route.UserID = authUserID(r)
+	}
+	if route.UserID != authUserID(r) {
+		respondWithError(w, http.StatusForbidden, "Insufficient permissions")
+		return
+	}
+	if !s.authorize(w, r, route.UserID, route.OrgID, roleEditor) {
+		return
+	}
+
+	created, err := s.routes.CreateNotificationRoute(r.Context(), route)
+	if err != nil {
+		log.Printf("Error creating notification route: %v", err)
+		respondWithError(w, http.StatusInternalServerError, "Error creating notification route")
+		return
+	}
+
+	log.Printf("Notification route created successfully with ID: %d", created.ID)
+	respondWithJSON(w, http.StatusCreated, created)
+}
+
+func (s *Server) deleteNotificationRoute(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	id, err := strconv.ParseInt(vars["id"], 10, 64)
+	if err != nil {
+		log.Printf("Invalid notification route ID: %s", vars["id"])
+		respondWithError(w, http.StatusBadRequest, "Invalid notification route ID")
+		return
+	}
+
+	route, err := s.routes.GetNotificationRoute(r.Context(), id)
+	if err != nil {
+		if errors.Is(err, ErrNotFound) {
+			respondWithError(w, http.StatusNotFound, "Notification route not found")
+		} else {
+			log.Printf("Error retrieving notification route: %v", err)
+			respondWithError(w, http.StatusInternalServerError, "Error deleting notification route")
+		}
+		return
+	}
+	if !s.authorize(w, r, route.UserID, route.OrgID, roleEditor) {
+		return
+	}
+
+	if err := s.routes.DeleteNotificationRoute(r.Context(), id); err != nil && !errors.Is(err, ErrNotFound) {
+		log.Printf("Error deleting notification route: %v", err)
+		respondWithError(w, http.StatusInternalServerError, "Error deleting notification route")
+		return
+	}
+
+	log.Printf("Notification route deleted successfully: %d", id)
+	respondWithJSON(w, http.StatusOK, map[string]string{"message": "Notification route deleted successfully"})
+}
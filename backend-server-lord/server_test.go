@@ -0,0 +1,237 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// The tests sign tokens with a fixed secret instead of JWT_SECRET
+func init() {
+	jwtSecret = []byte("test secret of at least 32 bytes")
+}
+
+// testAPI serves the HTTP API on an in-memory store with a settable clock
+type testAPI struct {
+	t       *testing.T
+	server  *Server
+	store   *MemoryStore
+	handler http.Handler
+	now     time.Time
+}
+
+func newTestAPI(t *testing.T) *testAPI {
+	t.Helper()
+	api := &testAPI{t: t, store: NewMemoryStore(), now: time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)}
+	api.store.now = func() time.Time { return api.now }
+	api.server = NewServer(api.store)
+	api.handler = api.server.Handler()
+	return api
+}
+
+// advance moves the store clock forward
+func (api *testAPI) advance(d time.Duration) {
+	api.now = api.now.Add(d)
+}
+
+// do sends a request with an optional bearer token and JSON body
+func (api *testAPI) do(method, path, token string, body interface{}) *httptest.ResponseRecorder {
+	api.t.Helper()
+	var reader *bytes.Reader
+	switch b := body.(type) {
+	case nil:
+		reader = bytes.NewReader(nil)
+	case string:
+		reader = bytes.NewReader([]byte(b))
+	default:
+		encoded, err := json.Marshal(b)
+		if err != nil {
+			api.t.Fatalf("encoding request body: %v", err)
+		}
+		reader = bytes.NewReader(encoded)
+	}
+
+	req := httptest.NewRequest(method, path, reader)
+	req.Header.Set("Content-Type", "application/json")
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	rec := httptest.NewRecorder()
+	api.handler.ServeHTTP(rec, req)
+	return rec
+}
+
+// expect fails the test unless the response has the given status, and
+// decodes its body into v when v is not nil
+func expect(t *testing.T, rec *httptest.ResponseRecorder, status int, v interface{}) {
+	t.Helper()
+	if rec.Code != status {
+		t.Fatalf("got status %d, want %d: %s", rec.Code, status, rec.Body.String())
+	}
+	if v != nil {
+		if err := json.Unmarshal(rec.Body.Bytes(), v); err != nil {
+			t.Fatalf("decoding response %q: %v", rec.Body.String(), err)
+		}
+	}
+}
+
+// taskListing is the response of GET /api/users/{user_id}/tasks
+type taskListing struct {
+	Tasks []struct {
+		Task Task `json:"task"`
+	} `json:"tasks"`
+	Total        int            `json:"total"`
+	StatusCounts map[string]int `json:"status_counts"`
+	NextCursor   *string        `json:"next_cursor"`
+}
+
+// signup creates a user and logs them in, returning their ID and access token
+func (api *testAPI) signup(username string) (int, string) {
+	api.t.Helper()
+	email := username + "@example.com"
+	var user User
+	expect(api.t, api.do("POST", "/api/users", "", map[string]string{
+		"username": username, "email": email, "password": "correct horse",
+	}), http.StatusCreated, &user)
+
+	var login LoginResponse
+	expect(api.t, api.do("POST", "/api/login", "", map[string]string{
+		"email": email, "password": "correct horse",
+	}), http.StatusOK, &login)
+	if login.Token == "" {
+		api.t.Fatalf("login returned no token: %+v", login)
+	}
+	return user.ID, login.Token
+}
+
+// createTask creates a task of the user behind token
+func (api *testAPI) createTask(token string, userID int, name string, taskNumber, interval int) Task {
+	api.t.Helper()
+	var task Task
+	expect(api.t, api.do("POST", "/api/tasks", token, map[string]interface{}{
+		"user_id": userID, "name": name, "task_number": taskNumber, "interval": interval,
+	}), http.StatusCreated, &task)
+	return task
+}
+
+func TestSignupAndLogin(t *testing.T) {
+	api := newTestAPI(t)
+	id, token := api.signup("alice")
+
+	var tasks taskListing
+	expect(t, api.do("GET", fmt.Sprintf("/api/users/%d/tasks", id), token, nil), http.StatusOK, &tasks)
+	if len(tasks.Tasks) != 0 || tasks.Total != 0 {
+		t.Errorf("new user has tasks: %+v", tasks)
+	}
+
+	expect(t, api.do("POST", "/api/login", "", map[string]string{
+		"email": "alice@example.com", "password": "wrong password",
+	}), http.StatusUnauthorized, nil)
+	expect(t, api.do("POST", "/api/login", "", map[string]string{
+		"email": "nobody@example.com", "password": "correct horse",
+	}), http.StatusUnauthorized, nil)
+	expect(t, api.do("POST", "/api/login", "", "{"), http.StatusBadRequest, nil)
+	expect(t, api.do("GET", fmt.Sprintf("/api/users/%d/tasks", id), "", nil), http.StatusUnauthorized, nil)
+	expect(t, api.do("GET", fmt.Sprintf("/api/users/%d/tasks", id), "not-a-token", nil), http.StatusUnauthorized, nil)
+}
+
+func TestTaskCRUD(t *testing.T) {
+	api := newTestAPI(t)
+	userID, token := api.signup("alice")
+
+	task := api.createTask(token, userID, "backup", 7, 60)
+	if task.Status != "alive" || task.Interval != 60 {
+		t.Fatalf("created task = %+v", task)
+	}
+	// Tasks can only be created for yourself or an organization you edit
+	expect(t, api.do("POST", "/api/tasks", token, map[string]interface{}{
+		"user_id": userID + 100, "name": "orphan", "task_number": 8, "interval": 60,
+	}), http.StatusForbidden, nil)
+
+	var fetched struct {
+		Task Task `json:"task"`
+	}
+	path := fmt.Sprintf("/api/tasks/%d", task.ID)
+	expect(t, api.do("GET", path, token, nil), http.StatusOK, &fetched)
+	if fetched.Task.Name != "backup" {
+		t.Errorf("GET %s = %+v", path, fetched.Task)
+	}
+
+	var listed taskListing
+	expect(t, api.do("GET", fmt.Sprintf("/api/users/%d/tasks", userID), token, nil), http.StatusOK, &listed)
+	if len(listed.Tasks) != 1 || listed.Tasks[0].Task.ID != task.ID || listed.NextCursor != nil {
+		t.Errorf("listed %+v, want the one task", listed)
+	}
+
+	var updated Task
+	expect(t, api.do("PUT", path, token, map[string]interface{}{
+		"name": "nightly backup", "interval": 120, "task_number": 7,
+	}), http.StatusOK, &updated)
+	if updated.Name != "nightly backup" || updated.Interval != 120 {
+		t.Errorf("PUT %s = %+v", path, updated)
+	}
+
+	expect(t, api.do("DELETE", path, token, nil), http.StatusOK, nil)
+	expect(t, api.do("GET", path, token, nil), http.StatusNotFound, nil)
+	expect(t, api.do("DELETE", path, token, nil), http.StatusNotFound, nil)
+	expect(t, api.do("GET", "/api/tasks/abc", token, nil), http.StatusBadRequest, nil)
+}
+
+func TestHeartbeat(t *testing.T) {
+	api := newTestAPI(t)
+	aliceID, alice := api.signup("alice")
+	bobID, bob := api.signup("bob")
+	aliceTask := api.createTask(alice, aliceID, "backup", 42, 60)
+	bobTask := api.createTask(bob, bobID, "backup", 42, 60)
+
+	api.advance(10 * time.Second)
+	expect(t, api.do("POST", "/tasks/42/heartbeat", "", nil), http.StatusOK, nil)
+	expect(t, api.do("POST", "/tasks/43/heartbeat", "", nil), http.StatusNotFound, nil)
+	expect(t, api.do("POST", "/tasks/abc/heartbeat", "", nil), http.StatusNotFound, nil)
+
+	for _, id := range []int64{aliceTask.ID, bobTask.ID} {
+		task, err := api.store.GetTask(context.Background(), id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if task.PingCount != 1 || task.LastPing == nil || !task.LastPing.Equal(api.now) {
+			t.Errorf("task %d after a ping: ping_count %d, last_ping %v", id, task.PingCount, task.LastPing)
+		}
+	}
+}
+
+func TestDebugMonitorToken(t *testing.T) {
+	api := newTestAPI(t)
+
+	t.Setenv("DEBUG_TOKEN", "")
+	expect(t, api.do("GET", "/debug/monitor", "", nil), http.StatusNotFound, nil)
+
+	t.Setenv("DEBUG_TOKEN", "s3cret")
+	rec := api.do("GET", "/debug/monitor", "", nil)
+	expect(t, rec, http.StatusUnauthorized, nil)
+	if rec.Header().Get("WWW-Authenticate") != "Bearer" {
+		t.Errorf("WWW-Authenticate = %q, want Bearer", rec.Header().Get("WWW-Authenticate"))
+	}
+	expect(t, api.do("GET", "/debug/monitor", "wrong", nil), http.StatusUnauthorized, nil)
+	expect(t, api.do("GET", "/debug/monitor", "s3cret", nil), http.StatusOK, nil)
+}
+
+func TestLoadJWTSecret(t *testing.T) {
+	t.Setenv("JWT_SECRET", "")
+	if _, err := loadJWTSecret(); err == nil {
+		t.Error("started without JWT_SECRET")
+	}
+	t.Setenv("JWT_SECRET", "{YOUR_JWT_TOKEN}")
+	if _, err := loadJWTSecret(); err == nil {
+		t.Error("started with a short JWT_SECRET")
+	}
+	t.Setenv("JWT_SECRET", "0123456789abcdef0123456789abcdef")
+	if secret, err := loadJWTSecret(); err != nil || string(secret) != "0123456789abcdef0123456789abcdef" {
+		t.Errorf("loadJWTSecret = %q, %v", secret, err)
+	}
+}
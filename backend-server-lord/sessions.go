@@ -0,0 +1,268 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// Logins open a session. Its access tokens are short-lived JWTs carrying the
+// session ID, and its refresh token is replaced by every refresh; ending the
+// session revokes both.
+const (
+	accessTokenTTL  = 15 * time.Minute
+	refreshTokenTTL = 30 * 24 * time.Hour
+)
+
+// Session is a device a user is logged in on
+type Session struct {
+	ID                  int64     `json:"id"`
+	UserID              int64     `json:"user_id"`
+	RefreshHash         string    `json:"-"`
+	PreviousRefreshHash string    `json:"-"`
+	UserAgent           string    `json:"user_agent"`
+	Device              string    `json:"device"` // summary of UserAgent
+	IP                  string    `json:"ip"`
+	Current             bool      `json:"current"` // the session of the request
+	CreatedAt           time.Time `json:"created_at"`
+	LastUsedAt          time.Time `json:"last_used_at"`
+	ExpiresAt           time.Time `json:"expires_at"`
+}
+
+// clientIP returns the address a request came from. X-Forwarded-For is only
+// believed behind reverse proxies, TRUST_PROXY of them (true for one). Each
+// appends the address it received the request from, so the client is the
+// entry the outermost trusted proxy added; entries to the left of it are
+// whatever the client sent.
+func clientIP(r *http.Request) string {
+	if proxies := trustedProxies(); proxies > 0 {
+		var forwarded []string
+		for _, header := range r.Header.Values("X-Forwarded-For") {
+			for _, addr := range strings.Split(header, ",") {
+				if addr = strings.TrimSpace(addr); addr != "" {
+					forwarded = append(forwarded, addr)
+				}
+			}
+		}
+		if len(forwarded) > 0 {
+			return forwarded[max(len(forwarded)-proxies, 0)]
+		}
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// trustedProxies reads TRUST_PROXY: true for one proxy or the number of them
+func trustedProxies() int {
+	value := os.Getenv("TRUST_PROXY")
+	if value == "true" {
+		return 1
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil || n < 0 {
+		return 0
+	}
+	return n
+}
+
+// describeDevice summarises a User-Agent as "browser on OS", e.g. "Firefox on
+// Linux", falling back to the product name for clients like curl
+func describeDevice(userAgent string) string {
+	var browser, system string
+	for _, b := range []struct{ token, name string }{
+		{"Edg/", "Edge"}, {"OPR/", "Opera"}, {"Firefox/", "Firefox"}, {"Chrome/", "Chrome"}, {"Safari/", "Safari"},
+	} {
+		if strings.Contains(userAgent, b.token) {
+			browser = b.name
+			break
+		}
+	}
+	for _, o := range []struct{ token, name string }{
+		{"Android", "Android"}, {"iPhone", "iOS"}, {"iPad", "iOS"}, {"Mac OS X", "macOS"}, {"Windows", "Windows"}, {"Linux", "Linux"},
+	} {
+		if strings.Contains(userAgent, o.token) {
+			system = o.name
+			break
+		}
+	}
+
+	switch {
+	case browser != "" && system != "":
+		return browser + " on " + system
+	case browser != "":
+		return browser
+	case system != "":
+		return system
+	case userAgent != "":
+		return strings.SplitN(strings.Fields(userAgent)[0], "/", 2)[0]
+	}
+	return "Unknown device"
+}
+
+// startSession opens a session for a user logging in with r and returns its
+// first tokens
+func (s *Server) startSession(ctx context.Context, r *http.Request, user User) (LoginResponse, error) {
+	now, err := s.monitor.Now(ctx)
+	if err != nil {
+		return LoginResponse{}, err
+	}
+	refreshToken, err := newAccessToken()
+	if err != nil {
+		return LoginResponse{}, err
+	}
+
+	session, err := s.sessions.CreateSession(ctx, Session{
+		UserID:      int64(user.ID),
+		RefreshHash: hashToken(refreshToken),
+		UserAgent:   r.UserAgent(),
+		IP:          clientIP(r),
+		ExpiresAt:   now.Add(refreshTokenTTL),
+	})
+	if err != nil {
+		return LoginResponse{}, err
+	}
+
+	token, err := generateToken(user, session.ID)
+	if err != nil {
+		return LoginResponse{}, err
+	}
+	return LoginResponse{
+		Token:        token,
+		RefreshToken: refreshToken,
+		ExpiresIn:    int(accessTokenTTL.Seconds()),
+		User:         User{ID: user.ID, Username: user.Username, Email: user.Email, EmailVerified: user.EmailVerified},
+	}, nil
+}
+
+// refreshSession trades a refresh token for a new access token and a new
+// refresh token. A refresh token works once; replaying an old one ends the
+// session.
+func (s *Server) refreshSession(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		RefreshToken string `json:"refresh_token" validate:"required"`
+	}
+	if !decodeJSON(w, r, &req) {
+		return
+	}
+
+	now, err := s.monitor.Now(r.Context())
+	var refreshToken string
+	if err == nil {
+		refreshToken, err = newAccessToken()
+	}
+	if err != nil {
+		log.Printf("Error generating refresh token: %v", err)
+		respondWithError(w, http.StatusInternalServerError, "Error refreshing session")
+		return
+	}
+
+	session, err := s.sessions.RotateSession(r.Context(), hashToken(req.RefreshToken), Session{
+		RefreshHash: hashToken(refreshToken),
+		UserAgent:   r.UserAgent(),
+		IP:          clientIP(r),
+		ExpiresAt:   now.Add(refreshTokenTTL),
+	})
+	if err != nil {
+		if errors.Is(err, ErrNotFound) {
+			respondWithError(w, http.StatusUnauthorized, "Invalid or expired refresh token")
+		} else {
+			log.Printf("Error refreshing session: %v", err)
+			respondWithError(w, http.StatusInternalServerError, "Error refreshing session")
+		}
+		return
+	}
+
+	user, err := s.users.GetUser(r.Context(), session.UserID)
+	var token string
+	if err == nil {
+		token, err = generateToken(user, session.ID)
+	}
+	if err != nil {
+		log.Printf("Error generating token: %v", err)
+		respondWithError(w, http.StatusInternalServerError, "Error generating token")
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, LoginResponse{
+		Token:        token,
+		RefreshToken: refreshToken,
+		ExpiresIn:    int(accessTokenTTL.Seconds()),
+		User:         user,
+	})
+}
+
+// logout ends the session of the request's access token
+func (s *Server) logout(w http.ResponseWriter, r *http.Request) {
+	err := s.sessions.DeleteSession(r.Context(), authUserID(r), authSessionID(r))
+	if err != nil && !errors.Is(err, ErrNotFound) {
+		log.Printf("Error ending session: %v", err)
+		respondWithError(w, http.StatusInternalServerError, "Error logging out")
+		return
+	}
+
+	log.Printf("User ID %d logged out of session %d", authUserID(r), authSessionID(r))
+	respondWithJSON(w, http.StatusOK, map[string]string{"message": "Logged out successfully"})
+}
+
+// logoutAll ends every session of the authenticated user, including the
+// request's own
+func (s *Server) logoutAll(w http.ResponseWriter, r *http.Request) {
+	if err := s.sessions.DeleteUserSessions(r.Context(), authUserID(r)); err != nil {
+		log.Printf("Error ending sessions: %v", err)
+		respondWithError(w, http.StatusInternalServerError, "Error logging out")
+		return
+	}
+
+	log.Printf("User ID %d logged out of all sessions", authUserID(r))
+	respondWithJSON(w, http.StatusOK, map[string]string{"message": "Logged out of all sessions"})
+}
+
+// getSessions lists the devices the authenticated user is logged in on
+func (s *Server) getSessions(w http.ResponseWriter, r *http.Request) {
+	sessions, err := s.sessions.ListSessions(r.Context(), authUserID(r))
+	if err != nil {
+		log.Printf("Error retrieving sessions for user %d: %v", authUserID(r), err)
+		respondWithError(w, http.StatusInternalServerError, "Error retrieving sessions")
+		return
+	}
+	for i := range sessions {
+		sessions[i].Device = describeDevice(sessions[i].UserAgent)
+		sessions[i].Current = sessions[i].ID == authSessionID(r)
+	}
+	respondWithJSON(w, http.StatusOK, sessions)
+}
+
+// deleteSession ends one of the authenticated user's sessions
+func (s *Server) deleteSession(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	id, err := strconv.ParseInt(vars["id"], 10, 64)
+	if err != nil {
+		log.Printf("Invalid session ID: %s", vars["id"])
+		respondWithError(w, http.StatusBadRequest, "Invalid session ID")
+		return
+	}
+
+	if err := s.sessions.DeleteSession(r.Context(), authUserID(r), id); err != nil {
+		if errors.Is(err, ErrNotFound) {
+			respondWithError(w, http.StatusNotFound, "Session not found")
+		} else {
+			log.Printf("Error deleting session: %v", err)
+			respondWithError(w, http.StatusInternalServerError, "Error deleting session")
+		}
+		return
+	}
+
+	log.Printf("Session deleted successfully: %d", id)
+	respondWithJSON(w, http.StatusOK, map[string]string{"message": "Session deleted successfully"})
+}
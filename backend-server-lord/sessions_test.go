@@ -0,0 +1,144 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestDescribeDevice(t *testing.T) {
+	for userAgent, want := range map[string]string{
+		"Mozilla/5.0 (X11; Linux x86_64; rv:128.0) Gecko/20100101 Firefox/128.0":                                                "Firefox on Linux",
+		"Mozilla/5.0 (Macintosh; Intel Mac OS X 10_15_7) AppleWebKit/605.1.15 (KHTML, like Gecko) Version/17.5 Safari/605.1.15": "Safari on macOS",
+		"Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/126.0 Safari/537.36 Edg/126.0": "Edge on Windows",
+		"curl/8.5.0": "curl",
+		"":           "Unknown device",
+	} {
+		if got := describeDevice(userAgent); got != want {
+			t.Errorf("describeDevice(%q) = %q, want %q", userAgent, got, want)
+		}
+	}
+}
+
+func TestClientIP(t *testing.T) {
+	tests := []struct {
+		trust     string
+		forwarded []string
+		want      string
+	}{
+		{"", []string{"203.0.113.9"}, "192.0.2.1"},
+		{"true", nil, "192.0.2.1"},
+		{"true", []string{"203.0.113.9"}, "203.0.113.9"},
+		// The client sent the first entry, the proxy appended the second
+		{"true", []string{"10.0.0.1, 203.0.113.9"}, "203.0.113.9"},
+		{"true", []string{"10.0.0.1", "203.0.113.9"}, "203.0.113.9"},
+		{"2", []string{"10.0.0.1, 203.0.113.9, 198.51.100.2"}, "203.0.113.9"},
+		{"2", []string{"203.0.113.9"}, "203.0.113.9"},
+		{"no", []string{"203.0.113.9"}, "192.0.2.1"},
+	}
+	for _, tt := range tests {
+		t.Setenv("TRUST_PROXY", tt.trust)
+		r := httptest.NewRequest("GET", "/", nil)
+		r.RemoteAddr = "192.0.2.1:40000"
+		for _, header := range tt.forwarded {
+			r.Header.Add("X-Forwarded-For", header)
+		}
+		if got := clientIP(r); got != tt.want {
+			t.Errorf("clientIP with TRUST_PROXY=%q and X-Forwarded-For %q = %q, want %q", tt.trust, tt.forwarded, got, tt.want)
+		}
+	}
+}
+
+func TestStoreSessions(t *testing.T) {
+	forEachStore(t, func(t *testing.T, store Store, clock *time.Time) {
+		ctx := context.Background()
+		user, err := store.CreateUser(ctx, "alice", "alice@example.com", "hash")
+		if err != nil {
+			t.Fatal(err)
+		}
+		userID := int64(user.ID)
+
+		created, err := store.CreateSession(ctx, Session{UserID: userID, RefreshHash: "first", UserAgent: "curl/8.5.0", ExpiresAt: clock.Add(time.Hour)})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		*clock = clock.Add(time.Minute)
+		rotated, err := store.RotateSession(ctx, "first", Session{RefreshHash: "second", IP: "192.0.2.1", ExpiresAt: clock.Add(time.Hour)})
+		if err != nil || rotated.ID != created.ID || rotated.IP != "192.0.2.1" || !rotated.LastUsedAt.Equal(*clock) {
+			t.Fatalf("RotateSession = %+v, %v", rotated, err)
+		}
+		if _, err := store.RotateSession(ctx, "unknown", Session{RefreshHash: "third"}); !errors.Is(err, ErrNotFound) {
+			t.Errorf("RotateSession of an unknown token: %v, want ErrNotFound", err)
+		}
+
+		// Replaying the replaced token ends the session
+		if _, err := store.RotateSession(ctx, "first", Session{RefreshHash: "third", ExpiresAt: clock.Add(time.Hour)}); !errors.Is(err, ErrNotFound) {
+			t.Errorf("RotateSession of a replaced token: %v, want ErrNotFound", err)
+		}
+		if _, err := store.GetActiveSession(ctx, created.ID); !errors.Is(err, ErrNotFound) {
+			t.Errorf("session after a replayed token: %v, want ErrNotFound", err)
+		}
+
+		other, err := store.CreateSession(ctx, Session{UserID: userID, RefreshHash: "other", ExpiresAt: clock.Add(time.Hour)})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if sessions, err := store.ListSessions(ctx, userID); err != nil || len(sessions) != 1 || sessions[0].ID != other.ID {
+			t.Errorf("ListSessions = %+v, %v; want the other session", sessions, err)
+		}
+		*clock = clock.Add(2 * time.Hour)
+		if _, err := store.GetActiveSession(ctx, other.ID); !errors.Is(err, ErrNotFound) {
+			t.Errorf("expired session: %v, want ErrNotFound", err)
+		}
+	})
+}
+
+func TestSessionAPI(t *testing.T) {
+	api := newTestAPI(t)
+	userID, _ := api.signup("alice")
+	login := func() LoginResponse {
+		t.Helper()
+		var login LoginResponse
+		expect(t, api.do("POST", "/api/login", "", map[string]string{
+			"email": "alice@example.com", "password": "correct horse",
+		}), http.StatusOK, &login)
+		if login.RefreshToken == "" || login.ExpiresIn != int(accessTokenTTL.Seconds()) {
+			t.Fatalf("login %+v", login)
+		}
+		return login
+	}
+	laptop, phone := login(), login()
+	tasksPath := fmt.Sprintf("/api/users/%d/tasks", userID)
+
+	var refreshed LoginResponse
+	expect(t, api.do("POST", "/api/token/refresh", "", map[string]string{"refresh_token": laptop.RefreshToken}), http.StatusOK, &refreshed)
+	if refreshed.RefreshToken == laptop.RefreshToken || refreshed.User.ID != userID {
+		t.Fatalf("refreshed %+v", refreshed)
+	}
+	expect(t, api.do("GET", tasksPath, refreshed.Token, nil), http.StatusOK, nil)
+
+	// A refresh token works once, and replaying it revokes the session
+	expect(t, api.do("POST", "/api/token/refresh", "", map[string]string{"refresh_token": laptop.RefreshToken}), http.StatusUnauthorized, nil)
+	expect(t, api.do("GET", tasksPath, refreshed.Token, nil), http.StatusUnauthorized, nil)
+	expect(t, api.do("POST", "/api/token/refresh", "", map[string]string{}), http.StatusBadRequest, nil)
+
+	var sessions []Session
+	expect(t, api.do("GET", "/api/sessions", phone.Token, nil), http.StatusOK, &sessions)
+	if len(sessions) != 2 || !sessions[0].Current && !sessions[1].Current {
+		t.Errorf("sessions %+v, want the phone's and the first signup's", sessions)
+	}
+
+	expect(t, api.do("POST", "/api/logout", "", nil), http.StatusUnauthorized, nil)
+	expect(t, api.do("POST", "/api/logout", phone.Token, nil), http.StatusOK, nil)
+	expect(t, api.do("GET", tasksPath, phone.Token, nil), http.StatusUnauthorized, nil)
+	expect(t, api.do("POST", "/api/token/refresh", "", map[string]string{"refresh_token": phone.RefreshToken}), http.StatusUnauthorized, nil)
+
+	other := login()
+	expect(t, api.do("POST", "/api/logout/all", other.Token, nil), http.StatusOK, nil)
+	expect(t, api.do("GET", tasksPath, other.Token, nil), http.StatusUnauthorized, nil)
+}
@@ -0,0 +1,783 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"net/url"
+	"os"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// SLO is a service level objective on a task over a rolling window: the share
+// of time the task meets its schedule (is alive), e.g. 99.5% over 28 days, or
+// with Kind "runs" the share of its scheduled runs that succeed. An SLO with
+// a Tag instead of a TaskID covers every personal task of its user carrying
+// the tag, with one error budget for all of them. Alerts go to WebhookURL and
+// Email, falling back to SLO_ALERT_WEBHOOK_URL and SLO_ALERT_EMAIL.
+type SLO struct {
+	ID         int64     `json:"id"`
+	TaskID     int64     `json:"task_id,omitempty"`
+	Tag        string    `json:"tag,omitempty"`
+	UserID     int64     `json:"user_id"`
+	Name       string    `json:"name"`
+	Target     float64   `json:"target"` // percent
+	Kind       string    `json:"kind"`
+	WindowDays int       `json:"window_days"`
+	WebhookURL string    `json:"webhook_url,omitempty"`
+	Email      string    `json:"email,omitempty"`
+	CreatedAt  time.Time `json:"created_at"`
+	// FiringAlerts are the burn-rate alerts last notified as firing, in
+	// the order of burnRateAlerts
+	FiringAlerts []string `json:"-"`
+}
+
+// createSLORequest is the body of POST /api/slos. Tag SLOs belong to the
+// caller, so UserID may only name them.
+type createSLORequest struct {
+	TaskID     int64   `json:"task_id"`
+	Tag        string  `json:"tag"`
+	UserID     int64   `json:"user_id" validate:"min=1"`
+	Name       string  `json:"name" validate:"required,max=255"`
+	Target     float64 `json:"target" validate:"required"`
+	Kind       string  `json:"kind" validate:"oneof=time runs"`
+	WindowDays int     `json:"window_days" validate:"min=1,max=365"`
+	WebhookURL string  `json:"webhook_url" validate:"url"`
+	Email      string  `json:"email" validate:"email"`
+}
+
+// updateSLORequest is the body of PUT /api/slos/{id}, which replaces the
+// editable fields of an SLO
+type updateSLORequest struct {
+	Name       string  `json:"name" validate:"required,max=255"`
+	Target     float64 `json:"target" validate:"required"`
+	Kind       string  `json:"kind" validate:"oneof=time runs"`
+	WindowDays int     `json:"window_days" validate:"min=1,max=365"`
+	WebhookURL string  `json:"webhook_url" validate:"url"`
+	Email      string  `json:"email" validate:"email"`
+}
+
+// Window used when an SLO is created without one
+const defaultSLOWindowDays = 28
+
+// SLO kinds: the share of the tracked time the tasks are alive, or the share
+// of their runs that succeed. A ping is a successful run, and every interval
+// a task spends dead is a missed one.
+const (
+	sloKindTime = "time"
+	sloKindRuns = "runs"
+)
+
+// How often the burn-rate alerts are evaluated
+const sloAlertInterval = time.Minute
+
+// burnRateWindows are the lookbacks burn rates are reported for
+var burnRateWindows = map[string]time.Duration{
+	"5m":  5 * time.Minute,
+	"30m": 30 * time.Minute,
+	"1h":  time.Hour,
+	"6h":  6 * time.Hour,
+}
+
+// burnRateAlert fires while the burn rate over both its long and its short
+// window is at least Threshold. The short window makes it resolve soon after
+// the burning stops.
+type burnRateAlert struct {
+	Name      string
+	Long      string
+	Short     string
+	Threshold float64
+}
+
+// burnRateAlerts are the multiwindow alerts: fast burn spends 2% of a 30 day
+// budget in an hour, slow burn 5% in six hours
+var burnRateAlerts = []burnRateAlert{
+	{Name: "fast_burn", Long: "1h", Short: "5m", Threshold: 14.4},
+	{Name: "slow_burn", Long: "6h", Short: "30m", Threshold: 6},
+}
+
+// SLOStatus is the state of an SLO over its current window
+type SLOStatus struct {
+	// Number of tasks the SLO covers
+	Tasks       int       `json:"tasks"`
+	WindowStart time.Time `json:"window_start"`
+	WindowEnd   time.Time `json:"window_end"`
+	GoodSeconds float64   `json:"good_seconds"`
+	BadSeconds  float64   `json:"bad_seconds"`
+	// Successful and missed runs
+	GoodRuns float64 `json:"good_runs"`
+	BadRuns  float64 `json:"bad_runs"`
+	// Percentage of the tracked time the tasks were alive, or of their runs
+	// that succeeded for a runs SLO; null until anything is tracked
+	Availability *float64 `json:"availability"`
+	// Downtime and missed runs the target allows over the tracked time and runs
+	ErrorBudgetSeconds          float64 `json:"error_budget_seconds"`
+	ErrorBudgetRemainingSeconds float64 `json:"error_budget_remaining_seconds"`
+	ErrorBudgetRuns             float64 `json:"error_budget_runs"`
+	ErrorBudgetRemainingRuns    float64 `json:"error_budget_remaining_runs"`
+	// Fraction of the budget of the SLO's kind left, negative once it is
+	// overspent
+	ErrorBudgetRemaining float64            `json:"error_budget_remaining"`
+	BurnRates            map[string]float64 `json:"burn_rates"`
+	Alerts               []string           `json:"alerts"`
+}
+
+// timeInStates splits [from, to) into the time the transition log has the task
+// alive and dead. Time before the first transition (task creation) and paused
+// time are not tracked.
+func timeInStates(transitions []TaskTransition, from, to time.Time) (good, bad float64) {
+	for i, t := range transitions {
+		start := t.TransitionedAt
+		end := to
+		if i+1 < len(transitions) {
+			end = transitions[i+1].TransitionedAt
+		}
+		if start.Before(from) {
+			start = from
+		}
+		if end.After(to) {
+			end = to
+		}
+		elapsed := end.Sub(start).Seconds()
+		if elapsed <= 0 {
+			continue
+		}
+		switch t.ToStatus {
+		case "alive":
+			good += elapsed
+		case "paused":
+		default:
+			bad += elapsed
+		}
+	}
+	return good, bad
+}
+
+// sloTask is what an SLO is evaluated on for one of its tasks
+type sloTask struct {
+	Interval    int // seconds
+	Transitions []TaskTransition
+	// Ping counts from the graph samples, ordered by time
+	Pings []pingSample
+}
+
+// pingSample is the ping count of a task at a point in time
+type pingSample struct {
+	At    time.Time
+	Count int64
+}
+
+// pingsBetween counts the pings in [from, to). The count at a time is the one
+// of the last sample up to it, or of the first sample before that.
+func pingsBetween(samples []pingSample, from, to time.Time) float64 {
+	if len(samples) == 0 {
+		return 0
+	}
+	countAt := func(t time.Time) int64 {
+		i := sort.Search(len(samples), func(i int) bool { return samples[i].At.After(t) })
+		if i == 0 {
+			return samples[0].Count
+		}
+		return samples[i-1].Count
+	}
+	return math.Max(float64(countAt(to)-countAt(from)), 0)
+}
+
+// runsInStates counts the successful and missed runs of a task in [from,
+// to): its pings, and the intervals it spent dead
+func runsInStates(task sloTask, from, to time.Time) (good, bad float64) {
+	if task.Interval <= 0 {
+		return 0, 0
+	}
+	_, dead := timeInStates(task.Transitions, from, to)
+	return pingsBetween(task.Pings, from, to), dead / float64(task.Interval)
+}
+
+// sloMeasure sums the good and bad time of the tasks in [from, to), or their
+// good and bad runs for a runs SLO
+func sloMeasure(kind string, tasks []sloTask, from, to time.Time) (good, bad float64) {
+	for _, task := range tasks {
+		var taskGood, taskBad float64
+		if kind == sloKindRuns {
+			taskGood, taskBad = runsInStates(task, from, to)
+		} else {
+			taskGood, taskBad = timeInStates(task.Transitions, from, to)
+		}
+		good += taskGood
+		bad += taskBad
+	}
+	return good, bad
+}
+
+// computeSLOStatus evaluates the SLO against the tasks it covers at now. The
+// error budget is the share of the tracked time, or of the runs, the target
+// allows to be bad, so a task created yesterday does not bring a full
+// window's budget.
+func computeSLOStatus(slo SLO, tasks []sloTask, now time.Time) SLOStatus {
+	window := time.Duration(slo.WindowDays) * 24 * time.Hour
+	allowed := 1 - slo.Target/100
+
+	status := SLOStatus{
+		Tasks:       len(tasks),
+		WindowStart: now.Add(-window),
+		WindowEnd:   now,
+		BurnRates:   map[string]float64{},
+		Alerts:      []string{},
+	}
+	status.GoodSeconds, status.BadSeconds = sloMeasure(sloKindTime, tasks, status.WindowStart, now)
+	status.ErrorBudgetSeconds = allowed * (status.GoodSeconds + status.BadSeconds)
+	status.ErrorBudgetRemainingSeconds = status.ErrorBudgetSeconds - status.BadSeconds
+	status.GoodRuns, status.BadRuns = sloMeasure(sloKindRuns, tasks, status.WindowStart, now)
+	status.ErrorBudgetRuns = allowed * (status.GoodRuns + status.BadRuns)
+	status.ErrorBudgetRemainingRuns = status.ErrorBudgetRuns - status.BadRuns
+
+	good, bad := status.GoodSeconds, status.BadSeconds
+	if slo.Kind == sloKindRuns {
+		good, bad = status.GoodRuns, status.BadRuns
+	}
+	status.ErrorBudgetRemaining = 1
+	if good+bad > 0 {
+		availability := good / (good + bad) * 100
+		status.Availability = &availability
+		if budget := allowed * (good + bad); budget > 0 {
+			status.ErrorBudgetRemaining = (budget - bad) / budget
+		}
+	}
+
+	// The burn rate is the error rate relative to the one the target allows;
+	// 1 spends the budget exactly over the window
+	for name, lookback := range burnRateWindows {
+		good, bad := sloMeasure(slo.Kind, tasks, now.Add(-lookback), now)
+		if good+bad > 0 && allowed > 0 {
+			status.BurnRates[name] = bad / (good + bad) / allowed
+		} else {
+			status.BurnRates[name] = 0
+		}
+	}
+
+	for _, alert := range burnRateAlerts {
+		if status.BurnRates[alert.Long] >= alert.Threshold && status.BurnRates[alert.Short] >= alert.Threshold {
+			status.Alerts = append(status.Alerts, alert.Name)
+		}
+	}
+	return status
+}
+
+// sloStatus loads the transition logs and ping counts of the SLO's tasks and
+// evaluates it in the store's clock. A tag SLO covers the tasks carrying the
+// tag right now.
+func (s *Server) sloStatus(ctx context.Context, slo SLO) (SLOStatus, error) {
+	now, err := s.monitor.Now(ctx)
+	if err != nil {
+		return SLOStatus{}, err
+	}
+
+	var covered []Task
+	if slo.Tag != "" {
+		covered, err = s.tasks.ListUserTasks(ctx, slo.UserID, TaskFilter{Tag: slo.Tag})
+	} else {
+		var task Task
+		task, err = s.tasks.GetTask(ctx, slo.TaskID)
+		covered = []Task{task}
+	}
+	if err != nil {
+		return SLOStatus{}, err
+	}
+	pings, err := s.sloPings(ctx, slo, now)
+	if err != nil {
+		return SLOStatus{}, err
+	}
+
+	tasks := make([]sloTask, 0, len(covered))
+	for _, task := range covered {
+		transitions, err := s.transitions.ListTransitions(ctx, task.ID)
+		if err != nil {
+			return SLOStatus{}, err
+		}
+		tasks = append(tasks, sloTask{Interval: task.Interval, Transitions: transitions, Pings: pings[task.ID]})
+	}
+	return computeSLOStatus(slo, tasks, now), nil
+}
+
+// sloPings reads the ping counts of the SLO's tasks from the graph data, by
+// task ID: over the whole window from the finest tier still holding it, and
+// over the burn-rate windows at a minute's resolution
+func (s *Server) sloPings(ctx context.Context, slo SLO, now time.Time) (map[int64][]pingSample, error) {
+	q := GraphQuery{TaskID: slo.TaskID, To: now}
+	if slo.Tag != "" {
+		q = GraphQuery{UserID: slo.UserID, Filter: TaskFilter{Tag: slo.Tag}, To: now}
+	}
+	var longest time.Duration
+	for _, lookback := range burnRateWindows {
+		longest = max(longest, lookback)
+	}
+
+	pings := map[int64][]pingSample{}
+	for _, age := range []time.Duration{time.Duration(slo.WindowDays) * 24 * time.Hour, longest} {
+		q.Tier = sloGraphTier(s.graphTiers, age)
+		q.Step = max(q.Tier.Spacing(), time.Minute)
+		q.From = now.Add(-age)
+		buckets, err := s.graphs.GraphBuckets(ctx, q)
+		if err != nil {
+			return nil, err
+		}
+		// A bucket holds the count at its start and end
+		for _, b := range buckets {
+			end := b.BucketStart.Add(q.Step)
+			if end.After(now) {
+				end = now
+			}
+			pings[b.TaskID] = append(pings[b.TaskID],
+				pingSample{At: b.BucketStart, Count: b.MinPingCount},
+				pingSample{At: end, Count: b.MaxPingCount})
+		}
+	}
+
+	for _, samples := range pings {
+		sort.Slice(samples, func(i, j int) bool {
+			if !samples[i].At.Equal(samples[j].At) {
+				return samples[i].At.Before(samples[j].At)
+			}
+			return samples[i].Count < samples[j].Count
+		})
+	}
+	return pings, nil
+}
+
+// sloGraphTier is the finest graph tier still holding data of the given age
+func sloGraphTier(tiers []GraphTier, age time.Duration) GraphTier {
+	for _, tier := range tiers {
+		if tier.Retention == 0 || tier.Retention >= age {
+			return tier
+		}
+	}
+	return tiers[len(tiers)-1]
+}
+
+// validateSLO checks what the validate tags cannot and fills in defaults. It
+// returns the message for a 400 response, or "".
+func validateSLO(slo *SLO) string {
+	slo.Name = strings.TrimSpace(slo.Name)
+	if slo.Target <= 0 || slo.Target >= 100 {
+		return "target must be a percentage between 0 and 100 (exclusive)"
+	}
+	if slo.WindowDays == 0 {
+		slo.WindowDays = defaultSLOWindowDays
+	}
+	if slo.Kind == "" {
+		slo.Kind = sloKindTime
+	}
+	return ""
+}
+
+// validateSLOSelector checks that a new SLO covers either a task or a tag
+// and normalizes the tag
+func validateSLOSelector(slo *SLO) []FieldError {
+	slo.Tag = strings.ToLower(strings.TrimSpace(slo.Tag))
+	switch {
+	case slo.TaskID != 0 && slo.Tag != "":
+		return []FieldError{{Field: "tag", Message: "cannot be combined with task_id"}}
+	case slo.TaskID == 0 && slo.Tag == "":
+		return []FieldError{{Field: "task_id", Message: "is required unless tag is set"}}
+	case slo.TaskID != 0 && slo.UserID != 0:
+		return []FieldError{{Field: "user_id", Message: "cannot be combined with task_id"}}
+	case slo.TaskID < 0:
+		return []FieldError{{Field: "task_id", Message: "must be a task ID"}}
+	case slo.Tag != "" && !tagPattern.MatchString(slo.Tag):
+		return []FieldError{{Field: "tag", Message: "must be up to 64 lowercase letters, digits and _ . : / -"}}
+	}
+	return nil
+}
+
+// validWebhookURL reports whether raw is an absolute http(s) URL
+func validWebhookURL(raw string) bool {
+	u, err := url.Parse(raw)
+	return err == nil && (u.Scheme == "http" || u.Scheme == "https") && u.Host != ""
+}
+
+// sloWithStatus is the API representation of an SLO
+type sloWithStatus struct {
+	SLO
+	Status SLOStatus `json:"status"`
+}
+
+// getSLOs lists the SLOs on the tasks of a user (?user_id=) or organization
+// (?org_id=) with their current status
+func (s *Server) getSLOs(w http.ResponseWriter, r *http.Request) {
+	userID, orgID, ok := s.ownerFromQuery(w, r, roleViewer)
+	if !ok {
+		return
+	}
+
+	slos, err := s.slos.ListSLOs(r.Context(), userID, orgID)
+	if err != nil {
+		log.Printf("Error retrieving SLOs for user %d, organization %d: %v", userID, orgID, err)
+		respondWithError(w, http.StatusInternalServerError, "Error retrieving SLOs")
+		return
+	}
+
+	response := make([]sloWithStatus, 0, len(slos))
+	for _, slo := range slos {
+		status, err := s.sloStatus(r.Context(), slo)
+		if err != nil {
+			log.Printf("Error evaluating SLO %d: %v", slo.ID, err)
+			respondWithError(w, http.StatusInternalServerError, "Error retrieving SLOs")
+			return
+		}
+		response = append(response, sloWithStatus{SLO: slo, Status: status})
+	}
+
+	log.Printf("Retrieved %d SLOs for user ID: %d, organization ID: %d", len(response), userID, orgID)
+	respondWithJSON(w, http.StatusOK, response)
+}
+
+// createSLO creates an SLO on a task (task_id), or on every personal task of
+// the caller carrying a tag (tag)
+func (s *Server) createSLO(w http.ResponseWriter, r *http.Request) {
+	var req createSLORequest
+	if !decodeJSON(w, r, &req) {
+		return
+	}
+	slo := SLO{
+		TaskID:     req.TaskID,
+		Tag:        req.Tag,
+		UserID:     req.UserID,
+		Name:       req.Name,
+		Target:     req.Target,
+		Kind:       req.Kind,
+		WindowDays: req.WindowDays,
+		WebhookURL: req.WebhookURL,
+		Email:      req.Email,
+	}
+
+	if errs := validateSLOSelector(&slo); len(errs) > 0 {
+		respondWithFieldErrors(w, errs)
+		return
+	}
+	if msg := validateSLO(&slo); msg != "" {
+		respondWithError(w, http.StatusBadRequest, msg)
+		return
+	}
+
+	if slo.Tag != "" {
+		// Tag SLOs are created by the caller, for their own tasks
+		if slo.UserID == 0 {
+			slo.UserID = authUserID(r)
+		}
+		if slo.UserID != authUserID(r) {
+			respondWithError(w, http.StatusForbidden, "Insufficient permissions")
+			return
+		}
+		if !s.authorize(w, r, slo.UserID, 0, roleEditor) {
+			return
+		}
+	} else {
+		task, err := s.tasks.GetTask(r.Context(), slo.TaskID)
+		if err != nil {
+			if errors.Is(err, ErrNotFound) {
+				respondWithError(w, http.StatusBadRequest, "Task does not exist")
+			} else {
+				log.Printf("Error retrieving task: %v", err)
+				respondWithError(w, http.StatusInternalServerError, "Error creating SLO")
+			}
+			return
+		}
+		if !s.authorize(w, r, task.UserID, task.OrgID, roleEditor) {
+			return
+		}
+	}
+
+	created, err := s.slos.CreateSLO(r.Context(), slo)
+	if err != nil {
+		log.Printf("Error creating SLO: %v", err)
+		respondWithError(w, http.StatusInternalServerError, "Error creating SLO")
+		return
+	}
+
+	log.Printf("SLO created successfully with ID: %d", created.ID)
+	respondWithJSON(w, http.StatusCreated, created)
+}
+
+// sloFromRequest loads the SLO named by the {id} route variable and checks
+// that the authenticated user has at least the required role on its task, or
+// is the user of a tag SLO. It writes the error response itself and returns
+// ok=false on failure.
+func (s *Server) sloFromRequest(w http.ResponseWriter, r *http.Request, required string) (SLO, bool) {
+	vars := mux.Vars(r)
+	id, err := strconv.ParseInt(vars["id"], 10, 64)
+	if err != nil {
+		log.Printf("Invalid SLO ID: %s", vars["id"])
+		respondWithError(w, http.StatusBadRequest, "Invalid SLO ID")
+		return SLO{}, false
+	}
+
+	slo, err := s.slos.GetSLO(r.Context(), id)
+	if err != nil {
+		if errors.Is(err, ErrNotFound) {
+			respondWithError(w, http.StatusNotFound, "SLO not found")
+		} else {
+			log.Printf("Error retrieving SLO: %v", err)
+			respondWithError(w, http.StatusInternalServerError, "Error retrieving SLO")
+		}
+		return SLO{}, false
+	}
+
+	if slo.Tag != "" {
+		if !s.authorize(w, r, slo.UserID, 0, required) {
+			return SLO{}, false
+		}
+		return slo, true
+	}
+	task, err := s.tasks.GetTask(r.Context(), slo.TaskID)
+	if err != nil {
+		log.Printf("Error retrieving task of SLO %d: %v", slo.ID, err)
+		respondWithError(w, http.StatusInternalServerError, "Error retrieving SLO")
+		return SLO{}, false
+	}
+	if !s.authorize(w, r, task.UserID, task.OrgID, required) {
+		return SLO{}, false
+	}
+	return slo, true
+}
+
+func (s *Server) getSLO(w http.ResponseWriter, r *http.Request) {
+	slo, ok := s.sloFromRequest(w, r, roleViewer)
+	if !ok {
+		return
+	}
+
+	status, err := s.sloStatus(r.Context(), slo)
+	if err != nil {
+		log.Printf("Error evaluating SLO %d: %v", slo.ID, err)
+		respondWithError(w, http.StatusInternalServerError, "Error retrieving SLO")
+		return
+	}
+	respondWithJSON(w, http.StatusOK, sloWithStatus{SLO: slo, Status: status})
+}
+
+// updateSLO replaces the name, target, window and alert targets of an SLO.
+// The task or tag it covers cannot be changed.
+func (s *Server) updateSLO(w http.ResponseWriter, r *http.Request) {
+	slo, ok := s.sloFromRequest(w, r, roleEditor)
+	if !ok {
+		return
+	}
+
+	var req updateSLORequest
+	if !decodeJSON(w, r, &req) {
+		return
+	}
+	update := SLO{
+		ID:         slo.ID,
+		Name:       req.Name,
+		Target:     req.Target,
+		Kind:       req.Kind,
+		WindowDays: req.WindowDays,
+		WebhookURL: req.WebhookURL,
+		Email:      req.Email,
+	}
+
+	if msg := validateSLO(&update); msg != "" {
+		respondWithError(w, http.StatusBadRequest, msg)
+		return
+	}
+
+	updated, err := s.slos.UpdateSLO(r.Context(), update)
+	if err != nil {
+		if errors.Is(err, ErrNotFound) {
+			respondWithError(w, http.StatusNotFound, "SLO not found")
+		} else {
+			log.Printf("Error updating SLO: %v", err)
+			respondWithError(w, http.StatusInternalServerError, "Error updating SLO")
+		}
+		return
+	}
+
+	log.Printf("SLO updated successfully: %s (ID: %d)", updated.Name, updated.ID)
+	respondWithJSON(w, http.StatusOK, updated)
+}
+
+func (s *Server) deleteSLO(w http.ResponseWriter, r *http.Request) {
+	slo, ok := s.sloFromRequest(w, r, roleEditor)
+	if !ok {
+		return
+	}
+	id := slo.ID
+
+	if err := s.slos.DeleteSLO(r.Context(), id); err != nil {
+		if errors.Is(err, ErrNotFound) {
+			respondWithError(w, http.StatusNotFound, "SLO not found")
+		} else {
+			log.Printf("Error deleting SLO: %v", err)
+			respondWithError(w, http.StatusInternalServerError, "Error deleting SLO")
+		}
+		return
+	}
+
+	log.Printf("SLO deleted successfully: %d", id)
+	respondWithJSON(w, http.StatusOK, map[string]string{"message": "SLO deleted successfully"})
+}
+
+// SLOAlert is the JSON body POSTed to an SLO's webhook
+type SLOAlert struct {
+	Event   string    `json:"event"` // "slo_burn_rate_firing" or "slo_burn_rate_resolved"
+	Alert   string    `json:"alert"` // "fast_burn" or "slow_burn"
+	Message string    `json:"message"`
+	SLO     SLO       `json:"slo"`
+	Status  SLOStatus `json:"status"`
+	SentAt  time.Time `json:"sent_at"`
+}
+
+// alertClient is used for SLO alert webhooks
+var alertClient = &http.Client{Timeout: 10 * time.Second}
+
+// startSLOAlerts evaluates the SLO alerts on each tick until ctx is cancelled
+func (s *Server) startSLOAlerts(ctx context.Context) {
+	log.Println("Starting SLO burn-rate alerts...")
+	ticker := time.NewTicker(sloAlertInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			log.Println("SLO burn-rate alerts stopped")
+			return
+		case <-ticker.C:
+		}
+		s.evaluateSLOAlerts(ctx)
+	}
+}
+
+// evaluateSLOAlerts evaluates every SLO and notifies when one of its
+// burn-rate alerts starts or stops firing
+func (s *Server) evaluateSLOAlerts(ctx context.Context) {
+	slos, err := s.slos.ListSLOs(ctx, 0, 0)
+	if err != nil {
+		log.Printf("Error retrieving SLOs: %v", err)
+		return
+	}
+
+	for _, slo := range slos {
+		status, err := s.sloStatus(ctx, slo)
+		if err != nil {
+			log.Printf("Error evaluating SLO %d: %v", slo.ID, err)
+			continue
+		}
+		if joinSLOAlerts(status.Alerts) == joinSLOAlerts(slo.FiringAlerts) {
+			continue
+		}
+
+		// Claim the change before notifying, so that it is sent once across
+		// restarts and by only one of several servers
+		claimed, err := s.slos.ClaimSLOAlerts(ctx, slo.ID, slo.FiringAlerts, status.Alerts)
+		if err != nil {
+			log.Printf("Error recording the alerts of SLO %d: %v", slo.ID, err)
+			continue
+		}
+		if !claimed {
+			continue
+		}
+		for _, alert := range burnRateAlerts {
+			was, now := slices.Contains(slo.FiringAlerts, alert.Name), slices.Contains(status.Alerts, alert.Name)
+			if was != now {
+				s.notifySLOAlert(ctx, slo, status, alert, now)
+			}
+		}
+	}
+}
+
+// joinSLOAlerts encodes the names of firing alerts for the firing_alerts
+// column
+func joinSLOAlerts(names []string) string {
+	return strings.Join(names, ",")
+}
+
+// splitSLOAlerts decodes the firing_alerts column
+func splitSLOAlerts(s string) []string {
+	if s == "" {
+		return nil
+	}
+	return strings.Split(s, ",")
+}
+
+// notifySLOAlert sends a firing or resolved notification to the SLO's webhook
+// and email address and to the notification routes matching its task, or its
+// tag for a tag SLO
+func (s *Server) notifySLOAlert(ctx context.Context, slo SLO, status SLOStatus, alert burnRateAlert, firing bool) {
+	msg := SLOAlert{
+		Event:  "slo_burn_rate_resolved",
+		Alert:  alert.Name,
+		SLO:    slo,
+		Status: status,
+		SentAt: time.Now(),
+	}
+	if firing {
+		msg.Event = "slo_burn_rate_firing"
+		msg.Message = fmt.Sprintf("SLO %q (%.3g%% over %d days) is burning its error budget: %.1fx over %s, %.1fx over %s; %.1f%% of the budget left",
+			slo.Name, slo.Target, slo.WindowDays, status.BurnRates[alert.Long], alert.Long,
+			status.BurnRates[alert.Short], alert.Short, status.ErrorBudgetRemaining*100)
+	} else {
+		msg.Message = fmt.Sprintf("SLO %q is no longer burning its error budget (%s); %.1f%% of the budget left",
+			slo.Name, alert.Name, status.ErrorBudgetRemaining*100)
+	}
+	log.Printf("SLO ALERT: %s", msg.Message)
+
+	webhookURLs, emails := []string{slo.WebhookURL}, []string{slo.Email}
+	// A tag SLO matches the routes of its tag
+	task := Task{UserID: slo.UserID, Tags: []string{slo.Tag}}
+	var err error
+	if slo.Tag == "" {
+		task, err = s.tasks.GetTask(ctx, slo.TaskID)
+	}
+	if err == nil {
+		routeWebhooks, routeEmails, err := s.routeDestinations(ctx, task)
+		if err != nil {
+			log.Printf("Error retrieving notification routes for SLO %d: %v", slo.ID, err)
+		}
+		webhookURLs = append(webhookURLs, routeWebhooks...)
+		emails = append(emails, routeEmails...)
+	}
+
+	webhookURLs = uniqueDestinations(webhookURLs, os.Getenv("SLO_ALERT_WEBHOOK_URL"))
+	for _, webhookURL := range webhookURLs {
+		if err := postSLOAlert(webhookURL, msg); err != nil {
+			log.Printf("Error sending SLO alert webhook: %v", err)
+		}
+	}
+
+	for _, email := range uniqueDestinations(emails, os.Getenv("SLO_ALERT_EMAIL")) {
+		if err := sendEmail(email, "[ServerLord] "+msg.Message, "text/plain", msg.Message); err != nil {
+			log.Printf("Error sending SLO alert email: %v", err)
+		}
+	}
+}
+
+// postSLOAlert POSTs the alert as JSON to the webhook
+func postSLOAlert(webhookURL string, alert SLOAlert) error {
+	body, err := json.Marshal(alert)
+	if err != nil {
+		return err
+	}
+
+	resp, err := alertClient.Post(webhookURL, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("webhook responded with status %d", resp.StatusCode)
+	}
+	return nil
+}
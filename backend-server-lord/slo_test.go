@@ -0,0 +1,542 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestTimeInStates(t *testing.T) {
+	start := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+	transitions := []TaskTransition{
+		{ToStatus: "alive", TransitionedAt: start},
+		{ToStatus: "dead", TransitionedAt: start.Add(10 * time.Minute)},
+		{ToStatus: "alive", TransitionedAt: start.Add(15 * time.Minute)},
+	}
+	for _, tc := range []struct {
+		from, to  time.Duration
+		good, bad float64
+	}{
+		// Time before the task was created is not tracked
+		{-time.Hour, 20 * time.Minute, 900, 300},
+		{5 * time.Minute, 12 * time.Minute, 300, 120},
+		{11 * time.Minute, 14 * time.Minute, 0, 180},
+		{30 * time.Minute, time.Hour, 1800, 0},
+	} {
+		good, bad := timeInStates(transitions, start.Add(tc.from), start.Add(tc.to))
+		if good != tc.good || bad != tc.bad {
+			t.Errorf("[%v, %v): good %v, bad %v; want %v, %v", tc.from, tc.to, good, bad, tc.good, tc.bad)
+		}
+	}
+}
+
+func TestComputeSLOStatus(t *testing.T) {
+	now := time.Date(2026, 1, 2, 0, 0, 0, 0, time.UTC)
+	slo := SLO{Target: 99, WindowDays: 1}
+
+	// Alive for a day, dead for the last 30 minutes
+	transitions := []TaskTransition{
+		{ToStatus: "alive", TransitionedAt: now.Add(-24 * time.Hour)},
+		{ToStatus: "dead", TransitionedAt: now.Add(-30 * time.Minute)},
+	}
+	status := computeSLOStatus(slo, []sloTask{{Transitions: transitions}}, now)
+
+	if status.GoodSeconds != 84600 || status.BadSeconds != 1800 {
+		t.Errorf("good %v, bad %v; want 84600, 1800", status.GoodSeconds, status.BadSeconds)
+	}
+	if status.Availability == nil || math.Abs(*status.Availability-97.916666) > 1e-4 {
+		t.Errorf("availability %v, want 97.92", status.Availability)
+	}
+	// 1% of a day is 864s, overspent by 936s
+	if math.Abs(status.ErrorBudgetSeconds-864) > 1e-6 || math.Abs(status.ErrorBudgetRemainingSeconds+936) > 1e-6 {
+		t.Errorf("budget %v, remaining %v; want 864, -936", status.ErrorBudgetSeconds, status.ErrorBudgetRemainingSeconds)
+	}
+	for window, want := range map[string]float64{"5m": 100, "30m": 100, "1h": 50, "6h": 100.0 / 12} {
+		if math.Abs(status.BurnRates[window]-want) > 1e-6 {
+			t.Errorf("%s burn rate %v, want %v", window, status.BurnRates[window], want)
+		}
+	}
+	// The slow burn needs 6x over six hours
+	if len(status.Alerts) != 2 || status.Alerts[0] != "fast_burn" || status.Alerts[1] != "slow_burn" {
+		t.Errorf("alerts %v, want fast_burn and slow_burn", status.Alerts)
+	}
+
+	// Dead for the last 10 minutes: fast over 5m only
+	transitions[1].TransitionedAt = now.Add(-10 * time.Minute)
+	if status := computeSLOStatus(slo, []sloTask{{Transitions: transitions}}, now); len(status.Alerts) != 1 || status.Alerts[0] != "fast_burn" {
+		t.Errorf("alerts after 10 minutes dead: %v, want fast_burn", status.Alerts)
+	}
+	// Recovered 10 minutes ago: the short windows resolve the alerts
+	transitions = append(transitions, TaskTransition{ToStatus: "alive", TransitionedAt: now.Add(-6 * time.Minute)})
+	if status := computeSLOStatus(slo, []sloTask{{Transitions: transitions}}, now); len(status.Alerts) != 0 {
+		t.Errorf("alerts after recovering: %v, want none", status.Alerts)
+	}
+
+	// Nothing tracked yet
+	status = computeSLOStatus(slo, []sloTask{{}}, now)
+	if status.Availability != nil || status.BurnRates["1h"] != 0 || status.ErrorBudgetRemaining != 1 {
+		t.Errorf("status without transitions %+v", status)
+	}
+}
+
+func TestComputeSLOStatusAggregatesTasks(t *testing.T) {
+	now := time.Date(2026, 1, 2, 0, 0, 0, 0, time.UTC)
+	start := now.Add(-time.Hour)
+	tasks := []sloTask{
+		{Transitions: []TaskTransition{{ToStatus: "alive", TransitionedAt: start}}},
+		{Transitions: []TaskTransition{{ToStatus: "alive", TransitionedAt: start}, {ToStatus: "dead", TransitionedAt: now.Add(-15 * time.Minute)}}},
+	}
+	status := computeSLOStatus(SLO{Target: 99, WindowDays: 1}, tasks, now)
+
+	if status.Tasks != 2 || status.GoodSeconds != 6300 || status.BadSeconds != 900 {
+		t.Errorf("tasks %d, good %v, bad %v; want 2, 6300, 900", status.Tasks, status.GoodSeconds, status.BadSeconds)
+	}
+	// The budget is 1% of the two tracked hours, not of two whole days
+	if want := 72.0; math.Abs(status.ErrorBudgetSeconds-want) > 1e-6 {
+		t.Errorf("error budget %v, want %v", status.ErrorBudgetSeconds, want)
+	}
+	if want := (72.0 - 900) / 72; math.Abs(status.ErrorBudgetRemaining-want) > 1e-6 {
+		t.Errorf("error budget remaining %v, want %v", status.ErrorBudgetRemaining, want)
+	}
+	// Over the last 30 minutes one of two tasks was dead half the time
+	if want := 0.25 / 0.01; math.Abs(status.BurnRates["30m"]-want) > 1e-6 {
+		t.Errorf("30m burn rate %v, want %v", status.BurnRates["30m"], want)
+	}
+}
+
+func TestComputeRunsSLOStatus(t *testing.T) {
+	now := time.Date(2026, 1, 2, 0, 0, 0, 0, time.UTC)
+	start := now.Add(-10 * time.Hour)
+
+	// Every 10 minutes for 10 hours, but dead for the last half hour: 57
+	// runs succeeded and 3 were missed
+	task := sloTask{
+		Interval: 600,
+		Transitions: []TaskTransition{
+			{ToStatus: "alive", TransitionedAt: start},
+			{ToStatus: "dead", TransitionedAt: now.Add(-30 * time.Minute)},
+		},
+		Pings: []pingSample{{At: start, Count: 0}, {At: now.Add(-time.Hour), Count: 55}, {At: now.Add(-40 * time.Minute), Count: 57}, {At: now, Count: 57}},
+	}
+	status := computeSLOStatus(SLO{Target: 99, WindowDays: 1, Kind: sloKindRuns}, []sloTask{task}, now)
+
+	if status.GoodRuns != 57 || status.BadRuns != 3 {
+		t.Errorf("good runs %v, bad runs %v; want 57, 3", status.GoodRuns, status.BadRuns)
+	}
+	if status.Availability == nil || math.Abs(*status.Availability-95) > 1e-6 {
+		t.Errorf("availability %v, want 95", status.Availability)
+	}
+	if math.Abs(status.ErrorBudgetRuns-0.6) > 1e-6 || math.Abs(status.ErrorBudgetRemainingRuns+2.4) > 1e-6 {
+		t.Errorf("budget %v runs, remaining %v; want 0.6, -2.4", status.ErrorBudgetRuns, status.ErrorBudgetRemainingRuns)
+	}
+	if want := (0.6 - 3) / 0.6; math.Abs(status.ErrorBudgetRemaining-want) > 1e-6 {
+		t.Errorf("error budget remaining %v, want %v", status.ErrorBudgetRemaining, want)
+	}
+	// Over the last hour 2 runs pinged and 3 were missed
+	if want := 0.6 / 0.01; math.Abs(status.BurnRates["1h"]-want) > 1e-6 {
+		t.Errorf("1h burn rate %v, want %v", status.BurnRates["1h"], want)
+	}
+
+	// A run due every hour is not missed in a 5 minute window while alive
+	task.Interval = 3600
+	task.Transitions = task.Transitions[:1]
+	if status := computeSLOStatus(SLO{Target: 99, WindowDays: 1, Kind: sloKindRuns}, []sloTask{task}, now); status.BurnRates["5m"] != 0 {
+		t.Errorf("5m burn rate of a healthy hourly task %v, want 0", status.BurnRates["5m"])
+	}
+}
+
+func TestPingsBetween(t *testing.T) {
+	start := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	samples := []pingSample{
+		{At: start, Count: 10},
+		{At: start.Add(time.Minute), Count: 12},
+		{At: start.Add(2 * time.Minute), Count: 15},
+	}
+	for _, tc := range []struct {
+		from, to time.Duration
+		want     float64
+	}{
+		{-time.Hour, time.Hour, 5},
+		{0, time.Minute, 2},
+		{30 * time.Second, 90 * time.Second, 2},
+		{90 * time.Second, time.Hour, 3},
+		{time.Hour, 2 * time.Hour, 0},
+	} {
+		if got := pingsBetween(samples, start.Add(tc.from), start.Add(tc.to)); got != tc.want {
+			t.Errorf("[%v, %v): %v pings, want %v", tc.from, tc.to, got, tc.want)
+		}
+	}
+}
+
+func TestTagSLO(t *testing.T) {
+	api := newTestAPI(t)
+	aliceID, alice := api.signup("alice")
+	bobID, bob := api.signup("bob")
+	createTagged := func(token string, userID, taskNumber int, tags []string) {
+		expect(t, api.do("POST", "/api/tasks", token, map[string]interface{}{
+			"user_id": userID, "name": "task", "task_number": taskNumber, "interval": 60, "tags": tags,
+		}), http.StatusCreated, nil)
+	}
+	createTagged(alice, aliceID, 1, []string{"db"})
+	createTagged(alice, aliceID, 2, []string{"db", "nightly"})
+	createTagged(alice, aliceID, 3, nil)
+	createTagged(bob, bobID, 4, []string{"db"})
+
+	expect(t, api.do("POST", "/api/slos", alice, map[string]interface{}{
+		"task_id": 1, "tag": "db", "name": "databases", "target": 99,
+	}), http.StatusBadRequest, nil)
+	expect(t, api.do("POST", "/api/slos", alice, map[string]interface{}{
+		"name": "databases", "target": 99,
+	}), http.StatusBadRequest, nil)
+	expect(t, api.do("POST", "/api/slos", alice, map[string]interface{}{
+		"tag": "db", "user_id": bobID, "name": "databases", "target": 99,
+	}), http.StatusForbidden, nil)
+
+	var slo SLO
+	expect(t, api.do("POST", "/api/slos", alice, map[string]interface{}{
+		"tag": " DB ", "name": "databases", "target": 99, "window_days": 1,
+	}), http.StatusCreated, &slo)
+	if slo.Tag != "db" || slo.TaskID != 0 || slo.UserID != int64(aliceID) {
+		t.Fatalf("created SLO %+v", slo)
+	}
+
+	// Task 1 pings in time, task 2 dies at its deadline
+	api.advance(50 * time.Second)
+	expect(t, api.do("POST", "/tasks/1/heartbeat", "", nil), http.StatusOK, nil)
+	api.advance(40 * time.Second)
+	monitorPass(t, api.store, nil)
+	api.advance(10 * time.Second)
+
+	path := fmt.Sprintf("/api/slos/%d", slo.ID)
+	var got sloWithStatus
+	expect(t, api.do("GET", path, alice, nil), http.StatusOK, &got)
+	if got.Status.Tasks != 2 || got.Status.GoodSeconds != 160 || got.Status.BadSeconds != 40 {
+		t.Errorf("status over tasks %d: good %v, bad %v; want 2, 160, 40",
+			got.Status.Tasks, got.Status.GoodSeconds, got.Status.BadSeconds)
+	}
+	expect(t, api.do("GET", path, bob, nil), http.StatusForbidden, nil)
+
+	var listed []sloWithStatus
+	expect(t, api.do("GET", fmt.Sprintf("/api/slos?user_id=%d", aliceID), alice, nil), http.StatusOK, &listed)
+	if len(listed) != 1 || listed[0].ID != slo.ID {
+		t.Errorf("listed %+v, want the tag SLO", listed)
+	}
+	expect(t, api.do("GET", fmt.Sprintf("/api/slos?user_id=%d", bobID), bob, nil), http.StatusOK, &listed)
+	if len(listed) != 0 {
+		t.Errorf("bob's SLOs %+v, want none", listed)
+	}
+
+	expect(t, api.do("DELETE", path, alice, nil), http.StatusOK, nil)
+	expect(t, api.do("GET", path, alice, nil), http.StatusNotFound, nil)
+}
+
+func TestSQLiteTagSLOs(t *testing.T) {
+	ctx := context.Background()
+	store, err := openSQLiteStore(ctx, "sqlite://"+filepath.Join(t.TempDir(), "slos.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { store.db.Close() })
+	if err := store.Migrate(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	user, err := store.CreateUser(ctx, "alice", "alice@example.com", "hash")
+	if err != nil {
+		t.Fatal(err)
+	}
+	task, err := store.CreateTask(ctx, Task{Name: "backup", UserID: int64(user.ID), Interval: 60, TaskNumber: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	taskSLO, err := store.CreateSLO(ctx, SLO{TaskID: task.ID, Name: "backup", Target: 99, WindowDays: 28})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tagSLO, err := store.CreateSLO(ctx, SLO{Tag: "db", UserID: int64(user.ID), Name: "databases", Target: 99.5, WindowDays: 7})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tagSLO.Tag != "db" || tagSLO.TaskID != 0 || tagSLO.UserID != int64(user.ID) || taskSLO.UserID != int64(user.ID) {
+		t.Fatalf("created %+v and %+v", taskSLO, tagSLO)
+	}
+
+	slos, err := store.ListSLOs(ctx, int64(user.ID), 0)
+	if err != nil || len(slos) != 2 || slos[1].ID != tagSLO.ID {
+		t.Fatalf("ListSLOs = %+v, %v; want both SLOs", slos, err)
+	}
+
+	tagSLO.Target = 99.9
+	if updated, err := store.UpdateSLO(ctx, tagSLO); err != nil || updated.Target != 99.9 || updated.Tag != "db" {
+		t.Errorf("UpdateSLO = %+v, %v", updated, err)
+	}
+	if err := store.DeleteSLO(ctx, tagSLO.ID); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.GetSLO(ctx, tagSLO.ID); err != ErrNotFound {
+		t.Errorf("GetSLO of a deleted SLO: %v", err)
+	}
+
+	// The migration rolls back with the task SLOs intact
+	latest, err := latestMigrationVersion(sqliteMigrationsDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := store.MigrateSchema(ctx, "down", int(latest-17)); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.MigrateSchema(ctx, "up", 0); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := store.GetSLO(ctx, taskSLO.ID); err != nil || got.Name != "backup" {
+		t.Errorf("task SLO after the rollback: %+v, %v", got, err)
+	}
+}
+
+func TestSLOAPI(t *testing.T) {
+	api := newTestAPI(t)
+	userID, token := api.signup("alice")
+	task := api.createTask(token, userID, "backup", 7, 60)
+
+	for _, body := range []map[string]interface{}{
+		{"task_id": task.ID, "target": 99.5},
+		{"task_id": task.ID, "name": "backup", "target": 100},
+		{"task_id": task.ID, "name": "backup", "target": 99.5, "window_days": 400},
+		{"task_id": task.ID, "name": "backup", "target": 99.5, "webhook_url": "ftp://example.com"},
+		{"task_id": task.ID, "name": "backup", "target": 99.5, "email": "nobody"},
+		{"task_id": task.ID + 1, "name": "backup", "target": 99.5},
+	} {
+		expect(t, api.do("POST", "/api/slos", token, body), http.StatusBadRequest, nil)
+	}
+
+	var slo SLO
+	expect(t, api.do("POST", "/api/slos", token, map[string]interface{}{
+		"task_id": task.ID, "name": " backup ", "target": 99,
+	}), http.StatusCreated, &slo)
+	if slo.Name != "backup" || slo.WindowDays != defaultSLOWindowDays || slo.UserID != int64(userID) {
+		t.Fatalf("created SLO %+v", slo)
+	}
+
+	// Dead from the deadline a minute after creation
+	api.advance(90 * time.Second)
+	monitorPass(t, api.store, nil)
+	api.advance(30 * time.Second)
+
+	path := fmt.Sprintf("/api/slos/%d", slo.ID)
+	var got sloWithStatus
+	expect(t, api.do("GET", path, token, nil), http.StatusOK, &got)
+	if got.Status.GoodSeconds != 60 || got.Status.BadSeconds != 60 || math.Abs(got.Status.BurnRates["5m"]-50) > 1e-6 {
+		t.Errorf("status %+v, want 60s good, 60s bad", got.Status)
+	}
+
+	var listed []sloWithStatus
+	expect(t, api.do("GET", fmt.Sprintf("/api/slos?user_id=%d", userID), token, nil), http.StatusOK, &listed)
+	if len(listed) != 1 || listed[0].ID != slo.ID {
+		t.Errorf("listed %+v, want the SLO", listed)
+	}
+	expect(t, api.do("GET", "/api/slos", token, nil), http.StatusBadRequest, nil)
+
+	var updated SLO
+	expect(t, api.do("PUT", path, token, map[string]interface{}{
+		"name": "nightly backup", "target": 99.9, "window_days": 7,
+	}), http.StatusOK, &updated)
+	if updated.Name != "nightly backup" || updated.Target != 99.9 || updated.WindowDays != 7 || updated.TaskID != task.ID {
+		t.Errorf("updated SLO %+v", updated)
+	}
+
+	expect(t, api.do("DELETE", path, token, nil), http.StatusOK, nil)
+	expect(t, api.do("GET", path, token, nil), http.StatusNotFound, nil)
+	expect(t, api.do("DELETE", path, token, nil), http.StatusNotFound, nil)
+}
+
+func TestRunsSLOAPI(t *testing.T) {
+	api := newTestAPI(t)
+	userID, token := api.signup("alice")
+	task := api.createTask(token, userID, "backup", 7, 60)
+	expect(t, api.do("POST", "/api/slos", token, map[string]interface{}{
+		"task_id": task.ID, "name": "backup", "target": 99, "kind": "hourly",
+	}), http.StatusBadRequest, nil)
+
+	var slo SLO
+	expect(t, api.do("POST", "/api/slos", token, map[string]interface{}{
+		"task_id": task.ID, "name": "backup", "target": 99, "kind": "runs",
+	}), http.StatusCreated, &slo)
+	if slo.Kind != sloKindRuns {
+		t.Fatalf("created SLO %+v", slo)
+	}
+
+	// Two runs ping, then the task misses its deadline and stays dead for
+	// another interval
+	monitorPass(t, api.store, nil)
+	for i := 0; i < 2; i++ {
+		api.advance(30 * time.Second)
+		expect(t, api.do("POST", "/tasks/7/heartbeat", "", nil), http.StatusOK, nil)
+		monitorPass(t, api.store, nil)
+	}
+	api.advance(120 * time.Second)
+	monitorPass(t, api.store, nil)
+
+	var got sloWithStatus
+	expect(t, api.do("GET", fmt.Sprintf("/api/slos/%d", slo.ID), token, nil), http.StatusOK, &got)
+	if got.Status.GoodRuns != 2 || got.Status.BadRuns != 1 {
+		t.Errorf("good runs %v, bad runs %v; want 2, 1", got.Status.GoodRuns, got.Status.BadRuns)
+	}
+	if got.Status.Availability == nil || math.Abs(*got.Status.Availability-200.0/3) > 1e-6 {
+		t.Errorf("availability %v, want 66.7", got.Status.Availability)
+	}
+}
+
+func TestStoreSLOs(t *testing.T) {
+	forEachStore(t, func(t *testing.T, store Store, clock *time.Time) {
+		ctx := context.Background()
+		task := createMonitorTestTask(t, store)
+
+		created, err := store.CreateSLO(ctx, SLO{TaskID: task.ID, Name: "backup", Target: 99.5, WindowDays: 28, Email: "ops@example.com"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if created.ID == 0 || created.UserID != task.UserID || !created.CreatedAt.Equal(*clock) {
+			t.Fatalf("CreateSLO = %+v", created)
+		}
+		got, err := store.GetSLO(ctx, created.ID)
+		if err != nil || got.Name != "backup" || got.Email != "ops@example.com" || got.UserID != task.UserID {
+			t.Errorf("GetSLO = %+v, %v", got, err)
+		}
+
+		for userID, want := range map[int64]int{task.UserID: 1, task.UserID + 1: 0, 0: 1} {
+			if slos, err := store.ListSLOs(ctx, userID, 0); err != nil || len(slos) != want {
+				t.Errorf("ListSLOs(%d) = %+v, %v; want %d", userID, slos, err, want)
+			}
+		}
+
+		got.Target, got.Email = 99.9, ""
+		if updated, err := store.UpdateSLO(ctx, got); err != nil || updated.Target != 99.9 || updated.Email != "" {
+			t.Errorf("UpdateSLO = %+v, %v", updated, err)
+		}
+
+		// Only one of two servers claims the alert starting to fire
+		firing := []string{"fast_burn", "slow_burn"}
+		for i, want := range []bool{true, false} {
+			if claimed, err := store.ClaimSLOAlerts(ctx, created.ID, nil, firing); err != nil || claimed != want {
+				t.Errorf("ClaimSLOAlerts #%d = %v, %v; want %v", i+1, claimed, err, want)
+			}
+		}
+		if got, err := store.GetSLO(ctx, created.ID); err != nil || !reflect.DeepEqual(got.FiringAlerts, firing) {
+			t.Errorf("firing alerts = %v, %v; want %v", got.FiringAlerts, err, firing)
+		}
+		if claimed, err := store.ClaimSLOAlerts(ctx, created.ID, firing, nil); err != nil || !claimed {
+			t.Errorf("ClaimSLOAlerts resolving = %v, %v", claimed, err)
+		}
+
+		// Deleting the task deletes its SLOs
+		if err := store.DeleteTask(ctx, task.ID); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := store.GetSLO(ctx, created.ID); !errors.Is(err, ErrNotFound) {
+			t.Errorf("GetSLO after deleting the task: %v, want ErrNotFound", err)
+		}
+		if err := store.DeleteSLO(ctx, created.ID); !errors.Is(err, ErrNotFound) {
+			t.Errorf("DeleteSLO of a deleted SLO: %v, want ErrNotFound", err)
+		}
+	})
+}
+
+func TestNotifySLOAlert(t *testing.T) {
+	type delivery struct {
+		path  string
+		alert SLOAlert
+	}
+	alerts := make(chan delivery, 2)
+	hook := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var alert SLOAlert
+		if err := json.NewDecoder(r.Body).Decode(&alert); err != nil {
+			t.Errorf("decoding alert: %v", err)
+		}
+		alerts <- delivery{r.URL.Path, alert}
+	}))
+	defer hook.Close()
+
+	store := NewMemoryStore()
+	server := NewServer(store)
+	ctx := context.Background()
+	task := createMonitorTestTask(t, store)
+
+	slo := SLO{ID: 1, TaskID: task.ID, Name: "backup", Target: 99, WindowDays: 28, WebhookURL: hook.URL + "/slo"}
+	status := SLOStatus{BurnRates: map[string]float64{"1h": 20, "5m": 30}, ErrorBudgetRemaining: 0.5}
+	server.notifySLOAlert(ctx, slo, status, burnRateAlerts[0], true)
+
+	got := <-alerts
+	want := `SLO "backup" (99% over 28 days) is burning its error budget: 20.0x over 1h, 30.0x over 5m; 50.0% of the budget left`
+	if got.path != "/slo" || got.alert.Event != "slo_burn_rate_firing" || got.alert.Alert != "fast_burn" || got.alert.Message != want {
+		t.Errorf("alert %+v", got)
+	}
+
+	// Without its own webhook the SLO falls back to SLO_ALERT_WEBHOOK_URL
+	t.Setenv("SLO_ALERT_WEBHOOK_URL", hook.URL+"/default")
+	slo.WebhookURL = ""
+	server.notifySLOAlert(ctx, slo, status, burnRateAlerts[0], false)
+	if got := <-alerts; got.path != "/default" || got.alert.Event != "slo_burn_rate_resolved" {
+		t.Errorf("resolved alert %+v", got)
+	}
+
+	// A notification route matching the task replaces the fallback
+	if _, err := store.CreateNotificationRoute(ctx, NotificationRoute{UserID: task.UserID, WebhookURL: hook.URL + "/route"}); err != nil {
+		t.Fatal(err)
+	}
+	server.notifySLOAlert(ctx, slo, status, burnRateAlerts[0], true)
+	if got := <-alerts; got.path != "/route" {
+		t.Errorf("alert sent to %s, want the route", got.path)
+	}
+	select {
+	case got := <-alerts:
+		t.Errorf("alert also sent to %s", got.path)
+	default:
+	}
+}
+
+func TestEvaluateSLOAlertsOnce(t *testing.T) {
+	alerts := make(chan string, 4)
+	hook := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var alert SLOAlert
+		if err := json.NewDecoder(r.Body).Decode(&alert); err != nil {
+			t.Errorf("decoding alert: %v", err)
+		}
+		alerts <- alert.Event + " " + alert.Alert
+	}))
+	defer hook.Close()
+
+	api := newTestAPI(t)
+	userID, token := api.signup("alice")
+	task := api.createTask(token, userID, "backup", 1, 60)
+	expect(t, api.do("POST", "/api/slos", token, map[string]interface{}{
+		"task_id": task.ID, "name": "backup", "target": 99, "webhook_url": hook.URL,
+	}), http.StatusCreated, nil)
+
+	// The task goes dead and burns the budget; two servers and a restarted
+	// one notify only once
+	api.advance(2 * time.Minute)
+	monitorPass(t, api.store, nil)
+	api.advance(10 * time.Minute)
+	ctx := context.Background()
+	for i := 0; i < 3; i++ {
+		NewServer(api.store).evaluateSLOAlerts(ctx)
+	}
+	for _, want := range []string{"slo_burn_rate_firing fast_burn", "slo_burn_rate_firing slow_burn"} {
+		if got := <-alerts; got != want {
+			t.Errorf("alert %q, want %q", got, want)
+		}
+	}
+	select {
+	case got := <-alerts:
+		t.Errorf("also notified %q", got)
+	default:
+	}
+}
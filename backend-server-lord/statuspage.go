@@ -0,0 +1,561 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"html/template"
+	"log"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// StatusPage shares the health of selected tasks at /status/{slug} without an
+// account. A page is public, protected by a password (HTTP basic auth, any
+// username) or protected by an access token (?token= or a bearer token).
+// Pages of an organization (OrgID) show its tasks, personal pages the tasks
+// of their user.
+type StatusPage struct {
+	ID         int64   `json:"id"`
+	UserID     int64   `json:"user_id"`
+	OrgID      int64   `json:"org_id,omitempty"`
+	Slug       string  `json:"slug"`
+	Title      string  `json:"title"`
+	TaskIDs    []int64 `json:"task_ids"`
+	Protection string  `json:"protection"`
+	// Password is only set from requests; the store keeps PasswordHash
+	Password     string    `json:"-"`
+	PasswordHash string    `json:"-"`
+	AccessToken  string    `json:"access_token,omitempty"`
+	CreatedAt    time.Time `json:"created_at"`
+}
+
+// createStatusPageRequest is the body of POST /api/status-pages
+type createStatusPageRequest struct {
+	UserID     int64   `json:"user_id" validate:"min=1"`
+	OrgID      int64   `json:"org_id" validate:"min=1"`
+	Slug       string  `json:"slug" validate:"required"`
+	Title      string  `json:"title" validate:"max=255"`
+	TaskIDs    []int64 `json:"task_ids"`
+	Protection string  `json:"protection" validate:"oneof=public password token"`
+	Password   string  `json:"password" validate:"maxbytes=72"`
+}
+
+// updateStatusPageRequest is the body of PUT /api/status-pages/{id}, which
+// replaces the editable fields of a page
+type updateStatusPageRequest struct {
+	Slug       string  `json:"slug" validate:"required"`
+	Title      string  `json:"title" validate:"max=255"`
+	TaskIDs    []int64 `json:"task_ids"`
+	Protection string  `json:"protection" validate:"oneof=public password token"`
+	Password   string  `json:"password" validate:"maxbytes=72"`
+}
+
+// Number of days of history shown on a status page, today included
+const statusPageHistoryDays = 90
+
+var statusPageSlug = regexp.MustCompile(`^[a-z0-9][a-z0-9-]{0,63}$`)
+
+// StatusPageDay is the availability of a task over one UTC day
+type StatusPageDay struct {
+	Date            string   `json:"date"`
+	Uptime          *float64 `json:"uptime"` // percent, null without data
+	UptimeSeconds   float64  `json:"uptime_seconds"`
+	DowntimeSeconds float64  `json:"downtime_seconds"`
+}
+
+// StatusPageTask is the current state and history of a task on a status page.
+// Ping URLs and IDs are left out as the page is shared outside the team.
+type StatusPageTask struct {
+	Name     string          `json:"name"`
+	Status   string          `json:"status"`
+	LastPing *time.Time      `json:"last_ping"`
+	Interval int             `json:"interval"`
+	Uptime   *float64        `json:"uptime"` // percent over the history
+	History  []StatusPageDay `json:"history"`
+}
+
+// StatusPageView is what /status/{slug} renders
+type StatusPageView struct {
+	Title     string           `json:"title"`
+	Slug      string           `json:"slug"`
+	Status    string           `json:"status"` // operational, degraded or outage
+	UpdatedAt time.Time        `json:"updated_at"`
+	Days      int              `json:"days"`
+	Tasks     []StatusPageTask `json:"tasks"`
+}
+
+// newAccessToken returns an unguessable token for protected pages
+func newAccessToken() (string, error) {
+	b := make([]byte, 24)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// prepareStatusPage validates a page from a request and sets its protection
+// secrets. existing is the stored page on updates, whose password and token
+// are kept unless replaced.
+func (s *Server) prepareStatusPage(ctx context.Context, page *StatusPage, existing *StatusPage) error {
+	page.Slug = strings.ToLower(strings.TrimSpace(page.Slug))
+	if !statusPageSlug.MatchString(page.Slug) {
+		return fmt.Errorf("slug must be 1-64 lowercase letters, digits or dashes")
+	}
+	if page.Title == "" {
+		page.Title = page.Slug
+	}
+	if page.TaskIDs == nil {
+		page.TaskIDs = []int64{}
+	}
+	for _, id := range page.TaskIDs {
+		task, err := s.tasks.GetTask(ctx, id)
+		if err != nil || !(TaskFilter{OrgID: page.OrgID}).lists(task, page.UserID) {
+			return fmt.Errorf("task %d does not exist", id)
+		}
+	}
+
+	if page.Protection == "" {
+		page.Protection = "public"
+	}
+	page.PasswordHash, page.AccessToken = "", ""
+	switch page.Protection {
+	case "public":
+	case "password":
+		if page.Password == "" {
+			if existing == nil || existing.PasswordHash == "" {
+				return fmt.Errorf("password is required for password protected pages")
+			}
+			page.PasswordHash = existing.PasswordHash
+			break
+		}
+		hash, err := bcrypt.GenerateFromPassword([]byte(page.Password), bcrypt.DefaultCost)
+		if err != nil {
+			return err
+		}
+		page.PasswordHash = string(hash)
+	case "token":
+		if existing != nil && existing.AccessToken != "" {
+			page.AccessToken = existing.AccessToken
+			break
+		}
+		token, err := newAccessToken()
+		if err != nil {
+			return err
+		}
+		page.AccessToken = token
+	}
+	page.Password = ""
+	return nil
+}
+
+// getStatusPages lists the status pages of a user (?user_id=) or
+// organization (?org_id=)
+func (s *Server) getStatusPages(w http.ResponseWriter, r *http.Request) {
+	userID, orgID, ok := s.ownerFromQuery(w, r, roleViewer)
+	if !ok {
+		return
+	}
+
+	pages, err := s.statusPages.ListStatusPages(r.Context(), userID, orgID)
+	if err != nil {
+		log.Printf("Error retrieving status pages for user %d, organization %d: %v", userID, orgID, err)
+		respondWithError(w, http.StatusInternalServerError, "Error retrieving status pages")
+		return
+	}
+	respondWithJSON(w, http.StatusOK, pages)
+}
+
+func (s *Server) createStatusPage(w http.ResponseWriter, r *http.Request) {
+	var req createStatusPageRequest
+	if !decodeJSON(w, r, &req) {
+		return
+	}
+	page := StatusPage{
+		UserID:     req.UserID,
+		OrgID:      req.OrgID,
+		Slug:       req.Slug,
+		Title:      req.Title,
+		TaskIDs:    req.TaskIDs,
+		Protection: req.Protection,
+		Password:   req.Password,
+	}
+
+	// Pages are created by the caller, for themselves or for an organization
+	// they can edit
+	if page.UserID == 0 {
+		page.UserID = authUserID(r)
+	}
+	if page.UserID != authUserID(r) {
+		respondWithError(w, http.StatusForbidden, "Insufficient permissions")
+		return
+	}
+	if !s.authorize(w, r, page.UserID, page.OrgID, roleEditor) {
+		return
+	}
+	if err := s.prepareStatusPage(r.Context(), &page, nil); err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	created, err := s.statusPages.CreateStatusPage(r.Context(), page)
+	if err != nil {
+		if errors.Is(err, ErrConflict) {
+			respondWithError(w, http.StatusConflict, "Slug is already taken")
+		} else {
+			log.Printf("Error creating status page: %v", err)
+			respondWithError(w, http.StatusInternalServerError, "Error creating status page")
+		}
+		return
+	}
+
+	log.Printf("Status page created successfully with ID: %d (/status/%s)", created.ID, created.Slug)
+	respondWithJSON(w, http.StatusCreated, created)
+}
+
+// statusPageFromRequest loads the page named by the {id} path variable and
+// checks that the authenticated user has at least the required role on it. It
+// writes the error response itself and returns ok=false on failure.
+func (s *Server) statusPageFromRequest(w http.ResponseWriter, r *http.Request, required string) (StatusPage, bool) {
+	vars := mux.Vars(r)
+	id, err := strconv.ParseInt(vars["id"], 10, 64)
+	if err != nil {
+		log.Printf("Invalid status page ID: %s", vars["id"])
+		respondWithError(w, http.StatusBadRequest, "Invalid status page ID")
+		return StatusPage{}, false
+	}
+
+	page, err := s.statusPages.GetStatusPage(r.Context(), id)
+	if err != nil {
+		if errors.Is(err, ErrNotFound) {
+			respondWithError(w, http.StatusNotFound, "Status page not found")
+		} else {
+			log.Printf("Error retrieving status page: %v", err)
+			respondWithError(w, http.StatusInternalServerError, "Error retrieving status page")
+		}
+		return StatusPage{}, false
+	}
+	if !s.authorize(w, r, page.UserID, page.OrgID, required) {
+		return StatusPage{}, false
+	}
+	return page, true
+}
+
+func (s *Server) getStatusPage(w http.ResponseWriter, r *http.Request) {
+	page, ok := s.statusPageFromRequest(w, r, roleViewer)
+	if !ok {
+		return
+	}
+	respondWithJSON(w, http.StatusOK, page)
+}
+
+// updateStatusPage replaces the page. The password and access token are kept
+// while the protection is unchanged and no new password is given.
+func (s *Server) updateStatusPage(w http.ResponseWriter, r *http.Request) {
+	existing, ok := s.statusPageFromRequest(w, r, roleEditor)
+	if !ok {
+		return
+	}
+
+	var req updateStatusPageRequest
+	if !decodeJSON(w, r, &req) {
+		return
+	}
+	page := StatusPage{
+		ID:         existing.ID,
+		UserID:     existing.UserID,
+		OrgID:      existing.OrgID,
+		Slug:       req.Slug,
+		Title:      req.Title,
+		TaskIDs:    req.TaskIDs,
+		Protection: req.Protection,
+		Password:   req.Password,
+	}
+	if err := s.prepareStatusPage(r.Context(), &page, &existing); err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	updated, err := s.statusPages.UpdateStatusPage(r.Context(), page)
+	if err != nil {
+		switch {
+		case errors.Is(err, ErrConflict):
+			respondWithError(w, http.StatusConflict, "Slug is already taken")
+		case errors.Is(err, ErrNotFound):
+			respondWithError(w, http.StatusNotFound, "Status page not found")
+		default:
+			log.Printf("Error updating status page: %v", err)
+			respondWithError(w, http.StatusInternalServerError, "Error updating status page")
+		}
+		return
+	}
+
+	log.Printf("Status page updated successfully: %d", updated.ID)
+	respondWithJSON(w, http.StatusOK, updated)
+}
+
+func (s *Server) deleteStatusPage(w http.ResponseWriter, r *http.Request) {
+	page, ok := s.statusPageFromRequest(w, r, roleEditor)
+	if !ok {
+		return
+	}
+
+	if err := s.statusPages.DeleteStatusPage(r.Context(), page.ID); err != nil && !errors.Is(err, ErrNotFound) {
+		log.Printf("Error deleting status page: %v", err)
+		respondWithError(w, http.StatusInternalServerError, "Error deleting status page")
+		return
+	}
+
+	log.Printf("Status page deleted successfully: %d", page.ID)
+	respondWithJSON(w, http.StatusOK, map[string]string{"message": "Status page deleted successfully"})
+}
+
+// statusPageAuthorized checks the password or access token protecting the page
+func statusPageAuthorized(r *http.Request, page StatusPage) bool {
+	switch page.Protection {
+	case "password":
+		_, password, ok := r.BasicAuth()
+		return ok && bcrypt.CompareHashAndPassword([]byte(page.PasswordHash), []byte(password)) == nil
+	case "token":
+		token := r.URL.Query().Get("token")
+		if token == "" {
+			token = strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+		}
+		return subtle.ConstantTimeCompare([]byte(token), []byte(page.AccessToken)) == 1
+	default:
+		return true
+	}
+}
+
+// statusPageHistory returns the daily availability of a task over the last
+// statusPageHistoryDays days, today included
+func (s *Server) statusPageHistory(ctx context.Context, taskID int64, now time.Time) ([]StatusPageDay, error) {
+	step := 24 * time.Hour
+	today := alignToStep(now, step)
+	window := graphWindow{From: today.AddDate(0, 0, -(statusPageHistoryDays - 1)), To: now, Step: step}
+	window.Tier = graphTierFor(s.graphTiers, now.Sub(window.From), now.Sub(window.From))
+
+	points, err := s.querySeries(ctx, GraphQuery{TaskID: taskID}, window, 1)
+	if err != nil {
+		return nil, err
+	}
+
+	// Today is still open in the daily rollups, read it from a finer tier
+	if !window.Tier.IsRaw() && len(points) > 0 {
+		todayWindow := graphWindow{From: today, To: now, Step: step}
+		todayWindow.Tier = graphTierFor(s.graphTiers, now.Sub(today), now.Sub(today))
+		todayPoints, err := s.querySeries(ctx, GraphQuery{TaskID: taskID}, todayWindow, 1)
+		if err != nil {
+			return nil, err
+		}
+		if len(todayPoints) > 0 {
+			points[len(points)-1] = todayPoints[0]
+		}
+	}
+
+	days := make([]StatusPageDay, len(points))
+	for i, p := range points {
+		days[i] = StatusPageDay{
+			Date:            p.Timestamp[:len("2006-01-02")],
+			UptimeSeconds:   p.UptimeSeconds,
+			DowntimeSeconds: p.DowntimeSeconds,
+		}
+		if p.UptimeRatio != nil {
+			uptime := *p.UptimeRatio * 100
+			days[i].Uptime = &uptime
+		}
+	}
+	return days, nil
+}
+
+// buildStatusPageView gathers the current state of the page's tasks from the
+// tasks table and their history from the graph data
+func (s *Server) buildStatusPageView(ctx context.Context, page StatusPage) (StatusPageView, error) {
+	view := StatusPageView{
+		Title:  page.Title,
+		Slug:   page.Slug,
+		Status: "operational",
+		Days:   statusPageHistoryDays,
+		Tasks:  []StatusPageTask{},
+	}
+
+	now, err := s.monitor.Now(ctx)
+	if err != nil {
+		return view, err
+	}
+	view.UpdatedAt = now
+
+	tasks, err := s.tasks.ListUserTasks(ctx, page.UserID, TaskFilter{OrgID: page.OrgID})
+	if err != nil {
+		return view, err
+	}
+	byID := make(map[int64]Task, len(tasks))
+	for _, task := range tasks {
+		byID[task.ID] = task
+	}
+
+	dead := 0
+	for _, id := range page.TaskIDs {
+		task, ok := byID[id]
+		if !ok {
+			// Deleted since the page was saved
+			continue
+		}
+
+		history, err := s.statusPageHistory(ctx, task.ID, now)
+		if err != nil {
+			return view, err
+		}
+		var uptime, downtime float64
+		for _, day := range history {
+			uptime += day.UptimeSeconds
+			downtime += day.DowntimeSeconds
+		}
+
+		st := StatusPageTask{
+			Name:     task.Name,
+			Status:   task.Status,
+			LastPing: task.LastPing,
+			Interval: task.Interval,
+			History:  history,
+		}
+		if tracked := uptime + downtime; tracked > 0 {
+			percent := uptime / tracked * 100
+			st.Uptime = &percent
+		}
+		if task.Status == "dead" {
+			dead++
+		}
+		view.Tasks = append(view.Tasks, st)
+	}
+
+	switch {
+	case dead > 0 && dead == len(view.Tasks):
+		view.Status = "outage"
+	case dead > 0:
+		view.Status = "degraded"
+	}
+	return view, nil
+}
+
+// dayClass buckets a day's uptime into the colour shown on the status page
+func dayClass(uptime *float64) string {
+	switch {
+	case uptime == nil:
+		return "nodata"
+	case *uptime >= 99.9:
+		return "up"
+	case *uptime >= 95:
+		return "degraded"
+	default:
+		return "down"
+	}
+}
+
+var statusPageTemplate = template.Must(template.New("status").Funcs(template.FuncMap{
+	"uptime":   formatUptime,
+	"dayClass": dayClass,
+	"datetime": func(t time.Time) string { return t.Format("2006-01-02 15:04 MST") },
+}).Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<title>{{.Title}} status</title>
+<style>
+body { font-family: -apple-system, Helvetica, Arial, sans-serif; color: #222; max-width: 860px; margin: 24px auto; padding: 0 12px; }
+.banner { padding: 12px 16px; border-radius: 6px; color: #fff; font-weight: bold; margin-bottom: 24px; }
+.banner.operational { background: #27ae60; }
+.banner.degraded { background: #e67e22; }
+.banner.outage { background: #c0392b; }
+.task { border: 1px solid #ddd; border-radius: 6px; padding: 12px 16px; margin-bottom: 12px; }
+.task h2 { font-size: 16px; margin: 0 0 8px; display: flex; justify-content: space-between; }
+.alive { color: #27ae60; }
+.dead { color: #c0392b; }
+.history { display: flex; gap: 2px; height: 32px; }
+.history span { flex: 1; border-radius: 2px; }
+.history .up { background: #27ae60; }
+.history .degraded { background: #f1c40f; }
+.history .down { background: #c0392b; }
+.history .nodata { background: #ddd; }
+.legend { display: flex; justify-content: space-between; color: #888; font-size: 12px; margin-top: 4px; }
+footer { color: #888; font-size: 12px; margin-top: 24px; }
+</style>
+</head>
+<body>
+<h1>{{.Title}}</h1>
+<div class="banner {{.Status}}">{{if eq .Status "operational"}}All systems operational{{else if eq .Status "degraded"}}Some systems are down{{else}}All systems are down{{end}}</div>
+{{range .Tasks}}<div class="task">
+<h2><span>{{.Name}}</span><span class="{{.Status}}">{{.Status}}</span></h2>
+<div class="history">{{range .History}}<span class="{{dayClass .Uptime}}" title="{{.Date}}: {{uptime .Uptime}}"></span>{{end}}</div>
+<div class="legend"><span>{{$.Days}} days ago</span><span>{{uptime .Uptime}} uptime</span><span>Today</span></div>
+</div>
+{{else}}<p>No tasks are shared on this page.</p>
+{{end}}
+<footer>Updated {{datetime .UpdatedAt}}</footer>
+</body>
+</html>
+`))
+
+// wantsJSON reports whether the request asks for the JSON feed
+func wantsJSON(r *http.Request) bool {
+	if format := r.URL.Query().Get("format"); format != "" {
+		return format == "json"
+	}
+	return strings.Contains(r.Header.Get("Accept"), "application/json")
+}
+
+// serveStatusPage renders /status/{slug} as HTML, or as JSON for ?format=json
+// or an application/json Accept header
+func (s *Server) serveStatusPage(w http.ResponseWriter, r *http.Request) {
+	slug := mux.Vars(r)["slug"]
+
+	page, err := s.statusPages.GetStatusPageBySlug(r.Context(), slug)
+	if err != nil {
+		if errors.Is(err, ErrNotFound) {
+			respondWithError(w, http.StatusNotFound, "Status page not found")
+		} else {
+			log.Printf("Error retrieving status page %s: %v", slug, err)
+			respondWithError(w, http.StatusInternalServerError, "Error retrieving status page")
+		}
+		return
+	}
+
+	if !statusPageAuthorized(r, page) {
+		if page.Protection == "password" {
+			w.Header().Set("WWW-Authenticate", fmt.Sprintf("Basic realm=%q", page.Title))
+		}
+		respondWithError(w, http.StatusUnauthorized, "This status page is protected")
+		return
+	}
+
+	view, err := s.buildStatusPageView(r.Context(), page)
+	if err != nil {
+		log.Printf("Error building status page %s: %v", slug, err)
+		respondWithError(w, http.StatusInternalServerError, "Error retrieving status page")
+		return
+	}
+
+	if page.Protection != "public" {
+		w.Header().Set("Cache-Control", "private, no-store")
+	}
+	if wantsJSON(r) {
+		respondWithJSON(w, http.StatusOK, view)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(http.StatusOK)
+	if err := statusPageTemplate.Execute(w, view); err != nil {
+		log.Printf("Error rendering status page %s: %v", slug, err)
+	}
+}
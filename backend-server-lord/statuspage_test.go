@@ -0,0 +1,101 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+func TestStoreStatusPages(t *testing.T) {
+	forEachStore(t, func(t *testing.T, store Store, clock *time.Time) {
+		ctx := context.Background()
+		task := createMonitorTestTask(t, store)
+
+		created, err := store.CreateStatusPage(ctx, StatusPage{
+			UserID: task.UserID, Slug: "backups", Title: "Backups", TaskIDs: []int64{task.ID}, Protection: "public",
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if created.ID == 0 || !created.CreatedAt.Equal(*clock) {
+			t.Fatalf("CreateStatusPage = %+v", created)
+		}
+		if _, err := store.CreateStatusPage(ctx, StatusPage{UserID: task.UserID, Slug: "backups", Protection: "public"}); !errors.Is(err, ErrConflict) {
+			t.Errorf("CreateStatusPage with a taken slug: %v, want ErrConflict", err)
+		}
+
+		got, err := store.GetStatusPageBySlug(ctx, "backups")
+		if err != nil || got.ID != created.ID || len(got.TaskIDs) != 1 || got.TaskIDs[0] != task.ID {
+			t.Errorf("GetStatusPageBySlug = %+v, %v", got, err)
+		}
+		if _, err := store.GetStatusPageBySlug(ctx, "missing"); !errors.Is(err, ErrNotFound) {
+			t.Errorf("GetStatusPageBySlug of a missing page: %v, want ErrNotFound", err)
+		}
+
+		other, err := store.CreateStatusPage(ctx, StatusPage{UserID: task.UserID, Slug: "other", Protection: "public"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if pages, err := store.ListStatusPages(ctx, task.UserID, 0); err != nil || len(pages) != 2 {
+			t.Errorf("ListStatusPages = %+v, %v; want both pages", pages, err)
+		}
+
+		other.Slug = "backups"
+		if _, err := store.UpdateStatusPage(ctx, other); !errors.Is(err, ErrConflict) {
+			t.Errorf("UpdateStatusPage to a taken slug: %v, want ErrConflict", err)
+		}
+		other.Slug, other.Protection, other.AccessToken = "renamed", "token", "secret"
+		if updated, err := store.UpdateStatusPage(ctx, other); err != nil || updated.Slug != "renamed" || updated.AccessToken != "secret" {
+			t.Errorf("UpdateStatusPage = %+v, %v", updated, err)
+		}
+
+		if err := store.DeleteStatusPage(ctx, created.ID); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := store.GetStatusPage(ctx, created.ID); !errors.Is(err, ErrNotFound) {
+			t.Errorf("GetStatusPage after deleting it: %v, want ErrNotFound", err)
+		}
+	})
+}
+
+func TestStatusPageAuthorized(t *testing.T) {
+	hash, err := bcrypt.GenerateFromPassword([]byte("hunter2"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	passwordPage := StatusPage{Protection: "password", PasswordHash: string(hash)}
+	tokenPage := StatusPage{Protection: "token", AccessToken: "secret"}
+
+	req := httptest.NewRequest("GET", "/status/backups", nil)
+	if !statusPageAuthorized(req, StatusPage{Protection: "public"}) {
+		t.Error("public page refused")
+	}
+	if statusPageAuthorized(req, passwordPage) || statusPageAuthorized(req, tokenPage) {
+		t.Error("protected page served without credentials")
+	}
+
+	req.SetBasicAuth("anyone", "hunter2")
+	if !statusPageAuthorized(req, passwordPage) {
+		t.Error("password page refused the password")
+	}
+	req.SetBasicAuth("anyone", "wrong")
+	if statusPageAuthorized(req, passwordPage) {
+		t.Error("password page served with a wrong password")
+	}
+
+	if !statusPageAuthorized(httptest.NewRequest("GET", "/status/backups?token=secret", nil), tokenPage) {
+		t.Error("token page refused ?token=")
+	}
+	req = httptest.NewRequest("GET", "/status/backups", nil)
+	req.Header.Set("Authorization", "Bearer secret")
+	if !statusPageAuthorized(req, tokenPage) {
+		t.Error("token page refused the bearer token")
+	}
+	if statusPageAuthorized(httptest.NewRequest("GET", "/status/backups?token=secre", nil), tokenPage) {
+		t.Error("token page served with a wrong token")
+	}
+}
@@ -0,0 +1,1774 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"math"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// MemoryStore implements Store in process memory. Nothing is persisted; it is
+// meant for tests and for trying the API out without a database. Status
+// changes use the shared accounting rules in transitions.go.
+type MemoryStore struct {
+	mu sync.Mutex
+
+	// now is the store clock, replaceable in tests
+	now func() time.Time
+
+	users           map[int64]User
+	tasks           map[int64]*Task
+	graph           []TaskGraphPoint
+	rollups         map[rollupKey]*graphRollup
+	transitions     []TaskTransition
+	slos            map[int64]SLO
+	reportSchedules map[int64]ReportSchedule
+	statusPages     map[int64]StatusPage
+	badges          map[int64]Badge
+	routes          map[int64]NotificationRoute
+	orgs            map[int64]Organization
+	orgMembers      map[orgMemberKey]OrgMember
+	invitations     map[int64]OrgInvitation
+	apiKeys         map[int64]APIKey
+	sessions        map[int64]Session
+	identities      map[identityKey]int64 // user IDs
+	userTokens      map[int64]UserToken
+	twoFactors      map[int64]TwoFactor
+	recoveryCodes   map[int64]map[string]bool // user ID to code hashes
+
+	nextUserID           int64
+	nextTaskID           int64
+	nextGraphID          int64
+	nextTransitionID     int64
+	nextSLOID            int64
+	nextReportScheduleID int64
+	nextStatusPageID     int64
+	nextBadgeID          int64
+	nextRouteID          int64
+	nextOrgID            int64
+	nextInvitationID     int64
+	nextAPIKeyID         int64
+	nextSessionID        int64
+	nextUserTokenID      int64
+}
+
+// orgMemberKey identifies a membership
+type orgMemberKey struct {
+	OrgID  int64
+	UserID int64
+}
+
+// identityKey identifies an OpenID Connect account
+type identityKey struct {
+	Issuer  string
+	Subject string
+}
+
+// NewMemoryStore returns an empty in-memory store
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		now:             func() time.Time { return time.Now().UTC() },
+		users:           map[int64]User{},
+		tasks:           map[int64]*Task{},
+		rollups:         map[rollupKey]*graphRollup{},
+		slos:            map[int64]SLO{},
+		reportSchedules: map[int64]ReportSchedule{},
+		statusPages:     map[int64]StatusPage{},
+		badges:          map[int64]Badge{},
+		routes:          map[int64]NotificationRoute{},
+		orgs:            map[int64]Organization{},
+		orgMembers:      map[orgMemberKey]OrgMember{},
+		invitations:     map[int64]OrgInvitation{},
+		apiKeys:         map[int64]APIKey{},
+		sessions:        map[int64]Session{},
+		identities:      map[identityKey]int64{},
+		userTokens:      map[int64]UserToken{},
+		twoFactors:      map[int64]TwoFactor{},
+		recoveryCodes:   map[int64]map[string]bool{},
+	}
+}
+
+func (s *MemoryStore) Migrate(ctx context.Context) error     { return nil }
+func (s *MemoryStore) Ping(ctx context.Context) error        { return nil }
+func (s *MemoryStore) CheckSchema(ctx context.Context) error { return nil }
+func (s *MemoryStore) Close()                                {}
+
+func timePtr(t time.Time) *time.Time {
+	return &t
+}
+
+// Users
+
+func (s *MemoryStore) CreateUser(ctx context.Context, username, email, passwordHash string) (User, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, u := range s.users {
+		if u.Username == username || u.Email == email {
+			return User{}, ErrConflict
+		}
+	}
+
+	s.nextUserID++
+	s.users[s.nextUserID] = User{ID: int(s.nextUserID), Username: username, Email: email, Password: passwordHash}
+	return User{ID: int(s.nextUserID), Username: username, Email: email}, nil
+}
+
+func (s *MemoryStore) GetUserByEmail(ctx context.Context, email string) (User, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, u := range s.users {
+		if u.Email == email {
+			return u, nil
+		}
+	}
+	return User{}, ErrNotFound
+}
+
+func (s *MemoryStore) GetUser(ctx context.Context, id int64) (User, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	user, ok := s.users[id]
+	if !ok {
+		return User{}, ErrNotFound
+	}
+	return user, nil
+}
+
+func (s *MemoryStore) UserExists(ctx context.Context, id int64) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	_, ok := s.users[id]
+	return ok, nil
+}
+
+func (s *MemoryStore) GetUserByIdentity(ctx context.Context, issuer, subject string) (User, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	user, ok := s.users[s.identities[identityKey{issuer, subject}]]
+	if !ok {
+		return User{}, ErrNotFound
+	}
+	user.Password = ""
+	return user, nil
+}
+
+func (s *MemoryStore) LinkIdentity(ctx context.Context, userID int64, issuer, subject string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.users[userID]; !ok {
+		return fmt.Errorf("user %d does not exist", userID)
+	}
+	key := identityKey{issuer, subject}
+	if _, ok := s.identities[key]; ok {
+		return ErrConflict
+	}
+	s.identities[key] = userID
+	return nil
+}
+
+func (s *MemoryStore) UpdateUser(ctx context.Context, id int64, update UserUpdate) (User, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	user, ok := s.users[id]
+	if !ok {
+		return User{}, ErrNotFound
+	}
+	for otherID, u := range s.users {
+		if otherID != id && (update.Username != "" && u.Username == update.Username ||
+			update.Email != "" && u.Email == update.Email) {
+			return User{}, ErrConflict
+		}
+	}
+
+	if update.Username != "" {
+		user.Username = update.Username
+	}
+	if update.Email != "" && update.Email != user.Email {
+		user.Email = update.Email
+		user.EmailVerified = false
+	}
+	if update.PasswordHash != "" {
+		user.Password = update.PasswordHash
+	}
+	s.users[id] = user
+	user.Password = ""
+	return user, nil
+}
+
+func (s *MemoryStore) MarkEmailVerified(ctx context.Context, id int64, email string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	user, ok := s.users[id]
+	if !ok || user.Email != email {
+		return ErrNotFound
+	}
+	user.EmailVerified = true
+	s.users[id] = user
+	return nil
+}
+
+func (s *MemoryStore) DeleteUser(ctx context.Context, id int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.users[id]; !ok {
+		return ErrNotFound
+	}
+	owners := map[int64][]OrgMember{}
+	for _, member := range s.orgMembers {
+		if member.Role == roleOwner {
+			owners[member.OrgID] = append(owners[member.OrgID], member)
+		}
+	}
+	// heirs are the longest-standing other owner of each organization
+	heirs := map[int64]int64{}
+	for orgID, members := range owners {
+		sort.Slice(members, func(i, j int) bool {
+			if !members[i].CreatedAt.Equal(members[j].CreatedAt) {
+				return members[i].CreatedAt.Before(members[j].CreatedAt)
+			}
+			return members[i].UserID < members[j].UserID
+		})
+		for _, member := range members {
+			if member.UserID != id {
+				heirs[orgID] = member.UserID
+				break
+			}
+		}
+	}
+	for key, member := range s.orgMembers {
+		if key.UserID == id && member.Role == roleOwner && heirs[key.OrgID] == 0 {
+			return ErrConflict
+		}
+	}
+
+	// Like the foreign keys of the SQL stores
+	for taskID, task := range s.tasks {
+		switch {
+		case task.UserID != id:
+		case task.OrgID != 0:
+			task.UserID = heirs[task.OrgID]
+		default:
+			s.deleteTask(taskID)
+		}
+	}
+	for pageID, page := range s.statusPages {
+		switch {
+		case page.UserID != id:
+		case page.OrgID != 0:
+			page.UserID = heirs[page.OrgID]
+			s.statusPages[pageID] = page
+		default:
+			delete(s.statusPages, pageID)
+		}
+	}
+	for routeID, route := range s.routes {
+		switch {
+		case route.UserID != id:
+		case route.OrgID != 0:
+			route.UserID = heirs[route.OrgID]
+			s.routes[routeID] = route
+		default:
+			delete(s.routes, routeID)
+		}
+	}
+	for key := range s.orgMembers {
+		if key.UserID == id {
+			delete(s.orgMembers, key)
+		}
+	}
+	for sloID, slo := range s.slos {
+		if slo.Tag != "" && slo.UserID == id {
+			delete(s.slos, sloID)
+		}
+	}
+	for scheduleID, schedule := range s.reportSchedules {
+		if schedule.UserID == id {
+			delete(s.reportSchedules, scheduleID)
+		}
+	}
+	for badgeID, badge := range s.badges {
+		if badge.UserID == id {
+			delete(s.badges, badgeID)
+		}
+	}
+	for keyID, key := range s.apiKeys {
+		if key.UserID == id {
+			delete(s.apiKeys, keyID)
+		}
+	}
+	for sessionID, session := range s.sessions {
+		if session.UserID == id {
+			delete(s.sessions, sessionID)
+		}
+	}
+	for key, userID := range s.identities {
+		if userID == id {
+			delete(s.identities, key)
+		}
+	}
+	for tokenID, token := range s.userTokens {
+		if token.UserID == id {
+			delete(s.userTokens, tokenID)
+		}
+	}
+	delete(s.twoFactors, id)
+	delete(s.recoveryCodes, id)
+	delete(s.users, id)
+	return nil
+}
+
+func (s *MemoryStore) CreateUserToken(ctx context.Context, token UserToken) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for id, t := range s.userTokens {
+		if t.TokenHash == token.TokenHash {
+			return ErrConflict
+		}
+		if t.UserID == token.UserID && t.Purpose == token.Purpose {
+			delete(s.userTokens, id)
+		}
+	}
+	s.nextUserTokenID++
+	token.ID = s.nextUserTokenID
+	token.CreatedAt = s.now()
+	s.userTokens[token.ID] = token
+	return nil
+}
+
+func (s *MemoryStore) UseUserToken(ctx context.Context, purpose, tokenHash string) (UserToken, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for id, token := range s.userTokens {
+		if token.Purpose == purpose && token.TokenHash == tokenHash && token.ExpiresAt.After(s.now()) {
+			delete(s.userTokens, id)
+			return token, nil
+		}
+	}
+	return UserToken{}, ErrNotFound
+}
+
+// Tasks
+
+func (s *MemoryStore) CreateTask(ctx context.Context, task Task) (Task, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.users[task.UserID]; !ok {
+		return Task{}, fmt.Errorf("user %d does not exist", task.UserID)
+	}
+
+	now := s.now()
+	s.nextTaskID++
+	created := Task{
+		ID:              s.nextTaskID,
+		Name:            task.Name,
+		PingURL:         task.PingURL,
+		UserID:          task.UserID,
+		OrgID:           task.OrgID,
+		LastPing:        timePtr(now),
+		Interval:        task.Interval,
+		TaskNumber:      task.TaskNumber,
+		Project:         task.Project,
+		Tags:            task.Tags,
+		Status:          "alive",
+		LastChecked:     timePtr(now),
+		PreviousStatus:  "alive",
+		StatusChangedAt: timePtr(now),
+		Version:         1,
+	}
+	s.tasks[created.ID] = &created
+
+	// The first transition anchors uptime accounting at creation time
+	s.addTransition(TaskTransition{TaskID: created.ID, ToStatus: "alive", TransitionedAt: now})
+	return created, nil
+}
+
+func (s *MemoryStore) GetTask(ctx context.Context, id int64) (Task, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	task, ok := s.tasks[id]
+	if !ok {
+		return Task{}, ErrNotFound
+	}
+	return *task, nil
+}
+
+func (s *MemoryStore) ListUserTasks(ctx context.Context, userID int64, filter TaskFilter) ([]Task, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	tasks := []Task{}
+	for _, task := range s.sortedTasks() {
+		if filter.lists(*task, userID) && filter.Matches(*task) {
+			tasks = append(tasks, *task)
+		}
+	}
+	return tasks, nil
+}
+
+func (s *MemoryStore) ListTaskPage(ctx context.Context, userID int64, q TaskListQuery) (TaskPage, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	countFilter := q.Filter
+	countFilter.Status = ""
+	page := TaskPage{Tasks: []Task{}, StatusCounts: map[string]int{}}
+	var matched []Task
+	for _, task := range s.tasks {
+		if !q.Filter.lists(*task, userID) || !countFilter.Matches(*task) {
+			continue
+		}
+		page.StatusCounts[task.Status]++
+		if q.Filter.Matches(*task) && (q.After == nil || q.After.after(*task)) {
+			matched = append(matched, *task)
+		}
+	}
+
+	sort.Slice(matched, func(i, j int) bool {
+		if q.Desc {
+			return compareTasks(matched[i], matched[j], q.Sort) > 0
+		}
+		return compareTasks(matched[i], matched[j], q.Sort) < 0
+	})
+	page.Tasks = append(page.Tasks, matched[:min(len(matched), q.Limit+1)]...)
+	finishTaskPage(&page, q)
+	return page, nil
+}
+
+func (s *MemoryStore) UpdateTask(ctx context.Context, id int64, update TaskUpdate) (Task, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	task, ok := s.tasks[id]
+	if !ok {
+		return Task{}, ErrNotFound
+	}
+	if update.Version != 0 && update.Version != task.Version {
+		return Task{}, ErrStale
+	}
+
+	task.Name = update.Name
+	task.PingURL = update.PingURL
+	task.Interval = update.Interval
+	task.TaskNumber = update.TaskNumber
+	task.Project = update.Project
+	task.Tags = update.Tags
+	task.Version++
+
+	if update.Status != "" {
+		s.transition(task, update.Status, false)
+	}
+	return *task, nil
+}
+
+func (s *MemoryStore) SetTaskOrg(ctx context.Context, id, orgID int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	task, ok := s.tasks[id]
+	if !ok {
+		return ErrNotFound
+	}
+	task.OrgID = orgID
+	task.Version++
+	return nil
+}
+
+func (s *MemoryStore) DeleteTask(ctx context.Context, id int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.tasks[id]; !ok {
+		return ErrNotFound
+	}
+	s.deleteTask(id)
+	return nil
+}
+
+// deleteTask deletes a task with its graph samples, transitions and SLOs,
+// which cascade in the SQL stores. The caller holds s.mu.
+func (s *MemoryStore) deleteTask(id int64) {
+	delete(s.tasks, id)
+
+	graph := s.graph[:0]
+	for _, p := range s.graph {
+		if p.TaskID != id {
+			graph = append(graph, p)
+		}
+	}
+	s.graph = graph
+
+	for key := range s.rollups {
+		if key.TaskID == id {
+			delete(s.rollups, key)
+		}
+	}
+
+	for sloID, slo := range s.slos {
+		if slo.TaskID == id {
+			delete(s.slos, sloID)
+		}
+	}
+
+	transitions := s.transitions[:0]
+	for _, t := range s.transitions {
+		if t.TaskID != id {
+			transitions = append(transitions, t)
+		}
+	}
+	s.transitions = transitions
+}
+
+func (s *MemoryStore) RecordHeartbeat(ctx context.Context, taskNumber int) ([]int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var ids []int64
+	for _, task := range s.sortedTasks() {
+		if task.TaskNumber == taskNumber {
+			s.transition(task, heartbeatStatus(*task), true)
+			ids = append(ids, task.ID)
+		}
+	}
+	return ids, nil
+}
+
+func (s *MemoryStore) RecordTaskHeartbeat(ctx context.Context, id int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	task, ok := s.tasks[id]
+	if !ok {
+		return ErrNotFound
+	}
+	s.transition(task, heartbeatStatus(*task), true)
+	return nil
+}
+
+func (s *MemoryStore) DeleteTasks(ctx context.Context, ids []int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, id := range ids {
+		if _, ok := s.tasks[id]; ok {
+			s.deleteTask(id)
+		}
+	}
+	return nil
+}
+
+func (s *MemoryStore) SetTaskStatus(ctx context.Context, ids []int64, status string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, id := range ids {
+		if task, ok := s.tasks[id]; ok {
+			s.transition(task, status, false)
+			task.Version++
+		}
+	}
+	return nil
+}
+
+// transition applies a status change and logs it. The caller holds s.mu.
+func (s *MemoryStore) transition(task *Task, newStatus string, touchPing bool) {
+	if t := applyTransition(task, newStatus, touchPing, s.now()); t != nil {
+		s.addTransition(*t)
+	}
+}
+
+// addTransition appends to the transition log. The caller holds s.mu.
+func (s *MemoryStore) addTransition(t TaskTransition) {
+	s.nextTransitionID++
+	t.ID = s.nextTransitionID
+	s.transitions = append(s.transitions, t)
+}
+
+// sortedTasks returns the tasks ordered by ID. The caller holds s.mu.
+func (s *MemoryStore) sortedTasks() []*Task {
+	tasks := make([]*Task, 0, len(s.tasks))
+	for _, task := range s.tasks {
+		tasks = append(tasks, task)
+	}
+	sort.Slice(tasks, func(i, j int) bool { return tasks[i].ID < tasks[j].ID })
+	return tasks
+}
+
+// Transitions
+
+func (s *MemoryStore) ListTransitions(ctx context.Context, taskID int64) ([]TaskTransition, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	transitions := []TaskTransition{}
+	for _, t := range s.transitions {
+		if t.TaskID == taskID {
+			transitions = append(transitions, t)
+		}
+	}
+	sort.SliceStable(transitions, func(i, j int) bool {
+		return transitions[i].TransitionedAt.Before(transitions[j].TransitionedAt)
+	})
+	return transitions, nil
+}
+
+// SLOs
+
+func (s *MemoryStore) CreateSLO(ctx context.Context, slo SLO) (SLO, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if slo.Tag != "" {
+		if _, ok := s.users[slo.UserID]; !ok {
+			return SLO{}, fmt.Errorf("user %d does not exist", slo.UserID)
+		}
+		slo.TaskID = 0
+	} else {
+		task, ok := s.tasks[slo.TaskID]
+		if !ok {
+			return SLO{}, fmt.Errorf("task %d does not exist", slo.TaskID)
+		}
+		slo.UserID = task.UserID
+	}
+
+	if slo.Kind == "" {
+		slo.Kind = sloKindTime
+	}
+	s.nextSLOID++
+	slo.ID = s.nextSLOID
+	slo.FiringAlerts = nil
+	slo.CreatedAt = s.now()
+	s.slos[slo.ID] = slo
+	return slo, nil
+}
+
+func (s *MemoryStore) GetSLO(ctx context.Context, id int64) (SLO, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	slo, ok := s.slos[id]
+	if !ok {
+		return SLO{}, ErrNotFound
+	}
+	return slo, nil
+}
+
+func (s *MemoryStore) ListSLOs(ctx context.Context, userID, orgID int64) ([]SLO, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	slos := []SLO{}
+	for _, slo := range s.slos {
+		task, ok := s.tasks[slo.TaskID]
+		if slo.Tag != "" {
+			// Tag SLOs cover personal tasks
+			task, ok = &Task{UserID: slo.UserID}, true
+		}
+		if userID == 0 && orgID == 0 || ok && (TaskFilter{OrgID: orgID}).lists(*task, userID) {
+			slos = append(slos, slo)
+		}
+	}
+	sort.Slice(slos, func(i, j int) bool { return slos[i].ID < slos[j].ID })
+	return slos, nil
+}
+
+func (s *MemoryStore) UpdateSLO(ctx context.Context, slo SLO) (SLO, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	stored, ok := s.slos[slo.ID]
+	if !ok {
+		return SLO{}, ErrNotFound
+	}
+	stored.Name = slo.Name
+	stored.Target = slo.Target
+	stored.Kind = slo.Kind
+	if stored.Kind == "" {
+		stored.Kind = sloKindTime
+	}
+	stored.WindowDays = slo.WindowDays
+	stored.WebhookURL = slo.WebhookURL
+	stored.Email = slo.Email
+	s.slos[slo.ID] = stored
+	return stored, nil
+}
+
+func (s *MemoryStore) DeleteSLO(ctx context.Context, id int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.slos[id]; !ok {
+		return ErrNotFound
+	}
+	delete(s.slos, id)
+	return nil
+}
+
+func (s *MemoryStore) ClaimSLOAlerts(ctx context.Context, id int64, from, to []string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	stored, ok := s.slos[id]
+	if !ok || joinSLOAlerts(stored.FiringAlerts) != joinSLOAlerts(from) {
+		return false, nil
+	}
+	stored.FiringAlerts = append([]string(nil), to...)
+	s.slos[id] = stored
+	return true, nil
+}
+
+// Report schedules
+
+func (s *MemoryStore) CreateReportSchedule(ctx context.Context, schedule ReportSchedule) (ReportSchedule, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.users[schedule.UserID]; !ok {
+		return ReportSchedule{}, fmt.Errorf("user %d does not exist", schedule.UserID)
+	}
+
+	s.nextReportScheduleID++
+	schedule.ID = s.nextReportScheduleID
+	schedule.CreatedAt = s.now()
+	s.reportSchedules[schedule.ID] = schedule
+	return schedule, nil
+}
+
+func (s *MemoryStore) ListReportSchedules(ctx context.Context, userID int64) ([]ReportSchedule, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	schedules := []ReportSchedule{}
+	for _, rs := range s.reportSchedules {
+		if userID == 0 || rs.UserID == userID {
+			schedules = append(schedules, rs)
+		}
+	}
+	sort.Slice(schedules, func(i, j int) bool { return schedules[i].ID < schedules[j].ID })
+	return schedules, nil
+}
+
+func (s *MemoryStore) DeleteReportSchedule(ctx context.Context, id int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.reportSchedules[id]; !ok {
+		return ErrNotFound
+	}
+	delete(s.reportSchedules, id)
+	return nil
+}
+
+func (s *MemoryStore) ClaimReportPeriod(ctx context.Context, id int64, periodEnd time.Time) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	rs, ok := s.reportSchedules[id]
+	if !ok || (rs.LastPeriodEnd != nil && !rs.LastPeriodEnd.Before(periodEnd)) {
+		return false, nil
+	}
+	rs.LastPeriodEnd = timePtr(periodEnd)
+	s.reportSchedules[id] = rs
+	return true, nil
+}
+
+func (s *MemoryStore) ReleaseReportPeriod(ctx context.Context, id int64, periodEnd time.Time, previous *time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	rs, ok := s.reportSchedules[id]
+	if !ok || rs.LastPeriodEnd == nil || !rs.LastPeriodEnd.Equal(periodEnd) {
+		return nil
+	}
+	rs.LastPeriodEnd = previous
+	s.reportSchedules[id] = rs
+	return nil
+}
+
+// Status pages
+
+// slugTaken reports whether a page other than id uses slug
+func (s *MemoryStore) slugTaken(slug string, id int64) bool {
+	for _, page := range s.statusPages {
+		if page.Slug == slug && page.ID != id {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *MemoryStore) CreateStatusPage(ctx context.Context, page StatusPage) (StatusPage, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.users[page.UserID]; !ok {
+		return StatusPage{}, fmt.Errorf("user %d does not exist", page.UserID)
+	}
+	if s.slugTaken(page.Slug, 0) {
+		return StatusPage{}, ErrConflict
+	}
+
+	s.nextStatusPageID++
+	page.ID = s.nextStatusPageID
+	page.TaskIDs = append([]int64{}, page.TaskIDs...)
+	page.CreatedAt = s.now()
+	s.statusPages[page.ID] = page
+	return page, nil
+}
+
+func (s *MemoryStore) GetStatusPage(ctx context.Context, id int64) (StatusPage, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	page, ok := s.statusPages[id]
+	if !ok {
+		return StatusPage{}, ErrNotFound
+	}
+	return page, nil
+}
+
+func (s *MemoryStore) GetStatusPageBySlug(ctx context.Context, slug string) (StatusPage, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, page := range s.statusPages {
+		if page.Slug == slug {
+			return page, nil
+		}
+	}
+	return StatusPage{}, ErrNotFound
+}
+
+func (s *MemoryStore) ListStatusPages(ctx context.Context, userID, orgID int64) ([]StatusPage, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	pages := []StatusPage{}
+	for _, page := range s.statusPages {
+		if page.OrgID == orgID && (orgID != 0 || page.UserID == userID) {
+			pages = append(pages, page)
+		}
+	}
+	sort.Slice(pages, func(i, j int) bool { return pages[i].ID < pages[j].ID })
+	return pages, nil
+}
+
+func (s *MemoryStore) UpdateStatusPage(ctx context.Context, page StatusPage) (StatusPage, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	stored, ok := s.statusPages[page.ID]
+	if !ok {
+		return StatusPage{}, ErrNotFound
+	}
+	if s.slugTaken(page.Slug, page.ID) {
+		return StatusPage{}, ErrConflict
+	}
+	page.UserID = stored.UserID
+	page.OrgID = stored.OrgID
+	page.CreatedAt = stored.CreatedAt
+	page.TaskIDs = append([]int64{}, page.TaskIDs...)
+	s.statusPages[page.ID] = page
+	return page, nil
+}
+
+func (s *MemoryStore) DeleteStatusPage(ctx context.Context, id int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.statusPages[id]; !ok {
+		return ErrNotFound
+	}
+	delete(s.statusPages, id)
+	return nil
+}
+
+// Badges
+
+func (s *MemoryStore) CreateBadge(ctx context.Context, badge Badge) (Badge, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.users[badge.UserID]; !ok {
+		return Badge{}, fmt.Errorf("user %d does not exist", badge.UserID)
+	}
+	for _, b := range s.badges {
+		if b.Token == badge.Token {
+			return Badge{}, ErrConflict
+		}
+	}
+
+	s.nextBadgeID++
+	badge.ID = s.nextBadgeID
+	badge.CreatedAt = s.now()
+	s.badges[badge.ID] = badge
+	return badge, nil
+}
+
+func (s *MemoryStore) GetBadgeByToken(ctx context.Context, token string) (Badge, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, badge := range s.badges {
+		if badge.Token == token {
+			return badge, nil
+		}
+	}
+	return Badge{}, ErrNotFound
+}
+
+func (s *MemoryStore) ListBadges(ctx context.Context, userID int64) ([]Badge, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	badges := []Badge{}
+	for _, badge := range s.badges {
+		if badge.UserID == userID {
+			badges = append(badges, badge)
+		}
+	}
+	sort.Slice(badges, func(i, j int) bool { return badges[i].ID < badges[j].ID })
+	return badges, nil
+}
+
+func (s *MemoryStore) DeleteBadge(ctx context.Context, userID, id int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if badge, ok := s.badges[id]; !ok || badge.UserID != userID {
+		return ErrNotFound
+	}
+	delete(s.badges, id)
+	return nil
+}
+
+// Notification routes
+
+func (s *MemoryStore) CreateNotificationRoute(ctx context.Context, route NotificationRoute) (NotificationRoute, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.users[route.UserID]; !ok {
+		return NotificationRoute{}, fmt.Errorf("user %d does not exist", route.UserID)
+	}
+
+	s.nextRouteID++
+	route.ID = s.nextRouteID
+	route.CreatedAt = s.now()
+	s.routes[route.ID] = route
+	return route, nil
+}
+
+func (s *MemoryStore) GetNotificationRoute(ctx context.Context, id int64) (NotificationRoute, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	route, ok := s.routes[id]
+	if !ok {
+		return NotificationRoute{}, ErrNotFound
+	}
+	return route, nil
+}
+
+func (s *MemoryStore) ListNotificationRoutes(ctx context.Context, userID, orgID int64) ([]NotificationRoute, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	routes := []NotificationRoute{}
+	for _, route := range s.routes {
+		if route.OrgID == orgID && (orgID != 0 || route.UserID == userID) {
+			routes = append(routes, route)
+		}
+	}
+	sort.Slice(routes, func(i, j int) bool { return routes[i].ID < routes[j].ID })
+	return routes, nil
+}
+
+func (s *MemoryStore) DeleteNotificationRoute(ctx context.Context, id int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.routes[id]; !ok {
+		return ErrNotFound
+	}
+	delete(s.routes, id)
+	return nil
+}
+
+// Organizations
+
+func (s *MemoryStore) CreateOrg(ctx context.Context, name string, ownerID int64) (Organization, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.users[ownerID]; !ok {
+		return Organization{}, fmt.Errorf("user %d does not exist", ownerID)
+	}
+
+	s.nextOrgID++
+	org := Organization{ID: s.nextOrgID, Name: name, CreatedAt: s.now()}
+	s.orgs[org.ID] = org
+	s.orgMembers[orgMemberKey{org.ID, ownerID}] = OrgMember{OrgID: org.ID, UserID: ownerID, Role: roleOwner, CreatedAt: org.CreatedAt}
+	org.Role = roleOwner
+	return org, nil
+}
+
+func (s *MemoryStore) GetOrg(ctx context.Context, id int64) (Organization, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	org, ok := s.orgs[id]
+	if !ok {
+		return Organization{}, ErrNotFound
+	}
+	return org, nil
+}
+
+func (s *MemoryStore) UpdateOrg(ctx context.Context, org Organization) (Organization, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	stored, ok := s.orgs[org.ID]
+	if !ok {
+		return Organization{}, ErrNotFound
+	}
+	stored.Name, stored.Require2FA = org.Name, org.Require2FA
+	s.orgs[org.ID] = stored
+	return stored, nil
+}
+
+func (s *MemoryStore) ListUserOrgs(ctx context.Context, userID int64) ([]Organization, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	orgs := []Organization{}
+	for key, member := range s.orgMembers {
+		if key.UserID == userID {
+			org := s.orgs[key.OrgID]
+			org.Role = member.Role
+			orgs = append(orgs, org)
+		}
+	}
+	sort.Slice(orgs, func(i, j int) bool { return orgs[i].ID < orgs[j].ID })
+	return orgs, nil
+}
+
+func (s *MemoryStore) DeleteOrg(ctx context.Context, id int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.orgs[id]; !ok {
+		return ErrNotFound
+	}
+	delete(s.orgs, id)
+
+	// Like the foreign keys of the SQL stores
+	for key := range s.orgMembers {
+		if key.OrgID == id {
+			delete(s.orgMembers, key)
+		}
+	}
+	for invitationID, invitation := range s.invitations {
+		if invitation.OrgID == id {
+			delete(s.invitations, invitationID)
+		}
+	}
+	for pageID, page := range s.statusPages {
+		if page.OrgID == id {
+			delete(s.statusPages, pageID)
+		}
+	}
+	for routeID, route := range s.routes {
+		if route.OrgID == id {
+			delete(s.routes, routeID)
+		}
+	}
+	return nil
+}
+
+func (s *MemoryStore) GetOrgRole(ctx context.Context, orgID, userID int64) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	member, ok := s.orgMembers[orgMemberKey{orgID, userID}]
+	if !ok {
+		return "", ErrNotFound
+	}
+	return member.Role, nil
+}
+
+func (s *MemoryStore) ListOrgMembers(ctx context.Context, orgID int64) ([]OrgMember, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	members := []OrgMember{}
+	for key, member := range s.orgMembers {
+		if key.OrgID == orgID {
+			user := s.users[key.UserID]
+			member.Username, member.Email = user.Username, user.Email
+			member.TwoFactor = s.twoFactors[key.UserID].Enabled
+			members = append(members, member)
+		}
+	}
+	sort.Slice(members, func(i, j int) bool { return members[i].UserID < members[j].UserID })
+	return members, nil
+}
+
+func (s *MemoryStore) AddOrgMember(ctx context.Context, orgID, userID int64, role string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.orgs[orgID]; !ok {
+		return ErrNotFound
+	}
+	key := orgMemberKey{orgID, userID}
+	if _, ok := s.orgMembers[key]; ok {
+		return ErrConflict
+	}
+	user := s.users[userID]
+	s.orgMembers[key] = OrgMember{
+		OrgID:     orgID,
+		UserID:    userID,
+		Username:  user.Username,
+		Email:     user.Email,
+		Role:      role,
+		CreatedAt: s.now(),
+	}
+	return nil
+}
+
+func (s *MemoryStore) SetOrgRole(ctx context.Context, orgID, userID int64, role string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	key := orgMemberKey{orgID, userID}
+	member, ok := s.orgMembers[key]
+	if !ok {
+		return ErrNotFound
+	}
+	member.Role = role
+	s.orgMembers[key] = member
+	return nil
+}
+
+func (s *MemoryStore) RemoveOrgMember(ctx context.Context, orgID, userID int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	key := orgMemberKey{orgID, userID}
+	if _, ok := s.orgMembers[key]; !ok {
+		return ErrNotFound
+	}
+	delete(s.orgMembers, key)
+	return nil
+}
+
+func (s *MemoryStore) CreateInvitation(ctx context.Context, invitation OrgInvitation) (OrgInvitation, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.orgs[invitation.OrgID]; !ok {
+		return OrgInvitation{}, fmt.Errorf("organization %d does not exist", invitation.OrgID)
+	}
+
+	s.nextInvitationID++
+	invitation.ID = s.nextInvitationID
+	invitation.Token = ""
+	invitation.CreatedAt = s.now()
+	s.invitations[invitation.ID] = invitation
+	return invitation, nil
+}
+
+func (s *MemoryStore) ListInvitations(ctx context.Context, orgID int64) ([]OrgInvitation, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	invitations := []OrgInvitation{}
+	for _, invitation := range s.invitations {
+		if invitation.OrgID == orgID && invitation.AcceptedAt == nil {
+			invitations = append(invitations, invitation)
+		}
+	}
+	sort.Slice(invitations, func(i, j int) bool { return invitations[i].ID < invitations[j].ID })
+	return invitations, nil
+}
+
+func (s *MemoryStore) DeleteInvitation(ctx context.Context, orgID, id int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if invitation, ok := s.invitations[id]; !ok || invitation.OrgID != orgID {
+		return ErrNotFound
+	}
+	delete(s.invitations, id)
+	return nil
+}
+
+func (s *MemoryStore) AcceptInvitation(ctx context.Context, tokenHash string, userID int64) (OrgMember, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := s.now()
+	user := s.users[userID]
+	for id, invitation := range s.invitations {
+		if invitation.TokenHash != tokenHash || invitation.AcceptedAt != nil || !now.Before(invitation.ExpiresAt) {
+			continue
+		}
+		if !user.EmailVerified || !strings.EqualFold(invitation.Email, user.Email) {
+			return OrgMember{}, ErrNotFound
+		}
+		key := orgMemberKey{invitation.OrgID, userID}
+		if _, ok := s.orgMembers[key]; ok {
+			return OrgMember{}, ErrConflict
+		}
+
+		member := OrgMember{
+			OrgID:     invitation.OrgID,
+			UserID:    userID,
+			Username:  user.Username,
+			Email:     user.Email,
+			Role:      invitation.Role,
+			CreatedAt: now,
+		}
+		s.orgMembers[key] = member
+		invitation.AcceptedAt = timePtr(now)
+		s.invitations[id] = invitation
+		return member, nil
+	}
+	return OrgMember{}, ErrNotFound
+}
+
+// API keys
+
+func (s *MemoryStore) CreateAPIKey(ctx context.Context, key APIKey) (APIKey, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.users[key.UserID]; !ok {
+		return APIKey{}, fmt.Errorf("user %d does not exist", key.UserID)
+	}
+	for _, k := range s.apiKeys {
+		if k.KeyHash == key.KeyHash {
+			return APIKey{}, ErrConflict
+		}
+	}
+
+	s.nextAPIKeyID++
+	key.ID = s.nextAPIKeyID
+	key.Key = ""
+	key.LastUsedAt = nil
+	key.CreatedAt = s.now()
+	s.apiKeys[key.ID] = key
+	return key, nil
+}
+
+func (s *MemoryStore) ListAPIKeys(ctx context.Context, userID int64) ([]APIKey, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	keys := []APIKey{}
+	for _, key := range s.apiKeys {
+		if key.UserID == userID {
+			keys = append(keys, key)
+		}
+	}
+	sort.Slice(keys, func(i, j int) bool { return keys[i].ID < keys[j].ID })
+	return keys, nil
+}
+
+func (s *MemoryStore) DeleteAPIKey(ctx context.Context, userID, id int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if key, ok := s.apiKeys[id]; !ok || key.UserID != userID {
+		return ErrNotFound
+	}
+	delete(s.apiKeys, id)
+	return nil
+}
+
+func (s *MemoryStore) UseAPIKey(ctx context.Context, keyHash string) (APIKey, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := s.now()
+	for id, key := range s.apiKeys {
+		if key.KeyHash != keyHash || key.ExpiresAt != nil && !now.Before(*key.ExpiresAt) {
+			continue
+		}
+		key.LastUsedAt = timePtr(now)
+		s.apiKeys[id] = key
+		return key, nil
+	}
+	return APIKey{}, ErrNotFound
+}
+
+// Sessions
+
+func (s *MemoryStore) CreateSession(ctx context.Context, session Session) (Session, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.users[session.UserID]; !ok {
+		return Session{}, fmt.Errorf("user %d does not exist", session.UserID)
+	}
+	now := s.now()
+	for id, existing := range s.sessions {
+		if existing.RefreshHash == session.RefreshHash {
+			return Session{}, ErrConflict
+		}
+		if existing.UserID == session.UserID && !now.Before(existing.ExpiresAt) {
+			delete(s.sessions, id)
+		}
+	}
+
+	s.nextSessionID++
+	session.ID = s.nextSessionID
+	session.CreatedAt = now
+	session.LastUsedAt = now
+	session.PreviousRefreshHash = ""
+	s.sessions[session.ID] = session
+	return session, nil
+}
+
+func (s *MemoryStore) GetActiveSession(ctx context.Context, id int64) (Session, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	session, ok := s.sessions[id]
+	if !ok || !s.now().Before(session.ExpiresAt) {
+		return Session{}, ErrNotFound
+	}
+	return session, nil
+}
+
+func (s *MemoryStore) RotateSession(ctx context.Context, refreshHash string, update Session) (Session, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := s.now()
+	for id, session := range s.sessions {
+		if session.RefreshHash == refreshHash && now.Before(session.ExpiresAt) {
+			session.PreviousRefreshHash = session.RefreshHash
+			session.RefreshHash = update.RefreshHash
+			session.UserAgent = update.UserAgent
+			session.IP = update.IP
+			session.LastUsedAt = now
+			session.ExpiresAt = update.ExpiresAt
+			s.sessions[id] = session
+			return session, nil
+		}
+	}
+	for id, session := range s.sessions {
+		if session.PreviousRefreshHash == refreshHash {
+			log.Printf("Revoked a session whose replaced refresh token was presented again")
+			delete(s.sessions, id)
+		}
+	}
+	return Session{}, ErrNotFound
+}
+
+func (s *MemoryStore) ListSessions(ctx context.Context, userID int64) ([]Session, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := s.now()
+	sessions := []Session{}
+	for _, session := range s.sessions {
+		if session.UserID == userID && now.Before(session.ExpiresAt) {
+			sessions = append(sessions, session)
+		}
+	}
+	sort.Slice(sessions, func(i, j int) bool {
+		if !sessions[i].LastUsedAt.Equal(sessions[j].LastUsedAt) {
+			return sessions[i].LastUsedAt.After(sessions[j].LastUsedAt)
+		}
+		return sessions[i].ID > sessions[j].ID
+	})
+	return sessions, nil
+}
+
+func (s *MemoryStore) DeleteSession(ctx context.Context, userID, id int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if session, ok := s.sessions[id]; !ok || session.UserID != userID {
+		return ErrNotFound
+	}
+	delete(s.sessions, id)
+	return nil
+}
+
+func (s *MemoryStore) DeleteUserSessions(ctx context.Context, userID int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for id, session := range s.sessions {
+		if session.UserID == userID {
+			delete(s.sessions, id)
+		}
+	}
+	return nil
+}
+
+// Two-factor authentication
+
+func (s *MemoryStore) GetTwoFactor(ctx context.Context, userID int64) (TwoFactor, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.users[userID]; !ok {
+		return TwoFactor{}, ErrNotFound
+	}
+	tf := s.twoFactors[userID]
+	tf.RecoveryCodes = len(s.recoveryCodes[userID])
+	return tf, nil
+}
+
+func (s *MemoryStore) SetTOTPSecret(ctx context.Context, userID int64, secret string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.users[userID]; !ok || s.twoFactors[userID].Enabled {
+		return ErrConflict
+	}
+	s.twoFactors[userID] = TwoFactor{Secret: secret}
+	return nil
+}
+
+func (s *MemoryStore) EnableTwoFactor(ctx context.Context, userID, step int64, recoveryHashes []string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	tf, ok := s.twoFactors[userID]
+	switch {
+	case !ok || tf.Secret == "":
+		return ErrNotFound
+	case tf.Enabled:
+		return ErrConflict
+	}
+	tf.Enabled, tf.LastStep = true, step
+	s.twoFactors[userID] = tf
+	s.replaceRecoveryCodes(userID, recoveryHashes)
+	return nil
+}
+
+// replaceRecoveryCodes swaps the user's recovery codes. The caller holds s.mu.
+func (s *MemoryStore) replaceRecoveryCodes(userID int64, codeHashes []string) {
+	codes := map[string]bool{}
+	for _, hash := range codeHashes {
+		codes[hash] = true
+	}
+	s.recoveryCodes[userID] = codes
+}
+
+func (s *MemoryStore) UseTOTPStep(ctx context.Context, userID, step int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	tf, ok := s.twoFactors[userID]
+	if !ok || tf.LastStep >= step {
+		return ErrConflict
+	}
+	tf.LastStep = step
+	s.twoFactors[userID] = tf
+	return nil
+}
+
+func (s *MemoryStore) UseRecoveryCode(ctx context.Context, userID int64, codeHash string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if !s.recoveryCodes[userID][codeHash] {
+		return ErrNotFound
+	}
+	delete(s.recoveryCodes[userID], codeHash)
+	return nil
+}
+
+func (s *MemoryStore) ReplaceRecoveryCodes(ctx context.Context, userID int64, codeHashes []string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.replaceRecoveryCodes(userID, codeHashes)
+	return nil
+}
+
+func (s *MemoryStore) DisableTwoFactor(ctx context.Context, userID int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.twoFactors, userID)
+	delete(s.recoveryCodes, userID)
+	return nil
+}
+
+// Graph data
+
+// rollupKey identifies one bucket of one task in a rollup tier
+type rollupKey struct {
+	TaskID      int64
+	Resolution  time.Duration
+	BucketStart time.Time
+}
+
+// graphRollup is a row of task_graph_rollups
+type graphRollup struct {
+	Samples             int64
+	AliveSamples        int64
+	DeadSamples         int64
+	AvgUptimePercentage float64
+	UptimeSeconds       float64
+	DowntimeSeconds     float64
+	PingCount           int64
+}
+
+func (s *MemoryStore) GraphBuckets(ctx context.Context, q GraphQuery) ([]TaskGraphBucket, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	selected := func(taskID int64) bool {
+		task, ok := s.tasks[taskID]
+		if !ok {
+			return false
+		}
+		if q.TaskID != 0 {
+			return taskID == q.TaskID
+		}
+		return q.Filter.lists(*task, q.UserID) && q.Filter.Matches(*task)
+	}
+
+	type key struct {
+		TaskID int64
+		Start  time.Time
+	}
+	byKey := map[key]*TaskGraphBucket{}
+	add := func(taskID int64, at time.Time, r graphRollup) {
+		if !selected(taskID) || at.Before(q.From) || !at.Before(q.To) {
+			return
+		}
+		k := key{TaskID: taskID, Start: alignToStep(at, q.Step)}
+		b, ok := byKey[k]
+		if !ok {
+			b = &TaskGraphBucket{
+				TaskID:             taskID,
+				BucketStart:        k.Start,
+				MinUptimeSeconds:   r.UptimeSeconds,
+				MinDowntimeSeconds: r.DowntimeSeconds,
+				MinPingCount:       r.PingCount,
+			}
+			byKey[k] = b
+		}
+		b.AvgUptimePercentage += r.AvgUptimePercentage * float64(r.Samples)
+		b.Samples += r.Samples
+		b.AliveSamples += r.AliveSamples
+		b.DeadSamples += r.DeadSamples
+		b.MinUptimeSeconds = math.Min(b.MinUptimeSeconds, r.UptimeSeconds)
+		b.MaxUptimeSeconds = math.Max(b.MaxUptimeSeconds, r.UptimeSeconds)
+		b.MinDowntimeSeconds = math.Min(b.MinDowntimeSeconds, r.DowntimeSeconds)
+		b.MaxDowntimeSeconds = math.Max(b.MaxDowntimeSeconds, r.DowntimeSeconds)
+		if r.PingCount < b.MinPingCount {
+			b.MinPingCount = r.PingCount
+		}
+		if r.PingCount > b.MaxPingCount {
+			b.MaxPingCount = r.PingCount
+		}
+	}
+
+	if q.Tier.IsRaw() {
+		for _, p := range s.graph {
+			add(p.TaskID, p.Timestamp, rawRollup(p))
+		}
+	} else {
+		for k, r := range s.rollups {
+			if k.Resolution == q.Tier.Resolution {
+				add(k.TaskID, k.BucketStart, *r)
+			}
+		}
+	}
+
+	buckets := make([]TaskGraphBucket, 0, len(byKey))
+	for _, b := range byKey {
+		b.AvgUptimePercentage /= float64(b.Samples)
+		buckets = append(buckets, *b)
+	}
+	sort.Slice(buckets, func(i, j int) bool {
+		if buckets[i].TaskID != buckets[j].TaskID {
+			return buckets[i].TaskID < buckets[j].TaskID
+		}
+		return buckets[i].BucketStart.Before(buckets[j].BucketStart)
+	})
+	return buckets, nil
+}
+
+// rawRollup treats a raw sample as a rollup of one sample
+func rawRollup(p TaskGraphPoint) graphRollup {
+	r := graphRollup{
+		Samples:             1,
+		AvgUptimePercentage: p.UptimePercentage,
+		UptimeSeconds:       p.UptimeSeconds,
+		DowntimeSeconds:     p.DowntimeSeconds,
+		PingCount:           p.PingCount,
+	}
+	if p.Status == "alive" {
+		r.AliveSamples = 1
+	} else if p.Status == "dead" {
+		r.DeadSamples = 1
+	}
+	return r
+}
+
+func (s *MemoryStore) CompactGraphData(ctx context.Context, tiers []GraphTier) (CompactionResult, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var result CompactionResult
+	now := s.now()
+
+	for i, tier := range tiers {
+		if tier.IsRaw() || i == 0 {
+			continue
+		}
+		result.RolledUp += s.rollUp(tiers[i-1], tier, now)
+	}
+
+	for _, tier := range tiers {
+		if tier.Retention <= 0 {
+			continue
+		}
+		cutoff := now.Add(-tier.Retention)
+
+		if tier.IsRaw() {
+			kept := s.graph[:0]
+			for _, p := range s.graph {
+				if p.Timestamp.Before(cutoff) {
+					result.Pruned++
+					continue
+				}
+				kept = append(kept, p)
+			}
+			s.graph = kept
+			continue
+		}
+		for key := range s.rollups {
+			if key.Resolution == tier.Resolution && key.BucketStart.Before(cutoff) {
+				delete(s.rollups, key)
+				result.Pruned++
+			}
+		}
+	}
+	return result, nil
+}
+
+// rollUp aggregates the completed buckets of tier from the source tier below
+// it, recomputing the newest existing bucket. The caller holds s.mu.
+func (s *MemoryStore) rollUp(source, tier GraphTier, now time.Time) int64 {
+	var from time.Time
+	for key := range s.rollups {
+		if key.Resolution == tier.Resolution && key.BucketStart.After(from) {
+			from = key.BucketStart
+		}
+	}
+	current := now.Truncate(tier.Resolution)
+
+	buckets := map[rollupKey]*graphRollup{}
+	add := func(taskID int64, at time.Time, r graphRollup) {
+		if at.Before(from) {
+			return
+		}
+		key := rollupKey{TaskID: taskID, Resolution: tier.Resolution, BucketStart: at.Truncate(tier.Resolution)}
+		if !key.BucketStart.Before(current) {
+			return
+		}
+		b, ok := buckets[key]
+		if !ok {
+			b = &graphRollup{}
+			buckets[key] = b
+		}
+		// Weight the average by the samples behind it, keep the latest counters
+		b.AvgUptimePercentage += r.AvgUptimePercentage * float64(r.Samples)
+		b.Samples += r.Samples
+		b.AliveSamples += r.AliveSamples
+		b.DeadSamples += r.DeadSamples
+		b.UptimeSeconds = math.Max(b.UptimeSeconds, r.UptimeSeconds)
+		b.DowntimeSeconds = math.Max(b.DowntimeSeconds, r.DowntimeSeconds)
+		if r.PingCount > b.PingCount {
+			b.PingCount = r.PingCount
+		}
+	}
+
+	if source.IsRaw() {
+		for _, p := range s.graph {
+			add(p.TaskID, p.Timestamp, rawRollup(p))
+		}
+	} else {
+		for key, r := range s.rollups {
+			if key.Resolution == source.Resolution {
+				add(key.TaskID, key.BucketStart, *r)
+			}
+		}
+	}
+
+	for key, b := range buckets {
+		b.AvgUptimePercentage /= float64(b.Samples)
+		s.rollups[key] = b
+	}
+	return int64(len(buckets))
+}
+
+// Monitor
+
+func (s *MemoryStore) Now(ctx context.Context) (time.Time, error) {
+	return s.now(), nil
+}
+
+// MonitorPartitions returns a single partition: all tasks are monitored at once
+func (s *MemoryStore) MonitorPartitions(ctx context.Context) ([]string, error) {
+	return []string{"tasks"}, nil
+}
+
+func (s *MemoryStore) MonitorPartition(ctx context.Context, partition string, graceStart *time.Time) (int, []MonitoredTask, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := s.now()
+	newlyDead := 0
+	var tasks []MonitoredTask
+
+	for _, task := range s.sortedTasks() {
+		if t := applyMonitorPass(task, now, graceStart); t != nil {
+			s.addTransition(*t)
+			newlyDead++
+		}
+		tasks = append(tasks, monitoredTask(*task, now))
+
+		s.nextGraphID++
+		s.graph = append(s.graph, TaskGraphPoint{
+			ID:               s.nextGraphID,
+			TaskID:           task.ID,
+			Timestamp:        now,
+			Status:           task.Status,
+			UptimeSeconds:    task.UptimeSeconds,
+			DowntimeSeconds:  task.DowntimeSeconds,
+			UptimePercentage: uptimePercentage(task.UptimeSeconds, task.DowntimeSeconds),
+			PingCount:        task.PingCount,
+		})
+	}
+	return newlyDead, tasks, nil
+}
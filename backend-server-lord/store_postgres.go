@@ -0,0 +1,2072 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgconn"
+	"github.com/jackc/pgx/v4"
+	"github.com/jackc/pgx/v4/pgxpool"
+	"go.opentelemetry.io/otel"
+)
+
+// PostgresStore implements Store on Postgres, optionally with Citus
+type PostgresStore struct {
+	pool *pgxpool.Pool
+}
+
+// openPostgresStore parses the database URL and opens the connection pool
+func openPostgresStore(ctx context.Context, dbURL string) (*PostgresStore, error) {
+	config, err := pgxpool.ParseConfig(dbURL)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing the dbURL: %v", err)
+	}
+
+	pool, err := pgxpool.ConnectConfig(ctx, config)
+	if err != nil {
+		return nil, err
+	}
+	return &PostgresStore{pool: pool}, nil
+}
+
+func (s *PostgresStore) Migrate(ctx context.Context) error {
+	return migrateDB(ctx, s.pool, "up", 0)
+}
+
+func (s *PostgresStore) MigrateSchema(ctx context.Context, direction string, steps int) error {
+	return migrateDB(ctx, s.pool, direction, steps)
+}
+
+func (s *PostgresStore) MigrationStatus(ctx context.Context) ([]Migration, map[int64]time.Time, error) {
+	return postgresMigrationStatus(ctx, s.pool)
+}
+
+func (s *PostgresStore) Ping(ctx context.Context) error {
+	return s.pool.Ping(ctx)
+}
+
+func (s *PostgresStore) CheckSchema(ctx context.Context) error {
+	var current int64
+	err := s.pool.QueryRow(ctx, "SELECT COALESCE(MAX(version), 0) FROM schema_migrations").Scan(&current)
+	if err != nil {
+		return err
+	}
+	return checkSchemaVersion(postgresMigrationsDir, current)
+}
+
+func (s *PostgresStore) Close() {
+	s.pool.Close()
+}
+
+// notFound maps pgx's "no rows" error to ErrNotFound
+func notFound(err error) error {
+	if errors.Is(err, pgx.ErrNoRows) {
+		return ErrNotFound
+	}
+	return err
+}
+
+// Users
+
+func (s *PostgresStore) CreateUser(ctx context.Context, username, email, passwordHash string) (User, error) {
+	user := User{Username: username, Email: email}
+	err := s.pool.QueryRow(
+		ctx,
+		"INSERT INTO users(username, email, password) VALUES($1, $2, $3) RETURNING id",
+		username, email, passwordHash).Scan(&user.ID)
+	return user, conflict(err)
+}
+
+func (s *PostgresStore) GetUserByEmail(ctx context.Context, email string) (User, error) {
+	var user User
+	err := s.pool.QueryRow(
+		ctx,
+		"SELECT id, username, email, email_verified_at IS NOT NULL, password FROM users WHERE email = $1",
+		email).Scan(&user.ID, &user.Username, &user.Email, &user.EmailVerified, &user.Password)
+	return user, notFound(err)
+}
+
+func (s *PostgresStore) GetUser(ctx context.Context, id int64) (User, error) {
+	var user User
+	err := s.pool.QueryRow(ctx, "SELECT id, username, email, email_verified_at IS NOT NULL, password FROM users WHERE id = $1", id).
+		Scan(&user.ID, &user.Username, &user.Email, &user.EmailVerified, &user.Password)
+	return user, notFound(err)
+}
+
+func (s *PostgresStore) UserExists(ctx context.Context, id int64) (bool, error) {
+	var exists bool
+	err := s.pool.QueryRow(ctx, "SELECT EXISTS(SELECT 1 FROM users WHERE id = $1)", id).Scan(&exists)
+	return exists, err
+}
+
+func (s *PostgresStore) GetUserByIdentity(ctx context.Context, issuer, subject string) (User, error) {
+	var user User
+	err := s.pool.QueryRow(ctx, `
+        SELECT u.id, u.username, u.email, u.email_verified_at IS NOT NULL FROM user_identities i
+        JOIN users u ON u.id = i.user_id
+        WHERE i.issuer = $1 AND i.subject = $2`, issuer, subject).Scan(&user.ID, &user.Username, &user.Email, &user.EmailVerified)
+	return user, notFound(err)
+}
+
+func (s *PostgresStore) LinkIdentity(ctx context.Context, userID int64, issuer, subject string) error {
+	_, err := s.pool.Exec(ctx,
+		"INSERT INTO user_identities (issuer, subject, user_id) VALUES ($1, $2, $3)",
+		issuer, subject, userID)
+	return conflict(err)
+}
+
+func (s *PostgresStore) UpdateUser(ctx context.Context, id int64, update UserUpdate) (User, error) {
+	var user User
+	err := s.pool.QueryRow(ctx, `
+        UPDATE users
+        SET username = COALESCE(NULLIF($2, ''), username),
+            email = COALESCE(NULLIF($3, ''), email),
+            password = COALESCE(NULLIF($4, ''), password),
+            email_verified_at = CASE WHEN $3 IN ('', email) THEN email_verified_at END
+        WHERE id = $1
+        RETURNING id, username, email, email_verified_at IS NOT NULL`,
+		id, update.Username, update.Email, update.PasswordHash).
+		Scan(&user.ID, &user.Username, &user.Email, &user.EmailVerified)
+	return user, conflict(notFound(err))
+}
+
+func (s *PostgresStore) MarkEmailVerified(ctx context.Context, id int64, email string) error {
+	tag, err := s.pool.Exec(ctx, `
+        UPDATE users SET email_verified_at = COALESCE(email_verified_at, LOCALTIMESTAMP)
+        WHERE id = $1 AND email = $2`, id, email)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (s *PostgresStore) DeleteUser(ctx context.Context, id int64) error {
+	tx, err := s.pool.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	// Under Citus, users can only be changed after the distributed tasks in
+	// the same transaction when the shards are modified one at a time. Without
+	// Citus this just sets an unused placeholder.
+	if _, err := tx.Exec(ctx, "SET LOCAL citus.multi_shard_modify_mode TO 'sequential'"); err != nil {
+		return err
+	}
+
+	var lastOwner bool
+	err = tx.QueryRow(ctx, `
+        SELECT EXISTS (
+            SELECT 1 FROM org_members m
+            WHERE m.user_id = $1 AND m.role = 'owner' AND NOT EXISTS (
+                SELECT 1 FROM org_members o
+                WHERE o.org_id = m.org_id AND o.role = 'owner' AND o.user_id <> $1))`, id).Scan(&lastOwner)
+	if err != nil {
+		return err
+	}
+	if lastOwner {
+		return ErrConflict
+	}
+
+	// Hand what the user created for organizations to their longest-standing
+	// other owner
+	rows, err := tx.Query(ctx, `
+        SELECT org_id FROM tasks WHERE user_id = $1 AND org_id IS NOT NULL
+        UNION SELECT org_id FROM status_pages WHERE user_id = $1 AND org_id IS NOT NULL
+        UNION SELECT org_id FROM notification_routes WHERE user_id = $1 AND org_id IS NOT NULL`, id)
+	if err != nil {
+		return err
+	}
+	var orgIDs []int64
+	for rows.Next() {
+		var orgID int64
+		if err := rows.Scan(&orgID); err != nil {
+			rows.Close()
+			return err
+		}
+		orgIDs = append(orgIDs, orgID)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	for _, orgID := range orgIDs {
+		var heir *int64
+		err := tx.QueryRow(ctx, `
+            SELECT user_id FROM org_members
+            WHERE org_id = $1 AND role = 'owner' AND user_id <> $2
+            ORDER BY created_at, user_id LIMIT 1`, orgID, id).Scan(&heir)
+		if err != nil && !errors.Is(err, pgx.ErrNoRows) {
+			return err
+		}
+		for _, table := range []string{"tasks", "status_pages", "notification_routes"} {
+			if _, err := tx.Exec(ctx,
+				"UPDATE "+table+" SET user_id = $3 WHERE user_id = $1 AND org_id = $2",
+				id, orgID, heir); err != nil {
+				return err
+			}
+		}
+	}
+
+	// Graph data, transitions and SLOs cascade with the tasks, and sessions,
+	// keys, memberships and personal status pages, badges and routes with the
+	// user
+	if _, err := tx.Exec(ctx, "DELETE FROM tasks WHERE user_id = $1 AND org_id IS NULL", id); err != nil {
+		return err
+	}
+	tag, err := tx.Exec(ctx, "DELETE FROM users WHERE id = $1", id)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return ErrNotFound
+	}
+	return tx.Commit(ctx)
+}
+
+func (s *PostgresStore) CreateUserToken(ctx context.Context, token UserToken) error {
+	tx, err := s.pool.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	if _, err := tx.Exec(ctx,
+		"DELETE FROM user_tokens WHERE user_id = $1 AND purpose = $2",
+		token.UserID, token.Purpose); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(ctx, `
+        INSERT INTO user_tokens (user_id, purpose, token_hash, email, expires_at)
+        VALUES ($1, $2, $3, $4, $5)`,
+		token.UserID, token.Purpose, token.TokenHash, token.Email, token.ExpiresAt); err != nil {
+		return conflict(err)
+	}
+	return tx.Commit(ctx)
+}
+
+func (s *PostgresStore) UseUserToken(ctx context.Context, purpose, tokenHash string) (UserToken, error) {
+	var token UserToken
+	err := s.pool.QueryRow(ctx, `
+        DELETE FROM user_tokens
+        WHERE purpose = $1 AND token_hash = $2 AND expires_at > LOCALTIMESTAMP
+        RETURNING id, user_id, purpose, token_hash, email, expires_at, created_at`,
+		purpose, tokenHash).Scan(&token.ID, &token.UserID, &token.Purpose, &token.TokenHash,
+		&token.Email, &token.ExpiresAt, &token.CreatedAt)
+	return token, notFound(err)
+}
+
+// Tasks
+
+const taskColumns = `id, name, ping_url, user_id, last_ping, interval, task_number, status,
+         last_checked, previous_status, status_changed_at, uptime_seconds, downtime_seconds, ping_count,
+         project, tags, COALESCE(org_id, 0), version`
+
+func scanTask(row pgx.Row) (Task, error) {
+	var task Task
+	err := row.Scan(
+		&task.ID,
+		&task.Name,
+		&task.PingURL,
+		&task.UserID,
+		&task.LastPing,
+		&task.Interval,
+		&task.TaskNumber,
+		&task.Status,
+		&task.LastChecked,
+		&task.PreviousStatus,
+		&task.StatusChangedAt,
+		&task.UptimeSeconds,
+		&task.DowntimeSeconds,
+		&task.PingCount,
+		&task.Project,
+		&task.Tags,
+		&task.OrgID,
+		&task.Version,
+	)
+	return task, err
+}
+
+func (s *PostgresStore) CreateTask(ctx context.Context, task Task) (Task, error) {
+	tx, err := s.pool.Begin(ctx)
+	if err != nil {
+		return task, err
+	}
+	defer tx.Rollback(ctx)
+
+	err = tx.QueryRow(
+		ctx,
+		`INSERT INTO tasks(name, ping_url, user_id, interval, task_number, status, project, tags, org_id)
+		VALUES($1, $2, $3, $4, $5, $6, $7, $8, NULLIF($9, 0)) RETURNING id`,
+		task.Name, task.PingURL, task.UserID, task.Interval, task.TaskNumber, "alive",
+		task.Project, nonNilTags(task.Tags), task.OrgID).Scan(&task.ID)
+	if err != nil {
+		return task, err
+	}
+
+	// The first transition anchors uptime accounting at creation time
+	_, err = tx.Exec(
+		ctx,
+		`INSERT INTO task_transitions (task_id, from_status, to_status, transitioned_at)
+		SELECT id, NULL, status, last_checked FROM tasks WHERE id = $1`,
+		task.ID)
+	if err != nil {
+		return task, err
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return task, err
+	}
+	return s.GetTask(ctx, task.ID)
+}
+
+func (s *PostgresStore) GetTask(ctx context.Context, id int64) (Task, error) {
+	task, err := scanTask(s.pool.QueryRow(ctx, `SELECT `+taskColumns+` FROM tasks WHERE id = $1`, id))
+	return task, notFound(err)
+}
+
+// taskDialect holds the task conditions that differ between Postgres and
+// SQLite. Both take the placeholder of their argument.
+type taskDialect struct {
+	// hasTag matches tasks carrying the tag in arg
+	hasTag func(arg string) string
+	// search matches tasks whose name (ignoring case) or one of whose tags is
+	// LIKE the pattern in arg, with \ as the escape character
+	search func(arg string) string
+}
+
+var pgTaskDialect = taskDialect{
+	hasTag: pgHasTag,
+	search: func(arg string) string {
+		return fmt.Sprintf("(t.name ILIKE %[1]s OR EXISTS (SELECT 1 FROM unnest(t.tags) AS tag WHERE tag LIKE %[1]s))", arg)
+	},
+}
+
+// taskFilterSQL builds the condition selecting the tasks t listed for userID
+// that match filter, and the values it binds
+func taskFilterSQL(userID int64, filter TaskFilter, dialect taskDialect) (string, []interface{}) {
+	where, args := "t.user_id = $1 AND t.org_id IS NULL", []interface{}{userID}
+	if filter.OrgID != 0 {
+		where, args = "t.org_id = $1", []interface{}{filter.OrgID}
+	}
+	bind := func(v interface{}) string {
+		args = append(args, v)
+		return fmt.Sprintf("$%d", len(args))
+	}
+	if filter.Tag != "" {
+		where += " AND " + dialect.hasTag(bind(filter.Tag))
+	}
+	if filter.Project != "" {
+		where += " AND t.project = " + bind(filter.Project)
+	}
+	if filter.Status != "" {
+		where += " AND t.status = " + bind(filter.Status)
+	}
+	if filter.Search != "" {
+		where += " AND " + dialect.search(bind(likePattern(filter.Search)))
+	}
+	return where, args
+}
+
+// taskPageQueries builds the queries behind ListTaskPage: one selecting up to
+// q.Limit+1 tasks after the cursor (the extra task tells whether there is a
+// next page), and one counting the matching tasks per status
+func taskPageQueries(userID int64, q TaskListQuery, dialect taskDialect) (string, []interface{}, string, []interface{}) {
+	countFilter := q.Filter
+	countFilter.Status = ""
+	countWhere, countArgs := taskFilterSQL(userID, countFilter, dialect)
+	countQuery := `SELECT t.status, COUNT(*) FROM tasks t WHERE ` + countWhere + ` GROUP BY t.status`
+
+	where, args := taskFilterSQL(userID, q.Filter, dialect)
+	sort := taskSorts[q.Sort]
+	direction, after := "ASC", ">"
+	if q.Desc {
+		direction, after = "DESC", "<"
+	}
+	if q.After != nil {
+		args = append(args, q.After.Value, q.After.ID)
+		where += fmt.Sprintf(" AND (%[1]s %[2]s $%[3]d OR (%[1]s = $%[3]d AND t.id %[2]s $%[4]d))",
+			sort, after, len(args)-1, len(args))
+	}
+	pageQuery := fmt.Sprintf(`SELECT %s FROM tasks t WHERE %s ORDER BY %s %s, t.id %s LIMIT %d`,
+		taskColumns, where, sort, direction, direction, q.Limit+1)
+	return pageQuery, args, countQuery, countArgs
+}
+
+// finishTaskPage trims the extra task of a page and sets the next cursor
+func finishTaskPage(page *TaskPage, q TaskListQuery) {
+	if len(page.Tasks) > q.Limit {
+		page.Tasks = page.Tasks[:q.Limit]
+		page.Next = taskCursorAt(page.Tasks[q.Limit-1], q)
+	}
+	page.Total = 0
+	for status, count := range page.StatusCounts {
+		if q.Filter.Status == "" || status == q.Filter.Status {
+			page.Total += count
+		}
+	}
+}
+
+func (s *PostgresStore) ListUserTasks(ctx context.Context, userID int64, filter TaskFilter) ([]Task, error) {
+	where, args := taskFilterSQL(userID, filter, pgTaskDialect)
+	rows, err := s.pool.Query(ctx, `SELECT `+taskColumns+` FROM tasks t WHERE `+where+` ORDER BY t.id`, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	tasks := []Task{}
+	for rows.Next() {
+		task, err := scanTask(rows)
+		if err != nil {
+			log.Printf("Error scanning task: %v", err)
+			continue
+		}
+		tasks = append(tasks, task)
+	}
+	return tasks, rows.Err()
+}
+
+func (s *PostgresStore) ListTaskPage(ctx context.Context, userID int64, q TaskListQuery) (TaskPage, error) {
+	pageQuery, args, countQuery, countArgs := taskPageQueries(userID, q, pgTaskDialect)
+	page := TaskPage{Tasks: []Task{}, StatusCounts: map[string]int{}}
+
+	rows, err := s.pool.Query(ctx, pageQuery, args...)
+	if err != nil {
+		return page, err
+	}
+	for rows.Next() {
+		task, err := scanTask(rows)
+		if err != nil {
+			rows.Close()
+			return page, err
+		}
+		page.Tasks = append(page.Tasks, task)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return page, err
+	}
+
+	rows, err = s.pool.Query(ctx, countQuery, countArgs...)
+	if err != nil {
+		return page, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var status string
+		var count int
+		if err := rows.Scan(&status, &count); err != nil {
+			return page, err
+		}
+		page.StatusCounts[status] = count
+	}
+	if err := rows.Err(); err != nil {
+		return page, err
+	}
+
+	finishTaskPage(&page, q)
+	return page, nil
+}
+
+func (s *PostgresStore) UpdateTask(ctx context.Context, id int64, update TaskUpdate) (Task, error) {
+	tx, err := s.pool.Begin(ctx)
+	if err != nil {
+		return Task{}, err
+	}
+	defer tx.Rollback(ctx)
+
+	result, err := tx.Exec(
+		ctx,
+		`UPDATE tasks SET name = $1, ping_url = $2, interval = $3,
+        task_number = $4, project = $5, tags = $6, version = version + 1
+        WHERE id = $7 AND ($8::BIGINT = 0 OR version = $8)`,
+		update.Name, update.PingURL, update.Interval, update.TaskNumber,
+		update.Project, nonNilTags(update.Tags), id, update.Version)
+	if err != nil {
+		return Task{}, err
+	}
+	if result.RowsAffected() == 0 {
+		// Either there is no such task or it is at another version
+		var exists bool
+		if err := tx.QueryRow(ctx, "SELECT EXISTS (SELECT 1 FROM tasks WHERE id = $1)", id).Scan(&exists); err != nil {
+			return Task{}, err
+		}
+		if exists {
+			return Task{}, ErrStale
+		}
+		return Task{}, ErrNotFound
+	}
+
+	// A status change goes through the transition log so that uptime/downtime
+	// stay consistent with it
+	if update.Status != "" {
+		if _, err := transitionTasks(ctx, tx, "id = $1", id, update.Status, false); err != nil {
+			return Task{}, err
+		}
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return Task{}, err
+	}
+	return s.GetTask(ctx, id)
+}
+
+func (s *PostgresStore) SetTaskOrg(ctx context.Context, id, orgID int64) error {
+	result, err := s.pool.Exec(ctx, "UPDATE tasks SET org_id = NULLIF($2, 0), version = version + 1 WHERE id = $1", id, orgID)
+	if err != nil {
+		return err
+	}
+	if result.RowsAffected() == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (s *PostgresStore) DeleteTask(ctx context.Context, id int64) error {
+	result, err := s.pool.Exec(ctx, "DELETE FROM tasks WHERE id = $1", id)
+	if err != nil {
+		return err
+	}
+	if result.RowsAffected() == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (s *PostgresStore) RecordHeartbeat(ctx context.Context, taskNumber int) ([]int64, error) {
+	tx, err := s.pool.Begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback(ctx)
+
+	ids, err := transitionTasks(ctx, tx, "task_number = $1 AND status <> 'paused'", taskNumber, "alive", true)
+	if err != nil {
+		return nil, err
+	}
+	// Paused tasks only get their ping recorded
+	paused, err := transitionTasks(ctx, tx, "task_number = $1 AND status = 'paused'", taskNumber, "paused", true)
+	if err != nil {
+		return nil, err
+	}
+	return append(ids, paused...), tx.Commit(ctx)
+}
+
+func (s *PostgresStore) RecordTaskHeartbeat(ctx context.Context, id int64) error {
+	tx, err := s.pool.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	ids, err := transitionTasks(ctx, tx, "id = $1 AND status <> 'paused'", id, "alive", true)
+	if err != nil {
+		return err
+	}
+	// A paused task only gets its ping recorded
+	paused, err := transitionTasks(ctx, tx, "id = $1 AND status = 'paused'", id, "paused", true)
+	if err != nil {
+		return err
+	}
+	if len(ids)+len(paused) == 0 {
+		return ErrNotFound
+	}
+	return tx.Commit(ctx)
+}
+
+func (s *PostgresStore) DeleteTasks(ctx context.Context, ids []int64) error {
+	if len(ids) == 0 {
+		return nil
+	}
+	_, err := s.pool.Exec(ctx, "DELETE FROM tasks WHERE id = ANY($1)", ids)
+	return err
+}
+
+func (s *PostgresStore) SetTaskStatus(ctx context.Context, ids []int64, status string) error {
+	if len(ids) == 0 {
+		return nil
+	}
+
+	tx, err := s.pool.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	if _, err := transitionTasks(ctx, tx, "id = ANY($1)", ids, status, false); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(ctx, "UPDATE tasks SET version = version + 1 WHERE id = ANY($1)", ids); err != nil {
+		return err
+	}
+	return tx.Commit(ctx)
+}
+
+// nonNilTags makes sure an empty tag list is stored as '{}' rather than NULL
+func nonNilTags(tags []string) []string {
+	if tags == nil {
+		return []string{}
+	}
+	return tags
+}
+
+// Transitions
+
+var transitionColumns = []string{"task_id", "from_status", "to_status", "transitioned_at", "uptime_seconds", "downtime_seconds"}
+
+// recordTransitions appends rows (in transitionColumns order) to the transition log
+func recordTransitions(ctx context.Context, tx pgx.Tx, rows [][]interface{}) error {
+	if len(rows) == 0 {
+		return nil
+	}
+	_, err := tx.CopyFrom(ctx, pgx.Identifier{"task_transitions"}, transitionColumns, pgx.CopyFromRows(rows))
+	if err != nil {
+		return fmt.Errorf("error recording transitions: %v", err)
+	}
+	return nil
+}
+
+// transitionTasks moves every task matched by where (a condition on tasks, using $1)
+// into newStatus. The rows are locked first, the time since last_checked is accounted
+// to the status the task was in, and a transition is logged for every task whose
+// status actually changed. The effective time never moves backwards past a
+// last_checked written by a monitor pass that committed while we waited for the lock.
+// When touchPing is set, last_ping is refreshed as well (heartbeats).
+func transitionTasks(ctx context.Context, tx pgx.Tx, where string, arg interface{}, newStatus string, touchPing bool) ([]int64, error) {
+	pingClause := ""
+	if touchPing {
+		pingClause = "last_ping = old.at, ping_count = t.ping_count + 1,"
+	}
+
+	query := fmt.Sprintf(`
+        UPDATE tasks t
+        SET
+            %s
+            uptime_seconds = t.uptime_seconds + CASE WHEN old.status = 'alive'
+                THEN EXTRACT(EPOCH FROM (old.at - COALESCE(old.last_checked, old.at))) ELSE 0 END,
+            downtime_seconds = t.downtime_seconds + CASE WHEN old.status IN ('alive', 'paused')
+                THEN 0 ELSE EXTRACT(EPOCH FROM (old.at - COALESCE(old.last_checked, old.at))) END,
+            previous_status = CASE WHEN old.status = $2 THEN t.previous_status ELSE old.status END,
+            status_changed_at = CASE WHEN old.status = $2 THEN t.status_changed_at ELSE old.at END,
+            status = $2,
+            last_checked = old.at
+        FROM (
+            SELECT id, status, last_checked,
+                GREATEST(LOCALTIMESTAMP, COALESCE(last_checked, LOCALTIMESTAMP)) AS at
+            FROM tasks
+            WHERE %s
+            FOR UPDATE
+        ) old
+        WHERE t.id = old.id
+        RETURNING t.id, old.status, old.at, t.uptime_seconds, t.downtime_seconds`, pingClause, where)
+
+	rows, err := tx.Query(ctx, query, arg, newStatus)
+	if err != nil {
+		return nil, err
+	}
+
+	var ids []int64
+	var transitions [][]interface{}
+	for rows.Next() {
+		var (
+			id        int64
+			oldStatus string
+			at        time.Time
+			uptime    float64
+			downtime  float64
+		)
+		if err := rows.Scan(&id, &oldStatus, &at, &uptime, &downtime); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		ids = append(ids, id)
+		if oldStatus != newStatus {
+			transitions = append(transitions, []interface{}{id, oldStatus, newStatus, at, uptime, downtime})
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	if err := recordTransitions(ctx, tx, transitions); err != nil {
+		return nil, err
+	}
+	return ids, nil
+}
+
+func (s *PostgresStore) ListTransitions(ctx context.Context, taskID int64) ([]TaskTransition, error) {
+	rows, err := s.pool.Query(ctx, `
+        SELECT id, task_id, from_status, to_status, transitioned_at, uptime_seconds, downtime_seconds
+        FROM task_transitions
+        WHERE task_id = $1
+        ORDER BY transitioned_at ASC, id ASC`, taskID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	transitions := []TaskTransition{}
+	for rows.Next() {
+		var t TaskTransition
+		if err := rows.Scan(&t.ID, &t.TaskID, &t.FromStatus, &t.ToStatus, &t.TransitionedAt,
+			&t.UptimeSeconds, &t.DowntimeSeconds); err != nil {
+			return nil, err
+		}
+		transitions = append(transitions, t)
+	}
+	return transitions, rows.Err()
+}
+
+// SLOs
+
+const sloColumns = `s.id, s.task_id, ''::text, t.user_id, s.name, s.target, s.kind, s.window_days,
+         COALESCE(s.webhook_url, ''), COALESCE(s.email, ''), s.created_at, s.firing_alerts`
+
+// tagSLOColumns are the sloColumns of the tag_slos table
+const tagSLOColumns = `id, 0::bigint, tag, user_id, name, target, kind, window_days,
+         COALESCE(webhook_url, ''), COALESCE(email, ''), created_at, firing_alerts`
+
+func scanSLO(row interface{ Scan(...interface{}) error }) (SLO, error) {
+	var slo SLO
+	var firing string
+	err := row.Scan(
+		&slo.ID,
+		&slo.TaskID,
+		&slo.Tag,
+		&slo.UserID,
+		&slo.Name,
+		&slo.Target,
+		&slo.Kind,
+		&slo.WindowDays,
+		&slo.WebhookURL,
+		&slo.Email,
+		&slo.CreatedAt,
+		&firing,
+	)
+	slo.FiringAlerts = splitSLOAlerts(firing)
+	return slo, err
+}
+
+// sloTable is the table holding an SLO: tag SLOs have their own, as slos is
+// distributed by task
+func sloTable(slo SLO) string {
+	if slo.Tag != "" {
+		return "tag_slos"
+	}
+	return "slos"
+}
+
+func (s *PostgresStore) CreateSLO(ctx context.Context, slo SLO) (SLO, error) {
+	var id int64
+	var err error
+	if slo.Tag != "" {
+		err = s.pool.QueryRow(ctx, `
+        INSERT INTO tag_slos (user_id, tag, name, target, kind, window_days, webhook_url, email)
+        VALUES ($1, $2, $3, $4, COALESCE(NULLIF($5, ''), 'time'), $6, $7, $8) RETURNING id`,
+			slo.UserID, slo.Tag, slo.Name, slo.Target, slo.Kind, slo.WindowDays, slo.WebhookURL, slo.Email).Scan(&id)
+	} else {
+		err = s.pool.QueryRow(ctx, `
+        INSERT INTO slos (task_id, name, target, kind, window_days, webhook_url, email)
+        VALUES ($1, $2, $3, COALESCE(NULLIF($4, ''), 'time'), $5, $6, $7) RETURNING id`,
+			slo.TaskID, slo.Name, slo.Target, slo.Kind, slo.WindowDays, slo.WebhookURL, slo.Email).Scan(&id)
+	}
+	if err != nil {
+		return SLO{}, err
+	}
+	return s.GetSLO(ctx, id)
+}
+
+func (s *PostgresStore) GetSLO(ctx context.Context, id int64) (SLO, error) {
+	slo, err := scanSLO(s.pool.QueryRow(ctx,
+		`SELECT `+sloColumns+` FROM slos s JOIN tasks t ON t.id = s.task_id WHERE s.id = $1`, id))
+	if errors.Is(err, pgx.ErrNoRows) {
+		slo, err = scanSLO(s.pool.QueryRow(ctx, `SELECT `+tagSLOColumns+` FROM tag_slos WHERE id = $1`, id))
+	}
+	return slo, notFound(err)
+}
+
+func (s *PostgresStore) ListSLOs(ctx context.Context, userID, orgID int64) ([]SLO, error) {
+	slos, err := s.querySLOs(ctx, `
+        SELECT `+sloColumns+`
+        FROM slos s
+        JOIN tasks t ON t.id = s.task_id
+        WHERE ($1 = 0 AND $2 = 0)
+            OR CASE WHEN $2 = 0 THEN t.user_id = $1 AND t.org_id IS NULL ELSE t.org_id = $2 END`, userID, orgID)
+	if err != nil {
+		return nil, err
+	}
+	// Tag SLOs cover personal tasks
+	tagSLOs, err := s.querySLOs(ctx, `
+        SELECT `+tagSLOColumns+`
+        FROM tag_slos
+        WHERE ($1 = 0 AND $2 = 0) OR ($2 = 0 AND user_id = $1)`, userID, orgID)
+	if err != nil {
+		return nil, err
+	}
+	slos = append(slos, tagSLOs...)
+	sort.Slice(slos, func(i, j int) bool { return slos[i].ID < slos[j].ID })
+	return slos, nil
+}
+
+// querySLOs runs a query selecting sloColumns or tagSLOColumns
+func (s *PostgresStore) querySLOs(ctx context.Context, query string, args ...interface{}) ([]SLO, error) {
+	rows, err := s.pool.Query(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	slos := []SLO{}
+	for rows.Next() {
+		slo, err := scanSLO(rows)
+		if err != nil {
+			return nil, err
+		}
+		slos = append(slos, slo)
+	}
+	return slos, rows.Err()
+}
+
+func (s *PostgresStore) UpdateSLO(ctx context.Context, slo SLO) (SLO, error) {
+	stored, err := s.GetSLO(ctx, slo.ID)
+	if err != nil {
+		return SLO{}, err
+	}
+	tag, err := s.pool.Exec(ctx, `
+        UPDATE `+sloTable(stored)+` SET name = $2, target = $3, kind = COALESCE(NULLIF($4, ''), 'time'),
+            window_days = $5, webhook_url = $6, email = $7
+        WHERE id = $1`,
+		slo.ID, slo.Name, slo.Target, slo.Kind, slo.WindowDays, slo.WebhookURL, slo.Email)
+	if err != nil {
+		return SLO{}, err
+	}
+	if tag.RowsAffected() == 0 {
+		return SLO{}, ErrNotFound
+	}
+	return s.GetSLO(ctx, slo.ID)
+}
+
+func (s *PostgresStore) DeleteSLO(ctx context.Context, id int64) error {
+	stored, err := s.GetSLO(ctx, id)
+	if err != nil {
+		return err
+	}
+	tag, err := s.pool.Exec(ctx, "DELETE FROM "+sloTable(stored)+" WHERE id = $1", id)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (s *PostgresStore) ClaimSLOAlerts(ctx context.Context, id int64, from, to []string) (bool, error) {
+	// IDs are unique across both tables
+	for _, table := range []string{"slos", "tag_slos"} {
+		tag, err := s.pool.Exec(ctx,
+			"UPDATE "+table+" SET firing_alerts = $3 WHERE id = $1 AND firing_alerts = $2",
+			id, joinSLOAlerts(from), joinSLOAlerts(to))
+		if err != nil {
+			return false, err
+		}
+		if tag.RowsAffected() > 0 {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// Report schedules
+
+const reportScheduleColumns = `id, user_id, period, format, email, last_period_end, created_at`
+
+func scanReportSchedule(row interface{ Scan(...interface{}) error }) (ReportSchedule, error) {
+	var rs ReportSchedule
+	err := row.Scan(&rs.ID, &rs.UserID, &rs.Period, &rs.Format, &rs.Email, &rs.LastPeriodEnd, &rs.CreatedAt)
+	return rs, err
+}
+
+func (s *PostgresStore) CreateReportSchedule(ctx context.Context, schedule ReportSchedule) (ReportSchedule, error) {
+	created, err := scanReportSchedule(s.pool.QueryRow(ctx, `
+        INSERT INTO report_schedules (user_id, period, format, email, last_period_end)
+        VALUES ($1, $2, $3, $4, $5)
+        RETURNING `+reportScheduleColumns,
+		schedule.UserID, schedule.Period, schedule.Format, schedule.Email, schedule.LastPeriodEnd))
+	return created, err
+}
+
+func (s *PostgresStore) ListReportSchedules(ctx context.Context, userID int64) ([]ReportSchedule, error) {
+	rows, err := s.pool.Query(ctx, `
+        SELECT `+reportScheduleColumns+`
+        FROM report_schedules
+        WHERE $1 = 0 OR user_id = $1
+        ORDER BY id`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	schedules := []ReportSchedule{}
+	for rows.Next() {
+		rs, err := scanReportSchedule(rows)
+		if err != nil {
+			return nil, err
+		}
+		schedules = append(schedules, rs)
+	}
+	return schedules, rows.Err()
+}
+
+func (s *PostgresStore) DeleteReportSchedule(ctx context.Context, id int64) error {
+	tag, err := s.pool.Exec(ctx, "DELETE FROM report_schedules WHERE id = $1", id)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (s *PostgresStore) ClaimReportPeriod(ctx context.Context, id int64, periodEnd time.Time) (bool, error) {
+	tag, err := s.pool.Exec(ctx, `
+        UPDATE report_schedules SET last_period_end = $2
+        WHERE id = $1 AND (last_period_end IS NULL OR last_period_end < $2)`, id, periodEnd)
+	if err != nil {
+		return false, err
+	}
+	return tag.RowsAffected() > 0, nil
+}
+
+func (s *PostgresStore) ReleaseReportPeriod(ctx context.Context, id int64, periodEnd time.Time, previous *time.Time) error {
+	_, err := s.pool.Exec(ctx,
+		"UPDATE report_schedules SET last_period_end = $3 WHERE id = $1 AND last_period_end = $2", id, periodEnd, previous)
+	return err
+}
+
+// Status pages
+
+const statusPageColumns = `id, user_id, COALESCE(org_id, 0), slug, title, task_ids, protection,
+         COALESCE(password_hash, ''), COALESCE(access_token, ''), created_at`
+
+func scanStatusPage(row pgx.Row) (StatusPage, error) {
+	var page StatusPage
+	err := row.Scan(&page.ID, &page.UserID, &page.OrgID, &page.Slug, &page.Title, &page.TaskIDs, &page.Protection,
+		&page.PasswordHash, &page.AccessToken, &page.CreatedAt)
+	return page, notFound(err)
+}
+
+// conflict maps unique violations to ErrConflict
+func conflict(err error) error {
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) && pgErr.Code == "23505" {
+		return ErrConflict
+	}
+	return err
+}
+
+func (s *PostgresStore) CreateStatusPage(ctx context.Context, page StatusPage) (StatusPage, error) {
+	created, err := scanStatusPage(s.pool.QueryRow(ctx, `
+        INSERT INTO status_pages (user_id, org_id, slug, title, task_ids, protection, password_hash, access_token)
+        VALUES ($1, NULLIF($2, 0), $3, $4, $5, $6, NULLIF($7, ''), NULLIF($8, ''))
+        RETURNING `+statusPageColumns,
+		page.UserID, page.OrgID, page.Slug, page.Title, page.TaskIDs, page.Protection, page.PasswordHash, page.AccessToken))
+	return created, conflict(err)
+}
+
+func (s *PostgresStore) GetStatusPage(ctx context.Context, id int64) (StatusPage, error) {
+	return scanStatusPage(s.pool.QueryRow(ctx, `SELECT `+statusPageColumns+` FROM status_pages WHERE id = $1`, id))
+}
+
+func (s *PostgresStore) GetStatusPageBySlug(ctx context.Context, slug string) (StatusPage, error) {
+	return scanStatusPage(s.pool.QueryRow(ctx, `SELECT `+statusPageColumns+` FROM status_pages WHERE slug = $1`, slug))
+}
+
+func (s *PostgresStore) ListStatusPages(ctx context.Context, userID, orgID int64) ([]StatusPage, error) {
+	rows, err := s.pool.Query(ctx, `
+        SELECT `+statusPageColumns+`
+        FROM status_pages
+        WHERE CASE WHEN $2 = 0 THEN user_id = $1 AND org_id IS NULL ELSE org_id = $2 END
+        ORDER BY id`, userID, orgID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	pages := []StatusPage{}
+	for rows.Next() {
+		page, err := scanStatusPage(rows)
+		if err != nil {
+			return nil, err
+		}
+		pages = append(pages, page)
+	}
+	return pages, rows.Err()
+}
+
+func (s *PostgresStore) UpdateStatusPage(ctx context.Context, page StatusPage) (StatusPage, error) {
+	updated, err := scanStatusPage(s.pool.QueryRow(ctx, `
+        UPDATE status_pages
+        SET slug = $2, title = $3, task_ids = $4, protection = $5,
+            password_hash = NULLIF($6, ''), access_token = NULLIF($7, '')
+        WHERE id = $1
+        RETURNING `+statusPageColumns,
+		page.ID, page.Slug, page.Title, page.TaskIDs, page.Protection, page.PasswordHash, page.AccessToken))
+	return updated, conflict(err)
+}
+
+func (s *PostgresStore) DeleteStatusPage(ctx context.Context, id int64) error {
+	tag, err := s.pool.Exec(ctx, "DELETE FROM status_pages WHERE id = $1", id)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// Badges
+
+const badgeColumns = `id, user_id, task_id, tag, project, label, show_uptime, token, created_at`
+
+func scanBadge(row interface{ Scan(...interface{}) error }) (Badge, error) {
+	var badge Badge
+	err := row.Scan(&badge.ID, &badge.UserID, &badge.TaskID, &badge.Tag, &badge.Project, &badge.Label,
+		&badge.ShowUptime, &badge.Token, &badge.CreatedAt)
+	return badge, err
+}
+
+func (s *PostgresStore) CreateBadge(ctx context.Context, badge Badge) (Badge, error) {
+	created, err := scanBadge(s.pool.QueryRow(ctx, `
+        INSERT INTO badges (user_id, task_id, tag, project, label, show_uptime, token)
+        VALUES ($1, $2, $3, $4, $5, $6, $7)
+        RETURNING `+badgeColumns,
+		badge.UserID, badge.TaskID, badge.Tag, badge.Project, badge.Label, badge.ShowUptime, badge.Token))
+	return created, conflict(err)
+}
+
+func (s *PostgresStore) GetBadgeByToken(ctx context.Context, token string) (Badge, error) {
+	badge, err := scanBadge(s.pool.QueryRow(ctx, `SELECT `+badgeColumns+` FROM badges WHERE token = $1`, token))
+	return badge, notFound(err)
+}
+
+func (s *PostgresStore) ListBadges(ctx context.Context, userID int64) ([]Badge, error) {
+	rows, err := s.pool.Query(ctx, `SELECT `+badgeColumns+` FROM badges WHERE user_id = $1 ORDER BY id`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	badges := []Badge{}
+	for rows.Next() {
+		badge, err := scanBadge(rows)
+		if err != nil {
+			return nil, err
+		}
+		badges = append(badges, badge)
+	}
+	return badges, rows.Err()
+}
+
+func (s *PostgresStore) DeleteBadge(ctx context.Context, userID, id int64) error {
+	tag, err := s.pool.Exec(ctx, "DELETE FROM badges WHERE id = $1 AND user_id = $2", id, userID)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// Notification routes
+
+const notificationRouteColumns = `id, user_id, COALESCE(org_id, 0), tag, project, webhook_url, email, created_at`
+
+func scanNotificationRoute(row interface{ Scan(...interface{}) error }) (NotificationRoute, error) {
+	var route NotificationRoute
+	err := row.Scan(&route.ID, &route.UserID, &route.OrgID, &route.Tag, &route.Project, &route.WebhookURL, &route.Email, &route.CreatedAt)
+	return route, err
+}
+
+func (s *PostgresStore) CreateNotificationRoute(ctx context.Context, route NotificationRoute) (NotificationRoute, error) {
+	return scanNotificationRoute(s.pool.QueryRow(ctx, `
+        INSERT INTO notification_routes (user_id, org_id, tag, project, webhook_url, email)
+        VALUES ($1, NULLIF($2, 0), $3, $4, $5, $6)
+        RETURNING `+notificationRouteColumns,
+		route.UserID, route.OrgID, route.Tag, route.Project, route.WebhookURL, route.Email))
+}
+
+func (s *PostgresStore) GetNotificationRoute(ctx context.Context, id int64) (NotificationRoute, error) {
+	route, err := scanNotificationRoute(s.pool.QueryRow(ctx,
+		`SELECT `+notificationRouteColumns+` FROM notification_routes WHERE id = $1`, id))
+	return route, notFound(err)
+}
+
+func (s *PostgresStore) ListNotificationRoutes(ctx context.Context, userID, orgID int64) ([]NotificationRoute, error) {
+	rows, err := s.pool.Query(ctx, `
+        SELECT `+notificationRouteColumns+` FROM notification_routes
+        WHERE CASE WHEN $2 = 0 THEN user_id = $1 AND org_id IS NULL ELSE org_id = $2 END
+        ORDER BY id`, userID, orgID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	routes := []NotificationRoute{}
+	for rows.Next() {
+		route, err := scanNotificationRoute(rows)
+		if err != nil {
+			return nil, err
+		}
+		routes = append(routes, route)
+	}
+	return routes, rows.Err()
+}
+
+func (s *PostgresStore) DeleteNotificationRoute(ctx context.Context, id int64) error {
+	tag, err := s.pool.Exec(ctx, "DELETE FROM notification_routes WHERE id = $1", id)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// Organizations
+
+func (s *PostgresStore) CreateOrg(ctx context.Context, name string, ownerID int64) (Organization, error) {
+	tx, err := s.pool.Begin(ctx)
+	if err != nil {
+		return Organization{}, err
+	}
+	defer tx.Rollback(ctx)
+
+	org := Organization{Name: name, Role: roleOwner}
+	err = tx.QueryRow(ctx,
+		`INSERT INTO organizations (name) VALUES ($1) RETURNING id, created_at`,
+		name).Scan(&org.ID, &org.CreatedAt)
+	if err != nil {
+		return Organization{}, err
+	}
+	_, err = tx.Exec(ctx,
+		`INSERT INTO org_members (org_id, user_id, role) VALUES ($1, $2, $3)`,
+		org.ID, ownerID, roleOwner)
+	if err != nil {
+		return Organization{}, err
+	}
+	return org, tx.Commit(ctx)
+}
+
+func (s *PostgresStore) GetOrg(ctx context.Context, id int64) (Organization, error) {
+	var org Organization
+	err := s.pool.QueryRow(ctx, `SELECT id, name, require_2fa, created_at FROM organizations WHERE id = $1`, id).
+		Scan(&org.ID, &org.Name, &org.Require2FA, &org.CreatedAt)
+	return org, notFound(err)
+}
+
+func (s *PostgresStore) UpdateOrg(ctx context.Context, org Organization) (Organization, error) {
+	err := s.pool.QueryRow(ctx, `
+        UPDATE organizations SET name = $2, require_2fa = $3 WHERE id = $1
+        RETURNING id, name, require_2fa, created_at`, org.ID, org.Name, org.Require2FA).
+		Scan(&org.ID, &org.Name, &org.Require2FA, &org.CreatedAt)
+	return org, notFound(err)
+}
+
+func (s *PostgresStore) ListUserOrgs(ctx context.Context, userID int64) ([]Organization, error) {
+	rows, err := s.pool.Query(ctx, `
+        SELECT o.id, o.name, o.require_2fa, m.role, o.created_at
+        FROM organizations o
+        JOIN org_members m ON m.org_id = o.id
+        WHERE m.user_id = $1
+        ORDER BY o.id`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	orgs := []Organization{}
+	for rows.Next() {
+		var org Organization
+		if err := rows.Scan(&org.ID, &org.Name, &org.Require2FA, &org.Role, &org.CreatedAt); err != nil {
+			return nil, err
+		}
+		orgs = append(orgs, org)
+	}
+	return orgs, rows.Err()
+}
+
+func (s *PostgresStore) DeleteOrg(ctx context.Context, id int64) error {
+	// Members, invitations, status pages and routes cascade
+	tag, err := s.pool.Exec(ctx, "DELETE FROM organizations WHERE id = $1", id)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (s *PostgresStore) GetOrgRole(ctx context.Context, orgID, userID int64) (string, error) {
+	var role string
+	err := s.pool.QueryRow(ctx,
+		`SELECT role FROM org_members WHERE org_id = $1 AND user_id = $2`,
+		orgID, userID).Scan(&role)
+	return role, notFound(err)
+}
+
+func (s *PostgresStore) ListOrgMembers(ctx context.Context, orgID int64) ([]OrgMember, error) {
+	rows, err := s.pool.Query(ctx, `
+        SELECT m.org_id, m.user_id, u.username, u.email, m.role, u.totp_enabled_at IS NOT NULL, m.created_at
+        FROM org_members m
+        JOIN users u ON u.id = m.user_id
+        WHERE m.org_id = $1
+        ORDER BY m.user_id`, orgID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	members := []OrgMember{}
+	for rows.Next() {
+		var m OrgMember
+		if err := rows.Scan(&m.OrgID, &m.UserID, &m.Username, &m.Email, &m.Role, &m.TwoFactor, &m.CreatedAt); err != nil {
+			return nil, err
+		}
+		members = append(members, m)
+	}
+	return members, rows.Err()
+}
+
+func (s *PostgresStore) AddOrgMember(ctx context.Context, orgID, userID int64, role string) error {
+	tag, err := s.pool.Exec(ctx, `
+        INSERT INTO org_members (org_id, user_id, role)
+        SELECT id, $2, $3 FROM organizations WHERE id = $1`,
+		orgID, userID, role)
+	if err != nil {
+		return conflict(err)
+	}
+	if tag.RowsAffected() == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (s *PostgresStore) SetOrgRole(ctx context.Context, orgID, userID int64, role string) error {
+	tag, err := s.pool.Exec(ctx,
+		"UPDATE org_members SET role = $3 WHERE org_id = $1 AND user_id = $2",
+		orgID, userID, role)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (s *PostgresStore) RemoveOrgMember(ctx context.Context, orgID, userID int64) error {
+	tag, err := s.pool.Exec(ctx, "DELETE FROM org_members WHERE org_id = $1 AND user_id = $2", orgID, userID)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+const invitationColumns = `id, org_id, email, role, token_hash, invited_by, expires_at, accepted_at, created_at`
+
+func scanInvitation(row interface{ Scan(...interface{}) error }) (OrgInvitation, error) {
+	var inv OrgInvitation
+	err := row.Scan(&inv.ID, &inv.OrgID, &inv.Email, &inv.Role, &inv.TokenHash, &inv.InvitedBy,
+		&inv.ExpiresAt, &inv.AcceptedAt, &inv.CreatedAt)
+	return inv, err
+}
+
+func (s *PostgresStore) CreateInvitation(ctx context.Context, invitation OrgInvitation) (OrgInvitation, error) {
+	created, err := scanInvitation(s.pool.QueryRow(ctx, `
+        INSERT INTO org_invitations (org_id, email, role, token_hash, invited_by, expires_at)
+        VALUES ($1, $2, $3, $4, $5, $6)
+        RETURNING `+invitationColumns,
+		invitation.OrgID, invitation.Email, invitation.Role, invitation.TokenHash, invitation.InvitedBy, invitation.ExpiresAt))
+	return created, conflict(err)
+}
+
+func (s *PostgresStore) ListInvitations(ctx context.Context, orgID int64) ([]OrgInvitation, error) {
+	rows, err := s.pool.Query(ctx, `
+        SELECT `+invitationColumns+` FROM org_invitations
+        WHERE org_id = $1 AND accepted_at IS NULL
+        ORDER BY id`, orgID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	invitations := []OrgInvitation{}
+	for rows.Next() {
+		invitation, err := scanInvitation(rows)
+		if err != nil {
+			return nil, err
+		}
+		invitations = append(invitations, invitation)
+	}
+	return invitations, rows.Err()
+}
+
+func (s *PostgresStore) DeleteInvitation(ctx context.Context, orgID, id int64) error {
+	tag, err := s.pool.Exec(ctx, "DELETE FROM org_invitations WHERE id = $1 AND org_id = $2", id, orgID)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (s *PostgresStore) AcceptInvitation(ctx context.Context, tokenHash string, userID int64) (OrgMember, error) {
+	tx, err := s.pool.Begin(ctx)
+	if err != nil {
+		return OrgMember{}, err
+	}
+	defer tx.Rollback(ctx)
+
+	member := OrgMember{UserID: userID}
+	err = tx.QueryRow(ctx, `
+        UPDATE org_invitations SET accepted_at = LOCALTIMESTAMP
+        WHERE token_hash = $1 AND accepted_at IS NULL AND expires_at > LOCALTIMESTAMP
+            AND lower(email) = (SELECT lower(email) FROM users WHERE id = $2 AND email_verified_at IS NOT NULL)
+        RETURNING org_id, role`, tokenHash, userID).Scan(&member.OrgID, &member.Role)
+	if err != nil {
+		return OrgMember{}, notFound(err)
+	}
+
+	err = tx.QueryRow(ctx, `
+        INSERT INTO org_members (org_id, user_id, role) VALUES ($1, $2, $3)
+        RETURNING created_at, (SELECT username FROM users WHERE id = $2), (SELECT email FROM users WHERE id = $2)`,
+		member.OrgID, userID, member.Role).Scan(&member.CreatedAt, &member.Username, &member.Email)
+	if err != nil {
+		return OrgMember{}, conflict(err)
+	}
+	return member, tx.Commit(ctx)
+}
+
+// API keys
+
+const apiKeyColumns = `id, user_id, name, prefix, key_hash, scopes, expires_at, last_used_at, created_at`
+
+func scanAPIKey(row interface{ Scan(...interface{}) error }) (APIKey, error) {
+	var key APIKey
+	var scopes string
+	err := row.Scan(&key.ID, &key.UserID, &key.Name, &key.Prefix, &key.KeyHash, &scopes,
+		&key.ExpiresAt, &key.LastUsedAt, &key.CreatedAt)
+	key.Scopes = strings.Fields(scopes)
+	return key, err
+}
+
+func (s *PostgresStore) CreateAPIKey(ctx context.Context, key APIKey) (APIKey, error) {
+	created, err := scanAPIKey(s.pool.QueryRow(ctx, `
+        INSERT INTO api_keys (user_id, name, prefix, key_hash, scopes, expires_at)
+        VALUES ($1, $2, $3, $4, $5, $6)
+        RETURNING `+apiKeyColumns,
+		key.UserID, key.Name, key.Prefix, key.KeyHash, strings.Join(key.Scopes, " "), key.ExpiresAt))
+	return created, conflict(err)
+}
+
+func (s *PostgresStore) ListAPIKeys(ctx context.Context, userID int64) ([]APIKey, error) {
+	rows, err := s.pool.Query(ctx, `SELECT `+apiKeyColumns+` FROM api_keys WHERE user_id = $1 ORDER BY id`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	keys := []APIKey{}
+	for rows.Next() {
+		key, err := scanAPIKey(rows)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, key)
+	}
+	return keys, rows.Err()
+}
+
+func (s *PostgresStore) DeleteAPIKey(ctx context.Context, userID, id int64) error {
+	tag, err := s.pool.Exec(ctx, "DELETE FROM api_keys WHERE id = $1 AND user_id = $2", id, userID)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (s *PostgresStore) UseAPIKey(ctx context.Context, keyHash string) (APIKey, error) {
+	key, err := scanAPIKey(s.pool.QueryRow(ctx, `
+        UPDATE api_keys SET last_used_at = LOCALTIMESTAMP
+        WHERE key_hash = $1 AND (expires_at IS NULL OR expires_at > LOCALTIMESTAMP)
+        RETURNING `+apiKeyColumns, keyHash))
+	return key, notFound(err)
+}
+
+// Sessions
+
+const sessionColumns = `id, user_id, refresh_hash, user_agent, ip, created_at, last_used_at, expires_at`
+
+func scanSession(row interface{ Scan(...interface{}) error }) (Session, error) {
+	var session Session
+	err := row.Scan(&session.ID, &session.UserID, &session.RefreshHash, &session.UserAgent, &session.IP,
+		&session.CreatedAt, &session.LastUsedAt, &session.ExpiresAt)
+	return session, err
+}
+
+func (s *PostgresStore) CreateSession(ctx context.Context, session Session) (Session, error) {
+	if _, err := s.pool.Exec(ctx,
+		"DELETE FROM sessions WHERE user_id = $1 AND expires_at <= LOCALTIMESTAMP", session.UserID); err != nil {
+		return Session{}, err
+	}
+	created, err := scanSession(s.pool.QueryRow(ctx, `
+        INSERT INTO sessions (user_id, refresh_hash, user_agent, ip, expires_at)
+        VALUES ($1, $2, $3, $4, $5)
+        RETURNING `+sessionColumns,
+		session.UserID, session.RefreshHash, session.UserAgent, session.IP, session.ExpiresAt))
+	return created, conflict(err)
+}
+
+func (s *PostgresStore) GetActiveSession(ctx context.Context, id int64) (Session, error) {
+	session, err := scanSession(s.pool.QueryRow(ctx, `
+        SELECT `+sessionColumns+` FROM sessions
+        WHERE id = $1 AND expires_at > LOCALTIMESTAMP`, id))
+	return session, notFound(err)
+}
+
+func (s *PostgresStore) RotateSession(ctx context.Context, refreshHash string, update Session) (Session, error) {
+	session, err := scanSession(s.pool.QueryRow(ctx, `
+        UPDATE sessions
+        SET refresh_hash = $2, previous_refresh_hash = refresh_hash, user_agent = $3, ip = $4,
+            last_used_at = LOCALTIMESTAMP, expires_at = $5
+        WHERE refresh_hash = $1 AND expires_at > LOCALTIMESTAMP
+        RETURNING `+sessionColumns,
+		refreshHash, update.RefreshHash, update.UserAgent, update.IP, update.ExpiresAt))
+	if !errors.Is(err, pgx.ErrNoRows) {
+		return session, conflict(err)
+	}
+
+	tag, err := s.pool.Exec(ctx, "DELETE FROM sessions WHERE previous_refresh_hash = $1", refreshHash)
+	if err != nil {
+		return Session{}, err
+	}
+	if tag.RowsAffected() > 0 {
+		log.Printf("Revoked a session whose replaced refresh token was presented again")
+	}
+	return Session{}, ErrNotFound
+}
+
+func (s *PostgresStore) ListSessions(ctx context.Context, userID int64) ([]Session, error) {
+	rows, err := s.pool.Query(ctx, `
+        SELECT `+sessionColumns+` FROM sessions
+        WHERE user_id = $1 AND expires_at > LOCALTIMESTAMP
+        ORDER BY last_used_at DESC, id DESC`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	sessions := []Session{}
+	for rows.Next() {
+		session, err := scanSession(rows)
+		if err != nil {
+			return nil, err
+		}
+		sessions = append(sessions, session)
+	}
+	return sessions, rows.Err()
+}
+
+func (s *PostgresStore) DeleteSession(ctx context.Context, userID, id int64) error {
+	tag, err := s.pool.Exec(ctx, "DELETE FROM sessions WHERE id = $1 AND user_id = $2", id, userID)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (s *PostgresStore) DeleteUserSessions(ctx context.Context, userID int64) error {
+	_, err := s.pool.Exec(ctx, "DELETE FROM sessions WHERE user_id = $1", userID)
+	return err
+}
+
+// Two-factor authentication
+
+func (s *PostgresStore) GetTwoFactor(ctx context.Context, userID int64) (TwoFactor, error) {
+	var tf TwoFactor
+	err := s.pool.QueryRow(ctx, `
+        SELECT COALESCE(totp_secret, ''), totp_enabled_at IS NOT NULL, totp_last_step,
+            (SELECT COUNT(*) FROM recovery_codes WHERE user_id = users.id)
+        FROM users WHERE id = $1`, userID).
+		Scan(&tf.Secret, &tf.Enabled, &tf.LastStep, &tf.RecoveryCodes)
+	return tf, notFound(err)
+}
+
+func (s *PostgresStore) SetTOTPSecret(ctx context.Context, userID int64, secret string) error {
+	tag, err := s.pool.Exec(ctx,
+		"UPDATE users SET totp_secret = $2 WHERE id = $1 AND totp_enabled_at IS NULL",
+		userID, secret)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return ErrConflict
+	}
+	return nil
+}
+
+func (s *PostgresStore) EnableTwoFactor(ctx context.Context, userID, step int64, recoveryHashes []string) error {
+	tx, err := s.pool.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	var enabled bool
+	err = tx.QueryRow(ctx, `
+        SELECT totp_enabled_at IS NOT NULL FROM users
+        WHERE id = $1 AND totp_secret IS NOT NULL FOR UPDATE`, userID).Scan(&enabled)
+	if err != nil {
+		return notFound(err)
+	}
+	if enabled {
+		return ErrConflict
+	}
+	if _, err := tx.Exec(ctx,
+		"UPDATE users SET totp_enabled_at = LOCALTIMESTAMP, totp_last_step = $2 WHERE id = $1",
+		userID, step); err != nil {
+		return err
+	}
+	if err := replaceRecoveryCodes(ctx, tx, userID, recoveryHashes); err != nil {
+		return err
+	}
+	return tx.Commit(ctx)
+}
+
+// replaceRecoveryCodes swaps the user's recovery codes within tx
+func replaceRecoveryCodes(ctx context.Context, tx pgx.Tx, userID int64, codeHashes []string) error {
+	if _, err := tx.Exec(ctx, "DELETE FROM recovery_codes WHERE user_id = $1", userID); err != nil {
+		return err
+	}
+	_, err := tx.Exec(ctx, `
+        INSERT INTO recovery_codes (user_id, code_hash)
+        SELECT $1, unnest($2::text[])`, userID, codeHashes)
+	return err
+}
+
+func (s *PostgresStore) UseTOTPStep(ctx context.Context, userID, step int64) error {
+	tag, err := s.pool.Exec(ctx,
+		"UPDATE users SET totp_last_step = $2 WHERE id = $1 AND totp_last_step < $2",
+		userID, step)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return ErrConflict
+	}
+	return nil
+}
+
+func (s *PostgresStore) UseRecoveryCode(ctx context.Context, userID int64, codeHash string) error {
+	tag, err := s.pool.Exec(ctx,
+		"DELETE FROM recovery_codes WHERE user_id = $1 AND code_hash = $2", userID, codeHash)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (s *PostgresStore) ReplaceRecoveryCodes(ctx context.Context, userID int64, codeHashes []string) error {
+	tx, err := s.pool.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	if err := replaceRecoveryCodes(ctx, tx, userID, codeHashes); err != nil {
+		return err
+	}
+	return tx.Commit(ctx)
+}
+
+func (s *PostgresStore) DisableTwoFactor(ctx context.Context, userID int64) error {
+	tx, err := s.pool.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	if _, err := tx.Exec(ctx, `
+        UPDATE users SET totp_secret = NULL, totp_enabled_at = NULL, totp_last_step = 0
+        WHERE id = $1`, userID); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(ctx, "DELETE FROM recovery_codes WHERE user_id = $1", userID); err != nil {
+		return err
+	}
+	return tx.Commit(ctx)
+}
+
+// Graph data
+
+// graphBucketsQuery builds the query behind GraphBuckets. The arguments are
+// the step in seconds, the task, user or organization ID, From, To, for
+// rollups the resolution in seconds and for users and organizations the tag
+// and project filters. hasTag returns the store's condition for "task t
+// carries the tag in arg".
+func graphBucketsQuery(q GraphQuery, bucket func(ts string) string, hasTag func(arg string) string) string {
+	owner := "g.task_id = $2"
+	if q.TaskID == 0 {
+		tag, project := "$5", "$6"
+		if !q.Tier.IsRaw() {
+			tag, project = "$6", "$7"
+		}
+		owner = "t.user_id = $2 AND t.org_id IS NULL"
+		if q.Filter.OrgID != 0 {
+			owner = "t.org_id = $2"
+		}
+		owner += fmt.Sprintf(" AND (%[1]s = '' OR %[2]s) AND (%[3]s = '' OR t.project = %[3]s)",
+			tag, hasTag(tag), project)
+	}
+
+	if q.Tier.IsRaw() {
+		return fmt.Sprintf(`
+        SELECT
+            g.task_id,
+            %s AS bucket,
+            COUNT(*),
+            SUM(CASE WHEN g.status = 'alive' THEN 1 ELSE 0 END),
+            SUM(CASE WHEN g.status = 'dead' THEN 1 ELSE 0 END),
+            AVG(g.uptime_percentage),
+            MIN(g.uptime_seconds), MAX(g.uptime_seconds),
+            MIN(g.downtime_seconds), MAX(g.downtime_seconds),
+            MIN(g.ping_count), MAX(g.ping_count)
+        FROM task_graph_data g
+        JOIN tasks t ON t.id = g.task_id
+        WHERE %s AND g.timestamp >= $3 AND g.timestamp < $4
+        GROUP BY g.task_id, bucket
+        ORDER BY g.task_id, bucket`, bucket("g.timestamp"), owner)
+	}
+	return fmt.Sprintf(`
+        SELECT
+            g.task_id,
+            %s AS bucket,
+            SUM(g.samples),
+            SUM(g.alive_samples),
+            SUM(g.dead_samples),
+            SUM(g.avg_uptime_percentage * g.samples) / SUM(g.samples),
+            MIN(g.uptime_seconds), MAX(g.uptime_seconds),
+            MIN(g.downtime_seconds), MAX(g.downtime_seconds),
+            MIN(g.ping_count), MAX(g.ping_count)
+        FROM task_graph_rollups g
+        JOIN tasks t ON t.id = g.task_id
+        WHERE %s AND g.bucket_start >= $3 AND g.bucket_start < $4 AND g.resolution_seconds = $5
+        GROUP BY g.task_id, bucket
+        ORDER BY g.task_id, bucket`, bucket("g.bucket_start"), owner)
+}
+
+// graphBucketsArgs returns the arguments of graphBucketsQuery
+func graphBucketsArgs(q GraphQuery) []interface{} {
+	owner := q.TaskID
+	switch {
+	case owner != 0:
+	case q.Filter.OrgID != 0:
+		owner = q.Filter.OrgID
+	default:
+		owner = q.UserID
+	}
+	args := []interface{}{int64(q.Step / time.Second), owner, q.From, q.To}
+	if !q.Tier.IsRaw() {
+		args = append(args, int64(q.Tier.Resolution/time.Second))
+	}
+	if q.TaskID == 0 {
+		args = append(args, q.Filter.Tag, q.Filter.Project)
+	}
+	return args
+}
+
+// pgHasTag matches tasks t carrying the tag in arg
+func pgHasTag(arg string) string {
+	return arg + " = ANY(t.tags)"
+}
+
+func (s *PostgresStore) GraphBuckets(ctx context.Context, q GraphQuery) ([]TaskGraphBucket, error) {
+	rows, err := s.pool.Query(ctx, graphBucketsQuery(q, pgBucket, pgHasTag), graphBucketsArgs(q)...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var buckets []TaskGraphBucket
+	for rows.Next() {
+		var b TaskGraphBucket
+		if err := rows.Scan(
+			&b.TaskID,
+			&b.BucketStart,
+			&b.Samples,
+			&b.AliveSamples,
+			&b.DeadSamples,
+			&b.AvgUptimePercentage,
+			&b.MinUptimeSeconds,
+			&b.MaxUptimeSeconds,
+			&b.MinDowntimeSeconds,
+			&b.MaxDowntimeSeconds,
+			&b.MinPingCount,
+			&b.MaxPingCount,
+		); err != nil {
+			return nil, err
+		}
+		buckets = append(buckets, b)
+	}
+	return buckets, rows.Err()
+}
+
+// pgBucket aligns the timestamp expression ts to buckets of $1 seconds
+func pgBucket(ts string) string {
+	return fmt.Sprintf(`('epoch'::timestamp + FLOOR(EXTRACT(EPOCH FROM %s) / $1::integer)::bigint * $1::integer * INTERVAL '1 second')`, ts)
+}
+
+func (s *PostgresStore) CompactGraphData(ctx context.Context, tiers []GraphTier) (CompactionResult, error) {
+	var result CompactionResult
+
+	for i, tier := range tiers {
+		if tier.IsRaw() || i == 0 {
+			continue
+		}
+		n, err := s.rollUp(ctx, tiers[i-1], tier)
+		if err != nil {
+			return result, fmt.Errorf("error rolling up %s: %v", tier.Name, err)
+		}
+		result.RolledUp += n
+	}
+
+	for _, tier := range tiers {
+		if tier.Retention <= 0 {
+			continue
+		}
+		retention := int64(tier.Retention / time.Second)
+
+		var err error
+		var tag pgconn.CommandTag
+		if tier.IsRaw() {
+			tag, err = s.pool.Exec(ctx,
+				"DELETE FROM task_graph_data WHERE timestamp < LOCALTIMESTAMP - $1::integer * INTERVAL '1 second'",
+				retention)
+		} else {
+			tag, err = s.pool.Exec(ctx,
+				"DELETE FROM task_graph_rollups WHERE resolution_seconds = $1 AND bucket_start < LOCALTIMESTAMP - $2::integer * INTERVAL '1 second'",
+				int64(tier.Resolution/time.Second), retention)
+		}
+		if err != nil {
+			return result, fmt.Errorf("error pruning %s: %v", tier.Name, err)
+		}
+		result.Pruned += tag.RowsAffected()
+	}
+	return result, nil
+}
+
+// rollUp aggregates the completed buckets of tier from the source tier below
+// it. The newest existing bucket is recomputed, since samples committed after
+// the last run may still have landed in it.
+func (s *PostgresStore) rollUp(ctx context.Context, source, tier GraphTier) (int64, error) {
+	resolution := int64(tier.Resolution / time.Second)
+
+	var from *time.Time
+	err := s.pool.QueryRow(ctx,
+		"SELECT MAX(bucket_start) FROM task_graph_rollups WHERE resolution_seconds = $1", resolution).Scan(&from)
+	if err != nil {
+		return 0, err
+	}
+
+	sourceQuery := `
+        SELECT task_id, timestamp AS at, 1 AS samples,
+            CASE WHEN status = 'alive' THEN 1 ELSE 0 END AS alive_samples,
+            CASE WHEN status = 'dead' THEN 1 ELSE 0 END AS dead_samples,
+            uptime_percentage AS avg_uptime_percentage, uptime_seconds, downtime_seconds, ping_count
+        FROM task_graph_data`
+	if !source.IsRaw() {
+		sourceQuery = fmt.Sprintf(`
+        SELECT task_id, bucket_start AS at, samples, alive_samples, dead_samples,
+            avg_uptime_percentage, uptime_seconds, downtime_seconds, ping_count
+        FROM task_graph_rollups
+        WHERE resolution_seconds = %d`, int64(source.Resolution/time.Second))
+	}
+
+	// The counters are cumulative, so a bucket keeps their latest (largest) values
+	query := fmt.Sprintf(`
+        INSERT INTO task_graph_rollups (task_id, resolution_seconds, bucket_start, samples,
+            alive_samples, dead_samples, avg_uptime_percentage, uptime_seconds, downtime_seconds, ping_count)
+        SELECT task_id, $1::integer, bucket, SUM(samples), SUM(alive_samples), SUM(dead_samples),
+            SUM(avg_uptime_percentage * samples) / SUM(samples), MAX(uptime_seconds), MAX(downtime_seconds),
+            MAX(ping_count)
+        FROM (
+            SELECT src.*, %s AS bucket
+            FROM (%s) src
+            WHERE src.at >= COALESCE($2::timestamp, '-infinity')
+        ) b
+        WHERE bucket < %s
+        GROUP BY task_id, bucket
+        ON CONFLICT (task_id, resolution_seconds, bucket_start) DO UPDATE SET
+            samples = EXCLUDED.samples,
+            alive_samples = EXCLUDED.alive_samples,
+            dead_samples = EXCLUDED.dead_samples,
+            avg_uptime_percentage = EXCLUDED.avg_uptime_percentage,
+            uptime_seconds = EXCLUDED.uptime_seconds,
+            downtime_seconds = EXCLUDED.downtime_seconds,
+            ping_count = EXCLUDED.ping_count`,
+		pgBucket("src.at"), sourceQuery, pgBucket("LOCALTIMESTAMP"))
+
+	tag, err := s.pool.Exec(ctx, query, resolution, from)
+	if err != nil {
+		return 0, err
+	}
+	return tag.RowsAffected(), nil
+}
+
+// Monitor
+
+func (s *PostgresStore) Now(ctx context.Context) (time.Time, error) {
+	var now time.Time
+	err := s.pool.QueryRow(ctx, "SELECT LOCALTIMESTAMP").Scan(&now)
+	return now, err
+}
+
+// MonitorPartitions returns the tables holding task rows. With Citus these are
+// the shards of the distributed tasks table; without Citus (or while tasks is
+// still a local table) the tasks table itself is a single partition.
+func (s *PostgresStore) MonitorPartitions(ctx context.Context) ([]string, error) {
+	var citusInstalled bool
+	err := s.pool.QueryRow(ctx, "SELECT EXISTS (SELECT 1 FROM pg_extension WHERE extname = 'citus')").Scan(&citusInstalled)
+	if err != nil {
+		return nil, err
+	}
+	if !citusInstalled {
+		return []string{"tasks"}, nil
+	}
+
+	// Fetch shard names from Citus metadata
+	shardQuery := `SELECT shard_name FROM citus_shards WHERE table_name = (SELECT oid FROM pg_class WHERE relname = 'tasks');`
+
+	shardRows, err := s.pool.Query(ctx, shardQuery)
+	if err != nil {
+		return nil, err
+	}
+	defer shardRows.Close()
+
+	var shards []string
+	for shardRows.Next() {
+		var shardName string
+		if err := shardRows.Scan(&shardName); err != nil {
+			log.Printf("Error scanning shard name: %v", err)
+			continue
+		}
+		shards = append(shards, shardName)
+	}
+	if err := shardRows.Err(); err != nil {
+		return nil, err
+	}
+
+	if len(shards) == 0 {
+		log.Println("No task shards found, monitoring the tasks table directly.")
+		return []string{"tasks"}, nil
+	}
+	return shards, nil
+}
+
+// MonitorPartition runs a monitor pass over one shard table. The status changes
+// and counters are computed by the UPDATE statements themselves against the
+// locked rows, so a heartbeat that commits while the pass is running is never
+// overwritten. Everything for a shard commits in one transaction.
+func (s *PostgresStore) MonitorPartition(ctx context.Context, shardName string, graceStart *time.Time) (int, []MonitoredTask, error) {
+	tx, err := s.pool.Begin(ctx)
+	if err != nil {
+		return 0, nil, fmt.Errorf("error starting transaction: %v", err)
+	}
+	defer tx.Rollback(ctx)
+
+	// Transition overdue tasks to dead. A task went dead at its ping deadline, so
+	// uptime runs up to the deadline and downtime from the deadline to now.
+	// GREATEST ignores the grace start when it is NULL. A task resumed from a
+	// pause gets a full interval from the resume. A task that never pinged has
+	// no deadline yet.
+	deadline := `(GREATEST(last_ping, $1::timestamp,
+        CASE WHEN previous_status = 'paused' AND last_ping IS NOT NULL THEN status_changed_at END)
+        + interval * INTERVAL '1 second')`
+	deadAt := fmt.Sprintf(`GREATEST(%s, COALESCE(last_checked, last_ping))`, deadline)
+	markDeadQuery := fmt.Sprintf(`
+        UPDATE %[1]s
+        SET
+            uptime_seconds = uptime_seconds + EXTRACT(EPOCH FROM (%[2]s - COALESCE(last_checked, %[2]s))),
+            downtime_seconds = downtime_seconds + EXTRACT(EPOCH FROM (LOCALTIMESTAMP - %[2]s)),
+            previous_status = status,
+            status = 'dead',
+            status_changed_at = %[2]s,
+            last_checked = LOCALTIMESTAMP
+        WHERE status = 'alive' AND last_ping IS NOT NULL AND %[3]s < LOCALTIMESTAMP
+        RETURNING id, status_changed_at, uptime_seconds,
+            downtime_seconds - EXTRACT(EPOCH FROM (last_checked - status_changed_at))`, shardName, deadAt, deadline)
+
+	deadCtx, deadSpan := otel.Tracer("task-tracker").Start(ctx, "mark-dead")
+	deadRows, err := tx.Query(deadCtx, markDeadQuery, graceStart)
+	if err != nil {
+		deadSpan.RecordError(err)
+		deadSpan.End()
+		return 0, nil, fmt.Errorf("error marking overdue tasks: %v", err)
+	}
+
+	var transitions [][]interface{}
+	for deadRows.Next() {
+		var (
+			taskID    int64
+			changedAt time.Time
+			uptime    float64
+			downtime  float64
+		)
+		if err := deadRows.Scan(&taskID, &changedAt, &uptime, &downtime); err != nil {
+			deadRows.Close()
+			deadSpan.End()
+			return 0, nil, fmt.Errorf("error scanning dead task: %v", err)
+		}
+		transitions = append(transitions, []interface{}{taskID, "alive", "dead", changedAt, uptime, downtime})
+	}
+	deadRows.Close()
+	deadSpan.End()
+	if err := deadRows.Err(); err != nil {
+		return 0, nil, fmt.Errorf("error marking overdue tasks: %v", err)
+	}
+
+	if err := recordTransitions(ctx, tx, transitions); err != nil {
+		return 0, nil, err
+	}
+
+	// Account the time since the last check for every other task. Rows whose
+	// last_checked was moved forward by a concurrent heartbeat are left alone.
+	accrueQuery := fmt.Sprintf(`
+        UPDATE %s
+        SET
+            uptime_seconds = uptime_seconds + CASE WHEN status = 'alive'
+                THEN EXTRACT(EPOCH FROM (LOCALTIMESTAMP - COALESCE(last_checked, LOCALTIMESTAMP))) ELSE 0 END,
+            downtime_seconds = downtime_seconds + CASE WHEN status IN ('alive', 'paused')
+                THEN 0 ELSE EXTRACT(EPOCH FROM (LOCALTIMESTAMP - COALESCE(last_checked, LOCALTIMESTAMP))) END,
+            last_checked = LOCALTIMESTAMP
+        WHERE last_checked IS NULL OR last_checked < LOCALTIMESTAMP`, shardName)
+
+	if _, err := tx.Exec(ctx, accrueQuery); err != nil {
+		return 0, nil, fmt.Errorf("error updating task metrics: %v", err)
+	}
+
+	// Read the updated rows back for the graph samples and the report
+	fetchQuery := fmt.Sprintf(`
+        SELECT
+            id,
+            name,
+            task_number,
+            status,
+            EXTRACT(EPOCH FROM (LOCALTIMESTAMP - last_ping)) AS time_diff,
+            interval,
+            last_ping,
+            LOCALTIMESTAMP,
+            uptime_seconds,
+            downtime_seconds,
+            ping_count
+        FROM %s;`, shardName)
+
+	// Create DB span for fetch operation
+	dbFetchCtx, dbFetchSpan := otel.Tracer("task-tracker").Start(ctx, "fetch-tasks")
+	taskRows, err := tx.Query(dbFetchCtx, fetchQuery)
+	if err != nil {
+		dbFetchSpan.RecordError(err)
+		dbFetchSpan.End()
+		return 0, nil, fmt.Errorf("error fetching task statuses: %v", err)
+	}
+
+	var tasks []MonitoredTask
+	var samples [][]interface{}
+	for taskRows.Next() {
+		var t MonitoredTask
+		var checkedAt time.Time
+		var pingCount int64
+		if err := taskRows.Scan(
+			&t.ID,
+			&t.Name,
+			&t.TaskNumber,
+			&t.Status,
+			&t.SinceLastPing,
+			&t.Interval,
+			&t.LastPing,
+			&checkedAt,
+			&t.UptimeSeconds,
+			&t.DowntimeSeconds,
+			&pingCount,
+		); err != nil {
+			// Skipping the row would drop its transition and sample; the
+			// shard transaction rolls back and the next tick retries it
+			taskRows.Close()
+			dbFetchSpan.RecordError(err)
+			dbFetchSpan.End()
+			return 0, nil, fmt.Errorf("error scanning task row: %v", err)
+		}
+		tasks = append(tasks, t)
+		samples = append(samples, []interface{}{
+			t.ID,
+			checkedAt,
+			t.Status,
+			t.UptimeSeconds,
+			t.DowntimeSeconds,
+			uptimePercentage(t.UptimeSeconds, t.DowntimeSeconds),
+			pingCount,
+		})
+	}
+	taskRows.Close()
+	dbFetchSpan.End()
+
+	if err := taskRows.Err(); err != nil {
+		return 0, nil, fmt.Errorf("error iterating task rows: %v", err)
+	}
+
+	// Stream the graph samples for this shard with COPY
+	_, err = tx.CopyFrom(
+		ctx,
+		pgx.Identifier{"task_graph_data"},
+		[]string{"task_id", "timestamp", "status", "uptime_seconds", "downtime_seconds", "uptime_percentage", "ping_count"},
+		pgx.CopyFromRows(samples),
+	)
+	if err != nil {
+		return 0, nil, fmt.Errorf("error storing graph data: %v", err)
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return 0, nil, fmt.Errorf("error committing shard results: %v", err)
+	}
+	return len(transitions), tasks, nil
+}
@@ -0,0 +1,1884 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"modernc.org/sqlite"
+	sqlite3 "modernc.org/sqlite/lib"
+)
+
+// SQLiteStore implements Store on an embedded SQLite database file, for
+// single-node deployments. The database has a single connection, so every
+// transaction is serialised; status changes are computed in Go with the shared
+// accounting rules in transitions.go and written back. All timestamps are UTC.
+type SQLiteStore struct {
+	db *sql.DB
+
+	// now is the store clock, replaceable in tests
+	now func() time.Time
+}
+
+// openSQLiteStore opens the database file named by a sqlite:// URL, e.g.
+// sqlite:///var/lib/serverlord/tasks.db (absolute) or sqlite://tasks.db
+// (relative to the working directory)
+func openSQLiteStore(ctx context.Context, dbURL string) (*SQLiteStore, error) {
+	path := dbURL[strings.Index(dbURL, "://")+3:]
+	if path == "" {
+		return nil, fmt.Errorf("sqlite URL %q has no file path", dbURL)
+	}
+
+	sep := "?"
+	if strings.Contains(path, "?") {
+		sep = "&"
+	}
+	// _time_format=sqlite stores timestamps as "2006-01-02 15:04:05.999999999-07:00",
+	// which compares correctly as text for the UTC times this store writes
+	dsn := "file:" + path + sep + "_pragma=foreign_keys(1)&_pragma=journal_mode(WAL)&_pragma=busy_timeout(5000)&_txlock=immediate&_time_format=sqlite"
+
+	db, err := sql.Open("sqlite", dsn)
+	if err != nil {
+		return nil, err
+	}
+	db.SetMaxOpenConns(1)
+
+	if err := db.PingContext(ctx); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return &SQLiteStore{db: db, now: func() time.Time { return time.Now().UTC() }}, nil
+}
+
+func (s *SQLiteStore) Migrate(ctx context.Context) error {
+	return s.MigrateSchema(ctx, "up", 0)
+}
+
+// MigrateSchema runs the SQLite migrations. The single connection already
+// serialises them, so no lock is needed.
+func (s *SQLiteStore) MigrateSchema(ctx context.Context, direction string, steps int) error {
+	migrations, err := loadMigrations(sqliteMigrationsDir)
+	if err != nil {
+		return fmt.Errorf("error loading migrations: %v", err)
+	}
+
+	_, err = s.db.ExecContext(ctx, `CREATE TABLE IF NOT EXISTS schema_migrations (
+            version INTEGER PRIMARY KEY,
+            name VARCHAR(255) NOT NULL,
+            applied_at TIMESTAMP NOT NULL
+        )`)
+	if err != nil {
+		return fmt.Errorf("error creating schema_migrations: %v", err)
+	}
+
+	applied, err := s.appliedMigrations(ctx)
+	if err != nil {
+		return fmt.Errorf("error reading schema_migrations: %v", err)
+	}
+
+	return runMigrations(migrations, applied, direction, steps, func(m Migration, up bool) error {
+		tx, err := s.db.BeginTx(ctx, nil)
+		if err != nil {
+			return err
+		}
+		defer tx.Rollback()
+
+		script, bookkeeping, args := m.Down, "DELETE FROM schema_migrations WHERE version = ?", []interface{}{m.Version}
+		if up {
+			script, bookkeeping, args = m.Up, "INSERT INTO schema_migrations (version, name, applied_at) VALUES (?, ?, ?)", []interface{}{m.Version, m.Name, s.now()}
+		}
+		if _, err := tx.ExecContext(ctx, script); err != nil {
+			return err
+		}
+		if _, err := tx.ExecContext(ctx, bookkeeping, args...); err != nil {
+			return err
+		}
+		return tx.Commit()
+	})
+}
+
+func (s *SQLiteStore) appliedMigrations(ctx context.Context) (map[int64]time.Time, error) {
+	rows, err := s.db.QueryContext(ctx, "SELECT version, applied_at FROM schema_migrations")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	applied := map[int64]time.Time{}
+	for rows.Next() {
+		var version int64
+		var appliedAt time.Time
+		if err := rows.Scan(&version, &appliedAt); err != nil {
+			return nil, err
+		}
+		applied[version] = appliedAt
+	}
+	return applied, rows.Err()
+}
+
+func (s *SQLiteStore) MigrationStatus(ctx context.Context) ([]Migration, map[int64]time.Time, error) {
+	migrations, err := loadMigrations(sqliteMigrationsDir)
+	if err != nil {
+		return nil, nil, fmt.Errorf("error loading migrations: %v", err)
+	}
+
+	var exists bool
+	err = s.db.QueryRowContext(ctx,
+		"SELECT EXISTS(SELECT 1 FROM sqlite_master WHERE type = 'table' AND name = 'schema_migrations')").Scan(&exists)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	applied := map[int64]time.Time{}
+	if exists {
+		applied, err = s.appliedMigrations(ctx)
+		if err != nil {
+			return nil, nil, fmt.Errorf("error reading schema_migrations: %v", err)
+		}
+	}
+	return migrations, applied, nil
+}
+
+func (s *SQLiteStore) Ping(ctx context.Context) error {
+	return s.db.PingContext(ctx)
+}
+
+func (s *SQLiteStore) CheckSchema(ctx context.Context) error {
+	var current int64
+	err := s.db.QueryRowContext(ctx, "SELECT COALESCE(MAX(version), 0) FROM schema_migrations").Scan(&current)
+	if err != nil {
+		return err
+	}
+	return checkSchemaVersion(sqliteMigrationsDir, current)
+}
+
+func (s *SQLiteStore) Close() {
+	s.db.Close()
+}
+
+// sqlNotFound maps database/sql's "no rows" error to ErrNotFound
+func sqlNotFound(err error) error {
+	if errors.Is(err, sql.ErrNoRows) {
+		return ErrNotFound
+	}
+	return err
+}
+
+// Users
+
+func (s *SQLiteStore) CreateUser(ctx context.Context, username, email, passwordHash string) (User, error) {
+	result, err := s.db.ExecContext(ctx,
+		"INSERT INTO users(username, email, password) VALUES(?, ?, ?)",
+		username, email, passwordHash)
+	if err != nil {
+		return User{}, sqlConflict(err)
+	}
+	id, err := result.LastInsertId()
+	if err != nil {
+		return User{}, err
+	}
+	return User{ID: int(id), Username: username, Email: email}, nil
+}
+
+func (s *SQLiteStore) GetUserByEmail(ctx context.Context, email string) (User, error) {
+	var user User
+	err := s.db.QueryRowContext(ctx,
+		"SELECT id, username, email, email_verified_at IS NOT NULL, password FROM users WHERE email = ?",
+		email).Scan(&user.ID, &user.Username, &user.Email, &user.EmailVerified, &user.Password)
+	return user, sqlNotFound(err)
+}
+
+func (s *SQLiteStore) GetUser(ctx context.Context, id int64) (User, error) {
+	var user User
+	err := s.db.QueryRowContext(ctx, "SELECT id, username, email, email_verified_at IS NOT NULL, password FROM users WHERE id = ?", id).
+		Scan(&user.ID, &user.Username, &user.Email, &user.EmailVerified, &user.Password)
+	return user, sqlNotFound(err)
+}
+
+func (s *SQLiteStore) UserExists(ctx context.Context, id int64) (bool, error) {
+	var exists bool
+	err := s.db.QueryRowContext(ctx, "SELECT EXISTS(SELECT 1 FROM users WHERE id = ?)", id).Scan(&exists)
+	return exists, err
+}
+
+func (s *SQLiteStore) GetUserByIdentity(ctx context.Context, issuer, subject string) (User, error) {
+	var user User
+	err := s.db.QueryRowContext(ctx, `
+        SELECT u.id, u.username, u.email, u.email_verified_at IS NOT NULL FROM user_identities i
+        JOIN users u ON u.id = i.user_id
+        WHERE i.issuer = ? AND i.subject = ?`, issuer, subject).Scan(&user.ID, &user.Username, &user.Email, &user.EmailVerified)
+	return user, sqlNotFound(err)
+}
+
+func (s *SQLiteStore) LinkIdentity(ctx context.Context, userID int64, issuer, subject string) error {
+	_, err := s.db.ExecContext(ctx,
+		"INSERT INTO user_identities (issuer, subject, user_id, created_at) VALUES (?, ?, ?, ?)",
+		issuer, subject, userID, s.now())
+	return sqlConflict(err)
+}
+
+func (s *SQLiteStore) UpdateUser(ctx context.Context, id int64, update UserUpdate) (User, error) {
+	var user User
+	err := s.db.QueryRowContext(ctx, `
+        UPDATE users
+        SET username = COALESCE(NULLIF(?2, ''), username),
+            email = COALESCE(NULLIF(?3, ''), email),
+            password = COALESCE(NULLIF(?4, ''), password),
+            email_verified_at = CASE WHEN ?3 IN ('', email) THEN email_verified_at END
+        WHERE id = ?1
+        RETURNING id, username, email, email_verified_at IS NOT NULL`,
+		id, update.Username, update.Email, update.PasswordHash).
+		Scan(&user.ID, &user.Username, &user.Email, &user.EmailVerified)
+	return user, sqlConflict(sqlNotFound(err))
+}
+
+func (s *SQLiteStore) MarkEmailVerified(ctx context.Context, id int64, email string) error {
+	result, err := s.db.ExecContext(ctx, `
+        UPDATE users SET email_verified_at = COALESCE(email_verified_at, ?3)
+        WHERE id = ?1 AND email = ?2`, id, email, s.now())
+	if err != nil {
+		return err
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (s *SQLiteStore) DeleteUser(ctx context.Context, id int64) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var lastOwner bool
+	err = tx.QueryRowContext(ctx, `
+        SELECT EXISTS (
+            SELECT 1 FROM org_members m
+            WHERE m.user_id = ?1 AND m.role = 'owner' AND NOT EXISTS (
+                SELECT 1 FROM org_members o
+                WHERE o.org_id = m.org_id AND o.role = 'owner' AND o.user_id <> ?1))`, id).Scan(&lastOwner)
+	if err != nil {
+		return err
+	}
+	if lastOwner {
+		return ErrConflict
+	}
+
+	// Hand what the user created for organizations to their longest-standing
+	// other owner
+	for _, table := range []string{"tasks", "status_pages", "notification_routes"} {
+		if _, err := tx.ExecContext(ctx, `
+            UPDATE `+table+` SET user_id = (
+                SELECT o.user_id FROM org_members o
+                WHERE o.org_id = `+table+`.org_id AND o.role = 'owner' AND o.user_id <> ?1
+                ORDER BY o.created_at, o.user_id LIMIT 1)
+            WHERE user_id = ?1 AND org_id IS NOT NULL`, id); err != nil {
+			return err
+		}
+	}
+
+	// Graph data, transitions and SLOs cascade with the tasks, and sessions,
+	// keys, memberships and personal status pages, badges and routes with the
+	// user
+	if _, err := tx.ExecContext(ctx, "DELETE FROM tasks WHERE user_id = ? AND org_id IS NULL", id); err != nil {
+		return err
+	}
+	result, err := tx.ExecContext(ctx, "DELETE FROM users WHERE id = ?", id)
+	if err != nil {
+		return err
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return ErrNotFound
+	}
+	return tx.Commit()
+}
+
+func (s *SQLiteStore) CreateUserToken(ctx context.Context, token UserToken) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx,
+		"DELETE FROM user_tokens WHERE user_id = ? AND purpose = ?",
+		token.UserID, token.Purpose); err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, `
+        INSERT INTO user_tokens (user_id, purpose, token_hash, email, expires_at, created_at)
+        VALUES (?, ?, ?, ?, ?, ?)`,
+		token.UserID, token.Purpose, token.TokenHash, token.Email, token.ExpiresAt.UTC(), s.now()); err != nil {
+		return sqlConflict(err)
+	}
+	return tx.Commit()
+}
+
+func (s *SQLiteStore) UseUserToken(ctx context.Context, purpose, tokenHash string) (UserToken, error) {
+	var token UserToken
+	err := s.db.QueryRowContext(ctx, `
+        DELETE FROM user_tokens
+        WHERE purpose = ? AND token_hash = ? AND expires_at > ?
+        RETURNING id, user_id, purpose, token_hash, email, expires_at, created_at`,
+		purpose, tokenHash, s.now()).Scan(&token.ID, &token.UserID, &token.Purpose, &token.TokenHash,
+		&token.Email, &token.ExpiresAt, &token.CreatedAt)
+	return token, sqlNotFound(err)
+}
+
+// Tasks
+
+// sqlQuerier is satisfied by both *sql.DB and *sql.Tx
+type sqlQuerier interface {
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+}
+
+func scanSQLTask(row interface{ Scan(...interface{}) error }) (Task, error) {
+	var task Task
+	var pingURL, previousStatus sql.NullString
+	var tags string
+	err := row.Scan(
+		&task.ID,
+		&task.Name,
+		&pingURL,
+		&task.UserID,
+		&task.LastPing,
+		&task.Interval,
+		&task.TaskNumber,
+		&task.Status,
+		&task.LastChecked,
+		&previousStatus,
+		&task.StatusChangedAt,
+		&task.UptimeSeconds,
+		&task.DowntimeSeconds,
+		&task.PingCount,
+		&task.Project,
+		&tags,
+		&task.OrgID,
+		&task.Version,
+	)
+	if err != nil {
+		return task, err
+	}
+	task.PingURL = pingURL.String
+	task.PreviousStatus = previousStatus.String
+	return task, json.Unmarshal([]byte(tags), &task.Tags)
+}
+
+// tagsJSON encodes the tags of a task for the tags column
+func tagsJSON(tags []string) string {
+	if tags == nil {
+		tags = []string{}
+	}
+	encoded, _ := json.Marshal(tags)
+	return string(encoded)
+}
+
+// queryTasks returns the tasks matching where (a condition on tasks t)
+func queryTasks(ctx context.Context, q sqlQuerier, where string, args ...interface{}) ([]Task, error) {
+	rows, err := q.QueryContext(ctx, `SELECT `+taskColumns+` FROM tasks t WHERE `+where+` ORDER BY id`, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	tasks := []Task{}
+	for rows.Next() {
+		task, err := scanSQLTask(rows)
+		if err != nil {
+			return nil, err
+		}
+		tasks = append(tasks, task)
+	}
+	return tasks, rows.Err()
+}
+
+// saveTaskState writes back the status and counters of a task
+func saveTaskState(ctx context.Context, q sqlQuerier, task Task) error {
+	_, err := q.ExecContext(ctx, `
+        UPDATE tasks SET
+            last_ping = ?, status = ?, last_checked = ?, previous_status = ?,
+            status_changed_at = ?, uptime_seconds = ?, downtime_seconds = ?, ping_count = ?
+        WHERE id = ?`,
+		task.LastPing, task.Status, task.LastChecked, task.PreviousStatus,
+		task.StatusChangedAt, task.UptimeSeconds, task.DowntimeSeconds, task.PingCount, task.ID)
+	return err
+}
+
+// insertTransition appends t to the transition log
+func insertTransition(ctx context.Context, q sqlQuerier, t TaskTransition) error {
+	_, err := q.ExecContext(ctx, `
+        INSERT INTO task_transitions (task_id, from_status, to_status, transitioned_at, uptime_seconds, downtime_seconds)
+        VALUES (?, ?, ?, ?, ?, ?)`,
+		t.TaskID, t.FromStatus, t.ToStatus, t.TransitionedAt, t.UptimeSeconds, t.DowntimeSeconds)
+	if err != nil {
+		return fmt.Errorf("error recording transitions: %v", err)
+	}
+	return nil
+}
+
+func (s *SQLiteStore) CreateTask(ctx context.Context, task Task) (Task, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return Task{}, err
+	}
+	defer tx.Rollback()
+
+	now := s.now()
+	result, err := tx.ExecContext(ctx, `
+        INSERT INTO tasks(name, ping_url, user_id, last_ping, interval, task_number, status,
+            last_checked, previous_status, status_changed_at, uptime_seconds, downtime_seconds, project, tags, org_id)
+        VALUES(?, ?, ?, ?, ?, ?, 'alive', ?, 'alive', ?, 0, 0, ?, ?, NULLIF(?, 0))`,
+		task.Name, task.PingURL, task.UserID, now, task.Interval, task.TaskNumber, now, now,
+		task.Project, tagsJSON(task.Tags), task.OrgID)
+	if err != nil {
+		return Task{}, err
+	}
+	id, err := result.LastInsertId()
+	if err != nil {
+		return Task{}, err
+	}
+
+	// The first transition anchors uptime accounting at creation time
+	if err := insertTransition(ctx, tx, TaskTransition{TaskID: id, ToStatus: "alive", TransitionedAt: now}); err != nil {
+		return Task{}, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return Task{}, err
+	}
+	return s.GetTask(ctx, id)
+}
+
+func (s *SQLiteStore) GetTask(ctx context.Context, id int64) (Task, error) {
+	task, err := scanSQLTask(s.db.QueryRowContext(ctx, `SELECT `+taskColumns+` FROM tasks WHERE id = ?`, id))
+	return task, sqlNotFound(err)
+}
+
+var sqliteTaskDialect = taskDialect{
+	hasTag: func(arg string) string {
+		return fmt.Sprintf("EXISTS (SELECT 1 FROM json_each(t.tags) WHERE value = %s)", arg)
+	},
+	search: func(arg string) string {
+		return fmt.Sprintf(`(t.name LIKE %[1]s ESCAPE '\' OR EXISTS (SELECT 1 FROM json_each(t.tags) WHERE value LIKE %[1]s ESCAPE '\'))`, arg)
+	},
+}
+
+func (s *SQLiteStore) ListUserTasks(ctx context.Context, userID int64, filter TaskFilter) ([]Task, error) {
+	where, args := taskFilterSQL(userID, filter, sqliteTaskDialect)
+	return queryTasks(ctx, s.db, strings.ReplaceAll(where, "$", "?"), args...)
+}
+
+func (s *SQLiteStore) ListTaskPage(ctx context.Context, userID int64, q TaskListQuery) (TaskPage, error) {
+	if q.After != nil {
+		// Timestamps are stored as UTC text, compare the cursor the same way
+		if at, ok := q.After.Value.(time.Time); ok {
+			after := *q.After
+			after.Value = at.UTC()
+			q.After = &after
+		}
+	}
+	pageQuery, args, countQuery, countArgs := taskPageQueries(userID, q, sqliteTaskDialect)
+	page := TaskPage{Tasks: []Task{}, StatusCounts: map[string]int{}}
+
+	rows, err := s.db.QueryContext(ctx, strings.ReplaceAll(pageQuery, "$", "?"), args...)
+	if err != nil {
+		return page, err
+	}
+	for rows.Next() {
+		task, err := scanSQLTask(rows)
+		if err != nil {
+			rows.Close()
+			return page, err
+		}
+		page.Tasks = append(page.Tasks, task)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return page, err
+	}
+
+	rows, err = s.db.QueryContext(ctx, strings.ReplaceAll(countQuery, "$", "?"), countArgs...)
+	if err != nil {
+		return page, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var status string
+		var count int
+		if err := rows.Scan(&status, &count); err != nil {
+			return page, err
+		}
+		page.StatusCounts[status] = count
+	}
+	if err := rows.Err(); err != nil {
+		return page, err
+	}
+
+	finishTaskPage(&page, q)
+	return page, nil
+}
+
+func (s *SQLiteStore) UpdateTask(ctx context.Context, id int64, update TaskUpdate) (Task, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return Task{}, err
+	}
+	defer tx.Rollback()
+
+	task, err := scanSQLTask(tx.QueryRowContext(ctx, `SELECT `+taskColumns+` FROM tasks WHERE id = ?`, id))
+	if err != nil {
+		return Task{}, sqlNotFound(err)
+	}
+
+	if update.Version != 0 && update.Version != task.Version {
+		return Task{}, ErrStale
+	}
+
+	_, err = tx.ExecContext(ctx,
+		"UPDATE tasks SET name = ?, ping_url = ?, interval = ?, task_number = ?, project = ?, tags = ?, version = version + 1 WHERE id = ?",
+		update.Name, update.PingURL, update.Interval, update.TaskNumber, update.Project, tagsJSON(update.Tags), id)
+	if err != nil {
+		return Task{}, err
+	}
+
+	if update.Status != "" {
+		if err := s.transition(ctx, tx, &task, update.Status, false); err != nil {
+			return Task{}, err
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return Task{}, err
+	}
+	return s.GetTask(ctx, id)
+}
+
+func (s *SQLiteStore) SetTaskOrg(ctx context.Context, id, orgID int64) error {
+	result, err := s.db.ExecContext(ctx, "UPDATE tasks SET org_id = NULLIF(?, 0), version = version + 1 WHERE id = ?", orgID, id)
+	if err != nil {
+		return err
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (s *SQLiteStore) DeleteTask(ctx context.Context, id int64) error {
+	result, err := s.db.ExecContext(ctx, "DELETE FROM tasks WHERE id = ?", id)
+	if err != nil {
+		return err
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (s *SQLiteStore) RecordHeartbeat(ctx context.Context, taskNumber int) ([]int64, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	tasks, err := queryTasks(ctx, tx, "task_number = ?", taskNumber)
+	if err != nil {
+		return nil, err
+	}
+
+	var ids []int64
+	for i := range tasks {
+		if err := s.transition(ctx, tx, &tasks[i], heartbeatStatus(tasks[i]), true); err != nil {
+			return nil, err
+		}
+		ids = append(ids, tasks[i].ID)
+	}
+	return ids, tx.Commit()
+}
+
+func (s *SQLiteStore) RecordTaskHeartbeat(ctx context.Context, id int64) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	task, err := scanSQLTask(tx.QueryRowContext(ctx, `SELECT `+taskColumns+` FROM tasks WHERE id = ?`, id))
+	if err != nil {
+		return sqlNotFound(err)
+	}
+	if err := s.transition(ctx, tx, &task, heartbeatStatus(task), true); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (s *SQLiteStore) DeleteTasks(ctx context.Context, ids []int64) error {
+	if len(ids) == 0 {
+		return nil
+	}
+	encoded, _ := json.Marshal(ids)
+	_, err := s.db.ExecContext(ctx, "DELETE FROM tasks WHERE id IN (SELECT value FROM json_each(?))", string(encoded))
+	return err
+}
+
+func (s *SQLiteStore) SetTaskStatus(ctx context.Context, ids []int64, status string) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for _, id := range ids {
+		task, err := scanSQLTask(tx.QueryRowContext(ctx, `SELECT `+taskColumns+` FROM tasks WHERE id = ?`, id))
+		if errors.Is(err, sql.ErrNoRows) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		if err := s.transition(ctx, tx, &task, status, false); err != nil {
+			return err
+		}
+		if _, err := tx.ExecContext(ctx, "UPDATE tasks SET version = version + 1 WHERE id = ?", id); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// transition applies a status change to task, writes it back and logs it
+func (s *SQLiteStore) transition(ctx context.Context, tx *sql.Tx, task *Task, newStatus string, touchPing bool) error {
+	t := applyTransition(task, newStatus, touchPing, s.now())
+	if err := saveTaskState(ctx, tx, *task); err != nil {
+		return err
+	}
+	if t != nil {
+		return insertTransition(ctx, tx, *t)
+	}
+	return nil
+}
+
+// Transitions
+
+func (s *SQLiteStore) ListTransitions(ctx context.Context, taskID int64) ([]TaskTransition, error) {
+	rows, err := s.db.QueryContext(ctx, `
+        SELECT id, task_id, from_status, to_status, transitioned_at, uptime_seconds, downtime_seconds
+        FROM task_transitions
+        WHERE task_id = ?
+        ORDER BY transitioned_at ASC, id ASC`, taskID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	transitions := []TaskTransition{}
+	for rows.Next() {
+		var t TaskTransition
+		if err := rows.Scan(&t.ID, &t.TaskID, &t.FromStatus, &t.ToStatus, &t.TransitionedAt,
+			&t.UptimeSeconds, &t.DowntimeSeconds); err != nil {
+			return nil, err
+		}
+		transitions = append(transitions, t)
+	}
+	return transitions, rows.Err()
+}
+
+// SLOs
+
+// sqliteSLOColumns are the sloColumns of the SQLite table, which holds the
+// tag SLOs too
+const sqliteSLOColumns = `s.id, COALESCE(s.task_id, 0), COALESCE(s.tag, ''), COALESCE(t.user_id, s.user_id),
+         s.name, s.target, s.kind, s.window_days, COALESCE(s.webhook_url, ''), COALESCE(s.email, ''), s.created_at,
+         s.firing_alerts`
+
+func (s *SQLiteStore) CreateSLO(ctx context.Context, slo SLO) (SLO, error) {
+	// The user of a task SLO is the task's
+	userID := slo.UserID
+	if slo.Tag == "" {
+		userID = 0
+	}
+	result, err := s.db.ExecContext(ctx, `
+        INSERT INTO slos (task_id, user_id, tag, name, target, kind, window_days, webhook_url, email, created_at)
+        VALUES (NULLIF(?, 0), NULLIF(?, 0), NULLIF(?, ''), ?, ?, COALESCE(NULLIF(?, ''), 'time'), ?, ?, ?, ?)`,
+		slo.TaskID, userID, slo.Tag, slo.Name, slo.Target, slo.Kind, slo.WindowDays, slo.WebhookURL, slo.Email, s.now())
+	if err != nil {
+		return SLO{}, err
+	}
+	id, err := result.LastInsertId()
+	if err != nil {
+		return SLO{}, err
+	}
+	return s.GetSLO(ctx, id)
+}
+
+func (s *SQLiteStore) GetSLO(ctx context.Context, id int64) (SLO, error) {
+	slo, err := scanSLO(s.db.QueryRowContext(ctx,
+		`SELECT `+sqliteSLOColumns+` FROM slos s LEFT JOIN tasks t ON t.id = s.task_id WHERE s.id = ?`, id))
+	return slo, sqlNotFound(err)
+}
+
+func (s *SQLiteStore) ListSLOs(ctx context.Context, userID, orgID int64) ([]SLO, error) {
+	rows, err := s.db.QueryContext(ctx, `
+        SELECT `+sqliteSLOColumns+`
+        FROM slos s
+        LEFT JOIN tasks t ON t.id = s.task_id
+        WHERE (?1 = 0 AND ?2 = 0)
+            OR CASE WHEN ?2 = 0 THEN COALESCE(t.user_id, s.user_id) = ?1 AND t.org_id IS NULL ELSE t.org_id = ?2 END
+        ORDER BY s.id`, userID, orgID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	slos := []SLO{}
+	for rows.Next() {
+		slo, err := scanSLO(rows)
+		if err != nil {
+			return nil, err
+		}
+		slos = append(slos, slo)
+	}
+	return slos, rows.Err()
+}
+
+func (s *SQLiteStore) UpdateSLO(ctx context.Context, slo SLO) (SLO, error) {
+	result, err := s.db.ExecContext(ctx, `
+        UPDATE slos SET name = ?, target = ?, kind = COALESCE(NULLIF(?, ''), 'time'), window_days = ?,
+            webhook_url = ?, email = ?
+        WHERE id = ?`,
+		slo.Name, slo.Target, slo.Kind, slo.WindowDays, slo.WebhookURL, slo.Email, slo.ID)
+	if err != nil {
+		return SLO{}, err
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return SLO{}, err
+	}
+	if affected == 0 {
+		return SLO{}, ErrNotFound
+	}
+	return s.GetSLO(ctx, slo.ID)
+}
+
+func (s *SQLiteStore) DeleteSLO(ctx context.Context, id int64) error {
+	result, err := s.db.ExecContext(ctx, "DELETE FROM slos WHERE id = ?", id)
+	if err != nil {
+		return err
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (s *SQLiteStore) ClaimSLOAlerts(ctx context.Context, id int64, from, to []string) (bool, error) {
+	result, err := s.db.ExecContext(ctx, "UPDATE slos SET firing_alerts = ? WHERE id = ? AND firing_alerts = ?",
+		joinSLOAlerts(to), id, joinSLOAlerts(from))
+	if err != nil {
+		return false, err
+	}
+	affected, err := result.RowsAffected()
+	return affected > 0, err
+}
+
+// Report schedules
+
+func (s *SQLiteStore) CreateReportSchedule(ctx context.Context, schedule ReportSchedule) (ReportSchedule, error) {
+	result, err := s.db.ExecContext(ctx, `
+        INSERT INTO report_schedules (user_id, period, format, email, last_period_end, created_at)
+        VALUES (?, ?, ?, ?, ?, ?)`,
+		schedule.UserID, schedule.Period, schedule.Format, schedule.Email, schedule.LastPeriodEnd, s.now())
+	if err != nil {
+		return ReportSchedule{}, err
+	}
+	id, err := result.LastInsertId()
+	if err != nil {
+		return ReportSchedule{}, err
+	}
+	return scanReportSchedule(s.db.QueryRowContext(ctx,
+		`SELECT `+reportScheduleColumns+` FROM report_schedules WHERE id = ?`, id))
+}
+
+func (s *SQLiteStore) ListReportSchedules(ctx context.Context, userID int64) ([]ReportSchedule, error) {
+	rows, err := s.db.QueryContext(ctx, `
+        SELECT `+reportScheduleColumns+`
+        FROM report_schedules
+        WHERE ?1 = 0 OR user_id = ?1
+        ORDER BY id`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	schedules := []ReportSchedule{}
+	for rows.Next() {
+		rs, err := scanReportSchedule(rows)
+		if err != nil {
+			return nil, err
+		}
+		schedules = append(schedules, rs)
+	}
+	return schedules, rows.Err()
+}
+
+func (s *SQLiteStore) DeleteReportSchedule(ctx context.Context, id int64) error {
+	result, err := s.db.ExecContext(ctx, "DELETE FROM report_schedules WHERE id = ?", id)
+	if err != nil {
+		return err
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (s *SQLiteStore) ClaimReportPeriod(ctx context.Context, id int64, periodEnd time.Time) (bool, error) {
+	result, err := s.db.ExecContext(ctx, `
+        UPDATE report_schedules SET last_period_end = ?2
+        WHERE id = ?1 AND (last_period_end IS NULL OR last_period_end < ?2)`, id, periodEnd.UTC())
+	if err != nil {
+		return false, err
+	}
+	affected, err := result.RowsAffected()
+	return affected > 0, err
+}
+
+func (s *SQLiteStore) ReleaseReportPeriod(ctx context.Context, id int64, periodEnd time.Time, previous *time.Time) error {
+	if previous != nil {
+		previous = timePtr(previous.UTC())
+	}
+	_, err := s.db.ExecContext(ctx,
+		"UPDATE report_schedules SET last_period_end = ?3 WHERE id = ?1 AND last_period_end = ?2", id, periodEnd.UTC(), previous)
+	return err
+}
+
+// Status pages
+
+func scanSQLStatusPage(row interface{ Scan(...interface{}) error }) (StatusPage, error) {
+	var page StatusPage
+	var taskIDs string
+	err := row.Scan(&page.ID, &page.UserID, &page.OrgID, &page.Slug, &page.Title, &taskIDs, &page.Protection,
+		&page.PasswordHash, &page.AccessToken, &page.CreatedAt)
+	if err != nil {
+		return page, sqlNotFound(err)
+	}
+	return page, json.Unmarshal([]byte(taskIDs), &page.TaskIDs)
+}
+
+// sqlConflict maps unique and primary key violations to ErrConflict
+func sqlConflict(err error) error {
+	var sqliteErr *sqlite.Error
+	if errors.As(err, &sqliteErr) {
+		switch sqliteErr.Code() {
+		case sqlite3.SQLITE_CONSTRAINT_UNIQUE, sqlite3.SQLITE_CONSTRAINT_PRIMARYKEY:
+			return ErrConflict
+		}
+	}
+	return err
+}
+
+// taskIDsJSON encodes the task IDs of a status page for the task_ids column
+func taskIDsJSON(ids []int64) string {
+	if ids == nil {
+		ids = []int64{}
+	}
+	encoded, _ := json.Marshal(ids)
+	return string(encoded)
+}
+
+func (s *SQLiteStore) CreateStatusPage(ctx context.Context, page StatusPage) (StatusPage, error) {
+	result, err := s.db.ExecContext(ctx, `
+        INSERT INTO status_pages (user_id, org_id, slug, title, task_ids, protection, password_hash, access_token, created_at)
+        VALUES (?, NULLIF(?, 0), ?, ?, ?, ?, NULLIF(?, ''), NULLIF(?, ''), ?)`,
+		page.UserID, page.OrgID, page.Slug, page.Title, taskIDsJSON(page.TaskIDs), page.Protection,
+		page.PasswordHash, page.AccessToken, s.now())
+	if err != nil {
+		return StatusPage{}, sqlConflict(err)
+	}
+	id, err := result.LastInsertId()
+	if err != nil {
+		return StatusPage{}, err
+	}
+	return s.GetStatusPage(ctx, id)
+}
+
+func (s *SQLiteStore) GetStatusPage(ctx context.Context, id int64) (StatusPage, error) {
+	return scanSQLStatusPage(s.db.QueryRowContext(ctx, `SELECT `+statusPageColumns+` FROM status_pages WHERE id = ?`, id))
+}
+
+func (s *SQLiteStore) GetStatusPageBySlug(ctx context.Context, slug string) (StatusPage, error) {
+	return scanSQLStatusPage(s.db.QueryRowContext(ctx, `SELECT `+statusPageColumns+` FROM status_pages WHERE slug = ?`, slug))
+}
+
+func (s *SQLiteStore) ListStatusPages(ctx context.Context, userID, orgID int64) ([]StatusPage, error) {
+	rows, err := s.db.QueryContext(ctx, `
+        SELECT `+statusPageColumns+`
+        FROM status_pages
+        WHERE CASE WHEN ?2 = 0 THEN user_id = ?1 AND org_id IS NULL ELSE org_id = ?2 END
+        ORDER BY id`, userID, orgID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	pages := []StatusPage{}
+	for rows.Next() {
+		page, err := scanSQLStatusPage(rows)
+		if err != nil {
+			return nil, err
+		}
+		pages = append(pages, page)
+	}
+	return pages, rows.Err()
+}
+
+func (s *SQLiteStore) UpdateStatusPage(ctx context.Context, page StatusPage) (StatusPage, error) {
+	result, err := s.db.ExecContext(ctx, `
+        UPDATE status_pages
+        SET slug = ?, title = ?, task_ids = ?, protection = ?,
+            password_hash = NULLIF(?, ''), access_token = NULLIF(?, '')
+        WHERE id = ?`,
+		page.Slug, page.Title, taskIDsJSON(page.TaskIDs), page.Protection,
+		page.PasswordHash, page.AccessToken, page.ID)
+	if err != nil {
+		return StatusPage{}, sqlConflict(err)
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return StatusPage{}, err
+	}
+	if affected == 0 {
+		return StatusPage{}, ErrNotFound
+	}
+	return s.GetStatusPage(ctx, page.ID)
+}
+
+func (s *SQLiteStore) DeleteStatusPage(ctx context.Context, id int64) error {
+	result, err := s.db.ExecContext(ctx, "DELETE FROM status_pages WHERE id = ?", id)
+	if err != nil {
+		return err
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// Badges
+
+func (s *SQLiteStore) CreateBadge(ctx context.Context, badge Badge) (Badge, error) {
+	result, err := s.db.ExecContext(ctx, `
+        INSERT INTO badges (user_id, task_id, tag, project, label, show_uptime, token, created_at)
+        VALUES (?, ?, ?, ?, ?, ?, ?, ?)`,
+		badge.UserID, badge.TaskID, badge.Tag, badge.Project, badge.Label, badge.ShowUptime, badge.Token, s.now())
+	if err != nil {
+		return Badge{}, sqlConflict(err)
+	}
+	id, err := result.LastInsertId()
+	if err != nil {
+		return Badge{}, err
+	}
+	return scanBadge(s.db.QueryRowContext(ctx, `SELECT `+badgeColumns+` FROM badges WHERE id = ?`, id))
+}
+
+func (s *SQLiteStore) GetBadgeByToken(ctx context.Context, token string) (Badge, error) {
+	badge, err := scanBadge(s.db.QueryRowContext(ctx, `SELECT `+badgeColumns+` FROM badges WHERE token = ?`, token))
+	return badge, sqlNotFound(err)
+}
+
+func (s *SQLiteStore) ListBadges(ctx context.Context, userID int64) ([]Badge, error) {
+	rows, err := s.db.QueryContext(ctx, `SELECT `+badgeColumns+` FROM badges WHERE user_id = ? ORDER BY id`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	badges := []Badge{}
+	for rows.Next() {
+		badge, err := scanBadge(rows)
+		if err != nil {
+			return nil, err
+		}
+		badges = append(badges, badge)
+	}
+	return badges, rows.Err()
+}
+
+func (s *SQLiteStore) DeleteBadge(ctx context.Context, userID, id int64) error {
+	result, err := s.db.ExecContext(ctx, "DELETE FROM badges WHERE id = ? AND user_id = ?", id, userID)
+	if err != nil {
+		return err
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// Notification routes
+
+func (s *SQLiteStore) CreateNotificationRoute(ctx context.Context, route NotificationRoute) (NotificationRoute, error) {
+	result, err := s.db.ExecContext(ctx, `
+        INSERT INTO notification_routes (user_id, org_id, tag, project, webhook_url, email, created_at)
+        VALUES (?, NULLIF(?, 0), ?, ?, ?, ?, ?)`,
+		route.UserID, route.OrgID, route.Tag, route.Project, route.WebhookURL, route.Email, s.now())
+	if err != nil {
+		return NotificationRoute{}, err
+	}
+	id, err := result.LastInsertId()
+	if err != nil {
+		return NotificationRoute{}, err
+	}
+	return s.GetNotificationRoute(ctx, id)
+}
+
+func (s *SQLiteStore) GetNotificationRoute(ctx context.Context, id int64) (NotificationRoute, error) {
+	route, err := scanNotificationRoute(s.db.QueryRowContext(ctx,
+		`SELECT `+notificationRouteColumns+` FROM notification_routes WHERE id = ?`, id))
+	return route, sqlNotFound(err)
+}
+
+func (s *SQLiteStore) ListNotificationRoutes(ctx context.Context, userID, orgID int64) ([]NotificationRoute, error) {
+	rows, err := s.db.QueryContext(ctx, `
+        SELECT `+notificationRouteColumns+` FROM notification_routes
+        WHERE CASE WHEN ?2 = 0 THEN user_id = ?1 AND org_id IS NULL ELSE org_id = ?2 END
+        ORDER BY id`, userID, orgID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	routes := []NotificationRoute{}
+	for rows.Next() {
+		route, err := scanNotificationRoute(rows)
+		if err != nil {
+			return nil, err
+		}
+		routes = append(routes, route)
+	}
+	return routes, rows.Err()
+}
+
+func (s *SQLiteStore) DeleteNotificationRoute(ctx context.Context, id int64) error {
+	result, err := s.db.ExecContext(ctx, "DELETE FROM notification_routes WHERE id = ?", id)
+	if err != nil {
+		return err
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// Organizations
+
+func (s *SQLiteStore) CreateOrg(ctx context.Context, name string, ownerID int64) (Organization, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return Organization{}, err
+	}
+	defer tx.Rollback()
+
+	org := Organization{Name: name, Role: roleOwner, CreatedAt: s.now()}
+	result, err := tx.ExecContext(ctx, "INSERT INTO organizations (name, created_at) VALUES (?, ?)", name, org.CreatedAt)
+	if err != nil {
+		return Organization{}, err
+	}
+	if org.ID, err = result.LastInsertId(); err != nil {
+		return Organization{}, err
+	}
+	_, err = tx.ExecContext(ctx,
+		"INSERT INTO org_members (org_id, user_id, role, created_at) VALUES (?, ?, ?, ?)",
+		org.ID, ownerID, roleOwner, org.CreatedAt)
+	if err != nil {
+		return Organization{}, err
+	}
+	return org, tx.Commit()
+}
+
+func (s *SQLiteStore) GetOrg(ctx context.Context, id int64) (Organization, error) {
+	var org Organization
+	err := s.db.QueryRowContext(ctx, "SELECT id, name, require_2fa, created_at FROM organizations WHERE id = ?", id).
+		Scan(&org.ID, &org.Name, &org.Require2FA, &org.CreatedAt)
+	return org, sqlNotFound(err)
+}
+
+func (s *SQLiteStore) UpdateOrg(ctx context.Context, org Organization) (Organization, error) {
+	err := s.db.QueryRowContext(ctx, `
+        UPDATE organizations SET name = ?2, require_2fa = ?3 WHERE id = ?1
+        RETURNING id, name, require_2fa, created_at`, org.ID, org.Name, org.Require2FA).
+		Scan(&org.ID, &org.Name, &org.Require2FA, &org.CreatedAt)
+	return org, sqlNotFound(err)
+}
+
+func (s *SQLiteStore) ListUserOrgs(ctx context.Context, userID int64) ([]Organization, error) {
+	rows, err := s.db.QueryContext(ctx, `
+        SELECT o.id, o.name, o.require_2fa, m.role, o.created_at
+        FROM organizations o
+        JOIN org_members m ON m.org_id = o.id
+        WHERE m.user_id = ?
+        ORDER BY o.id`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	orgs := []Organization{}
+	for rows.Next() {
+		var org Organization
+		if err := rows.Scan(&org.ID, &org.Name, &org.Require2FA, &org.Role, &org.CreatedAt); err != nil {
+			return nil, err
+		}
+		orgs = append(orgs, org)
+	}
+	return orgs, rows.Err()
+}
+
+func (s *SQLiteStore) DeleteOrg(ctx context.Context, id int64) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	// Members and invitations cascade; the org_id columns have no foreign keys
+	for _, table := range []string{"status_pages", "notification_routes"} {
+		if _, err := tx.ExecContext(ctx, "DELETE FROM "+table+" WHERE org_id = ?", id); err != nil {
+			return err
+		}
+	}
+	result, err := tx.ExecContext(ctx, "DELETE FROM organizations WHERE id = ?", id)
+	if err != nil {
+		return err
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return ErrNotFound
+	}
+	return tx.Commit()
+}
+
+func (s *SQLiteStore) GetOrgRole(ctx context.Context, orgID, userID int64) (string, error) {
+	var role string
+	err := s.db.QueryRowContext(ctx,
+		"SELECT role FROM org_members WHERE org_id = ? AND user_id = ?",
+		orgID, userID).Scan(&role)
+	return role, sqlNotFound(err)
+}
+
+func (s *SQLiteStore) ListOrgMembers(ctx context.Context, orgID int64) ([]OrgMember, error) {
+	rows, err := s.db.QueryContext(ctx, `
+        SELECT m.org_id, m.user_id, u.username, u.email, m.role, u.totp_enabled_at IS NOT NULL, m.created_at
+        FROM org_members m
+        JOIN users u ON u.id = m.user_id
+        WHERE m.org_id = ?
+        ORDER BY m.user_id`, orgID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	members := []OrgMember{}
+	for rows.Next() {
+		var m OrgMember
+		if err := rows.Scan(&m.OrgID, &m.UserID, &m.Username, &m.Email, &m.Role, &m.TwoFactor, &m.CreatedAt); err != nil {
+			return nil, err
+		}
+		members = append(members, m)
+	}
+	return members, rows.Err()
+}
+
+func (s *SQLiteStore) AddOrgMember(ctx context.Context, orgID, userID int64, role string) error {
+	result, err := s.db.ExecContext(ctx, `
+        INSERT INTO org_members (org_id, user_id, role, created_at)
+        SELECT id, ?, ?, ? FROM organizations WHERE id = ?`,
+		userID, role, s.now(), orgID)
+	if err != nil {
+		return sqlConflict(err)
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (s *SQLiteStore) SetOrgRole(ctx context.Context, orgID, userID int64, role string) error {
+	result, err := s.db.ExecContext(ctx,
+		"UPDATE org_members SET role = ? WHERE org_id = ? AND user_id = ?",
+		role, orgID, userID)
+	if err != nil {
+		return err
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (s *SQLiteStore) RemoveOrgMember(ctx context.Context, orgID, userID int64) error {
+	result, err := s.db.ExecContext(ctx, "DELETE FROM org_members WHERE org_id = ? AND user_id = ?", orgID, userID)
+	if err != nil {
+		return err
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (s *SQLiteStore) CreateInvitation(ctx context.Context, invitation OrgInvitation) (OrgInvitation, error) {
+	result, err := s.db.ExecContext(ctx, `
+        INSERT INTO org_invitations (org_id, email, role, token_hash, invited_by, expires_at, created_at)
+        VALUES (?, ?, ?, ?, ?, ?, ?)`,
+		invitation.OrgID, invitation.Email, invitation.Role, invitation.TokenHash, invitation.InvitedBy,
+		invitation.ExpiresAt.UTC(), s.now())
+	if err != nil {
+		return OrgInvitation{}, sqlConflict(err)
+	}
+	id, err := result.LastInsertId()
+	if err != nil {
+		return OrgInvitation{}, err
+	}
+	return scanInvitation(s.db.QueryRowContext(ctx, `SELECT `+invitationColumns+` FROM org_invitations WHERE id = ?`, id))
+}
+
+func (s *SQLiteStore) ListInvitations(ctx context.Context, orgID int64) ([]OrgInvitation, error) {
+	rows, err := s.db.QueryContext(ctx, `
+        SELECT `+invitationColumns+` FROM org_invitations
+        WHERE org_id = ? AND accepted_at IS NULL
+        ORDER BY id`, orgID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	invitations := []OrgInvitation{}
+	for rows.Next() {
+		invitation, err := scanInvitation(rows)
+		if err != nil {
+			return nil, err
+		}
+		invitations = append(invitations, invitation)
+	}
+	return invitations, rows.Err()
+}
+
+func (s *SQLiteStore) DeleteInvitation(ctx context.Context, orgID, id int64) error {
+	result, err := s.db.ExecContext(ctx, "DELETE FROM org_invitations WHERE id = ? AND org_id = ?", id, orgID)
+	if err != nil {
+		return err
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (s *SQLiteStore) AcceptInvitation(ctx context.Context, tokenHash string, userID int64) (OrgMember, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return OrgMember{}, err
+	}
+	defer tx.Rollback()
+
+	now := s.now()
+	member := OrgMember{UserID: userID, CreatedAt: now}
+	var id int64
+	err = tx.QueryRowContext(ctx, `
+        SELECT id, org_id, role FROM org_invitations
+        WHERE token_hash = ? AND accepted_at IS NULL AND expires_at > ?
+            AND lower(email) = (SELECT lower(email) FROM users WHERE id = ? AND email_verified_at IS NOT NULL)`,
+		tokenHash, now, userID).Scan(&id, &member.OrgID, &member.Role)
+	if err != nil {
+		return OrgMember{}, sqlNotFound(err)
+	}
+
+	_, err = tx.ExecContext(ctx,
+		"INSERT INTO org_members (org_id, user_id, role, created_at) VALUES (?, ?, ?, ?)",
+		member.OrgID, userID, member.Role, now)
+	if err != nil {
+		return OrgMember{}, sqlConflict(err)
+	}
+	if _, err := tx.ExecContext(ctx, "UPDATE org_invitations SET accepted_at = ? WHERE id = ?", now, id); err != nil {
+		return OrgMember{}, err
+	}
+	err = tx.QueryRowContext(ctx, "SELECT username, email FROM users WHERE id = ?", userID).
+		Scan(&member.Username, &member.Email)
+	if err != nil {
+		return OrgMember{}, err
+	}
+	return member, tx.Commit()
+}
+
+// API keys
+
+func (s *SQLiteStore) CreateAPIKey(ctx context.Context, key APIKey) (APIKey, error) {
+	var expiresAt *time.Time
+	if key.ExpiresAt != nil {
+		expiresAt = timePtr(key.ExpiresAt.UTC())
+	}
+	result, err := s.db.ExecContext(ctx, `
+        INSERT INTO api_keys (user_id, name, prefix, key_hash, scopes, expires_at, created_at)
+        VALUES (?, ?, ?, ?, ?, ?, ?)`,
+		key.UserID, key.Name, key.Prefix, key.KeyHash, strings.Join(key.Scopes, " "), expiresAt, s.now())
+	if err != nil {
+		return APIKey{}, sqlConflict(err)
+	}
+	id, err := result.LastInsertId()
+	if err != nil {
+		return APIKey{}, err
+	}
+	return scanAPIKey(s.db.QueryRowContext(ctx, `SELECT `+apiKeyColumns+` FROM api_keys WHERE id = ?`, id))
+}
+
+func (s *SQLiteStore) ListAPIKeys(ctx context.Context, userID int64) ([]APIKey, error) {
+	rows, err := s.db.QueryContext(ctx, `SELECT `+apiKeyColumns+` FROM api_keys WHERE user_id = ? ORDER BY id`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	keys := []APIKey{}
+	for rows.Next() {
+		key, err := scanAPIKey(rows)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, key)
+	}
+	return keys, rows.Err()
+}
+
+func (s *SQLiteStore) DeleteAPIKey(ctx context.Context, userID, id int64) error {
+	result, err := s.db.ExecContext(ctx, "DELETE FROM api_keys WHERE id = ? AND user_id = ?", id, userID)
+	if err != nil {
+		return err
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (s *SQLiteStore) UseAPIKey(ctx context.Context, keyHash string) (APIKey, error) {
+	now := s.now()
+	key, err := scanAPIKey(s.db.QueryRowContext(ctx, `
+        UPDATE api_keys SET last_used_at = ?1
+        WHERE key_hash = ?2 AND (expires_at IS NULL OR expires_at > ?1)
+        RETURNING `+apiKeyColumns, now, keyHash))
+	return key, sqlNotFound(err)
+}
+
+// Sessions
+
+func (s *SQLiteStore) CreateSession(ctx context.Context, session Session) (Session, error) {
+	now := s.now()
+	if _, err := s.db.ExecContext(ctx,
+		"DELETE FROM sessions WHERE user_id = ? AND expires_at <= ?", session.UserID, now); err != nil {
+		return Session{}, err
+	}
+	result, err := s.db.ExecContext(ctx, `
+        INSERT INTO sessions (user_id, refresh_hash, user_agent, ip, created_at, last_used_at, expires_at)
+        VALUES (?, ?, ?, ?, ?, ?, ?)`,
+		session.UserID, session.RefreshHash, session.UserAgent, session.IP, now, now, session.ExpiresAt.UTC())
+	if err != nil {
+		return Session{}, sqlConflict(err)
+	}
+	id, err := result.LastInsertId()
+	if err != nil {
+		return Session{}, err
+	}
+	return scanSession(s.db.QueryRowContext(ctx, `SELECT `+sessionColumns+` FROM sessions WHERE id = ?`, id))
+}
+
+func (s *SQLiteStore) GetActiveSession(ctx context.Context, id int64) (Session, error) {
+	session, err := scanSession(s.db.QueryRowContext(ctx, `
+        SELECT `+sessionColumns+` FROM sessions
+        WHERE id = ? AND expires_at > ?`, id, s.now()))
+	return session, sqlNotFound(err)
+}
+
+func (s *SQLiteStore) RotateSession(ctx context.Context, refreshHash string, update Session) (Session, error) {
+	now := s.now()
+	session, err := scanSession(s.db.QueryRowContext(ctx, `
+        UPDATE sessions
+        SET refresh_hash = ?2, previous_refresh_hash = refresh_hash, user_agent = ?3, ip = ?4,
+            last_used_at = ?5, expires_at = ?6
+        WHERE refresh_hash = ?1 AND expires_at > ?5
+        RETURNING `+sessionColumns,
+		refreshHash, update.RefreshHash, update.UserAgent, update.IP, now, update.ExpiresAt.UTC()))
+	if !errors.Is(err, sql.ErrNoRows) {
+		return session, sqlConflict(err)
+	}
+
+	result, err := s.db.ExecContext(ctx, "DELETE FROM sessions WHERE previous_refresh_hash = ?", refreshHash)
+	if err != nil {
+		return Session{}, err
+	}
+	if affected, _ := result.RowsAffected(); affected > 0 {
+		log.Printf("Revoked a session whose replaced refresh token was presented again")
+	}
+	return Session{}, ErrNotFound
+}
+
+func (s *SQLiteStore) ListSessions(ctx context.Context, userID int64) ([]Session, error) {
+	rows, err := s.db.QueryContext(ctx, `
+        SELECT `+sessionColumns+` FROM sessions
+        WHERE user_id = ? AND expires_at > ?
+        ORDER BY last_used_at DESC, id DESC`, userID, s.now())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	sessions := []Session{}
+	for rows.Next() {
+		session, err := scanSession(rows)
+		if err != nil {
+			return nil, err
+		}
+		sessions = append(sessions, session)
+	}
+	return sessions, rows.Err()
+}
+
+func (s *SQLiteStore) DeleteSession(ctx context.Context, userID, id int64) error {
+	result, err := s.db.ExecContext(ctx, "DELETE FROM sessions WHERE id = ? AND user_id = ?", id, userID)
+	if err != nil {
+		return err
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (s *SQLiteStore) DeleteUserSessions(ctx context.Context, userID int64) error {
+	_, err := s.db.ExecContext(ctx, "DELETE FROM sessions WHERE user_id = ?", userID)
+	return err
+}
+
+// Two-factor authentication
+
+func (s *SQLiteStore) GetTwoFactor(ctx context.Context, userID int64) (TwoFactor, error) {
+	var tf TwoFactor
+	err := s.db.QueryRowContext(ctx, `
+        SELECT COALESCE(totp_secret, ''), totp_enabled_at IS NOT NULL, totp_last_step,
+            (SELECT COUNT(*) FROM recovery_codes WHERE user_id = users.id)
+        FROM users WHERE id = ?`, userID).
+		Scan(&tf.Secret, &tf.Enabled, &tf.LastStep, &tf.RecoveryCodes)
+	return tf, sqlNotFound(err)
+}
+
+func (s *SQLiteStore) SetTOTPSecret(ctx context.Context, userID int64, secret string) error {
+	result, err := s.db.ExecContext(ctx,
+		"UPDATE users SET totp_secret = ? WHERE id = ? AND totp_enabled_at IS NULL",
+		secret, userID)
+	if err != nil {
+		return err
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return ErrConflict
+	}
+	return nil
+}
+
+func (s *SQLiteStore) EnableTwoFactor(ctx context.Context, userID, step int64, recoveryHashes []string) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var enabled bool
+	err = tx.QueryRowContext(ctx, `
+        SELECT totp_enabled_at IS NOT NULL FROM users
+        WHERE id = ? AND totp_secret IS NOT NULL`, userID).Scan(&enabled)
+	if err != nil {
+		return sqlNotFound(err)
+	}
+	if enabled {
+		return ErrConflict
+	}
+	if _, err := tx.ExecContext(ctx,
+		"UPDATE users SET totp_enabled_at = ?, totp_last_step = ? WHERE id = ?",
+		s.now(), step, userID); err != nil {
+		return err
+	}
+	if err := s.replaceRecoveryCodes(ctx, tx, userID, recoveryHashes); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// replaceRecoveryCodes swaps the user's recovery codes within tx
+func (s *SQLiteStore) replaceRecoveryCodes(ctx context.Context, tx *sql.Tx, userID int64, codeHashes []string) error {
+	if _, err := tx.ExecContext(ctx, "DELETE FROM recovery_codes WHERE user_id = ?", userID); err != nil {
+		return err
+	}
+	now := s.now()
+	for _, hash := range codeHashes {
+		if _, err := tx.ExecContext(ctx,
+			"INSERT INTO recovery_codes (user_id, code_hash, created_at) VALUES (?, ?, ?)",
+			userID, hash, now); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *SQLiteStore) UseTOTPStep(ctx context.Context, userID, step int64) error {
+	result, err := s.db.ExecContext(ctx,
+		"UPDATE users SET totp_last_step = ?2 WHERE id = ?1 AND totp_last_step < ?2",
+		userID, step)
+	if err != nil {
+		return err
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return ErrConflict
+	}
+	return nil
+}
+
+func (s *SQLiteStore) UseRecoveryCode(ctx context.Context, userID int64, codeHash string) error {
+	result, err := s.db.ExecContext(ctx,
+		"DELETE FROM recovery_codes WHERE user_id = ? AND code_hash = ?", userID, codeHash)
+	if err != nil {
+		return err
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (s *SQLiteStore) ReplaceRecoveryCodes(ctx context.Context, userID int64, codeHashes []string) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := s.replaceRecoveryCodes(ctx, tx, userID, codeHashes); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (s *SQLiteStore) DisableTwoFactor(ctx context.Context, userID int64) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, `
+        UPDATE users SET totp_secret = NULL, totp_enabled_at = NULL, totp_last_step = 0
+        WHERE id = ?`, userID); err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, "DELETE FROM recovery_codes WHERE user_id = ?", userID); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// Graph data
+
+func (s *SQLiteStore) GraphBuckets(ctx context.Context, q GraphQuery) ([]TaskGraphBucket, error) {
+	// Buckets come back as Unix seconds; ?N binds the numbered arguments
+	bucket := func(ts string) string {
+		return fmt.Sprintf("(CAST(strftime('%%s', %s) AS INTEGER) / $1) * $1", ts)
+	}
+	query := strings.ReplaceAll(graphBucketsQuery(q, bucket, sqliteTaskDialect.hasTag), "$", "?")
+
+	args := graphBucketsArgs(q)
+	args[2], args[3] = q.From.UTC(), q.To.UTC()
+
+	rows, err := s.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var buckets []TaskGraphBucket
+	for rows.Next() {
+		var b TaskGraphBucket
+		var bucketStart int64
+		if err := rows.Scan(
+			&b.TaskID,
+			&bucketStart,
+			&b.Samples,
+			&b.AliveSamples,
+			&b.DeadSamples,
+			&b.AvgUptimePercentage,
+			&b.MinUptimeSeconds,
+			&b.MaxUptimeSeconds,
+			&b.MinDowntimeSeconds,
+			&b.MaxDowntimeSeconds,
+			&b.MinPingCount,
+			&b.MaxPingCount,
+		); err != nil {
+			return nil, err
+		}
+		b.BucketStart = time.Unix(bucketStart, 0).UTC()
+		buckets = append(buckets, b)
+	}
+	return buckets, rows.Err()
+}
+
+// sqliteBucket aligns the timestamp expression ts to buckets of the given
+// number of seconds, formatted the way the driver stores UTC timestamps so
+// the result compares and scans like any other timestamp
+func sqliteBucket(ts string, seconds int64) string {
+	return fmt.Sprintf(`strftime('%%Y-%%m-%%d %%H:%%M:%%S+00:00', (CAST(strftime('%%s', %s) AS INTEGER) / %d) * %d, 'unixepoch')`,
+		ts, seconds, seconds)
+}
+
+func (s *SQLiteStore) CompactGraphData(ctx context.Context, tiers []GraphTier) (CompactionResult, error) {
+	var result CompactionResult
+	now := s.now()
+
+	for i, tier := range tiers {
+		if tier.IsRaw() || i == 0 {
+			continue
+		}
+		n, err := s.rollUp(ctx, tiers[i-1], tier, now)
+		if err != nil {
+			return result, fmt.Errorf("error rolling up %s: %v", tier.Name, err)
+		}
+		result.RolledUp += n
+	}
+
+	for _, tier := range tiers {
+		if tier.Retention <= 0 {
+			continue
+		}
+		cutoff := now.Add(-tier.Retention)
+
+		var res sql.Result
+		var err error
+		if tier.IsRaw() {
+			res, err = s.db.ExecContext(ctx, "DELETE FROM task_graph_data WHERE timestamp < ?", cutoff)
+		} else {
+			res, err = s.db.ExecContext(ctx,
+				"DELETE FROM task_graph_rollups WHERE resolution_seconds = ? AND bucket_start < ?",
+				int64(tier.Resolution/time.Second), cutoff)
+		}
+		if err != nil {
+			return result, fmt.Errorf("error pruning %s: %v", tier.Name, err)
+		}
+		pruned, err := res.RowsAffected()
+		if err != nil {
+			return result, err
+		}
+		result.Pruned += pruned
+	}
+	return result, nil
+}
+
+// rollUp aggregates the completed buckets of tier from the source tier below
+// it, recomputing the newest existing bucket. The bucket holding now is still
+// filling and is left out.
+func (s *SQLiteStore) rollUp(ctx context.Context, source, tier GraphTier, now time.Time) (int64, error) {
+	resolution := int64(tier.Resolution / time.Second)
+
+	// MAX loses the column type, so the watermark stays in its stored text form
+	var from sql.NullString
+	err := s.db.QueryRowContext(ctx,
+		"SELECT MAX(bucket_start) FROM task_graph_rollups WHERE resolution_seconds = ?", resolution).Scan(&from)
+	if err != nil {
+		return 0, err
+	}
+
+	sourceQuery := `
+        SELECT task_id, timestamp AS at, 1 AS samples,
+            CASE WHEN status = 'alive' THEN 1 ELSE 0 END AS alive_samples,
+            CASE WHEN status = 'dead' THEN 1 ELSE 0 END AS dead_samples,
+            uptime_percentage AS avg_uptime_percentage, uptime_seconds, downtime_seconds, ping_count
+        FROM task_graph_data`
+	if !source.IsRaw() {
+		sourceQuery = fmt.Sprintf(`
+        SELECT task_id, bucket_start AS at, samples, alive_samples, dead_samples,
+            avg_uptime_percentage, uptime_seconds, downtime_seconds, ping_count
+        FROM task_graph_rollups
+        WHERE resolution_seconds = %d`, int64(source.Resolution/time.Second))
+	}
+
+	query := fmt.Sprintf(`
+        INSERT INTO task_graph_rollups (task_id, resolution_seconds, bucket_start, samples,
+            alive_samples, dead_samples, avg_uptime_percentage, uptime_seconds, downtime_seconds, ping_count)
+        SELECT task_id, %d, bucket, SUM(samples), SUM(alive_samples), SUM(dead_samples),
+            SUM(avg_uptime_percentage * samples) / SUM(samples), MAX(uptime_seconds), MAX(downtime_seconds),
+            MAX(ping_count)
+        FROM (
+            SELECT src.*, %s AS bucket
+            FROM (%s) src
+            WHERE src.at >= COALESCE(?, '')
+        ) b
+        WHERE bucket < %s
+        GROUP BY task_id, bucket
+        ON CONFLICT (task_id, resolution_seconds, bucket_start) DO UPDATE SET
+            samples = excluded.samples,
+            alive_samples = excluded.alive_samples,
+            dead_samples = excluded.dead_samples,
+            avg_uptime_percentage = excluded.avg_uptime_percentage,
+            uptime_seconds = excluded.uptime_seconds,
+            downtime_seconds = excluded.downtime_seconds,
+            ping_count = excluded.ping_count`,
+		resolution, sqliteBucket("src.at", resolution), sourceQuery, sqliteBucket("?", resolution))
+
+	res, err := s.db.ExecContext(ctx, query, from, now)
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
+// Monitor
+
+func (s *SQLiteStore) Now(ctx context.Context) (time.Time, error) {
+	return s.now(), nil
+}
+
+// MonitorPartitions returns a single partition: SQLite has no shards
+func (s *SQLiteStore) MonitorPartitions(ctx context.Context) ([]string, error) {
+	return []string{"tasks"}, nil
+}
+
+func (s *SQLiteStore) MonitorPartition(ctx context.Context, partition string, graceStart *time.Time) (int, []MonitoredTask, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, nil, fmt.Errorf("error starting transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	tasks, err := queryTasks(ctx, tx, "1 = 1")
+	if err != nil {
+		return 0, nil, fmt.Errorf("error fetching task statuses: %v", err)
+	}
+
+	sampleStmt, err := tx.PrepareContext(ctx, `
+        INSERT INTO task_graph_data (task_id, timestamp, status, uptime_seconds, downtime_seconds, uptime_percentage, ping_count)
+        VALUES (?, ?, ?, ?, ?, ?, ?)`)
+	if err != nil {
+		return 0, nil, err
+	}
+	defer sampleStmt.Close()
+
+	now := s.now()
+	newlyDead := 0
+	monitored := make([]MonitoredTask, 0, len(tasks))
+	for i := range tasks {
+		task := &tasks[i]
+		t := applyMonitorPass(task, now, graceStart)
+		if err := saveTaskState(ctx, tx, *task); err != nil {
+			return 0, nil, fmt.Errorf("error updating task metrics: %v", err)
+		}
+		if t != nil {
+			if err := insertTransition(ctx, tx, *t); err != nil {
+				return 0, nil, err
+			}
+			newlyDead++
+		}
+
+		_, err := sampleStmt.ExecContext(ctx, task.ID, now, task.Status, task.UptimeSeconds, task.DowntimeSeconds,
+			uptimePercentage(task.UptimeSeconds, task.DowntimeSeconds), task.PingCount)
+		if err != nil {
+			return 0, nil, fmt.Errorf("error storing graph data: %v", err)
+		}
+		monitored = append(monitored, monitoredTask(*task, now))
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, nil, fmt.Errorf("error committing shard results: %v", err)
+	}
+	return newlyDead, monitored, nil
+}
@@ -0,0 +1,402 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// testStores opens each store implementation the tests run against, with the
+// store clock reading *clock
+var testStores = []struct {
+	name string
+	open func(t *testing.T, clock *time.Time) Store
+}{
+	{"memory", func(t *testing.T, clock *time.Time) Store {
+		store := NewMemoryStore()
+		store.now = func() time.Time { return *clock }
+		return store
+	}},
+	{"sqlite", func(t *testing.T, clock *time.Time) Store {
+		store, err := openSQLiteStore(context.Background(), "sqlite://"+filepath.Join(t.TempDir(), "tasks.db"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(store.Close)
+		if err := store.Migrate(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+		store.now = func() time.Time { return *clock }
+		return store
+	}},
+}
+
+// forEachStore runs test as a subtest against every store implementation,
+// with the clock at 2026-01-01 12:00 UTC
+func forEachStore(t *testing.T, test func(t *testing.T, store Store, clock *time.Time)) {
+	for _, ts := range testStores {
+		t.Run(ts.name, func(t *testing.T) {
+			clock := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+			test(t, ts.open(t, &clock), &clock)
+		})
+	}
+}
+
+func TestStoreUsers(t *testing.T) {
+	forEachStore(t, func(t *testing.T, store Store, clock *time.Time) {
+		ctx := context.Background()
+		created, err := store.CreateUser(ctx, "alice", "alice@example.com", "hash")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		user, err := store.GetUserByEmail(ctx, "alice@example.com")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if user.ID != created.ID || user.Username != "alice" || user.Password != "hash" {
+			t.Errorf("GetUserByEmail = %+v, want alice with the password hash", user)
+		}
+		if _, err := store.GetUserByEmail(ctx, "bob@example.com"); !errors.Is(err, ErrNotFound) {
+			t.Errorf("GetUserByEmail of an unknown email: %v, want ErrNotFound", err)
+		}
+
+		for id, want := range map[int64]bool{int64(created.ID): true, int64(created.ID) + 1: false} {
+			if exists, err := store.UserExists(ctx, id); err != nil || exists != want {
+				t.Errorf("UserExists(%d) = %v, %v; want %v", id, exists, err, want)
+			}
+		}
+	})
+}
+
+func TestStoreIdentities(t *testing.T) {
+	forEachStore(t, func(t *testing.T, store Store, clock *time.Time) {
+		ctx := context.Background()
+		user, err := store.CreateUser(ctx, "alice", "alice@example.com", "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := store.CreateUser(ctx, "alice", "other@example.com", ""); !errors.Is(err, ErrConflict) {
+			t.Errorf("CreateUser with a taken username: %v, want ErrConflict", err)
+		}
+
+		const issuer = "https://idp.example.com"
+		if _, err := store.GetUserByIdentity(ctx, issuer, "sub-1"); !errors.Is(err, ErrNotFound) {
+			t.Errorf("GetUserByIdentity before linking: %v, want ErrNotFound", err)
+		}
+		if err := store.LinkIdentity(ctx, int64(user.ID), issuer, "sub-1"); err != nil {
+			t.Fatal(err)
+		}
+		if got, err := store.GetUserByIdentity(ctx, issuer, "sub-1"); err != nil || got.ID != user.ID {
+			t.Errorf("GetUserByIdentity = %+v, %v; want alice", got, err)
+		}
+		// Subjects are only unique per issuer
+		if _, err := store.GetUserByIdentity(ctx, "https://other.example.com", "sub-1"); !errors.Is(err, ErrNotFound) {
+			t.Errorf("GetUserByIdentity of another issuer: %v, want ErrNotFound", err)
+		}
+
+		if err := store.AddOrgMember(ctx, 999, int64(user.ID), roleViewer); !errors.Is(err, ErrNotFound) {
+			t.Errorf("AddOrgMember of an unknown organization: %v, want ErrNotFound", err)
+		}
+		org, err := store.CreateOrg(ctx, "Ops", int64(user.ID))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := store.AddOrgMember(ctx, org.ID, int64(user.ID), roleViewer); !errors.Is(err, ErrConflict) {
+			t.Errorf("AddOrgMember of a member: %v, want ErrConflict", err)
+		}
+	})
+}
+
+func TestStoreTasks(t *testing.T) {
+	forEachStore(t, func(t *testing.T, store Store, clock *time.Time) {
+		ctx := context.Background()
+		user, err := store.CreateUser(ctx, "alice", "alice@example.com", "hash")
+		if err != nil {
+			t.Fatal(err)
+		}
+		created := *clock
+		task, err := store.CreateTask(ctx, Task{Name: "backup", UserID: int64(user.ID), Interval: 60, TaskNumber: 7})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if task.ID == 0 || task.Status != "alive" || task.LastPing == nil || !task.LastPing.Equal(created) {
+			t.Fatalf("created task = %+v, want alive with its last ping at creation", task)
+		}
+
+		got := getTask(t, store, task.ID)
+		if got.Name != "backup" || got.Interval != 60 || got.TaskNumber != 7 {
+			t.Errorf("GetTask = %+v", got)
+		}
+		listed, err := store.ListUserTasks(ctx, int64(user.ID), TaskFilter{})
+		if err != nil || len(listed) != 1 || listed[0].ID != task.ID {
+			t.Errorf("ListUserTasks = %+v, %v; want the one task", listed, err)
+		}
+
+		*clock = created.Add(30 * time.Second)
+		updated, err := store.UpdateTask(ctx, task.ID, TaskUpdate{Name: "nightly backup", Interval: 120, TaskNumber: 7, Status: "dead"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if updated.Name != "nightly backup" || updated.Interval != 120 || updated.Status != "dead" || updated.UptimeSeconds != 30 {
+			t.Errorf("UpdateTask = %+v, want renamed, dead after 30s of uptime", updated)
+		}
+
+		*clock = created.Add(50 * time.Second)
+		ids, err := store.RecordHeartbeat(ctx, 7)
+		if err != nil || len(ids) != 1 || ids[0] != task.ID {
+			t.Fatalf("RecordHeartbeat = %v, %v; want the task", ids, err)
+		}
+		if ids, err := store.RecordHeartbeat(ctx, 8); err != nil || len(ids) != 0 {
+			t.Errorf("RecordHeartbeat of an unknown number = %v, %v; want no tasks", ids, err)
+		}
+		got = getTask(t, store, task.ID)
+		if got.Status != "alive" || got.DowntimeSeconds != 20 || !got.LastPing.Equal(*clock) {
+			t.Errorf("after the heartbeat: %+v, want alive after 20s of downtime", got)
+		}
+
+		transitions, err := store.ListTransitions(ctx, task.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var statuses []string
+		for _, tr := range transitions {
+			statuses = append(statuses, tr.ToStatus)
+		}
+		if len(statuses) != 3 || statuses[0] != "alive" || statuses[1] != "dead" || statuses[2] != "alive" {
+			t.Errorf("transitions to %v, want alive, dead, alive", statuses)
+		}
+
+		if err := store.DeleteTask(ctx, task.ID); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := store.GetTask(ctx, task.ID); !errors.Is(err, ErrNotFound) {
+			t.Errorf("GetTask after delete: %v, want ErrNotFound", err)
+		}
+		if err := store.DeleteTask(ctx, task.ID); !errors.Is(err, ErrNotFound) {
+			t.Errorf("DeleteTask twice: %v, want ErrNotFound", err)
+		}
+		if _, err := store.UpdateTask(ctx, task.ID, TaskUpdate{Name: "gone"}); !errors.Is(err, ErrNotFound) {
+			t.Errorf("UpdateTask after delete: %v, want ErrNotFound", err)
+		}
+	})
+}
+
+func TestStoreTaskLabels(t *testing.T) {
+	forEachStore(t, func(t *testing.T, store Store, clock *time.Time) {
+		ctx := context.Background()
+		user, err := store.CreateUser(ctx, "alice", "alice@example.com", "hash")
+		if err != nil {
+			t.Fatal(err)
+		}
+		userID := int64(user.ID)
+
+		var ids []int64
+		for i, task := range []Task{
+			{Name: "vacuum", Project: "billing", Tags: []string{"db-maintenance", "nightly"}},
+			{Name: "reindex", Project: "search", Tags: []string{"db-maintenance"}},
+			{Name: "backup", Project: "billing", Tags: []string{}},
+		} {
+			task.UserID, task.Interval, task.TaskNumber = userID, 60, i+1
+			created, err := store.CreateTask(ctx, task)
+			if err != nil {
+				t.Fatal(err)
+			}
+			ids = append(ids, created.ID)
+		}
+		if got := getTask(t, store, ids[0]); got.Project != "billing" || len(got.Tags) != 2 || got.Tags[1] != "nightly" {
+			t.Errorf("GetTask = %+v, want the project and tags", got)
+		}
+
+		for filter, want := range map[TaskFilter]int{
+			{}:                      3,
+			{Tag: "db-maintenance"}: 2,
+			{Project: "billing"}:    2,
+			{Tag: "db-maintenance", Project: "billing"}: 1,
+			{Tag: "db"}: 0,
+		} {
+			if tasks, err := store.ListUserTasks(ctx, userID, filter); err != nil || len(tasks) != want {
+				t.Errorf("ListUserTasks(%+v) = %d tasks, %v; want %d", filter, len(tasks), err, want)
+			}
+		}
+
+		if err := store.SetTaskStatus(ctx, ids[:2], "paused"); err != nil {
+			t.Fatal(err)
+		}
+		if got := getTask(t, store, ids[1]); got.Status != "paused" {
+			t.Errorf("status after SetTaskStatus %q, want paused", got.Status)
+		}
+		if got := getTask(t, store, ids[2]); got.Status != "alive" {
+			t.Errorf("status of the other task %q, want alive", got.Status)
+		}
+
+		// Missing tasks are skipped
+		if err := store.DeleteTasks(ctx, []int64{ids[0], ids[1], ids[2] + 100}); err != nil {
+			t.Fatal(err)
+		}
+		if tasks, err := store.ListUserTasks(ctx, userID, TaskFilter{}); err != nil || len(tasks) != 1 || tasks[0].ID != ids[2] {
+			t.Errorf("tasks after DeleteTasks = %+v, %v; want only task %d", tasks, err, ids[2])
+		}
+		if err := store.DeleteTasks(ctx, nil); err != nil {
+			t.Errorf("DeleteTasks(nil) = %v", err)
+		}
+	})
+}
+
+// testGraphTiers is the default tier layout with a short raw retention
+var testGraphTiers = []GraphTier{
+	{Name: "raw", Resolution: 0, Retention: 30 * time.Minute},
+	{Name: "1m", Resolution: time.Minute, Retention: 7 * 24 * time.Hour},
+	{Name: "1h", Resolution: time.Hour, Retention: 90 * 24 * time.Hour},
+	{Name: "1d", Resolution: 24 * time.Hour},
+}
+
+// expectGraph checks the task's graph buckets in a tier, at the tier's own
+// spacing, against the wanted bucket starts and whether the task was alive
+// throughout each bucket
+func expectGraph(t *testing.T, store Store, taskID int64, tier GraphTier, want map[time.Time]bool) {
+	t.Helper()
+	expectGraphBuckets(t, store, GraphQuery{TaskID: taskID, Step: tier.Spacing(), Tier: tier}, want)
+}
+
+func expectGraphBuckets(t *testing.T, store Store, q GraphQuery, want map[time.Time]bool) {
+	t.Helper()
+	if q.To.IsZero() {
+		q.To = time.Date(2100, 1, 1, 0, 0, 0, 0, time.UTC)
+	}
+	buckets, err := store.GraphBuckets(context.Background(), q)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(buckets) != len(want) {
+		t.Fatalf("%s graph by %v has %d buckets %+v, want %d", q.Tier.Name, q.Step, len(buckets), buckets, len(want))
+	}
+	for _, b := range buckets {
+		alive, ok := want[b.BucketStart.UTC()]
+		if !ok {
+			t.Errorf("%s graph by %v has a bucket at %v, want buckets at %v", q.Tier.Name, q.Step, b.BucketStart, want)
+			continue
+		}
+		if alive != (b.DeadSamples == 0) {
+			t.Errorf("%s bucket %v: %d dead samples, want alive %v", q.Tier.Name, b.BucketStart, b.DeadSamples, alive)
+		}
+	}
+}
+
+func TestStoreTaskVersions(t *testing.T) {
+	forEachStore(t, func(t *testing.T, store Store, clock *time.Time) {
+		ctx := context.Background()
+		user, err := store.CreateUser(ctx, "alice", "alice@example.com", "hash")
+		if err != nil {
+			t.Fatal(err)
+		}
+		task := createTestTaskFor(t, store, int64(user.ID))
+
+		updated, err := store.UpdateTask(ctx, task.ID, TaskUpdate{Name: "nightly", Interval: 60, Version: task.Version})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if updated.Version <= task.Version {
+			t.Errorf("version after update = %d, want more than %d", updated.Version, task.Version)
+		}
+		if _, err := store.UpdateTask(ctx, task.ID, TaskUpdate{Name: "stale", Interval: 60, Version: task.Version}); !errors.Is(err, ErrStale) {
+			t.Errorf("UpdateTask at an old version: %v, want ErrStale", err)
+		}
+
+		// Heartbeats leave the version alone, status changes bump it
+		if _, err := store.RecordHeartbeat(ctx, task.TaskNumber); err != nil {
+			t.Fatal(err)
+		}
+		got, err := store.GetTask(ctx, task.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got.Version != updated.Version || got.Name != "nightly" {
+			t.Errorf("task after heartbeat = %+v, want version %d", got, updated.Version)
+		}
+		if err := store.SetTaskStatus(ctx, []int64{task.ID}, "paused"); err != nil {
+			t.Fatal(err)
+		}
+		if got, _ = store.GetTask(ctx, task.ID); got.Version <= updated.Version {
+			t.Errorf("version after status change = %d, want more than %d", got.Version, updated.Version)
+		}
+	})
+}
+
+func TestStoreGraphRollups(t *testing.T) {
+	forEachStore(t, func(t *testing.T, store Store, clock *time.Time) {
+		ctx := context.Background()
+		task := createMonitorTestTask(t, store)
+		created := *clock
+
+		// Alive through 12:00, dead from the 12:01 deadline on
+		for _, at := range []time.Duration{20, 40, 80, 100, 130} {
+			*clock = created.Add(at * time.Second)
+			monitorPass(t, store, nil)
+		}
+		expectGraph(t, store, task.ID, testGraphTiers[0], map[time.Time]bool{
+			created.Add(20 * time.Second):  true,
+			created.Add(40 * time.Second):  true,
+			created.Add(80 * time.Second):  false,
+			created.Add(100 * time.Second): false,
+			created.Add(130 * time.Second): false,
+		})
+
+		// Only completed buckets are rolled up: 12:02 is still filling
+		*clock = created.Add(150 * time.Second)
+		result, err := store.CompactGraphData(ctx, testGraphTiers)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if result.RolledUp != 2 || result.Pruned != 0 {
+			t.Errorf("first compaction %+v, want 2 minute buckets and nothing pruned", result)
+		}
+		expectGraph(t, store, task.ID, testGraphTiers[1], map[time.Time]bool{
+			created:                  true,
+			created.Add(time.Minute): false,
+		})
+		expectGraph(t, store, task.ID, testGraphTiers[2], nil)
+
+		// An hour later the 12:02 minute and the 12:00 hour are complete, and
+		// the raw samples have expired
+		*clock = created.Add(time.Hour + 30*time.Second)
+		result, err = store.CompactGraphData(ctx, testGraphTiers)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if result.Pruned != 5 {
+			t.Errorf("second compaction pruned %d rows, want the 5 raw samples", result.Pruned)
+		}
+		expectGraph(t, store, task.ID, testGraphTiers[0], nil)
+		expectGraph(t, store, task.ID, testGraphTiers[1], map[time.Time]bool{
+			created:                      true,
+			created.Add(time.Minute):     false,
+			created.Add(2 * time.Minute): false,
+		})
+		// The task died within the hour, so the hour is not alive
+		expectGraph(t, store, task.ID, testGraphTiers[2], map[time.Time]bool{created: false})
+		expectGraph(t, store, task.ID, testGraphTiers[3], nil)
+
+		// A step coarser than the tier merges its buckets on step boundaries
+		expectGraphBuckets(t, store, GraphQuery{TaskID: task.ID, Step: 2 * time.Minute, Tier: testGraphTiers[1]}, map[time.Time]bool{
+			created:                      false,
+			created.Add(2 * time.Minute): false,
+		})
+
+		// Compacting again rewrites only the newest bucket of each tier
+		result, err = store.CompactGraphData(ctx, testGraphTiers)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if result.Pruned != 0 {
+			t.Errorf("third compaction pruned %d rows", result.Pruned)
+		}
+		expectGraph(t, store, task.ID, testGraphTiers[1], map[time.Time]bool{
+			created:                      true,
+			created.Add(time.Minute):     false,
+			created.Add(2 * time.Minute): false,
+		})
+	})
+}
@@ -0,0 +1,147 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// Tags are short lowercase labels such as "db-maintenance" or "team:payments"
+var tagPattern = regexp.MustCompile(`^[a-z0-9][a-z0-9_.:/-]{0,63}$`)
+
+// Most tags a single task can carry
+const maxTaskTags = 20
+
+// taskStatuses are the statuses of a task. The monitor moves tasks between
+// alive and dead, while users can only pause and resume them. The monitor
+// never touches a paused task and its time counts as neither uptime nor
+// downtime.
+var taskStatuses = map[string]bool{"alive": true, "dead": true, "paused": true}
+
+// taskStatusChange checks a status a user wrote to a task: "paused" pauses
+// it and "alive" resumes it when paused. It returns the status to move the
+// task into ("" for none), or the message for a 400 response.
+func taskStatusChange(task Task, status string) (string, string) {
+	switch {
+	case status == "" || status == task.Status:
+		return "", ""
+	case status == "paused":
+		return "paused", ""
+	case status == "alive" && task.Status == "paused":
+		return "alive", ""
+	}
+	return "", "can only be set to alive to resume a paused task"
+}
+
+// normalizeTags lowercases, dedupes and sorts tags, so that they compare and
+// filter the same however they were entered. It returns the message for a
+// 400 response, or "".
+func normalizeTags(tags []string) ([]string, string) {
+	seen := map[string]bool{}
+	normalized := []string{}
+	for _, tag := range tags {
+		tag = strings.ToLower(strings.TrimSpace(tag))
+		if !tagPattern.MatchString(tag) {
+			return nil, fmt.Sprintf("invalid tag %q: use up to 64 lowercase letters, digits and _ . : / -", tag)
+		}
+		if !seen[tag] {
+			seen[tag] = true
+			normalized = append(normalized, tag)
+		}
+	}
+	if len(normalized) > maxTaskTags {
+		return nil, fmt.Sprintf("a task can have at most %d tags", maxTaskTags)
+	}
+	sort.Strings(normalized)
+	return normalized, ""
+}
+
+// validateTaskLabels normalizes the project and tags of a task. It returns
+// the message for a 400 response, or "".
+func validateTaskLabels(task *Task) string {
+	task.Project = strings.TrimSpace(task.Project)
+	if len(task.Project) > 255 {
+		return "project must be at most 255 characters"
+	}
+	tags, msg := normalizeTags(task.Tags)
+	task.Tags = tags
+	return msg
+}
+
+// taskFilterFromRequest reads the ?tag= and ?project= filters
+func taskFilterFromRequest(r *http.Request) TaskFilter {
+	query := r.URL.Query()
+	return TaskFilter{
+		Tag:     strings.ToLower(strings.TrimSpace(query.Get("tag"))),
+		Project: strings.TrimSpace(query.Get("project")),
+	}
+}
+
+// bulkTaskRequest selects the tasks of a bulk operation by tag and/or project
+type bulkTaskRequest struct {
+	Tag     string `json:"tag"`
+	Project string `json:"project"`
+	Action  string `json:"action" validate:"required,oneof=pause resume delete"`
+}
+
+// bulkTaskAction pauses, resumes or deletes every task of a user or
+// organization that carries a tag and/or belongs to a project. Resuming only
+// touches paused tasks.
+func (s *Server) bulkTaskAction(w http.ResponseWriter, r *http.Request) {
+	userID, orgID, ok := s.taskOwnerFromRequest(w, r, roleEditor)
+	if !ok {
+		return
+	}
+
+	var req bulkTaskRequest
+	if !decodeJSON(w, r, &req) {
+		return
+	}
+
+	filter := TaskFilter{
+		Tag:     strings.ToLower(strings.TrimSpace(req.Tag)),
+		Project: strings.TrimSpace(req.Project),
+	}
+	if filter == (TaskFilter{}) {
+		respondWithError(w, http.StatusBadRequest, "tag or project is required")
+		return
+	}
+	filter.OrgID = orgID
+
+	tasks, err := s.tasks.ListUserTasks(r.Context(), userID, filter)
+	if err != nil {
+		log.Printf("Error querying tasks: %v", err)
+		respondWithError(w, http.StatusInternalServerError, "Error retrieving tasks")
+		return
+	}
+
+	ids := []int64{}
+	for _, task := range tasks {
+		if req.Action != "resume" || task.Status == "paused" {
+			ids = append(ids, task.ID)
+		}
+	}
+
+	switch req.Action {
+	case "pause":
+		err = s.tasks.SetTaskStatus(r.Context(), ids, "paused")
+	case "resume":
+		err = s.tasks.SetTaskStatus(r.Context(), ids, "alive")
+	case "delete":
+		err = s.tasks.DeleteTasks(r.Context(), ids)
+	}
+	if err != nil {
+		log.Printf("Error applying %s to tasks of user %d, organization %d: %v", req.Action, userID, orgID, err)
+		respondWithError(w, http.StatusInternalServerError, "Error updating tasks")
+		return
+	}
+
+	log.Printf("Applied %s to %d tasks of user ID: %d, organization ID: %d", req.Action, len(ids), userID, orgID)
+	respondWithJSON(w, http.StatusOK, map[string]interface{}{
+		"action":   req.Action,
+		"task_ids": ids,
+	})
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"testing"
+	"time"
+)
+
+func TestNormalizeTags(t *testing.T) {
+	tags, msg := normalizeTags([]string{" Nightly", "db-maintenance", "nightly", "team:payments"})
+	if msg != "" || fmt.Sprint(tags) != "[db-maintenance nightly team:payments]" {
+		t.Errorf("normalizeTags = %v, %q", tags, msg)
+	}
+	for _, tag := range []string{"", "-leading-dash", "has space"} {
+		if _, msg := normalizeTags([]string{tag}); msg == "" {
+			t.Errorf("tag %q accepted", tag)
+		}
+	}
+	many := make([]string, maxTaskTags+1)
+	for i := range many {
+		many[i] = fmt.Sprintf("tag-%d", i)
+	}
+	if _, msg := normalizeTags(many); msg == "" {
+		t.Errorf("%d tags accepted", len(many))
+	}
+}
+
+func TestTaskTagsAndBulkActions(t *testing.T) {
+	api := newTestAPI(t)
+	userID, token := api.signup("alice")
+
+	create := func(name string, number int, project string, tags ...string) Task {
+		t.Helper()
+		var task Task
+		expect(t, api.do("POST", "/api/tasks", token, map[string]interface{}{
+			"user_id": userID, "name": name, "task_number": number, "interval": 60, "project": project, "tags": tags,
+		}), http.StatusCreated, &task)
+		return task
+	}
+	vacuum := create("vacuum", 1, "billing", "DB-Maintenance", "nightly")
+	create("reindex", 2, "search", "db-maintenance")
+	create("backup", 3, "billing")
+	if vacuum.Project != "billing" || fmt.Sprint(vacuum.Tags) != "[db-maintenance nightly]" {
+		t.Errorf("created task %+v", vacuum)
+	}
+	expect(t, api.do("POST", "/api/tasks", token, map[string]interface{}{
+		"user_id": userID, "name": "bad", "task_number": 4, "interval": 60, "tags": []string{"bad tag"},
+	}), http.StatusBadRequest, nil)
+
+	listed := func(query string) []string {
+		t.Helper()
+		var tasks taskListing
+		expect(t, api.do("GET", fmt.Sprintf("/api/users/%d/tasks?%s", userID, query), token, nil), http.StatusOK, &tasks)
+		names := []string{}
+		for _, task := range tasks.Tasks {
+			names = append(names, task.Task.Name+":"+task.Task.Status)
+		}
+		sort.Strings(names)
+		return names
+	}
+	for query, want := range map[string]string{
+		"tag=db-maintenance":                 "[reindex:alive vacuum:alive]",
+		"project=billing":                    "[backup:alive vacuum:alive]",
+		"tag=db-maintenance&project=billing": "[vacuum:alive]",
+		"tag=missing":                        "[]",
+	} {
+		if got := listed(query); fmt.Sprint(got) != want {
+			t.Errorf("tasks with %s: %v, want %s", query, got, want)
+		}
+	}
+
+	bulk := func(body map[string]interface{}, status int) {
+		t.Helper()
+		expect(t, api.do("POST", fmt.Sprintf("/api/users/%d/tasks/bulk", userID), token, body), status, nil)
+	}
+	bulk(map[string]interface{}{"action": "pause"}, http.StatusBadRequest)
+	bulk(map[string]interface{}{"tag": "nightly", "action": "archive"}, http.StatusBadRequest)
+
+	bulk(map[string]interface{}{"tag": "db-maintenance", "action": "pause"}, http.StatusOK)
+	// Paused tasks are never marked dead
+	api.advance(5 * time.Minute)
+	monitorPass(t, api.store, nil)
+	if got := listed("tag=db-maintenance"); fmt.Sprint(got) != "[reindex:paused vacuum:paused]" {
+		t.Errorf("paused tasks %v", got)
+	}
+	if got := listed("project=search"); fmt.Sprint(got) != "[reindex:paused]" {
+		t.Errorf("paused search tasks %v", got)
+	}
+
+	bulk(map[string]interface{}{"project": "search", "action": "resume"}, http.StatusOK)
+	bulk(map[string]interface{}{"project": "billing", "action": "delete"}, http.StatusOK)
+	if got := listed(""); fmt.Sprint(got) != "[reindex:alive]" {
+		t.Errorf("tasks after the bulk actions %v", got)
+	}
+}
@@ -0,0 +1,196 @@
+package main
+
+import (
+	"cmp"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Page size of task listings when no limit is given, and the largest allowed
+const (
+	defaultTaskPageSize = 100
+	maxTaskPageSize     = 500
+)
+
+// taskSorts maps the sort keys of task listings to the SQL expression they
+// order by, shared by Postgres and SQLite. Tasks that never pinged sort as if
+// they had pinged at the zero time, and tasks without tracked time at 0% uptime.
+var taskSorts = map[string]string{
+	"id":        "id",
+	"name":      "name",
+	"last_ping": "COALESCE(last_ping, '0001-01-01 00:00:00+00:00')",
+	"uptime":    "CASE WHEN uptime_seconds + downtime_seconds > 0 THEN uptime_seconds / (uptime_seconds + downtime_seconds) ELSE 0 END",
+	"status":    "status",
+}
+
+// taskSortValue is the value of the sort key for a task, typed like the
+// expression in taskSorts
+func taskSortValue(task Task, sort string) interface{} {
+	switch sort {
+	case "name":
+		return task.Name
+	case "last_ping":
+		if task.LastPing == nil {
+			return time.Time{}
+		}
+		return task.LastPing.UTC()
+	case "uptime":
+		if tracked := task.UptimeSeconds + task.DowntimeSeconds; tracked > 0 {
+			return task.UptimeSeconds / tracked
+		}
+		return 0.0
+	case "status":
+		return task.Status
+	}
+	return task.ID
+}
+
+// compareSortValues compares two values returned by taskSortValue for the
+// same key. It returns -1, 0 or 1.
+func compareSortValues(a, b interface{}) int {
+	switch va := a.(type) {
+	case string:
+		return strings.Compare(va, b.(string))
+	case time.Time:
+		return va.Compare(b.(time.Time))
+	case float64:
+		return cmp.Compare(va, b.(float64))
+	case int64:
+		return cmp.Compare(va, b.(int64))
+	}
+	return 0
+}
+
+// compareTasks orders two tasks by the sort key and then by ID, like the SQL
+// stores do. It returns -1, 0 or 1.
+func compareTasks(a, b Task, sort string) int {
+	if c := compareSortValues(taskSortValue(a, sort), taskSortValue(b, sort)); c != 0 {
+		return c
+	}
+	return cmp.Compare(a.ID, b.ID)
+}
+
+// TaskCursor is the position of the last task of a page in its sort order.
+// It is handed to clients as an opaque string.
+type TaskCursor struct {
+	Sort  string      `json:"s"`
+	Desc  bool        `json:"d,omitempty"`
+	Value interface{} `json:"v"`
+	ID    int64       `json:"id"`
+}
+
+// taskCursorAt returns the cursor continuing after task
+func taskCursorAt(task Task, q TaskListQuery) *TaskCursor {
+	return &TaskCursor{Sort: q.Sort, Desc: q.Desc, Value: taskSortValue(task, q.Sort), ID: task.ID}
+}
+
+// Encode returns the cursor as an opaque string
+func (c TaskCursor) Encode() string {
+	encoded, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(encoded)
+}
+
+var errInvalidCursor = errors.New("invalid cursor")
+
+// decodeTaskCursor parses a cursor string and restores the type of its value
+func decodeTaskCursor(raw string) (*TaskCursor, error) {
+	decoded, err := base64.RawURLEncoding.DecodeString(raw)
+	if err != nil {
+		return nil, errInvalidCursor
+	}
+	var c TaskCursor
+	if err := json.Unmarshal(decoded, &c); err != nil {
+		return nil, errInvalidCursor
+	}
+
+	ok := false
+	switch c.Sort {
+	case "name", "status":
+		_, ok = c.Value.(string)
+	case "last_ping":
+		if v, isString := c.Value.(string); isString {
+			var at time.Time
+			at, err = time.Parse(time.RFC3339Nano, v)
+			c.Value, ok = at, err == nil
+		}
+	case "uptime":
+		_, ok = c.Value.(float64)
+	case "id":
+		if v, isNumber := c.Value.(float64); isNumber {
+			c.Value, ok = int64(v), true
+		}
+	}
+	if !ok {
+		return nil, errInvalidCursor
+	}
+	return &c, nil
+}
+
+// after reports whether task comes after the cursor in its sort order
+func (c TaskCursor) after(task Task) bool {
+	order := compareSortValues(taskSortValue(task, c.Sort), c.Value)
+	if order == 0 {
+		order = cmp.Compare(task.ID, c.ID)
+	}
+	if c.Desc {
+		return order < 0
+	}
+	return order > 0
+}
+
+// parseTaskListQuery reads the listing parameters of getUserTasks: tag,
+// project, status, search, sort (a key of taskSorts, prefixed with - for
+// descending order), limit and cursor
+func parseTaskListQuery(query url.Values) (TaskListQuery, error) {
+	q := TaskListQuery{
+		Filter: TaskFilter{
+			Tag:     strings.ToLower(strings.TrimSpace(query.Get("tag"))),
+			Project: strings.TrimSpace(query.Get("project")),
+			Status:  query.Get("status"),
+			Search:  strings.TrimSpace(query.Get("search")),
+		},
+		Sort:  strings.TrimPrefix(query.Get("sort"), "-"),
+		Desc:  strings.HasPrefix(query.Get("sort"), "-"),
+		Limit: defaultTaskPageSize,
+	}
+
+	if q.Filter.Status != "" && !taskStatuses[q.Filter.Status] {
+		return q, errors.New("status must be alive, dead or paused")
+	}
+	if q.Sort == "" {
+		q.Sort = "id"
+	}
+	if _, ok := taskSorts[q.Sort]; !ok {
+		return q, errors.New("sort must be one of id, name, last_ping, uptime or status, optionally prefixed with -")
+	}
+	if v := query.Get("limit"); v != "" {
+		limit, err := strconv.Atoi(v)
+		if err != nil || limit < 1 || limit > maxTaskPageSize {
+			return q, errors.New("limit must be between 1 and " + strconv.Itoa(maxTaskPageSize))
+		}
+		q.Limit = limit
+	}
+	if v := query.Get("cursor"); v != "" {
+		cursor, err := decodeTaskCursor(v)
+		if err != nil {
+			return q, err
+		}
+		if cursor.Sort != q.Sort || cursor.Desc != q.Desc {
+			return q, errors.New("cursor belongs to a different sort order")
+		}
+		q.After = cursor
+	}
+	return q, nil
+}
+
+// likePattern turns a search term into a LIKE pattern matching it anywhere,
+// with \ escaping the wildcards in the term
+func likePattern(search string) string {
+	escaped := strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(strings.ToLower(search))
+	return "%" + escaped + "%"
+}
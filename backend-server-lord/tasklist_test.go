@@ -0,0 +1,143 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"testing"
+	"time"
+)
+
+func TestParseTaskListQuery(t *testing.T) {
+	q, err := parseTaskListQuery(url.Values{"tag": {" Nightly "}, "status": {"dead"}, "sort": {"-last_ping"}, "limit": {"20"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if q.Filter.Tag != "nightly" || q.Filter.Status != "dead" || q.Sort != "last_ping" || !q.Desc || q.Limit != 20 {
+		t.Errorf("parsed %+v", q)
+	}
+	if q, _ := parseTaskListQuery(url.Values{}); q.Sort != "id" || q.Desc || q.Limit != defaultTaskPageSize {
+		t.Errorf("defaults %+v", q)
+	}
+
+	cursor := TaskCursor{Sort: "name", Value: "backup", ID: 3}.Encode()
+	if q, err := parseTaskListQuery(url.Values{"sort": {"name"}, "cursor": {cursor}}); err != nil || q.After.Value != "backup" {
+		t.Errorf("cursor %+v, %v", q.After, err)
+	}
+	for _, query := range []url.Values{
+		{"status": {"sleeping"}},
+		{"sort": {"interval"}},
+		{"limit": {"0"}},
+		{"limit": {fmt.Sprint(maxTaskPageSize + 1)}},
+		{"cursor": {"not a cursor"}},
+		// The cursor was issued for another sort order
+		{"sort": {"-name"}, "cursor": {cursor}},
+	} {
+		if _, err := parseTaskListQuery(query); err == nil {
+			t.Errorf("%v accepted", query)
+		}
+	}
+}
+
+func TestTaskCursorRoundTrip(t *testing.T) {
+	at := time.Date(2026, 1, 1, 12, 0, 0, 5, time.UTC)
+	for _, task := range []Task{{ID: 7, Name: "backup", LastPing: &at, UptimeSeconds: 3, DowntimeSeconds: 1}, {ID: 8}} {
+		for sort := range taskSorts {
+			q := TaskListQuery{Sort: sort, Desc: true}
+			decoded, err := decodeTaskCursor(taskCursorAt(task, q).Encode())
+			if err != nil {
+				t.Fatalf("%s cursor: %v", sort, err)
+			}
+			if compareSortValues(decoded.Value, taskSortValue(task, sort)) != 0 || decoded.ID != task.ID || !decoded.Desc {
+				t.Errorf("%s cursor of %+v decoded as %+v", sort, task, decoded)
+			}
+		}
+	}
+}
+
+func TestStoreTaskPages(t *testing.T) {
+	forEachStore(t, func(t *testing.T, store Store, clock *time.Time) {
+		ctx := context.Background()
+		user, err := store.CreateUser(ctx, "alice", "alice@example.com", "hash")
+		if err != nil {
+			t.Fatal(err)
+		}
+		userID := int64(user.ID)
+
+		// Created a minute apart, so each pinged last a minute after the previous one
+		for i, name := range []string{"delta", "alpha", "charlie", "bravo", "echo"} {
+			*clock = clock.Add(time.Minute)
+			tags := []string{"nightly"}
+			if i%2 == 1 {
+				tags = []string{"hourly"}
+			}
+			if _, err := store.CreateTask(ctx, Task{Name: name, UserID: userID, Interval: 60, TaskNumber: i + 1, Tags: tags}); err != nil {
+				t.Fatal(err)
+			}
+		}
+		tasks, err := store.ListUserTasks(ctx, userID, TaskFilter{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := store.SetTaskStatus(ctx, []int64{tasks[4].ID}, "dead"); err != nil {
+			t.Fatal(err)
+		}
+
+		// pages lists every page of q and returns the task names page by page
+		pages := func(q TaskListQuery) []string {
+			t.Helper()
+			var names []string
+			for {
+				page, err := store.ListTaskPage(ctx, userID, q)
+				if err != nil {
+					t.Fatal(err)
+				}
+				names = append(names, fmt.Sprint(taskNames(page.Tasks)))
+				if page.Next == nil {
+					return names
+				}
+				// Cursors pass through their encoded form, as between requests
+				if q.After, err = decodeTaskCursor(page.Next.Encode()); err != nil {
+					t.Fatal(err)
+				}
+			}
+		}
+
+		for _, tc := range []struct {
+			q    TaskListQuery
+			want string
+		}{
+			{TaskListQuery{Sort: "name", Limit: 2}, "[[alpha bravo] [charlie delta] [echo]]"},
+			{TaskListQuery{Sort: "name", Desc: true, Limit: 3}, "[[echo delta charlie] [bravo alpha]]"},
+			{TaskListQuery{Sort: "last_ping", Desc: true, Limit: 2}, "[[echo bravo] [charlie alpha] [delta]]"},
+			{TaskListQuery{Sort: "status", Limit: 4}, "[[delta alpha charlie bravo] [echo]]"},
+			{TaskListQuery{Sort: "id", Limit: 5}, "[[delta alpha charlie bravo echo]]"},
+			{TaskListQuery{Sort: "id", Limit: 2, Filter: TaskFilter{Tag: "nightly"}}, "[[delta charlie] [echo]]"},
+			{TaskListQuery{Sort: "id", Limit: 10, Filter: TaskFilter{Status: "dead"}}, "[[echo]]"},
+			{TaskListQuery{Sort: "name", Limit: 10, Filter: TaskFilter{Search: "HA"}}, "[[alpha charlie]]"},
+			{TaskListQuery{Sort: "name", Limit: 10, Filter: TaskFilter{Search: "our"}}, "[[alpha bravo]]"},
+			{TaskListQuery{Sort: "name", Limit: 10, Filter: TaskFilter{Search: "%"}}, "[[]]"},
+		} {
+			if got := fmt.Sprint(pages(tc.q)); got != tc.want {
+				t.Errorf("pages of %+v: %s, want %s", tc.q, got, tc.want)
+			}
+		}
+
+		page, err := store.ListTaskPage(ctx, userID, TaskListQuery{Sort: "id", Limit: 1, Filter: TaskFilter{Status: "alive"}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		// The status counts ignore the status filter
+		if page.Total != 4 || page.StatusCounts["alive"] != 4 || page.StatusCounts["dead"] != 1 {
+			t.Errorf("total %d, status counts %v; want 4 of 4 alive and 1 dead", page.Total, page.StatusCounts)
+		}
+	})
+}
+
+func taskNames(tasks []Task) []string {
+	names := []string{}
+	for _, task := range tasks {
+		names = append(names, task.Name)
+	}
+	return names
+}
@@ -0,0 +1,221 @@
+package main
+
+import (
+	"log"
+	"net/http"
+	"time"
+)
+
+// TaskTransition is one entry of the status transition log. UptimeSeconds and
+// DowntimeSeconds are the task's cumulative counters at the moment of the
+// transition, so the counters can be replayed from any entry onwards.
+type TaskTransition struct {
+	ID              int64     `json:"id"`
+	TaskID          int64     `json:"task_id"`
+	FromStatus      *string   `json:"from_status"`
+	ToStatus        string    `json:"to_status"`
+	TransitionedAt  time.Time `json:"transitioned_at"`
+	UptimeSeconds   float64   `json:"uptime_seconds"`
+	DowntimeSeconds float64   `json:"downtime_seconds"`
+}
+
+// replayTransitions derives uptime and downtime from a transition log, starting
+// from the counters recorded on the first entry and accounting every following
+// interval to the status that was entered, up to the given time. Paused
+// intervals count as neither.
+func replayTransitions(transitions []TaskTransition, until time.Time) (float64, float64) {
+	if len(transitions) == 0 {
+		return 0, 0
+	}
+
+	uptime := transitions[0].UptimeSeconds
+	downtime := transitions[0].DowntimeSeconds
+	for i, t := range transitions {
+		end := until
+		if i+1 < len(transitions) {
+			end = transitions[i+1].TransitionedAt
+		}
+		elapsed := end.Sub(t.TransitionedAt).Seconds()
+		if elapsed <= 0 {
+			continue
+		}
+		switch t.ToStatus {
+		case "alive":
+			uptime += elapsed
+		case "paused":
+		default:
+			downtime += elapsed
+		}
+	}
+	return uptime, downtime
+}
+
+// getTaskTransitions returns the transition log of a task together with the
+// uptime/downtime replayed from it, so the stored counters can be audited
+func (s *Server) getTaskTransitions(w http.ResponseWriter, r *http.Request) {
+	task, ok := s.taskFromRequest(w, r, roleViewer)
+	if !ok {
+		return
+	}
+	id := task.ID
+
+	transitions, err := s.transitions.ListTransitions(r.Context(), id)
+	if err != nil {
+		log.Printf("Error retrieving transitions for task %d: %v", id, err)
+		respondWithError(w, http.StatusInternalServerError, "Error retrieving transitions")
+		return
+	}
+
+	// Counters are accounted up to last_checked, so replay up to the same point
+	until := time.Now()
+	if task.LastChecked != nil {
+		until = *task.LastChecked
+	}
+	uptime, downtime := replayTransitions(transitions, until)
+
+	type counters struct {
+		UptimeSeconds   float64 `json:"uptime_seconds"`
+		DowntimeSeconds float64 `json:"downtime_seconds"`
+	}
+
+	response := struct {
+		TaskID      int64            `json:"task_id"`
+		Transitions []TaskTransition `json:"transitions"`
+		Stored      counters         `json:"stored"`
+		Replayed    counters         `json:"replayed"`
+	}{
+		TaskID:      id,
+		Transitions: transitions,
+		Stored:      counters{task.UptimeSeconds, task.DowntimeSeconds},
+		Replayed:    counters{uptime, downtime},
+	}
+
+	log.Printf("Retrieved %d transitions for task ID: %d", len(transitions), id)
+	respondWithJSON(w, http.StatusOK, response)
+}
+
+// The functions below apply the same accounting rules as the Postgres queries
+// to a task held in memory. Stores without set-based SQL for it (memory,
+// SQLite) load the task, apply them and write the task back.
+
+// accrueTask accounts the time from last_checked up to at to the task's
+// current status and moves last_checked to at. Paused time is not accounted.
+func accrueTask(task *Task, at time.Time) {
+	if task.LastChecked != nil {
+		if elapsed := at.Sub(*task.LastChecked).Seconds(); elapsed > 0 {
+			switch task.Status {
+			case "alive":
+				task.UptimeSeconds += elapsed
+			case "paused":
+			default:
+				task.DowntimeSeconds += elapsed
+			}
+		}
+	}
+	task.LastChecked = &at
+}
+
+// applyTransition moves the task into newStatus at now (or at last_checked,
+// if that is later), refreshing last_ping when touchPing is set. It returns
+// the transition to log, or nil when the status did not change.
+func applyTransition(task *Task, newStatus string, touchPing bool, now time.Time) *TaskTransition {
+	at := now
+	if task.LastChecked != nil && task.LastChecked.After(at) {
+		at = *task.LastChecked
+	}
+
+	accrueTask(task, at)
+	if touchPing {
+		task.LastPing = &at
+		task.PingCount++
+	}
+	if task.Status == newStatus {
+		return nil
+	}
+
+	from := task.Status
+	task.PreviousStatus = from
+	task.StatusChangedAt = &at
+	task.Status = newStatus
+	return &TaskTransition{
+		TaskID:          task.ID,
+		FromStatus:      &from,
+		ToStatus:        newStatus,
+		TransitionedAt:  at,
+		UptimeSeconds:   task.UptimeSeconds,
+		DowntimeSeconds: task.DowntimeSeconds,
+	}
+}
+
+// heartbeatStatus is the status a heartbeat moves the task into: alive,
+// unless the task is paused, which only gets its ping recorded
+func heartbeatStatus(task Task) string {
+	if task.Status == "paused" {
+		return "paused"
+	}
+	return "alive"
+}
+
+// applyMonitorPass marks an overdue alive task dead at its ping deadline
+// (counted from no earlier than graceStart, or than its resume from a pause)
+// and accounts the time since the last check up to now. It returns the
+// alive→dead transition, if any.
+func applyMonitorPass(task *Task, now time.Time, graceStart *time.Time) *TaskTransition {
+	var transition *TaskTransition
+
+	if task.Status == "alive" && task.LastPing != nil {
+		deadlineFrom := *task.LastPing
+		if graceStart != nil && graceStart.After(deadlineFrom) {
+			deadlineFrom = *graceStart
+		}
+		if task.PreviousStatus == "paused" && task.StatusChangedAt != nil && task.StatusChangedAt.After(deadlineFrom) {
+			deadlineFrom = *task.StatusChangedAt
+		}
+		deadline := deadlineFrom.Add(time.Duration(task.Interval) * time.Second)
+
+		if deadline.Before(now) {
+			deadAt := deadline
+			if task.LastChecked != nil && task.LastChecked.After(deadAt) {
+				deadAt = *task.LastChecked
+			}
+			accrueTask(task, deadAt)
+			task.PreviousStatus = task.Status
+			task.Status = "dead"
+			task.StatusChangedAt = &deadAt
+
+			from := "alive"
+			transition = &TaskTransition{
+				TaskID:          task.ID,
+				FromStatus:      &from,
+				ToStatus:        "dead",
+				TransitionedAt:  deadAt,
+				UptimeSeconds:   task.UptimeSeconds,
+				DowntimeSeconds: task.DowntimeSeconds,
+			}
+		}
+	}
+
+	// Rows moved forward by a later heartbeat are left alone
+	if task.LastChecked == nil || task.LastChecked.Before(now) {
+		accrueTask(task, now)
+	}
+	return transition
+}
+
+// monitoredTask describes a task after a monitor pass at now
+func monitoredTask(task Task, now time.Time) MonitoredTask {
+	t := MonitoredTask{
+		ID:              task.ID,
+		Name:            task.Name,
+		TaskNumber:      task.TaskNumber,
+		Status:          task.Status,
+		Interval:        task.Interval,
+		UptimeSeconds:   task.UptimeSeconds,
+		DowntimeSeconds: task.DowntimeSeconds,
+	}
+	if task.LastPing != nil {
+		t.LastPing = *task.LastPing
+		t.SinceLastPing = now.Sub(*task.LastPing).Seconds()
+	}
+	return t
+}
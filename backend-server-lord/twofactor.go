@@ -0,0 +1,507 @@
+package main
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/golang-jwt/jwt"
+)
+
+// TwoFactor is the TOTP state of a user
+type TwoFactor struct {
+	Secret        string // base32, set once enrollment started
+	Enabled       bool
+	LastStep      int64 // time step of the last accepted code
+	RecoveryCodes int   // unused recovery codes left
+}
+
+// TOTP parameters (RFC 6238 with the defaults authenticator apps expect).
+// Codes of the previous and next time step are accepted too, for clock skew.
+const (
+	totpIssuer = "ServerLord"
+	totpPeriod = 30
+	totpDigits = 6
+	totpSkew   = 1
+
+	recoveryCodeCount = 10
+)
+
+// A login of a user with two-factor authentication first gets an MFA token,
+// which is traded for a session with a code within mfaTokenTTL. Wrong codes
+// lock the user out like wrong passwords, see lockout.
+const mfaTokenTTL = 5 * time.Minute
+
+// errTwoFactorRequired is returned by roleOn for members of an organization
+// that requires two-factor authentication when they have not enabled it
+var errTwoFactorRequired = errors.New("this organization requires two-factor authentication, enable it on your account first")
+
+// errInvalidCode is returned by verifySecondFactor for wrong, reused and
+// missing codes
+var errInvalidCode = errors.New("invalid code")
+
+var totpEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// newTOTPSecret returns a random 160-bit secret in base32
+func newTOTPSecret() (string, error) {
+	b := make([]byte, 20)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return totpEncoding.EncodeToString(b), nil
+}
+
+// totpCode returns the code of a time step (RFC 4226 truncation of HMAC-SHA1)
+func totpCode(key []byte, step int64) string {
+	var msg [8]byte
+	binary.BigEndian.PutUint64(msg[:], uint64(step))
+	mac := hmac.New(sha1.New, key)
+	mac.Write(msg[:])
+	sum := mac.Sum(nil)
+
+	offset := sum[len(sum)-1] & 0x0f
+	value := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+	return fmt.Sprintf("%0*d", totpDigits, value%1000000)
+}
+
+// matchTOTP returns the time step a code is valid for at now, if any
+func matchTOTP(secret, code string, now time.Time) (int64, bool) {
+	key, err := totpEncoding.DecodeString(strings.ToUpper(secret))
+	code = strings.ReplaceAll(code, " ", "")
+	if err != nil || len(code) != totpDigits {
+		return 0, false
+	}
+	current := now.Unix() / totpPeriod
+	for step := current - totpSkew; step <= current+totpSkew; step++ {
+		if subtle.ConstantTimeCompare([]byte(totpCode(key, step)), []byte(code)) == 1 {
+			return step, true
+		}
+	}
+	return 0, false
+}
+
+// totpURI returns the otpauth:// URI authenticator apps enroll with, usually
+// shown as a QR code
+func totpURI(secret, account string) string {
+	query := url.Values{}
+	query.Set("secret", secret)
+	query.Set("issuer", totpIssuer)
+	query.Set("algorithm", "SHA1")
+	query.Set("digits", fmt.Sprint(totpDigits))
+	query.Set("period", fmt.Sprint(totpPeriod))
+	uri := url.URL{Scheme: "otpauth", Host: "totp", Path: "/" + totpIssuer + ":" + account, RawQuery: query.Encode()}
+	return uri.String()
+}
+
+// newRecoveryCodes returns a fresh set of recovery codes like "3f9a1-c27e0"
+// and their hashes
+func newRecoveryCodes() (codes, hashes []string, err error) {
+	for i := 0; i < recoveryCodeCount; i++ {
+		b := make([]byte, 5)
+		if _, err := rand.Read(b); err != nil {
+			return nil, nil, err
+		}
+		code := hex.EncodeToString(b)
+		codes = append(codes, code[:5]+"-"+code[5:])
+		hashes = append(hashes, hashRecoveryCode(code))
+	}
+	return codes, hashes, nil
+}
+
+// hashRecoveryCode hashes a recovery code as typed, ignoring case, spaces
+// and dashes
+func hashRecoveryCode(code string) string {
+	code = strings.ToLower(strings.NewReplacer("-", "", " ", "").Replace(code))
+	return hashToken(code)
+}
+
+// generateMFAToken returns the token a user with two-factor authentication
+// gets for their password. It has no session, so JWTMiddleware refuses it.
+func generateMFAToken(user User) (string, error) {
+	claims := jwt.MapClaims{
+		"id":          user.ID,
+		"mfa_pending": true,
+		"iat":         time.Now().Unix(),
+		"exp":         time.Now().Add(mfaTokenTTL).Unix(),
+	}
+	return jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString(jwtSecret)
+}
+
+// parseMFAToken returns the user an unexpired MFA token was issued to
+func parseMFAToken(tokenString string) (int64, error) {
+	token, err := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
+		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
+			return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
+		}
+		return jwtSecret, nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	claims, _ := token.Claims.(jwt.MapClaims)
+	pending, _ := claims["mfa_pending"].(bool)
+	userID, _ := claims["id"].(float64)
+	if !token.Valid || !pending || userID <= 0 {
+		return 0, errors.New("not an MFA token")
+	}
+	return int64(userID), nil
+}
+
+// verifySecondFactor checks a TOTP code, or else a recovery code, of a user
+// with two-factor authentication enabled. Each code works once. It returns
+// errInvalidCode when neither is valid.
+func (s *Server) verifySecondFactor(ctx context.Context, userID int64, code, recoveryCode string) error {
+	tf, err := s.twoFactor.GetTwoFactor(ctx, userID)
+	if err != nil {
+		return err
+	}
+	if !tf.Enabled {
+		return errInvalidCode
+	}
+
+	switch {
+	case code != "":
+		step, ok := matchTOTP(tf.Secret, code, time.Now())
+		if !ok {
+			return errInvalidCode
+		}
+		err = s.twoFactor.UseTOTPStep(ctx, userID, step)
+		if errors.Is(err, ErrConflict) {
+			return errInvalidCode
+		}
+		return err
+	case recoveryCode != "":
+		err = s.twoFactor.UseRecoveryCode(ctx, userID, hashRecoveryCode(recoveryCode))
+		if errors.Is(err, ErrNotFound) {
+			return errInvalidCode
+		}
+		if err == nil {
+			log.Printf("User ID %d used a recovery code", userID)
+		}
+		return err
+	}
+	return errInvalidCode
+}
+
+// verifyWithLockout is verifySecondFactor behind the user's MFA lockout:
+// wrong codes count towards it, and no code is checked while it lasts. It
+// writes the 429 response itself and returns ok=false when locked out.
+func (s *Server) verifyWithLockout(w http.ResponseWriter, r *http.Request, userID int64, code, recoveryCode string) (bool, error) {
+	key := strconv.FormatInt(userID, 10)
+	now := time.Now()
+	if wait := s.mfaLockout.attempt(key, now); wait > 0 {
+		tooManyRequests(w, wait, "Too many wrong codes, try again later")
+		return false, nil
+	}
+
+	err := s.verifySecondFactor(r.Context(), userID, code, recoveryCode)
+	switch {
+	case errors.Is(err, errInvalidCode):
+		log.Printf("Wrong second factor for user ID %d", userID)
+	case err == nil:
+		s.mfaLockout.reset(key)
+	default:
+		s.mfaLockout.release(key)
+	}
+	return true, err
+}
+
+// checkOrgTwoFactor returns errTwoFactorRequired when the organization
+// requires two-factor authentication and the user has not enabled it
+func (s *Server) checkOrgTwoFactor(ctx context.Context, orgID, userID int64) error {
+	org, err := s.orgs.GetOrg(ctx, orgID)
+	if err != nil || !org.Require2FA {
+		return err
+	}
+	tf, err := s.twoFactor.GetTwoFactor(ctx, userID)
+	if err != nil {
+		return err
+	}
+	if !tf.Enabled {
+		return errTwoFactorRequired
+	}
+	return nil
+}
+
+// loginOutcome opens the session of a login whose password or SSO check
+// passed. Users with two-factor authentication get an MFA token to trade for
+// a session at /api/login/mfa instead.
+func (s *Server) loginOutcome(r *http.Request, user User) (response LoginResponse, mfaToken string, err error) {
+	tf, err := s.twoFactor.GetTwoFactor(r.Context(), int64(user.ID))
+	if err != nil {
+		return LoginResponse{}, "", fmt.Errorf("retrieving two-factor state: %w", err)
+	}
+	if tf.Enabled {
+		mfaToken, err = generateMFAToken(user)
+		if err != nil {
+			return LoginResponse{}, "", fmt.Errorf("generating MFA token: %w", err)
+		}
+		return LoginResponse{}, mfaToken, nil
+	}
+
+	// Open a session and return its tokens and the user info
+	response, err = s.startSession(r.Context(), r, user)
+	if err != nil {
+		return LoginResponse{}, "", fmt.Errorf("generating token: %w", err)
+	}
+	return response, "", nil
+}
+
+// completeLogin finishes a login whose password check passed with the
+// session's tokens, or the MFA token of users with two-factor
+// authentication. It writes the response and reports whether the user is
+// logged in.
+func (s *Server) completeLogin(w http.ResponseWriter, r *http.Request, user User) bool {
+	response, mfaToken, err := s.loginOutcome(r, user)
+	if err != nil {
+		log.Printf("Error logging in user ID %d: %v", user.ID, err)
+		respondWithError(w, http.StatusInternalServerError, "Error logging in")
+		return false
+	}
+	if mfaToken != "" {
+		respondWithJSON(w, http.StatusOK, map[string]interface{}{
+			"mfa_required": true,
+			"mfa_token":    mfaToken,
+			"expires_in":   int(mfaTokenTTL.Seconds()),
+		})
+		return false
+	}
+	respondWithJSON(w, http.StatusOK, response)
+	return true
+}
+
+// loginMFA trades an MFA token and a TOTP or recovery code for a session
+func (s *Server) loginMFA(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		MFAToken     string `json:"mfa_token" validate:"required"`
+		Code         string `json:"code"`
+		RecoveryCode string `json:"recovery_code"`
+	}
+	if !decodeJSON(w, r, &req) {
+		return
+	}
+
+	userID, err := parseMFAToken(req.MFAToken)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Login expired, please start again")
+		return
+	}
+	ok, err := s.verifyWithLockout(w, r, userID, req.Code, req.RecoveryCode)
+	if !ok {
+		return
+	}
+	if err != nil {
+		if errors.Is(err, errInvalidCode) {
+			respondWithError(w, http.StatusUnauthorized, "Invalid code")
+		} else {
+			log.Printf("Error verifying second factor: %v", err)
+			respondWithError(w, http.StatusInternalServerError, "Error logging in")
+		}
+		return
+	}
+
+	user, err := s.users.GetUser(r.Context(), userID)
+	if err == nil {
+		var response LoginResponse
+		if response, err = s.startSession(r.Context(), r, user); err == nil {
+			log.Printf("User %s logged in with two-factor authentication", user.Username)
+			respondWithJSON(w, http.StatusOK, response)
+			return
+		}
+	}
+	log.Printf("Error generating token: %v", err)
+	respondWithError(w, http.StatusInternalServerError, "Error generating token")
+}
+
+// getTwoFactor reports whether the authenticated user enabled two-factor
+// authentication and how many recovery codes they have left
+func (s *Server) getTwoFactor(w http.ResponseWriter, r *http.Request) {
+	tf, err := s.twoFactor.GetTwoFactor(r.Context(), authUserID(r))
+	if err != nil {
+		log.Printf("Error retrieving two-factor state: %v", err)
+		respondWithError(w, http.StatusInternalServerError, "Error retrieving two-factor authentication")
+		return
+	}
+	respondWithJSON(w, http.StatusOK, map[string]interface{}{
+		"enabled":             tf.Enabled,
+		"recovery_codes_left": tf.RecoveryCodes,
+	})
+}
+
+// setupTwoFactor starts an enrollment with a new secret. It returns the
+// secret and its otpauth:// URI for the authenticator app; the enrollment is
+// completed with a code from the app at /api/me/2fa/enable.
+func (s *Server) setupTwoFactor(w http.ResponseWriter, r *http.Request) {
+	user, ok := s.currentUser(w, r)
+	if !ok {
+		return
+	}
+
+	secret, err := newTOTPSecret()
+	if err == nil {
+		err = s.twoFactor.SetTOTPSecret(r.Context(), int64(user.ID), secret)
+	}
+	if err != nil {
+		if errors.Is(err, ErrConflict) {
+			respondWithError(w, http.StatusConflict, "Two-factor authentication is already enabled")
+		} else {
+			log.Printf("Error starting two-factor enrollment: %v", err)
+			respondWithError(w, http.StatusInternalServerError, "Error setting up two-factor authentication")
+		}
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, map[string]string{
+		"secret":      secret,
+		"otpauth_uri": totpURI(secret, user.Email),
+	})
+}
+
+// enableTwoFactor completes an enrollment with a code from the authenticator
+// app and returns the recovery codes, which are only shown this once. The
+// user's other sessions end, as they were opened without the second factor.
+func (s *Server) enableTwoFactor(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Code string `json:"code" validate:"required"`
+	}
+	if !decodeJSON(w, r, &req) {
+		return
+	}
+
+	userID := authUserID(r)
+	tf, err := s.twoFactor.GetTwoFactor(r.Context(), userID)
+	if err != nil {
+		log.Printf("Error retrieving two-factor state: %v", err)
+		respondWithError(w, http.StatusInternalServerError, "Error enabling two-factor authentication")
+		return
+	}
+	switch {
+	case tf.Enabled:
+		respondWithError(w, http.StatusConflict, "Two-factor authentication is already enabled")
+		return
+	case tf.Secret == "":
+		respondWithError(w, http.StatusBadRequest, "Start the setup with POST /api/me/2fa/setup first")
+		return
+	}
+	step, ok := matchTOTP(tf.Secret, req.Code, time.Now())
+	if !ok {
+		respondWithError(w, http.StatusBadRequest, "Invalid code, check the time on your device")
+		return
+	}
+
+	codes, hashes, err := newRecoveryCodes()
+	if err == nil {
+		err = s.twoFactor.EnableTwoFactor(r.Context(), userID, step, hashes)
+	}
+	if err != nil {
+		if errors.Is(err, ErrConflict) {
+			respondWithError(w, http.StatusConflict, "Two-factor authentication is already enabled")
+		} else {
+			log.Printf("Error enabling two-factor authentication: %v", err)
+			respondWithError(w, http.StatusInternalServerError, "Error enabling two-factor authentication")
+		}
+		return
+	}
+
+	if err := s.endOtherSessions(r.Context(), userID, authSessionID(r)); err != nil {
+		log.Printf("Error ending sessions of user ID %d: %v", userID, err)
+	}
+
+	log.Printf("User ID %d enabled two-factor authentication", userID)
+	respondWithJSON(w, http.StatusOK, map[string]interface{}{"recovery_codes": codes})
+}
+
+// regenerateRecoveryCodes replaces the recovery codes of the authenticated
+// user, given a current code from the authenticator app
+func (s *Server) regenerateRecoveryCodes(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Code string `json:"code" validate:"required"`
+	}
+	if !decodeJSON(w, r, &req) {
+		return
+	}
+
+	userID := authUserID(r)
+	ok, err := s.verifyWithLockout(w, r, userID, req.Code, "")
+	if !ok {
+		return
+	}
+	var codes, hashes []string
+	if err == nil {
+		codes, hashes, err = newRecoveryCodes()
+	}
+	if err == nil {
+		err = s.twoFactor.ReplaceRecoveryCodes(r.Context(), userID, hashes)
+	}
+	if err != nil {
+		if errors.Is(err, errInvalidCode) {
+			respondWithError(w, http.StatusForbidden, "Invalid code")
+		} else {
+			log.Printf("Error replacing recovery codes: %v", err)
+			respondWithError(w, http.StatusInternalServerError, "Error replacing recovery codes")
+		}
+		return
+	}
+
+	log.Printf("User ID %d replaced their recovery codes", userID)
+	respondWithJSON(w, http.StatusOK, map[string]interface{}{"recovery_codes": codes})
+}
+
+// disableTwoFactor turns two-factor authentication off. It takes the current
+// password and a code from the authenticator app or a recovery code.
+func (s *Server) disableTwoFactor(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Password     string `json:"password"`
+		Code         string `json:"code"`
+		RecoveryCode string `json:"recovery_code"`
+	}
+	if !decodeJSON(w, r, &req) {
+		return
+	}
+
+	user, ok := s.currentUser(w, r)
+	if !ok {
+		return
+	}
+	if user.Password != "" && req.Password == "" {
+		respondWithError(w, http.StatusBadRequest, "password is required")
+		return
+	}
+	// The code reauthenticates users without a password
+	if user.Password != "" && !s.checkCurrentPassword(w, r, user, req.Password, "") {
+		return
+	}
+
+	ok, err := s.verifyWithLockout(w, r, int64(user.ID), req.Code, req.RecoveryCode)
+	if !ok {
+		return
+	}
+	if err == nil {
+		err = s.twoFactor.DisableTwoFactor(r.Context(), int64(user.ID))
+	}
+	if err != nil {
+		if errors.Is(err, errInvalidCode) {
+			respondWithError(w, http.StatusForbidden, "Invalid code")
+		} else {
+			log.Printf("Error disabling two-factor authentication: %v", err)
+			respondWithError(w, http.StatusInternalServerError, "Error disabling two-factor authentication")
+		}
+		return
+	}
+
+	log.Printf("User ID %d disabled two-factor authentication", user.ID)
+	respondWithJSON(w, http.StatusOK, map[string]string{"message": "Two-factor authentication disabled"})
+}
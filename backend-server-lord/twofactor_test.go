@@ -0,0 +1,131 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestTOTPCode(t *testing.T) {
+	// RFC 6238 test vector for SHA-1, truncated to six digits
+	key := []byte("12345678901234567890")
+	if got := totpCode(key, 59/totpPeriod); got != "287082" {
+		t.Errorf("totpCode = %q, want 287082", got)
+	}
+	if got := totpCode(key, 1111111109/totpPeriod); got != "081804" {
+		t.Errorf("totpCode = %q, want 081804", got)
+	}
+}
+
+// enableTwoFactor enrolls the user behind token and returns their TOTP key
+// and recovery codes
+func (api *testAPI) enableTwoFactor(token string) ([]byte, []string) {
+	api.t.Helper()
+	var setup struct {
+		Secret string `json:"secret"`
+	}
+	expect(api.t, api.do("POST", "/api/me/2fa/setup", token, nil), http.StatusOK, &setup)
+	key, err := totpEncoding.DecodeString(setup.Secret)
+	if err != nil {
+		api.t.Fatal(err)
+	}
+	code := totpCode(key, time.Now().Unix()/totpPeriod)
+	var enabled struct {
+		RecoveryCodes []string `json:"recovery_codes"`
+	}
+	expect(api.t, api.do("POST", "/api/me/2fa/enable", token, map[string]string{"code": code}), http.StatusOK, &enabled)
+	return key, enabled.RecoveryCodes
+}
+
+func TestTwoFactorLogin(t *testing.T) {
+	api := newTestAPI(t)
+	_, token := api.signup("alice")
+	key, recovery := api.enableTwoFactor(token)
+	if len(recovery) == 0 {
+		t.Fatal("no recovery codes returned")
+	}
+
+	login := func() string {
+		var resp struct {
+			MFARequired bool   `json:"mfa_required"`
+			MFAToken    string `json:"mfa_token"`
+			Token       string `json:"token"`
+		}
+		expect(t, api.do("POST", "/api/login", "", map[string]string{
+			"email": "alice@example.com", "password": "correct horse",
+		}), http.StatusOK, &resp)
+		if !resp.MFARequired || resp.MFAToken == "" || resp.Token != "" {
+			t.Fatalf("password login = %+v, want only an MFA token", resp)
+		}
+		return resp.MFAToken
+	}
+
+	// The MFA token is no session
+	mfaToken := login()
+	expect(t, api.do("GET", "/api/me", mfaToken, nil), http.StatusUnauthorized, nil)
+	expect(t, api.do("POST", "/api/login/mfa", "", map[string]string{
+		"mfa_token": mfaToken, "code": "000000",
+	}), http.StatusUnauthorized, nil)
+
+	var session LoginResponse
+	expect(t, api.do("POST", "/api/login/mfa", "", map[string]string{
+		"mfa_token": mfaToken, "code": totpCode(key, time.Now().Unix()/totpPeriod+1),
+	}), http.StatusOK, &session)
+	expect(t, api.do("GET", "/api/me", session.Token, nil), http.StatusOK, nil)
+
+	// Recovery codes work once
+	body := map[string]string{"mfa_token": login(), "recovery_code": recovery[0]}
+	expect(t, api.do("POST", "/api/login/mfa", "", body), http.StatusOK, nil)
+	expect(t, api.do("POST", "/api/login/mfa", "", body), http.StatusUnauthorized, nil)
+
+	var tf struct {
+		Enabled           bool `json:"enabled"`
+		RecoveryCodesLeft int  `json:"recovery_codes_left"`
+	}
+	expect(t, api.do("GET", "/api/me/2fa", token, nil), http.StatusOK, &tf)
+	if !tf.Enabled || tf.RecoveryCodesLeft != len(recovery)-1 {
+		t.Errorf("2fa state = %+v, want enabled with %d recovery codes", tf, len(recovery)-1)
+	}
+}
+
+func TestEnableTwoFactorEndsOtherSessions(t *testing.T) {
+	api := newTestAPI(t)
+	_, current := api.signup("alice")
+	var other LoginResponse
+	expect(t, api.do("POST", "/api/login", "", map[string]string{
+		"email": "alice@example.com", "password": "correct horse",
+	}), http.StatusOK, &other)
+
+	api.enableTwoFactor(current)
+
+	expect(t, api.do("GET", "/api/me", current, nil), http.StatusOK, nil)
+	expect(t, api.do("GET", "/api/me", other.Token, nil), http.StatusUnauthorized, nil)
+	expect(t, api.do("POST", "/api/token/refresh", "", map[string]string{
+		"refresh_token": other.RefreshToken,
+	}), http.StatusUnauthorized, nil)
+}
+
+func TestSecondFactorLockout(t *testing.T) {
+	api := newTestAPI(t)
+	_, token := api.signup("alice")
+	key, _ := api.enableTwoFactor(token)
+
+	for i := 0; i < lockoutFreeFailures+1; i++ {
+		expect(t, api.do("POST", "/api/me/2fa/recovery-codes", token, map[string]string{"code": "abcdef"}), http.StatusForbidden, nil)
+	}
+
+	// Locked out, even with the right code and on the other endpoints
+	code := totpCode(key, time.Now().Unix()/totpPeriod+1)
+	expect(t, api.do("POST", "/api/me/2fa/recovery-codes", token, map[string]string{"code": code}), http.StatusTooManyRequests, nil)
+	expect(t, api.do("DELETE", "/api/me/2fa", token, map[string]string{
+		"password": "correct horse", "code": code,
+	}), http.StatusTooManyRequests, nil)
+
+	var tf struct {
+		Enabled bool `json:"enabled"`
+	}
+	expect(t, api.do("GET", "/api/me/2fa", token, nil), http.StatusOK, &tf)
+	if !tf.Enabled {
+		t.Error("two-factor authentication was disabled during the lockout")
+	}
+}
@@ -0,0 +1,294 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// Problem is an RFC 7807 problem details response. Every error of the API is
+// one; Error repeats the detail for clients of the older {"error": "..."}
+// responses.
+type Problem struct {
+	Type   string       `json:"type"`
+	Title  string       `json:"title"`
+	Status int          `json:"status"`
+	Detail string       `json:"detail,omitempty"`
+	Errors []FieldError `json:"errors,omitempty"`
+	Error  string       `json:"error"`
+}
+
+// FieldError describes an invalid field of a request body. Field is its JSON
+// path, like "interval" or "task_ids[2]".
+type FieldError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+// respondWithProblem writes a problem+json response
+func respondWithProblem(w http.ResponseWriter, problem Problem) {
+	if problem.Type == "" {
+		problem.Type = "about:blank"
+	}
+	if problem.Title == "" {
+		problem.Title = http.StatusText(problem.Status)
+	}
+	if problem.Error == "" {
+		problem.Error = problem.Detail
+	}
+
+	response, err := json.Marshal(problem)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/problem+json")
+	w.WriteHeader(problem.Status)
+	w.Write(response)
+}
+
+// respondWithFieldErrors rejects a request body with invalid fields
+func respondWithFieldErrors(w http.ResponseWriter, errs []FieldError) {
+	detail := errs[0].Field + " " + errs[0].Message
+	if len(errs) > 1 {
+		detail = fmt.Sprintf("%s (and %d more invalid fields)", detail, len(errs)-1)
+	}
+	respondWithProblem(w, Problem{Status: http.StatusBadRequest, Detail: detail, Errors: errs})
+}
+
+// Largest request body the API reads
+const maxRequestBodyBytes = 1 << 20
+
+// decodeJSON reads the JSON request body into dst, a pointer to a request
+// struct, and validates it against its validate tags. Bodies over
+// maxRequestBodyBytes, unknown fields and trailing data are rejected. It
+// writes the problem response itself and returns false on failure.
+func decodeJSON(w http.ResponseWriter, r *http.Request, dst interface{}) bool {
+	defer r.Body.Close()
+	return decodeValid(w, http.MaxBytesReader(w, r.Body, maxRequestBodyBytes), dst)
+}
+
+// decodeValid is decodeJSON for a body that was already read, like a task
+// with a merge patch applied
+func decodeValid(w http.ResponseWriter, body io.Reader, dst interface{}) bool {
+	decoder := json.NewDecoder(body)
+	decoder.DisallowUnknownFields()
+	err := decoder.Decode(dst)
+	if err == nil && decoder.Decode(&struct{}{}) != io.EOF {
+		err = errors.New("trailing data after the JSON body")
+	}
+	if err != nil {
+		respondWithDecodeError(w, err)
+		return false
+	}
+
+	if errs := validateStruct(dst); len(errs) > 0 {
+		respondWithFieldErrors(w, errs)
+		return false
+	}
+	return true
+}
+
+// respondWithDecodeError turns a JSON decoding error into a problem response
+func respondWithDecodeError(w http.ResponseWriter, err error) {
+	var maxBytesErr *http.MaxBytesError
+	var typeErr *json.UnmarshalTypeError
+	switch {
+	case errors.As(err, &maxBytesErr):
+		respondWithProblem(w, Problem{
+			Status: http.StatusRequestEntityTooLarge,
+			Detail: fmt.Sprintf("Request body must be at most %d bytes", maxBytesErr.Limit),
+		})
+	case errors.As(err, &typeErr) && typeErr.Field != "":
+		respondWithFieldErrors(w, []FieldError{{Field: typeErr.Field, Message: "must be " + jsonTypeName(typeErr.Type)}})
+	case strings.HasPrefix(err.Error(), "json: unknown field "):
+		field, _ := strconv.Unquote(strings.TrimPrefix(err.Error(), "json: unknown field "))
+		respondWithFieldErrors(w, []FieldError{{Field: field, Message: "is not a known field"}})
+	case errors.Is(err, io.EOF):
+		respondWithError(w, http.StatusBadRequest, "Request body is required")
+	default:
+		respondWithError(w, http.StatusBadRequest, "Invalid request payload: "+err.Error())
+	}
+}
+
+// jsonTypeName names the JSON type a Go type decodes from
+func jsonTypeName(t reflect.Type) string {
+	switch t.Kind() {
+	case reflect.Bool:
+		return "a boolean"
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return "an integer"
+	case reflect.Float32, reflect.Float64:
+		return "a number"
+	case reflect.String:
+		return "a string"
+	case reflect.Slice, reflect.Array:
+		return "an array"
+	case reflect.Ptr:
+		return jsonTypeName(t.Elem())
+	}
+	return "an object"
+}
+
+// validateStruct checks the fields of a struct (or a pointer to one) against
+// their validate tags and returns every violation. The tag is a comma
+// separated list of rules:
+//
+//	required    present, and for strings not blank
+//	notblank    not a blank string when present
+//	min=N       at least N, or at least N characters or items
+//	max=N       at most N, or at most N characters or items
+//	maxbytes=N  a string of at most N bytes
+//	oneof=a b   one of the listed values
+//	trimmed     a string without leading or trailing spaces
+//	email       an email address
+//	url         an absolute http(s) URL
+//
+// A field is present when it is not the zero value, or for pointers when it
+// is not nil (a pointer to the zero value is present). Rules only apply to
+// present fields, and nested structs and slices of structs are validated too.
+func validateStruct(v interface{}) []FieldError {
+	var errs []FieldError
+	validateValue(reflect.ValueOf(v), "", &errs)
+	return errs
+}
+
+func validateValue(v reflect.Value, path string, errs *[]FieldError) {
+	for v.Kind() == reflect.Ptr || v.Kind() == reflect.Interface {
+		if v.IsNil() {
+			return
+		}
+		v = v.Elem()
+	}
+
+	switch v.Kind() {
+	case reflect.Struct:
+		t := v.Type()
+		for i := 0; i < t.NumField(); i++ {
+			field := t.Field(i)
+			if !field.IsExported() {
+				continue
+			}
+			name := strings.Split(field.Tag.Get("json"), ",")[0]
+			if name == "-" {
+				continue
+			}
+			if name == "" {
+				name = field.Name
+			}
+			if path != "" {
+				name = path + "." + name
+			}
+			if msg := checkRules(v.Field(i), field.Tag.Get("validate")); msg != "" {
+				*errs = append(*errs, FieldError{Field: name, Message: msg})
+				continue
+			}
+			validateValue(v.Field(i), name, errs)
+		}
+	case reflect.Slice, reflect.Array:
+		for i := 0; i < v.Len(); i++ {
+			validateValue(v.Index(i), fmt.Sprintf("%s[%d]", path, i), errs)
+		}
+	}
+}
+
+// checkRules returns why a field breaks its rules, or "" when it does not
+func checkRules(v reflect.Value, tag string) string {
+	if tag == "" {
+		return ""
+	}
+	rules := strings.Split(tag, ",")
+	has := func(rule string) bool { return slices.Contains(rules, rule) }
+
+	present := !v.IsZero()
+	if v.Kind() == reflect.Ptr && present {
+		v = v.Elem()
+	}
+	blank := !present || (v.Kind() == reflect.String && strings.TrimSpace(v.String()) == "")
+	switch {
+	case blank && has("required"):
+		return "is required"
+	case present && blank && has("notblank"):
+		return "cannot be blank"
+	case !present:
+		return ""
+	}
+
+	for _, rule := range rules {
+		name, arg, _ := strings.Cut(rule, "=")
+		switch name {
+		case "required", "notblank":
+		case "min", "max":
+			limit, err := strconv.ParseFloat(arg, 64)
+			if err != nil {
+				panic(fmt.Sprintf("validate: invalid rule %q", rule))
+			}
+			size, unit := measure(v)
+			if name == "min" && size < limit {
+				return fmt.Sprintf("must be at least %s%s", arg, unit)
+			}
+			if name == "max" && size > limit {
+				return fmt.Sprintf("must be at most %s%s", arg, unit)
+			}
+		case "maxbytes":
+			limit, err := strconv.Atoi(arg)
+			if err != nil {
+				panic(fmt.Sprintf("validate: invalid rule %q", rule))
+			}
+			if len(v.String()) > limit {
+				return fmt.Sprintf("must be at most %d bytes", limit)
+			}
+		case "oneof":
+			options := strings.Fields(arg)
+			value := fmt.Sprint(v.Interface())
+			found := false
+			for _, option := range options {
+				found = found || value == option
+			}
+			if !found {
+				return "must be one of " + strings.Join(options, ", ")
+			}
+		case "trimmed":
+			if strings.TrimSpace(v.String()) != v.String() {
+				return "cannot start or end with spaces"
+			}
+		case "email":
+			if !validEmail(v.String()) {
+				return "must be an email address"
+			}
+		case "url":
+			if !validWebhookURL(v.String()) {
+				return "must be an http or https URL"
+			}
+		default:
+			panic(fmt.Sprintf("validate: unknown rule %q", rule))
+		}
+	}
+	return ""
+}
+
+// measure returns what min and max compare against: the value of a number,
+// the length of a string in characters, or the number of items
+func measure(v reflect.Value) (float64, string) {
+	switch v.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return float64(v.Int()), ""
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return float64(v.Uint()), ""
+	case reflect.Float32, reflect.Float64:
+		return v.Float(), ""
+	case reflect.String:
+		return float64(utf8.RuneCountInString(v.String())), " characters"
+	case reflect.Slice, reflect.Array, reflect.Map:
+		return float64(v.Len()), " items"
+	}
+	panic(fmt.Sprintf("validate: min/max on %s", v.Kind()))
+}
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestValidateStruct(t *testing.T) {
+	type item struct {
+		Name string `json:"name" validate:"required"`
+	}
+	type request struct {
+		Name     string  `json:"name" validate:"required,trimmed,max=5"`
+		Email    string  `json:"email" validate:"email"`
+		Interval int     `json:"interval" validate:"min=1,max=60"`
+		Status   string  `json:"status" validate:"oneof=alive paused"`
+		Note     *string `json:"note" validate:"notblank"`
+		Items    []item  `json:"items" validate:"max=2"`
+	}
+	blank := " "
+
+	tests := []struct {
+		name string
+		req  request
+		want []FieldError
+	}{
+		{"valid", request{Name: "abc", Interval: 60, Status: "paused", Items: []item{{"a"}}}, nil},
+		{"missing", request{}, []FieldError{{"name", "is required"}}},
+		{"blank", request{Name: "  "}, []FieldError{{"name", "is required"}}},
+		{"rules", request{Name: " abc", Email: "nope", Interval: 61, Status: "dead", Note: &blank}, []FieldError{
+			{"name", "cannot start or end with spaces"},
+			{"email", "must be an email address"},
+			{"interval", "must be at most 60"},
+			{"status", "must be one of alive, paused"},
+			{"note", "cannot be blank"},
+		}},
+		{"length", request{Name: "abcdef"}, []FieldError{{"name", "must be at most 5 characters"}}},
+		{"nested", request{Name: "abc", Items: []item{{"a"}, {""}}}, []FieldError{{"items[1].name", "is required"}}},
+		{"items", request{Name: "abc", Items: []item{{"a"}, {"b"}, {"c"}}}, []FieldError{{"items", "must be at most 2 items"}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := validateStruct(&tt.req); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("validateStruct = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDecodeJSON(t *testing.T) {
+	type request struct {
+		Name     string `json:"name" validate:"required"`
+		Interval int    `json:"interval"`
+	}
+
+	tests := []struct {
+		name   string
+		body   string
+		status int
+		field  string
+	}{
+		{"valid", `{"name": "backup", "interval": 60}`, http.StatusOK, ""},
+		{"empty", ``, http.StatusBadRequest, ""},
+		{"unknown field", `{"name": "backup", "status": "alive"}`, http.StatusBadRequest, "status"},
+		{"wrong type", `{"name": "backup", "interval": "60"}`, http.StatusBadRequest, "interval"},
+		{"invalid", `{"interval": 60}`, http.StatusBadRequest, "name"},
+		{"trailing data", `{"name": "backup"} {}`, http.StatusBadRequest, ""},
+		{"too large", `{"name": "` + strings.Repeat("a", maxRequestBodyBytes) + `"}`, http.StatusRequestEntityTooLarge, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			req := httptest.NewRequest("POST", "/", bytes.NewBufferString(tt.body))
+			var dst request
+			if decodeJSON(rec, req, &dst) {
+				rec.WriteHeader(http.StatusOK)
+			}
+			if rec.Code != tt.status {
+				t.Fatalf("status = %d, want %d: %s", rec.Code, tt.status, rec.Body)
+			}
+			if tt.status == http.StatusOK {
+				return
+			}
+
+			if ct := rec.Header().Get("Content-Type"); ct != "application/problem+json" {
+				t.Errorf("Content-Type = %q, want application/problem+json", ct)
+			}
+			var problem Problem
+			if err := json.Unmarshal(rec.Body.Bytes(), &problem); err != nil {
+				t.Fatal(err)
+			}
+			if problem.Status != tt.status || problem.Detail == "" || problem.Error != problem.Detail {
+				t.Errorf("problem = %+v", problem)
+			}
+			if tt.field != "" && (len(problem.Errors) != 1 || problem.Errors[0].Field != tt.field) {
+				t.Errorf("errors = %+v, want one for %s", problem.Errors, tt.field)
+			}
+		})
+	}
+}
+
+func TestCreateTaskFieldErrors(t *testing.T) {
+	api := newTestAPI(t)
+	userID, token := api.signup("alice")
+
+	var problem Problem
+	expect(t, api.do("POST", "/api/tasks", token, map[string]interface{}{
+		"user_id": userID, "name": "", "interval": -1,
+	}), http.StatusBadRequest, &problem)
+	want := []FieldError{{"name", "is required"}, {"interval", "must be at least 1"}}
+	if !reflect.DeepEqual(problem.Errors, want) {
+		t.Errorf("errors = %+v, want %+v", problem.Errors, want)
+	}
+}
+
+func TestRequestsRejectServerFields(t *testing.T) {
+	api := newTestAPI(t)
+	userID, token := api.signup("alice")
+	task := api.createTask(token, userID, "backup", 1, 60)
+
+	var slo SLO
+	expect(t, api.do("POST", "/api/slos", token, map[string]interface{}{
+		"task_id": task.ID, "name": "backup", "target": 99,
+	}), http.StatusCreated, &slo)
+	var page StatusPage
+	expect(t, api.do("POST", "/api/status-pages", token, map[string]interface{}{
+		"slug": "ops", "task_ids": []int64{task.ID},
+	}), http.StatusCreated, &page)
+
+	tests := []struct {
+		method, path string
+		body         map[string]interface{}
+		field        string
+	}{
+		{"POST", "/api/slos", map[string]interface{}{"task_id": task.ID, "name": "x", "target": 99, "id": 7}, "id"},
+		{"POST", "/api/slos", map[string]interface{}{"task_id": task.ID, "user_id": userID, "name": "x", "target": 99}, "user_id"},
+		{"PUT", fmt.Sprintf("/api/slos/%d", slo.ID), map[string]interface{}{"name": "x", "target": 99, "task_id": task.ID}, "task_id"},
+		{"PUT", fmt.Sprintf("/api/slos/%d", slo.ID), map[string]interface{}{"name": "x", "target": 99, "tag": "db"}, "tag"},
+		{"POST", "/api/status-pages", map[string]interface{}{"slug": "dev", "access_token": "mine"}, "access_token"},
+		{"PUT", fmt.Sprintf("/api/status-pages/%d", page.ID), map[string]interface{}{"slug": "ops", "user_id": userID}, "user_id"},
+		{"PUT", fmt.Sprintf("/api/status-pages/%d", page.ID), map[string]interface{}{"slug": "ops", "created_at": "2026-01-01T00:00:00Z"}, "created_at"},
+		{"POST", "/api/reports/schedules", map[string]interface{}{"period": "daily", "email": "ops@example.com", "last_period_end": "2030-01-01T00:00:00Z"}, "last_period_end"},
+		{"POST", "/api/badges", map[string]interface{}{"task_id": task.ID, "token": "mine"}, "token"},
+		{"POST", "/api/notification-routes", map[string]interface{}{"email": "ops@example.com", "id": 7}, "id"},
+	}
+	for _, tt := range tests {
+		var problem Problem
+		expect(t, api.do(tt.method, tt.path, token, tt.body), http.StatusBadRequest, &problem)
+		if len(problem.Errors) != 1 || problem.Errors[0].Field != tt.field {
+			t.Errorf("%s %s: errors %+v, want one for %s", tt.method, tt.path, problem.Errors, tt.field)
+		}
+	}
+}
@@ -0,0 +1,178 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"time"
+)
+
+// How often the watchdog checks monitor progress
+const watchdogInterval = 5 * time.Second
+
+// watchdogClient is used for stall alerts and dead-man pings
+var watchdogClient = &http.Client{Timeout: 10 * time.Second}
+
+// WatchdogAlert is the JSON body POSTed to WATCHDOG_WEBHOOK_URL
+type WatchdogAlert struct {
+	Event             string     `json:"event"` // "monitor_stalled" or "monitor_recovered"
+	Message           string     `json:"message"`
+	LastHealthyTickAt *time.Time `json:"last_healthy_tick_at"`
+	LastError         string     `json:"last_error,omitempty"`
+	SentAt            time.Time  `json:"sent_at"`
+}
+
+// monitorGraceStart is called at the start of every monitor tick. If no healthy
+// tick completed within the stall threshold (which includes the first tick after
+// startup), heartbeats may have been lost while nobody was watching. In that case
+// the current store time becomes the grace start: an overdue task is only marked
+// dead once a full interval has passed since then without a heartbeat. The grace
+// start is kept for later ticks, where it only affects tasks that have not pinged
+// since the recovery.
+func monitorGraceStart(ctx context.Context, store MonitorStore) (*time.Time, error) {
+	monitorStatus.RLock()
+	lastHealthy := monitorStatus.LastHealthyTickAt
+	graceStart := monitorStatus.GraceStart
+	monitorStatus.RUnlock()
+
+	if !lastHealthy.IsZero() && time.Since(lastHealthy) <= monitorMaxTickAge() {
+		return graceStart, nil
+	}
+
+	now, err := store.Now(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	if lastHealthy.IsZero() {
+		log.Printf("First monitor tick: overdue tasks get one interval from %s before being marked dead", now.Format(time.RFC3339))
+	} else {
+		log.Printf("Monitor recovering after %s without a healthy tick: overdue tasks get one interval from %s before being marked dead",
+			time.Since(lastHealthy).Round(time.Second), now.Format(time.RFC3339))
+	}
+
+	monitorStatus.Lock()
+	monitorStatus.GraceStart = &now
+	monitorStatus.Unlock()
+	return &now, nil
+}
+
+// startWatchdog watches the monitor loop until ctx is cancelled. When no healthy
+// tick has completed within MONITOR_MAX_TICK_AGE it sends a single "monitor_stalled"
+// alert to WATCHDOG_WEBHOOK_URL, followed by "monitor_recovered" once ticks resume.
+// After every new healthy tick it pings WATCHDOG_DEADMAN_URL, so an external
+// dead-man service notices when the whole process is gone.
+func startWatchdog(ctx context.Context) {
+	webhookURL := os.Getenv("WATCHDOG_WEBHOOK_URL")
+	deadmanURL := os.Getenv("WATCHDOG_DEADMAN_URL")
+	if webhookURL == "" && deadmanURL == "" {
+		log.Println("Watchdog disabled: neither WATCHDOG_WEBHOOK_URL nor WATCHDOG_DEADMAN_URL is set")
+		return
+	}
+
+	log.Println("Starting monitor watchdog...")
+	ticker := time.NewTicker(watchdogInterval)
+	defer ticker.Stop()
+
+	stalled := false
+	var lastPinged time.Time
+
+	for {
+		select {
+		case <-ctx.Done():
+			log.Println("Monitor watchdog stopped")
+			return
+		case <-ticker.C:
+		}
+
+		monitorStatus.RLock()
+		startedAt := monitorStatus.StartedAt
+		lastHealthy := monitorStatus.LastHealthyTickAt
+		lastError := monitorStatus.LastError
+		monitorStatus.RUnlock()
+
+		// Nothing to judge until the monitor loop is running
+		if startedAt.IsZero() {
+			continue
+		}
+
+		reference := lastHealthy
+		if reference.IsZero() {
+			reference = startedAt
+		}
+		age := time.Since(reference)
+		maxAge := monitorMaxTickAge()
+
+		alert := WatchdogAlert{
+			LastError: lastError,
+			SentAt:    time.Now(),
+		}
+		if !lastHealthy.IsZero() {
+			alert.LastHealthyTickAt = &lastHealthy
+		}
+
+		if age > maxAge && !stalled {
+			stalled = true
+			alert.Event = "monitor_stalled"
+			alert.Message = fmt.Sprintf("ServerLord is not monitoring: no healthy monitor tick for %s", age.Round(time.Second))
+			log.Printf("WATCHDOG: %s", alert.Message)
+			sendWatchdogAlert(webhookURL, alert)
+		} else if age <= maxAge && stalled {
+			stalled = false
+			alert.Event = "monitor_recovered"
+			alert.Message = "ServerLord monitoring has recovered"
+			log.Printf("WATCHDOG: %s", alert.Message)
+			sendWatchdogAlert(webhookURL, alert)
+		}
+
+		if deadmanURL != "" && !lastHealthy.IsZero() && lastHealthy.After(lastPinged) {
+			if err := pingDeadman(deadmanURL); err != nil {
+				log.Printf("Error pinging dead-man endpoint: %v", err)
+			} else {
+				lastPinged = lastHealthy
+			}
+		}
+	}
+}
+
+// sendWatchdogAlert POSTs the alert to the webhook, if one is configured
+func sendWatchdogAlert(webhookURL string, alert WatchdogAlert) {
+	if webhookURL == "" {
+		return
+	}
+
+	body, err := json.Marshal(alert)
+	if err != nil {
+		log.Printf("Error encoding watchdog alert: %v", err)
+		return
+	}
+
+	resp, err := watchdogClient.Post(webhookURL, "application/json", bytes.NewReader(body))
+	if err != nil {
+		log.Printf("Error sending watchdog alert: %v", err)
+		return
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		log.Printf("Watchdog webhook responded with status %d", resp.StatusCode)
+	}
+}
+
+// pingDeadman tells the external dead-man service that monitoring is alive
+func pingDeadman(deadmanURL string) error {
+	resp, err := watchdogClient.Get(deadmanURL)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("dead-man endpoint responded with status %d", resp.StatusCode)
+	}
+	return nil
+}
@@ -3,6 +3,32 @@ import NextAuth from "next-auth"
 import { NextAuthOptions } from "next-auth"
 import CredentialsProvider from "next-auth/providers/credentials"
 import GoogleProvider from "next-auth/providers/google"
+import { JWT } from "next-auth/jwt"
+
+// Access tokens from the backend are short-lived; trade the refresh token for
+// a new pair shortly before they expire
+async function refreshAccessToken(token: JWT): Promise<JWT> {
+  try {
+    const response = await fetch(`http://localhost:3000/api/token/refresh`, {
+      method: 'POST',
+      headers: { 'Content-Type': 'application/json' },
+      body: JSON.stringify({ refresh_token: token.refreshToken }),
+    })
+    const data = await response.json()
+    if (!response.ok) {
+      throw new Error(data.error || 'Refresh failed')
+    }
+    return {
+      ...token,
+      accessToken: data.token,
+      refreshToken: data.refresh_token,
+      accessTokenExpires: Date.now() + data.expires_in * 1000,
+    }
+  } catch (error) {
+    console.error("Token refresh error:", error)
+    return { ...token, error: "RefreshAccessTokenError" }
+  }
+}
 
 export const authOptions: NextAuthOptions = {
   providers: [
@@ -10,7 +36,8 @@ export const authOptions: NextAuthOptions = {
       name: "Credentials",
       credentials: {
         email: { label: "Email", type: "email", placeholder: "your@email.com" },
-        password: { label: "Password", type: "password" }
+        password: { label: "Password", type: "password" },
+        code: { label: "Authentication code", type: "text" }
       },
       async authorize(credentials) {
         if (!credentials?.email || !credentials?.password) {
@@ -26,16 +53,39 @@ export const authOptions: NextAuthOptions = {
               password: credentials.password,
             }),
           })
-          const data = await response.json()
+          let data = await response.json()
           if (!response.ok) {
             throw new Error(data.error || 'Authentication failed')
           }
+          // Accounts with two-factor authentication trade the MFA token and
+          // a code from the authenticator app (or a recovery code) for a session
+          if (data.mfa_required) {
+            if (!credentials.code) {
+              throw new Error('Authentication code required')
+            }
+            const code = credentials.code.trim()
+            const mfaResponse = await fetch(`http://localhost:3000/api/login/mfa`, {
+              method: 'POST',
+              headers: { 'Content-Type': 'application/json' },
+              body: JSON.stringify(
+                /^\d{6}$/.test(code)
+                  ? { mfa_token: data.mfa_token, code }
+                  : { mfa_token: data.mfa_token, recovery_code: code }
+              ),
+            })
+            data = await mfaResponse.json()
+            if (!mfaResponse.ok) {
+              throw new Error(data.error || 'Authentication failed')
+            }
+          }
           // Map the Go backend response to NextAuth user object
           return {
             id: data.user.id.toString(),
             name: data.user.username,
             email: data.user.email,
-            accessToken: data.token
+            accessToken: data.token,
+            refreshToken: data.refresh_token,
+            accessTokenExpires: Date.now() + data.expires_in * 1000
           }
         } catch (error) {
           console.error("Authentication error:", error)
@@ -43,6 +93,68 @@ export const authOptions: NextAuthOptions = {
         }
       }
     }),
+    // Single sign-on: the backend's SSO callback sends the browser to
+    // /login/sso with the session's tokens, or with an MFA token that the
+    // page trades for a session together with a code
+    CredentialsProvider({
+      id: "sso",
+      name: "SSO",
+      credentials: {
+        token: { type: "text" },
+        refresh_token: { type: "text" },
+        expires_in: { type: "text" },
+        mfa_token: { type: "text" },
+        code: { type: "text" }
+      },
+      async authorize(credentials) {
+        try {
+          let tokens = {
+            token: credentials?.token,
+            refresh_token: credentials?.refresh_token,
+            expires_in: Number(credentials?.expires_in),
+          }
+          if (credentials?.mfa_token) {
+            const code = credentials.code?.trim() || ''
+            const mfaResponse = await fetch(`http://localhost:3000/api/login/mfa`, {
+              method: 'POST',
+              headers: { 'Content-Type': 'application/json' },
+              body: JSON.stringify(
+                /^\d{6}$/.test(code)
+                  ? { mfa_token: credentials.mfa_token, code }
+                  : { mfa_token: credentials.mfa_token, recovery_code: code }
+              ),
+            })
+            const data = await mfaResponse.json()
+            if (!mfaResponse.ok) {
+              throw new Error(data.error || 'Authentication failed')
+            }
+            tokens = data
+          }
+          if (!tokens.token || !tokens.refresh_token) {
+            return null
+          }
+          // The tokens came through the browser; check them with the backend
+          const response = await fetch(`http://localhost:3000/api/me`, {
+            headers: { 'Authorization': `Bearer ${tokens.token}` },
+          })
+          const user = await response.json()
+          if (!response.ok) {
+            throw new Error(user.error || 'Authentication failed')
+          }
+          return {
+            id: user.id.toString(),
+            name: user.username,
+            email: user.email,
+            accessToken: tokens.token,
+            refreshToken: tokens.refresh_token,
+            accessTokenExpires: Date.now() + tokens.expires_in * 1000
+          }
+        } catch (error) {
+          console.error("SSO authentication error:", error)
+          return null
+        }
+      }
+    }),
     GoogleProvider({
       clientId: process.env.GOOGLE_CLIENT_ID as string,
       clientSecret: process.env.GOOGLE_CLIENT_SECRET as string,
@@ -54,9 +166,14 @@ export const authOptions: NextAuthOptions = {
       if (user) {
         token.id = user.id
         token.accessToken = user.accessToken
+        token.refreshToken = user.refreshToken
+        token.accessTokenExpires = user.accessTokenExpires
         token.name = user.name
       }
-      return token
+      if (!token.refreshToken || !token.accessTokenExpires || Date.now() < token.accessTokenExpires - 60 * 1000) {
+        return token
+      }
+      return refreshAccessToken(token)
     },
     async session({ session, token }) {
       if (session.user) {
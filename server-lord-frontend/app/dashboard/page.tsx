@@ -55,11 +55,20 @@ export default function DashboardPage() {
       if (!refreshing) setLoading(true)
       // Use the user ID from the session
       const userId = session.user.id
-      const response = await api.get(`/users/${userId}/tasks`)
-      console.log("refresh res=",response)
+      // The API returns a page of tasks at a time; follow next_cursor until
+      // the last page so every task shows up
+      const tasks: any[] = []
+      let cursor: string | null = null
+      do {
+        const response = await api.get(`/users/${userId}/tasks`, {
+          params: cursor ? { limit: 500, cursor } : { limit: 500 },
+        })
+        tasks.push(...response.data.tasks)
+        cursor = response.data.next_cursor
+      } while (cursor)
   
       // Process the response data with actual metrics from the API
-      const processesWithRealData = response.data.map((processData) => ({
+      const processesWithRealData = tasks.map((processData) => ({
         id: processData.task.id,
         name: processData.task.name,
         status: processData.task.status,
@@ -115,13 +124,13 @@ export default function DashboardPage() {
 
   const addProcess = async (process: { name: string, interval: number, pid: number }) => {
     try {
-      // Send the new process to the backend with user ID from session
+      // Send the new process to the backend with user ID from session. The
+      // API rejects fields it does not know; new tasks always start alive.
       const response = await api.post(
         "/tasks", 
         {
           user_id: parseInt(session.user.id),
           name: process.name,
-          status: "pending",
           interval: process.interval,
           task_number: process.pid,
           ping_url: `http://localhost:3000/tasks/${process.pid}/heartbeat` // https://exampleurl/12
@@ -113,6 +113,9 @@ export default function LoginPage() {
                   "Sign in"
                 )}
               </Button>
+              <Button asChild variant="outline" className="w-full" disabled={loading}>
+                <a href="http://localhost:3000/api/oidc/login">Sign in with SSO</a>
+              </Button>
               <div className="text-center text-sm">
                 Don&apos;t have an account?{" "}
                 <Link href="/signup" className="text-primary hover:underline">
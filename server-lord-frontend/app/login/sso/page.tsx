@@ -0,0 +1,128 @@
+"use client";
+
+import { useEffect, useState } from "react";
+import Link from "next/link";
+import { useRouter } from "next/navigation";
+import { signIn } from "next-auth/react";
+import { Server } from "lucide-react";
+
+import { Button } from "@/components/ui/button";
+import { Card, CardContent, CardDescription, CardFooter, CardHeader, CardTitle } from "@/components/ui/card";
+import { Input } from "@/components/ui/input";
+import { Label } from "@/components/ui/label";
+
+// The backend's SSO callback sends the browser here with the outcome of the
+// login in the URL fragment: the session's tokens, an MFA token for accounts
+// with two-factor authentication, or an error
+export default function SSOCallbackPage() {
+  const router = useRouter();
+  const [mfaToken, setMFAToken] = useState<string | null>(null);
+  const [code, setCode] = useState("");
+  const [error, setError] = useState<string | null>(null);
+  const [loading, setLoading] = useState(true);
+
+  const finish = async (credentials: Record<string, string>) => {
+    const result = await signIn("sso", { ...credentials, redirect: false });
+    if (result?.error) {
+      throw new Error("Single sign-on failed, please try again");
+    }
+    router.push("/dashboard");
+  };
+
+  useEffect(() => {
+    const outcome = new URLSearchParams(window.location.hash.slice(1));
+    // Keep the tokens out of the history
+    window.history.replaceState(null, "", window.location.pathname);
+
+    if (outcome.get("error")) {
+      setError(outcome.get("error"));
+      setLoading(false);
+    } else if (outcome.get("mfa_token")) {
+      setMFAToken(outcome.get("mfa_token"));
+      setLoading(false);
+    } else if (outcome.get("token")) {
+      finish({
+        token: outcome.get("token") || "",
+        refresh_token: outcome.get("refresh_token") || "",
+        expires_in: outcome.get("expires_in") || "0",
+      }).catch((err) => {
+        setError(err.message);
+        setLoading(false);
+      });
+    } else {
+      setError("Single sign-on did not return a session, please try again");
+      setLoading(false);
+    }
+  }, []);
+
+  const handleSubmit = async (e: React.FormEvent) => {
+    e.preventDefault();
+    if (!mfaToken) return;
+    setError(null);
+    setLoading(true);
+    try {
+      await finish({ mfa_token: mfaToken, code });
+    } catch (err: any) {
+      setError("Invalid authentication code, or the login expired");
+      setLoading(false);
+    }
+  };
+
+  return (
+    <div className="flex min-h-screen items-center justify-center px-4 py-12 dark gradient-hero">
+      <div className="relative z-10 w-full max-w-md">
+        <div className="flex justify-center mb-6">
+          <div className="flex items-center gap-2">
+            <Server className="h-8 w-8 text-primary" />
+            <span className="text-2xl font-bold text-white">Server Lord</span>
+          </div>
+        </div>
+        <Card className="bg-card/80 backdrop-blur-sm border-primary/20">
+          <CardHeader>
+            <CardTitle className="text-2xl">Single Sign-On</CardTitle>
+            <CardDescription>
+              {mfaToken ? "Enter a code from your authenticator app or a recovery code" : "Completing your login"}
+            </CardDescription>
+            {error && (
+              <div className="mt-2 p-2 bg-destructive/20 text-destructive text-sm rounded">
+                {error}
+              </div>
+            )}
+          </CardHeader>
+          {mfaToken ? (
+            <form onSubmit={handleSubmit}>
+              <CardContent className="space-y-2">
+                <Label htmlFor="code">Authentication code</Label>
+                <Input
+                  id="code"
+                  name="code"
+                  autoComplete="one-time-code"
+                  required
+                  value={code}
+                  onChange={(e) => setCode(e.target.value)}
+                  className="bg-background/50"
+                  disabled={loading}
+                />
+              </CardContent>
+              <CardFooter>
+                <Button type="submit" className="w-full gradient-accent" disabled={loading}>
+                  {loading ? "Verifying..." : "Verify"}
+                </Button>
+              </CardFooter>
+            </form>
+          ) : (
+            <CardFooter className="flex justify-center">
+              {loading ? (
+                <div className="h-6 w-6 animate-spin rounded-full border-2 border-primary border-t-transparent"></div>
+              ) : (
+                <Link href="/login" className="text-primary hover:underline">
+                  Back to sign in
+                </Link>
+              )}
+            </CardFooter>
+          )}
+        </Card>
+      </div>
+    </div>
+  );
+}
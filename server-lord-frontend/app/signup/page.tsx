@@ -114,6 +114,7 @@ export default function SignupPage() {
                     name="password"
                     type={showPassword ? "text" : "password"}
                     required
+                    minLength={8}
                     value={formData.password}
                     onChange={handleChange}
                     className="bg-background/50"
@@ -4,6 +4,8 @@ declare module "next-auth" {
   interface User {
     id: string
     accessToken?: string
+    refreshToken?: string
+    accessTokenExpires?: number
     name?: string
   }
 
@@ -19,5 +21,8 @@ declare module "next-auth/jwt" {
   interface JWT {
     id: string
     accessToken: string
+    refreshToken?: string
+    accessTokenExpires?: number
+    error?: string
   }
 }
\ No newline at end of file
@@ -43,29 +43,67 @@ export function ProcessCard({ process, onDelete, onUpdate }: ProcessCardProps) {
   const [advancedInfo, setAdvancedInfo] = useState<any>(null)
   const [loading, setLoading] = useState(false)
   const [deleteDialogOpen, setDeleteDialogOpen] = useState(false)
+  // ETag of the version of the task the edit dialog started from
+  const [etag, setEtag] = useState<string | null>(null)
   const { toast } = useToast()
 
+  // fetchTask reads the task and remembers its ETag, so that an edit only
+  // applies to the version the user has seen
+  const fetchTask = async () => {
+    const response = await axios.get(
+      `http://localhost:3000/api/tasks/${process.id}`,
+      {
+        headers: {
+          Authorization: `Bearer ${session?.user?.accessToken || ''}`
+        }
+      }
+    )
+    setEtag(response.headers["etag"] || null)
+    return response.data.task
+  }
+
+  const openEditDialog = async () => {
+    setEditDialogOpen(true)
+    try {
+      setLoading(true)
+      const task = await fetchTask()
+      setEditedProcess({ ...process, name: task.name })
+    } catch (error) {
+      console.error("Error fetching process:", error)
+      toast({
+        title: "Error",
+        description: "Failed to load the process. Please try again.",
+        variant: "destructive",
+      })
+      setEditDialogOpen(false)
+    } finally {
+      setLoading(false)
+    }
+  }
+
   const handleEditProcess = async () => {
     try {
       setLoading(true)
-      const response = await axios.put(
+      // PATCH leaves the other fields alone; the status is up to the monitor.
+      // If-Match makes the server refuse the edit with 412 when someone else
+      // changed the task since the dialog loaded it.
+      const response = await axios.patch(
         `http://localhost:3000/api/tasks/${process.id}`, 
         {
-          name: editedProcess.name,
-          status: editedProcess.status
+          name: editedProcess.name
         },
         {
           headers: {
-            Authorization: `Bearer ${session?.user?.accessToken || ''}`
+            Authorization: `Bearer ${session?.user?.accessToken || ''}`,
+            ...(etag ? { "If-Match": etag } : {})
           }
         }
       )
-      console.log("res1=",response)
+      setEtag(response.headers["etag"] || null)
       // Create updated process object with response data
       const updatedProcess = {
         ...process,
-        name: editedProcess.name,
-        status: editedProcess.status === "alive" ? "alive" : "dead",
+        name: response.data.name,
         lastChecked: "just now"
       }
       
@@ -82,6 +120,24 @@ export function ProcessCard({ process, onDelete, onUpdate }: ProcessCardProps) {
       
       setEditDialogOpen(false)
     } catch (error) {
+      if (axios.isAxiosError(error) && error.response?.status === 412) {
+        // Show the current version and keep the dialog open with the user's
+        // edit, which they can save again on top of it
+        toast({
+          title: "Process changed",
+          description: "Someone else changed this process since you opened it. The card shows the latest version; save again to apply your edit.",
+          variant: "destructive",
+        })
+        try {
+          const task = await fetchTask()
+          if (onUpdate) {
+            onUpdate(process.id, { ...process, name: task.name, status: task.status })
+          }
+        } catch (fetchError) {
+          console.error("Error fetching process:", fetchError)
+        }
+        return
+      }
       console.error("Error updating process:", error)
       toast({
         title: "Error",
@@ -97,25 +153,16 @@ export function ProcessCard({ process, onDelete, onUpdate }: ProcessCardProps) {
     try {
       setLoading(true)
       // Make the actual API call to your backend
-      const userId = session?.user?.id
-      const response = await axios.get(
-        `http://localhost:3000/api/tasks/${process.id}`,
-        {
-          headers: {
-            Authorization: `Bearer ${session?.user?.accessToken || ''}`
-          }
-        }
-      )
-      console.log("task_res=",response.data)
+      const task = await fetchTask()
       
       // Use the real data from the API response
       setAdvancedInfo({
-        processId: response.data.task.id || Math.floor(Math.random() * 100),
-        interval: response.data.task.interval || Math.floor(Math.random() * 1024),
-        lastPing: response.data.task.last_ping || Math.floor(Math.random() * 10) + 1,
-        name: response.data.task.name || new Date(Date.now() - Math.random() * 10000000000).toISOString(),
-        pingUrl: response.data.task.ping_url,
-        status: response.data.task.status,
+        processId: task.id || Math.floor(Math.random() * 100),
+        interval: task.interval || Math.floor(Math.random() * 1024),
+        lastPing: task.last_ping || Math.floor(Math.random() * 10) + 1,
+        name: task.name || new Date(Date.now() - Math.random() * 10000000000).toISOString(),
+        pingUrl: task.ping_url,
+        status: task.status,
       })
     } catch (error) {
       console.error("Error fetching advanced info:", error)
@@ -207,7 +254,7 @@ export function ProcessCard({ process, onDelete, onUpdate }: ProcessCardProps) {
                 </Button>
               </DropdownMenuTrigger>
               <DropdownMenuContent align="end">
-                <DropdownMenuItem onClick={() => setEditDialogOpen(true)}>Edit Process Info</DropdownMenuItem>
+                <DropdownMenuItem onClick={openEditDialog}>Edit Process Info</DropdownMenuItem>
                 <DropdownMenuItem
                   onClick={() => {
                     setAdvancedDialogOpen(true)
@@ -259,20 +306,6 @@ export function ProcessCard({ process, onDelete, onUpdate }: ProcessCardProps) {
                 className="col-span-3"
               />
             </div>
-            <div className="grid grid-cols-4 items-center gap-4">
-              <Label htmlFor="status" className="text-right">
-                Status
-              </Label>
-              <select
-                id="status"
-                value={editedProcess.status}
-                onChange={(e) => setEditedProcess({ ...editedProcess, status: e.target.value })}
-                className="col-span-3 flex h-10 w-full rounded-md border border-input bg-background px-3 py-2 text-sm ring-offset-background file:border-0 file:bg-transparent file:text-sm file:font-medium placeholder:text-muted-foreground focus-visible:outline-none focus-visible:ring-2 focus-visible:ring-ring focus-visible:ring-offset-2 disabled:cursor-not-allowed disabled:opacity-50"
-              >
-                <option value="alive">Alive</option>
-                <option value="dead">Dead</option>
-              </select>
-            </div>
           </div>
           <DialogFooter>
             <Button variant="outline" onClick={() => setEditDialogOpen(false)} disabled={loading}>
@@ -12,6 +12,7 @@ interface DataPoint {
   timestamp: string
   status?: string
   uptime_percentage: number
+  avg_uptime_percentage?: number
   uptime_seconds: number
   downtime_seconds: number
   value?: number // For chart display
@@ -21,11 +22,25 @@ interface DataPoint {
 // Chart props definition
 interface UptimeChartProps {
   taskId?: number
-  dataKey?: "uptime_percentage" | "uptime_seconds" | "downtime_seconds"
+  dataKey?: "uptime_percentage" | "avg_uptime_percentage" | "uptime_seconds" | "downtime_seconds"
   timeRange?: string
   maxPoints?: number
 }
 
+// graphRanges maps each time range to its length and bucket step in seconds.
+// The steps are multiples of the resolution the backend keeps for that range
+// (raw samples up to 2h, minute rollups for a day, hourly ones up to 90 days
+// and daily ones beyond), so the graph API can serve them from one tier.
+const graphRanges: Record<string, { seconds: number; step: number; dateFormat: string }> = {
+  "1h": { seconds: 3600, step: 60, dateFormat: "h:mm a" },
+  "6h": { seconds: 6 * 3600, step: 5 * 60, dateFormat: "h:mm a" },
+  "24h": { seconds: 24 * 3600, step: 15 * 60, dateFormat: "h:mm a" },
+  "7d": { seconds: 7 * 86400, step: 3600, dateFormat: "MMM d, h a" },
+  "30d": { seconds: 30 * 86400, step: 6 * 3600, dateFormat: "MMM d, h a" },
+  "90d": { seconds: 90 * 86400, step: 86400, dateFormat: "MMM d" },
+  "1y": { seconds: 365 * 86400, step: 7 * 86400, dateFormat: "MMM d, yyyy" },
+}
+
 // Custom tooltip content component with improved visibility
 function CustomTooltipContent({ active, payload, label }: any) {
   if (!active || !payload || !payload.length) {
@@ -102,10 +117,17 @@ export function UptimeChart({
         
         // Construct the API URL based on whether we have a taskId
         const url = taskId
-          ? `http://localhost:3000/api/tasks/${taskId}/graph?timeRange=${timeRange}`
-          : `http://localhost:3000/api/users/${session.user.id}/graph?timeRange=${timeRange}`;
+          ? `http://localhost:3000/api/tasks/${taskId}/graph`
+          : `http://localhost:3000/api/users/${session.user.id}/graph`;
+        const range = graphRanges[timeRange] || graphRanges["6h"];
+        const to = Math.floor(Date.now() / 1000);
         
         const response = await axios.get(url, {
+          params: {
+            from: to - range.seconds,
+            to,
+            step: range.step,
+          },
           headers: {
             Authorization: `Bearer ${session.user.accessToken}`
           }
@@ -129,7 +151,7 @@ export function UptimeChart({
           // Set the value based on the selected dataKey
           value: point[dataKey],
           // Format date for display
-          date: format(parseISO(point.timestamp), "h:mm a")
+          date: format(parseISO(point.timestamp), range.dateFormat)
         }));
         
         console.log("Formatted chart data:", formattedData);